@@ -0,0 +1,490 @@
+// Package accesstoken issues short-lived access tokens for mobile clients, so
+// they do not keep a long-lived API token in app storage. An API token is
+// exchanged once for a signed access token and a refresh token. Refresh tokens
+// are single use, each refresh returns a new pair of the same token family and
+// using a replaced refresh token again revokes the whole family. Requests with
+// an access token are authenticated by its signature and its family in Valkey,
+// the user is loaded for the handlers.
+package accesstoken
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	userservice "github.com/flurbudurbur/Shiori/internal/user"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+const (
+	issuer = "shiori"
+
+	tokenBytes = 32
+	// refreshTokenPrefix marks refresh tokens like shi_ marks API tokens, so secret scanners can find them.
+	refreshTokenPrefix = "shr_"
+
+	refreshKeyPrefix = "refresh:token:"
+	usedKeyPrefix    = "refresh:used:"
+	familyKeyPrefix  = "refresh:family:"
+	// userKeyPrefix keys the set of the token families of a user, so they can all be revoked.
+	userKeyPrefix = "refresh:user:"
+)
+
+var (
+	ErrDisabled            = pkgErrors.New("access tokens are disabled")
+	ErrInvalidAccessToken  = pkgErrors.New("invalid access token")
+	ErrAccessTokenExpired  = pkgErrors.New("access token expired")
+	ErrInvalidRefreshToken = pkgErrors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = pkgErrors.New("refresh token was already used, its token family is revoked")
+	ErrTokenFamilyRevoked  = pkgErrors.New("token family revoked")
+	ErrUserExpired         = pkgErrors.New("user account expired")
+)
+
+// userService is the part of user.Service authenticating the credentials access tokens are issued for.
+type userService interface {
+	AuthenticateUserByToken(ctx context.Context, plainToken string, ip string) (*domain.User, *domain.APIToken, error)
+	GetUserForAuthentication(ctx context.Context, hashedUUID string) (*domain.User, error)
+}
+
+type auditRecorder interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+type Service interface {
+	// Enabled reports whether API tokens can be exchanged for access tokens.
+	Enabled() bool
+	// Exchange authenticates an API token and starts a token family with a new access and refresh token.
+	Exchange(ctx context.Context, plainToken string, ip string) (*domain.TokenPair, error)
+	// Refresh replaces a refresh token with a new pair. It returns ErrRefreshTokenReused for a
+	// replaced refresh token, after revoking its family, and ErrInvalidRefreshToken when the
+	// family ended or the API token it was started with was revoked.
+	Refresh(ctx context.Context, refreshToken string, ip string) (*domain.TokenPair, error)
+	// Verify checks the signature and expiry of an access token, it needs no lookup.
+	Verify(accessToken string) (*Claims, error)
+	// Authenticate verifies an access token, checks that its family was not
+	// revoked and loads its user. The scopes of the returned user are those of
+	// the token, limited to the scopes the user still holds.
+	Authenticate(ctx context.Context, accessToken string) (*domain.User, *Claims, error)
+	// RevokeUser ends every token family of a user, their access tokens stop
+	// working at once. It is called when the user is disabled, deleted, merged
+	// away or moved to a new bookmark.
+	RevokeUser(ctx context.Context, userHashedUUID string) error
+}
+
+// Claims are the claims of an access token. The subject is the PublicID of the
+// user, the token is only signed and must not carry the bookmark.
+type Claims struct {
+	Scope      string `json:"scope"`          // Space-separated scope names, as in RFC 8693
+	FamilyID   string `json:"fid"`            // Token family, the same for every refresh
+	APITokenID int64  `json:"tid,omitempty"`  // Named API token the family was started with, 0 for the user token
+	DeviceName string `json:"name,omitempty"` // Name of the named API token
+	jwt.RegisteredClaims
+}
+
+// Scopes returns the scopes granted to the access token.
+func (c Claims) Scopes() domain.ScopeSet {
+	return domain.ScopeSetFromNames(strings.Fields(c.Scope))
+}
+
+// family is a token family as stored in Valkey, it ends with the API token it was started with.
+type family struct {
+	ID             string    `json:"id"`
+	UserHashedUUID string    `json:"user_hashed_uuid"`
+	LookupID       string    `json:"lookup_id"`
+	APITokenID     int64     `json:"api_token_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type service struct {
+	log          zerolog.Logger
+	cfg          domain.TokenConfig
+	retention    domain.RetentionConfig
+	key          []byte
+	users        userService
+	apiTokenRepo domain.APITokenRepo
+	audit        auditRecorder
+	client       valkeyClient.Client
+	now          func() time.Time
+}
+
+func NewService(log logger.Logger, cfg *domain.Config, users userService, apiTokenRepo domain.APITokenRepo, audit auditRecorder, client valkeyClient.Client) Service {
+	return &service{
+		log:          log.With().Str("module", "accesstoken").Logger(),
+		cfg:          cfg.Tokens,
+		retention:    cfg.Retention,
+		key:          newSigningKey(cfg),
+		users:        users,
+		apiTokenRepo: apiTokenRepo,
+		audit:        audit,
+		client:       client,
+		now:          time.Now,
+	}
+}
+
+// newSigningKey derives the HMAC key of the access tokens, it differs from
+// the TOTP key even when both fall back to the session secret.
+func newSigningKey(cfg *domain.Config) []byte {
+	secret := cfg.Tokens.SigningKey
+	if secret == "" {
+		secret = cfg.SessionSecret
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("access token"))
+	return mac.Sum(nil)
+}
+
+// refreshTokenID returns the ID a refresh token is stored under, the token itself is not stored.
+func refreshTokenID(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex() (string, error) {
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func (s *service) accessTTL() time.Duration {
+	return time.Duration(max(s.cfg.AccessTokenMinutes, 1)) * time.Minute
+}
+
+func (s *service) refreshTTL() time.Duration {
+	return time.Duration(max(s.cfg.RefreshTokenDays, 1)) * 24 * time.Hour
+}
+
+func (s *service) Enabled() bool {
+	return s.cfg.Enabled
+}
+
+func (s *service) Exchange(ctx context.Context, plainToken string, ip string) (*domain.TokenPair, error) {
+	if !s.cfg.Enabled {
+		return nil, ErrDisabled
+	}
+
+	// Failures count towards the lockout like any other use of the API token
+	user, apiToken, err := s.users.AuthenticateUserByToken(ctx, plainToken, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	familyID, err := randomHex()
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not generate token family")
+	}
+	f := &family{
+		ID:             familyID,
+		UserHashedUUID: user.HashedUUID,
+		CreatedAt:      s.now(),
+	}
+	if apiToken != nil {
+		f.LookupID = apiToken.LookupID
+		f.APITokenID = apiToken.ID
+	} else if user.APITokenLookupID != nil {
+		f.LookupID = *user.APITokenLookupID
+	}
+
+	pair, err := s.issue(ctx, f, user, apiToken)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info().Str("hashed_uuid", user.HashedUUID).Str("family_id", familyID[:8]).Msg("token family started")
+	return pair, nil
+}
+
+func (s *service) Refresh(ctx context.Context, refreshToken string, ip string) (*domain.TokenPair, error) {
+	if !s.cfg.Enabled {
+		return nil, ErrDisabled
+	}
+	if !strings.HasPrefix(refreshToken, refreshTokenPrefix) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	id := refreshTokenID(refreshToken)
+	familyID, err := s.client.Do(ctx, s.client.B().Getdel().Key(refreshKeyPrefix+id).Build()).ToString()
+	if errors.Is(err, valkeyClient.Nil) {
+		return nil, s.checkReuse(ctx, id, ip)
+	}
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not look up refresh token")
+	}
+
+	// The token is spent, remember it for as long as its family can live to detect a reuse
+	if err := s.client.Do(ctx, s.client.B().Set().Key(usedKeyPrefix+id).Value(familyID).Ex(s.refreshTTL()).Build()).Error(); err != nil {
+		return nil, pkgErrors.Wrap(err, "could not mark refresh token used")
+	}
+
+	f, err := s.family(ctx, familyID)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, apiToken, err := s.credential(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		s.revoke(ctx, familyID)
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.issue(ctx, f, user, apiToken)
+}
+
+// checkReuse handles a refresh token that is not valid (anymore). A token that
+// was already replaced was either stolen or its replacement was, so the family
+// is revoked for both the client and the attacker.
+func (s *service) checkReuse(ctx context.Context, id string, ip string) error {
+	familyID, err := s.client.Do(ctx, s.client.B().Get().Key(usedKeyPrefix+id).Build()).ToString()
+	if errors.Is(err, valkeyClient.Nil) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return pkgErrors.Wrap(err, "could not look up refresh token")
+	}
+
+	f, err := s.family(ctx, familyID)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		// Revoked before, the reuse was already reported
+		return ErrRefreshTokenReused
+	}
+
+	s.revoke(ctx, familyID)
+	s.log.Warn().Str("hashed_uuid", f.UserHashedUUID).Str("family_id", familyID[:8]).Str("ip", ip).Msg("refresh token reused, token family revoked")
+
+	if user, err := s.users.GetUserForAuthentication(ctx, f.UserHashedUUID); err == nil && user != nil {
+		s.audit.Record(ctx, domain.AuditEvent{
+			Action:   domain.AuditActionRefreshTokenReuse,
+			TargetID: user.PublicID,
+			IP:       ip,
+			Details:  map[string]string{"family_id": familyID[:8]},
+		})
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// credential returns the user and API token of a family, nil if the user or the API token is gone.
+func (s *service) credential(ctx context.Context, f *family) (*domain.User, *domain.APIToken, error) {
+	user, err := s.users.GetUserForAuthentication(ctx, f.UserHashedUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, nil
+	}
+
+	if f.APITokenID == 0 {
+		// A reset of the user token ends the families started with it
+		if user.APITokenLookupID == nil || *user.APITokenLookupID != f.LookupID {
+			return nil, nil, nil
+		}
+		return user, nil, nil
+	}
+
+	apiToken, err := s.apiTokenRepo.FindByLookupID(ctx, f.LookupID)
+	if err != nil {
+		return nil, nil, pkgErrors.Wrap(err, "could not look up API token")
+	}
+	if apiToken == nil || apiToken.ID != f.APITokenID || apiToken.UserHashedUUID != user.HashedUUID || apiToken.Expired(s.now()) {
+		return nil, nil, nil
+	}
+	return user, apiToken, nil
+}
+
+// issue signs an access token and stores a new refresh token of the family,
+// the family lives as long as its latest refresh token.
+func (s *service) issue(ctx context.Context, f *family, user *domain.User, apiToken *domain.APIToken) (*domain.TokenPair, error) {
+	now := s.now()
+	scopes := domain.ParseScopes(user.Scopes)
+	claims := Claims{
+		Scope:    strings.Join(scopes.Names(), " "),
+		FamilyID: f.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   user.PublicID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL())),
+		},
+	}
+	if apiToken != nil {
+		// A named token can only narrow down the scopes of its user
+		scopes = scopes.Intersect(domain.ScopeSetFromNames(apiToken.Scopes))
+		claims.Scope = strings.Join(scopes.Names(), " ")
+		claims.APITokenID = apiToken.ID
+		claims.DeviceName = apiToken.Name
+	}
+
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.key)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not sign access token")
+	}
+
+	secret, err := randomHex()
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not generate refresh token")
+	}
+	refreshToken := refreshTokenPrefix + secret
+
+	encoded, err := json.Marshal(f)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not encode token family")
+	}
+
+	ttl := s.refreshTTL()
+	results := s.client.DoMulti(ctx,
+		s.client.B().Set().Key(familyKeyPrefix+f.ID).Value(string(encoded)).Ex(ttl).Build(),
+		s.client.B().Set().Key(refreshKeyPrefix+refreshTokenID(refreshToken)).Value(f.ID).Ex(ttl).Build(),
+		s.client.B().Sadd().Key(userKeyPrefix+f.UserHashedUUID).Member(f.ID).Build(),
+		s.client.B().Expire().Key(userKeyPrefix+f.UserHashedUUID).Seconds(int64(ttl.Seconds())).Build(),
+	)
+	for _, result := range results {
+		if err := result.Error(); err != nil {
+			return nil, pkgErrors.Wrap(err, "could not store refresh token")
+		}
+	}
+
+	return &domain.TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  claims.ExpiresAt.Time,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: now.Add(ttl),
+		Scopes:           scopes,
+	}, nil
+}
+
+// family returns a stored token family, nil if it was revoked or expired.
+func (s *service) family(ctx context.Context, id string) (*family, error) {
+	value, err := s.client.Do(ctx, s.client.B().Get().Key(familyKeyPrefix+id).Build()).ToString()
+	if err != nil {
+		if errors.Is(err, valkeyClient.Nil) {
+			return nil, nil
+		}
+		return nil, pkgErrors.Wrap(err, "could not read token family")
+	}
+
+	var f family
+	if err := json.Unmarshal([]byte(value), &f); err != nil {
+		return nil, pkgErrors.Wrap(err, "could not decode token family")
+	}
+	return &f, nil
+}
+
+// revoke ends a token family, its refresh and access tokens are useless without it.
+func (s *service) revoke(ctx context.Context, id string) {
+	if err := s.client.Do(ctx, s.client.B().Del().Key(familyKeyPrefix+id).Build()).Error(); err != nil {
+		s.log.Error().Err(err).Str("family_id", id[:8]).Msg("could not revoke token family")
+	}
+}
+
+func (s *service) Verify(accessToken string) (*Claims, error) {
+	if !s.cfg.Enabled {
+		return nil, ErrDisabled
+	}
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(accessToken, &claims, func(*jwt.Token) (any, error) {
+		return s.key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(s.now),
+	)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrAccessTokenExpired
+		}
+		return nil, ErrInvalidAccessToken
+	}
+	if claims.Subject == "" {
+		return nil, ErrInvalidAccessToken
+	}
+
+	return &claims, nil
+}
+
+func (s *service) Authenticate(ctx context.Context, accessToken string) (*domain.User, *Claims, error) {
+	claims, err := s.Verify(accessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := s.family(ctx, claims.FamilyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f == nil {
+		return nil, nil, ErrTokenFamilyRevoked
+	}
+
+	// Handlers check the password, two-factor and retention state of the account, they need all of it
+	user, err := s.users.GetUserForAuthentication(ctx, f.UserHashedUUID)
+	switch {
+	case errors.Is(err, userservice.ErrUserExpired):
+		return nil, nil, ErrUserExpired
+	case errors.Is(err, userservice.ErrUserDisabled):
+		return nil, nil, ErrTokenFamilyRevoked
+	case err != nil:
+		return nil, nil, err
+	}
+	if user == nil || user.PublicID != claims.Subject {
+		return nil, nil, ErrTokenFamilyRevoked
+	}
+	if s.now().After(s.retention.DeletesAt(user.DeletionDate)) {
+		return nil, nil, ErrUserExpired
+	}
+
+	// Scopes taken from the user since the last refresh are not granted any longer
+	user.Scopes = domain.ParseScopes(user.Scopes).Intersect(claims.Scopes()).String()
+	return user, claims, nil
+}
+
+func (s *service) RevokeUser(ctx context.Context, userHashedUUID string) error {
+	if !s.cfg.Enabled {
+		return nil
+	}
+
+	key := userKeyPrefix + userHashedUUID
+	ids, err := s.client.Do(ctx, s.client.B().Smembers().Key(key).Build()).AsStrSlice()
+	if err != nil {
+		return pkgErrors.Wrap(err, "could not list token families")
+	}
+
+	// One command per key, the keys of a user may live in different cluster slots
+	cmds := make(valkeyClient.Commands, 0, len(ids)+1)
+	for _, id := range ids {
+		cmds = append(cmds, s.client.B().Del().Key(familyKeyPrefix+id).Build())
+	}
+	cmds = append(cmds, s.client.B().Del().Key(key).Build())
+	for _, result := range s.client.DoMulti(ctx, cmds...) {
+		if err := result.Error(); err != nil {
+			return pkgErrors.Wrap(err, "could not revoke token families")
+		}
+	}
+
+	if len(ids) > 0 {
+		s.log.Info().Str("hashed_uuid", userHashedUUID).Int("families", len(ids)).Msg("token families of user revoked")
+	}
+	return nil
+}
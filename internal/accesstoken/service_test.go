@@ -0,0 +1,268 @@
+package accesstoken
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/flurbudurbur/Shiori/internal/audit"
+	"github.com/flurbudurbur/Shiori/internal/config"
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/user"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+const testAPIToken = "shi_lookup_secret"
+
+type fakeUserService struct {
+	user     *domain.User
+	apiToken *domain.APIToken
+}
+
+func (s *fakeUserService) AuthenticateUserByToken(ctx context.Context, plainToken string, ip string) (*domain.User, *domain.APIToken, error) {
+	if plainToken != testAPIToken {
+		return nil, nil, user.ErrAuthenticationFailed
+	}
+	return s.user, s.apiToken, nil
+}
+
+func (s *fakeUserService) GetUserForAuthentication(ctx context.Context, hashedUUID string) (*domain.User, error) {
+	if s.user == nil || s.user.HashedUUID != hashedUUID {
+		return nil, nil
+	}
+	return s.user, nil
+}
+
+// newTestService authenticates testAPIToken as a token narrowed down to sync:read,
+// the audit log is returned to be checked.
+func newTestService(t *testing.T, enabled bool) (*service, *fakeUserService, domain.AuditEventRepo) {
+	ctx := context.Background()
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	db, log := databasetest.New(t)
+	cfg := config.New(t.TempDir(), "test").Config
+	cfg.Tokens.Enabled = enabled
+
+	u := domain.User{
+		HashedUUID:   "bookmark",
+		PublicID:     "public",
+		Scopes:       domain.NewScopeSet(domain.ScopeSyncRead, domain.ScopeSyncWrite).String(),
+		DeletionDate: time.Now().AddDate(0, 0, 30),
+	}
+	databasetest.StoreUsers(t, database.NewUserRepo(log, db), u)
+	apiTokens := database.NewAPITokenRepo(log, db)
+	apiToken := &domain.APIToken{UserHashedUUID: "bookmark", Name: "Phone", LookupID: "lookup", Scopes: []string{string(domain.ScopeSyncRead)}}
+	require.NoError(t, apiTokens.Store(ctx, apiToken))
+
+	users := &fakeUserService{user: &u, apiToken: apiToken}
+	auditRepo := database.NewAuditEventRepo(log, db)
+	svc := NewService(log, cfg, users, apiTokens, audit.NewService(log, auditRepo), client)
+	return svc.(*service), users, auditRepo
+}
+
+func TestService_Exchange(t *testing.T) {
+	ctx := context.Background()
+	svc, users, _ := newTestService(t, true)
+
+	_, err := svc.Exchange(ctx, "shi_lookup_wrong", "192.0.2.1")
+	assert.ErrorIs(t, err, user.ErrAuthenticationFailed)
+
+	pair, err := svc.Exchange(ctx, testAPIToken, "192.0.2.1")
+	require.NoError(t, err)
+	assert.Contains(t, pair.RefreshToken, refreshTokenPrefix)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), pair.AccessExpiresAt, time.Minute)
+
+	claims, err := svc.Verify(pair.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "public", claims.Subject)
+	assert.NotContains(t, pair.AccessToken, "bookmark")
+	assert.Equal(t, users.apiToken.ID, claims.APITokenID)
+	assert.Equal(t, "Phone", claims.DeviceName)
+	// The named token narrows the scopes of its user down
+	assert.Equal(t, domain.NewScopeSet(domain.ScopeSyncRead), claims.Scopes())
+}
+
+func TestService_Disabled(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t, false)
+
+	_, err := svc.Exchange(ctx, testAPIToken, "192.0.2.1")
+	assert.ErrorIs(t, err, ErrDisabled)
+	_, err = svc.Refresh(ctx, refreshTokenPrefix+"token", "192.0.2.1")
+	assert.ErrorIs(t, err, ErrDisabled)
+}
+
+func TestService_RefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	svc, _, auditRepo := newTestService(t, true)
+
+	first, err := svc.Exchange(ctx, testAPIToken, "192.0.2.1")
+	require.NoError(t, err)
+
+	second, err := svc.Refresh(ctx, first.RefreshToken, "192.0.2.1")
+	require.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	firstClaims, err := svc.Verify(first.AccessToken)
+	require.NoError(t, err)
+	secondClaims, err := svc.Verify(second.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, firstClaims.FamilyID, secondClaims.FamilyID)
+
+	_, err = svc.Refresh(ctx, "shr_unknown", "192.0.2.1")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.Empty(t, databasetest.AuditEvents(t, auditRepo))
+
+	// The replaced token is used again, the whole family is revoked
+	_, err = svc.Refresh(ctx, first.RefreshToken, "198.51.100.1")
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	events := databasetest.AuditEvents(t, auditRepo)
+	require.Len(t, events, 1)
+	assert.Equal(t, domain.AuditActionRefreshTokenReuse, events[0].Action)
+	assert.Equal(t, "public", events[0].TargetID)
+	assert.Equal(t, "198.51.100.1", events[0].IP)
+
+	_, err = svc.Refresh(ctx, second.RefreshToken, "192.0.2.1")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestService_RefreshRevokedAPIToken(t *testing.T) {
+	ctx := context.Background()
+	svc, users, _ := newTestService(t, true)
+
+	pair, err := svc.Exchange(ctx, testAPIToken, "192.0.2.1")
+	require.NoError(t, err)
+
+	require.NoError(t, svc.apiTokenRepo.Delete(ctx, "bookmark", users.apiToken.ID))
+	_, err = svc.Refresh(ctx, pair.RefreshToken, "192.0.2.1")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestService_Verify(t *testing.T) {
+	svc, _, _ := newTestService(t, true)
+	pair, err := svc.Exchange(context.Background(), testAPIToken, "192.0.2.1")
+	require.NoError(t, err)
+
+	claims := Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Subject:   "public",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("another key"))
+	require.NoError(t, err)
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		later   time.Duration // How long after the exchange the token is verified
+		wantErr error
+	}{
+		{name: "valid", token: pair.AccessToken},
+		{name: "tampered", token: pair.AccessToken[:len(pair.AccessToken)-2] + "xx", wantErr: ErrInvalidAccessToken},
+		// Tokens signed with another key or without a signature are rejected
+		{name: "forged", token: forged, wantErr: ErrInvalidAccessToken},
+		{name: "unsigned", token: unsigned, wantErr: ErrInvalidAccessToken},
+		{name: "expired", token: pair.AccessToken, later: 16 * time.Minute, wantErr: ErrAccessTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc.now = func() time.Time { return time.Now().Add(tt.later) }
+			_, err := svc.Verify(tt.token)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestService_Authenticate(t *testing.T) {
+	tests := []struct {
+		name       string
+		prepare    func(t *testing.T, svc *service, pair *domain.TokenPair)
+		wantErr    error
+		wantScopes domain.ScopeSet // sync:read if nil
+	}{
+		{name: "valid"},
+		{name: "tampered", prepare: func(t *testing.T, svc *service, pair *domain.TokenPair) {
+			pair.AccessToken = pair.AccessToken[:len(pair.AccessToken)-2] + "xx"
+		}, wantErr: ErrInvalidAccessToken},
+		{name: "family_revoked_on_reuse", prepare: func(t *testing.T, svc *service, pair *domain.TokenPair) {
+			_, err := svc.Refresh(context.Background(), pair.RefreshToken, "192.0.2.1")
+			require.NoError(t, err)
+			_, err = svc.Refresh(context.Background(), pair.RefreshToken, "192.0.2.1")
+			require.ErrorIs(t, err, ErrRefreshTokenReused)
+		}, wantErr: ErrTokenFamilyRevoked},
+		{name: "user_revoked", prepare: func(t *testing.T, svc *service, pair *domain.TokenPair) {
+			require.NoError(t, svc.RevokeUser(context.Background(), "bookmark"))
+		}, wantErr: ErrTokenFamilyRevoked},
+		{name: "user_deleted", prepare: func(t *testing.T, svc *service, pair *domain.TokenPair) {
+			svc.users.(*fakeUserService).user = nil
+		}, wantErr: ErrTokenFamilyRevoked},
+		// Scopes taken from the user apply before the next refresh
+		{name: "scope_taken", prepare: func(t *testing.T, svc *service, pair *domain.TokenPair) {
+			svc.users.(*fakeUserService).user.Scopes = domain.NewScopeSet(domain.ScopeSyncWrite).String()
+		}, wantScopes: domain.NewScopeSet()},
+		{name: "other_user_revoked", prepare: func(t *testing.T, svc *service, pair *domain.TokenPair) {
+			require.NoError(t, svc.RevokeUser(context.Background(), "other"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			svc, users, _ := newTestService(t, true)
+			pair, err := svc.Exchange(ctx, testAPIToken, "192.0.2.1")
+			require.NoError(t, err)
+			if tt.prepare != nil {
+				tt.prepare(t, svc, pair)
+			}
+
+			authenticated, claims, err := svc.Authenticate(ctx, pair.AccessToken)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "bookmark", authenticated.HashedUUID)
+			assert.Equal(t, "public", authenticated.PublicID)
+			wantScopes := tt.wantScopes
+			if wantScopes == nil {
+				wantScopes = domain.NewScopeSet(domain.ScopeSyncRead)
+			}
+			assert.Equal(t, wantScopes, domain.ParseScopes(authenticated.Scopes))
+			assert.Equal(t, users.apiToken.ID, claims.APITokenID)
+		})
+	}
+}
+
+func TestService_AuthenticateExpiredUser(t *testing.T) {
+	ctx := context.Background()
+	svc, users, _ := newTestService(t, true)
+	svc.retention.GraceDays = 7
+
+	// During the grace period the account is read-only, its deletion date is passed on
+	users.user.DeletionDate = time.Now().AddDate(0, 0, -3)
+	pair, err := svc.Exchange(ctx, testAPIToken, "192.0.2.1")
+	require.NoError(t, err)
+	authenticated, _, err := svc.Authenticate(ctx, pair.AccessToken)
+	require.NoError(t, err)
+	assert.True(t, authenticated.Expired(time.Now()))
+
+	// The deletion date is read from the user, not from the token family
+	users.user.DeletionDate = time.Now().AddDate(0, 0, -8)
+	_, _, err = svc.Authenticate(ctx, pair.AccessToken)
+	assert.ErrorIs(t, err, ErrUserExpired)
+
+	users.user.DeletionDate = time.Now().AddDate(0, 0, 30)
+	authenticated, _, err = svc.Authenticate(ctx, pair.AccessToken)
+	require.NoError(t, err)
+	assert.False(t, authenticated.Expired(time.Now()))
+}
@@ -0,0 +1,273 @@
+// Package admin implements account management for administrators. Every
+// change it makes is recorded as an audit event.
+package admin
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// maxExtendDays bounds a single extension of the expiry of a user.
+const maxExtendDays = 3650
+
+var (
+	ErrUserNotFound     = pkgErrors.New("user not found")
+	ErrSelfAction       = pkgErrors.New("administrators cannot disable or delete their own account")
+	ErrInvalidExtension = pkgErrors.New("invalid expiry extension")
+)
+
+// userService is the part of user.Service resetting user tokens.
+type userService interface {
+	ResetAndRetrieveUserToken(ctx context.Context, hashedUUID string) (string, error)
+}
+
+// sessionService ends the web sessions of a user, see session.Service.
+type sessionService interface {
+	RevokeAll(ctx context.Context, userHashedUUID string, exceptID string) error
+}
+
+// tokenService ends the access token families of a user, see accesstoken.Service.
+type tokenService interface {
+	RevokeUser(ctx context.Context, userHashedUUID string) error
+}
+
+type Service interface {
+	// ListUsers returns a page of users with their storage usage and the total number of users.
+	ListUsers(ctx context.Context, params domain.UserQueryParams) ([]domain.UserSummary, int, error)
+	// ExtendExpiry moves the deletion date of a user days out from the later of now and the current date.
+	ExtendExpiry(ctx context.Context, actor *domain.User, publicID string, days int, ip string) (*domain.UserSummary, error)
+	// SetDisabled blocks a user from authenticating and ends their sessions and
+	// access tokens, or allows it again.
+	SetDisabled(ctx context.Context, actor *domain.User, publicID string, disabled bool, ip string) error
+	// ResetToken replaces the user token, the user retrieves the new one after logging in.
+	ResetToken(ctx context.Context, actor *domain.User, publicID string, ip string) error
+	DeleteUser(ctx context.Context, actor *domain.User, publicID string, ip string) error
+	// GrantAdmin gives the user with a PublicID the admin role, used on startup and from the command line.
+	// The PublicID is the account ID shown to the user, unlike the bookmark it is no credential.
+	GrantAdmin(ctx context.Context, publicID string) error
+	ListAuditEvents(ctx context.Context, params domain.AuditEventQueryParams) ([]domain.AuditEvent, int, error)
+
+	// CreateInvite mints an invite code for invite only registration, the plain code is only returned now.
+	CreateInvite(ctx context.Context, actor *domain.User, req domain.CreateInviteRequest, ip string) (code string, invite *domain.Invite, err error)
+	ListInvites(ctx context.Context) ([]domain.Invite, error)
+	// RevokeInvite ends an invite, it returns domain.ErrInviteNotFound for unknown or revoked invites.
+	RevokeInvite(ctx context.Context, actor *domain.User, id int64, ip string) error
+}
+
+type service struct {
+	log        zerolog.Logger
+	repo       domain.UserRepo
+	syncRepo   domain.SyncRepo
+	auditRepo  domain.AuditEventRepo
+	inviteRepo domain.InviteRepo
+	users      userService
+	sessions   sessionService
+	tokens     tokenService
+}
+
+func NewService(log logger.Logger, repo domain.UserRepo, syncRepo domain.SyncRepo, auditRepo domain.AuditEventRepo, inviteRepo domain.InviteRepo, users userService, sessions sessionService, tokens tokenService) Service {
+	return &service{
+		log:        log.With().Str("module", "admin").Logger(),
+		repo:       repo,
+		syncRepo:   syncRepo,
+		auditRepo:  auditRepo,
+		inviteRepo: inviteRepo,
+		users:      users,
+		sessions:   sessions,
+		tokens:     tokens,
+	}
+}
+
+func (s *service) ListUsers(ctx context.Context, params domain.UserQueryParams) ([]domain.UserSummary, int, error) {
+	users, count, err := s.repo.FindUsers(ctx, params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hashedUUIDs := make([]string, 0, len(users))
+	for _, u := range users {
+		hashedUUIDs = append(hashedUUIDs, u.HashedUUID)
+	}
+	usage, err := s.syncRepo.GetUsageByUser(ctx, hashedUUIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	summaries := make([]domain.UserSummary, 0, len(users))
+	for _, u := range users {
+		summaries = append(summaries, summarize(u, usage[u.HashedUUID], now))
+	}
+	return summaries, count, nil
+}
+
+func summarize(u domain.User, usage domain.SyncUsage, now time.Time) domain.UserSummary {
+	return domain.UserSummary{
+		ID:           u.PublicID,
+		Admin:        u.IsAdmin(),
+		Disabled:     u.Disabled,
+		Scopes:       domain.ParseScopes(u.Scopes).Names(),
+		DeletionDate: u.DeletionDate,
+		Expired:      u.Expired(now),
+		StorageBytes: usage.Bytes,
+		LastSyncAt:   usage.LastUploadAt,
+		HasPassword:  u.HasPassword(),
+		TOTPEnabled:  u.TOTPEnabled,
+	}
+}
+
+func (s *service) ExtendExpiry(ctx context.Context, actor *domain.User, publicID string, days int, ip string) (*domain.UserSummary, error) {
+	if days <= 0 || days > maxExtendDays {
+		return nil, pkgErrors.Wrap(ErrInvalidExtension, "days must be between 1 and %d", maxExtendDays)
+	}
+
+	target, err := s.findUser(ctx, publicID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	from := target.DeletionDate
+	if from.Before(now) {
+		from = now
+	}
+	deletionDate := from.AddDate(0, 0, days)
+	if err := s.repo.UpdateDeletionDate(ctx, target.HashedUUID, deletionDate); err != nil {
+		return nil, pkgErrors.Wrap(err, "failed to extend expiry")
+	}
+	target.DeletionDate = deletionDate
+
+	s.record(ctx, domain.AuditActionExtendExpiry, actor.PublicID, target.PublicID, ip, map[string]string{
+		"days":          strconv.Itoa(days),
+		"deletion_date": deletionDate.Format(time.RFC3339),
+	})
+
+	usage, err := s.syncRepo.GetUsageByUser(ctx, []string{target.HashedUUID})
+	if err != nil {
+		return nil, err
+	}
+	summary := summarize(*target, usage[target.HashedUUID], now)
+	return &summary, nil
+}
+
+func (s *service) SetDisabled(ctx context.Context, actor *domain.User, publicID string, disabled bool, ip string) error {
+	target, err := s.findUser(ctx, publicID)
+	if err != nil {
+		return err
+	}
+	if disabled && target.PublicID == actor.PublicID {
+		return ErrSelfAction
+	}
+
+	if err := s.repo.UpdateDisabled(ctx, target.HashedUUID, disabled); err != nil {
+		return pkgErrors.Wrap(err, "failed to update disabled state")
+	}
+
+	action := domain.AuditActionEnableUser
+	if disabled {
+		action = domain.AuditActionDisableUser
+		// Sessions fail on their next request anyway, ending them now is cleanup
+		if err := s.sessions.RevokeAll(ctx, target.HashedUUID, ""); err != nil {
+			s.log.Error().Err(err).Str("public_id", target.PublicID).Msg("Failed to end sessions of disabled user")
+		}
+		// Access tokens are not checked against the user, they keep working until revoked
+		if err := s.tokens.RevokeUser(ctx, target.HashedUUID); err != nil {
+			s.log.Error().Err(err).Str("public_id", target.PublicID).Msg("Failed to revoke access tokens of disabled user")
+		}
+	}
+	s.record(ctx, action, actor.PublicID, target.PublicID, ip, nil)
+	return nil
+}
+
+func (s *service) ResetToken(ctx context.Context, actor *domain.User, publicID string, ip string) error {
+	target, err := s.findUser(ctx, publicID)
+	if err != nil {
+		return err
+	}
+
+	// The new token is not handed to the administrator
+	if _, err := s.users.ResetAndRetrieveUserToken(ctx, target.HashedUUID); err != nil {
+		return pkgErrors.Wrap(err, "failed to reset user token")
+	}
+
+	s.record(ctx, domain.AuditActionResetUserToken, actor.PublicID, target.PublicID, ip, nil)
+	return nil
+}
+
+func (s *service) DeleteUser(ctx context.Context, actor *domain.User, publicID string, ip string) error {
+	target, err := s.findUser(ctx, publicID)
+	if err != nil {
+		return err
+	}
+	if target.PublicID == actor.PublicID {
+		return ErrSelfAction
+	}
+
+	if err := s.repo.DeleteUserAndAssociatedData(ctx, target.HashedUUID); err != nil {
+		return pkgErrors.Wrap(err, "failed to delete user")
+	}
+	if err := s.sessions.RevokeAll(ctx, target.HashedUUID, ""); err != nil {
+		s.log.Error().Err(err).Str("public_id", target.PublicID).Msg("Failed to end sessions of deleted user")
+	}
+	if err := s.tokens.RevokeUser(ctx, target.HashedUUID); err != nil {
+		s.log.Error().Err(err).Str("public_id", target.PublicID).Msg("Failed to revoke access tokens of deleted user")
+	}
+
+	s.record(ctx, domain.AuditActionDeleteUser, actor.PublicID, target.PublicID, ip, nil)
+	return nil
+}
+
+func (s *service) GrantAdmin(ctx context.Context, publicID string) error {
+	target, err := s.repo.FindByPublicID(ctx, publicID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return ErrUserNotFound
+	}
+	if target.IsAdmin() {
+		return nil
+	}
+
+	scopes := domain.ParseScopes(target.Scopes)
+	scopes[domain.ScopeAdmin] = struct{}{}
+	if err := s.repo.UpdateScopes(ctx, target.HashedUUID, scopes.String()); err != nil {
+		return pkgErrors.Wrap(err, "failed to grant admin role")
+	}
+
+	s.record(ctx, domain.AuditActionGrantAdmin, domain.AuditActorSystem, target.PublicID, "", nil)
+	s.log.Info().Str("public_id", target.PublicID).Msg("Admin role granted")
+	return nil
+}
+
+func (s *service) ListAuditEvents(ctx context.Context, params domain.AuditEventQueryParams) ([]domain.AuditEvent, int, error) {
+	return s.auditRepo.Find(ctx, params)
+}
+
+func (s *service) findUser(ctx context.Context, publicID string) (*domain.User, error) {
+	if publicID == "" {
+		return nil, ErrUserNotFound
+	}
+	target, err := s.repo.FindByPublicID(ctx, publicID)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, ErrUserNotFound
+	}
+	return target, nil
+}
+
+// record stores an audit event. The action already happened, so a failure is logged only.
+func (s *service) record(ctx context.Context, action domain.AuditAction, actorID string, targetID string, ip string, details map[string]string) {
+	event := domain.AuditEvent{Action: action, ActorID: actorID, TargetID: targetID, IP: ip, Details: details}
+	if err := s.auditRepo.Store(ctx, event); err != nil {
+		s.log.Error().Err(err).Str("action", string(action)).Str("actor_id", actorID).Str("target_id", targetID).Msg("Failed to record audit event")
+	}
+}
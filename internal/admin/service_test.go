@@ -0,0 +1,203 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSessions records whose sessions and access tokens were ended.
+type fakeSessions struct{ revoked, tokensRevoked []string }
+
+func (s *fakeSessions) RevokeAll(ctx context.Context, userHashedUUID string, exceptID string) error {
+	s.revoked = append(s.revoked, userHashedUUID)
+	return nil
+}
+
+func (s *fakeSessions) RevokeUser(ctx context.Context, userHashedUUID string) error {
+	s.tokensRevoked = append(s.tokensRevoked, userHashedUUID)
+	return nil
+}
+
+// newTestService seeds an admin and a user whose account expired three days ago.
+func newTestService(t *testing.T) (*service, *fakeSessions) {
+	db, log := databasetest.New(t)
+
+	users := database.NewUserRepo(log, db)
+	databasetest.StoreUsers(t, users,
+		domain.User{HashedUUID: "admin-bookmark", PublicID: "admin", Scopes: `["sync:read","admin"]`, DeletionDate: time.Now().AddDate(0, 0, 30)},
+		domain.User{HashedUUID: "user-bookmark", PublicID: "user", Scopes: `["sync:read"]`, DeletionDate: time.Now().AddDate(0, 0, -3)},
+	)
+
+	sessions := &fakeSessions{}
+	svc := NewService(log, users, database.NewSyncRepo(log, db), database.NewAuditEventRepo(log, db), database.NewInviteRepo(log, db), nil, sessions, sessions)
+	return svc.(*service), sessions
+}
+
+func findUser(t *testing.T, svc *service, publicID string) *domain.User {
+	u, err := svc.repo.FindByPublicID(context.Background(), publicID)
+	require.NoError(t, err)
+	return u
+}
+
+func TestService_GrantAdmin(t *testing.T) {
+	svc, _ := newTestService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+		events  int
+	}{
+		// Bookmarks are credentials, the user is named by the PublicID
+		{name: "bookmark", id: "user-bookmark", wantErr: ErrUserNotFound},
+		{name: "grant", id: "user", events: 1},
+		{name: "already_admin", id: "user", events: 1},
+		{name: "unknown", id: "unknown", wantErr: ErrUserNotFound, events: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.GrantAdmin(ctx, tt.id)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Len(t, databasetest.AuditEvents(t, svc.auditRepo), tt.events)
+		})
+	}
+
+	u := findUser(t, svc, "user")
+	assert.True(t, u.IsAdmin())
+	assert.True(t, domain.ParseScopes(u.Scopes).Has(domain.ScopeSyncRead))
+	event := databasetest.AuditEvents(t, svc.auditRepo)[0]
+	assert.Equal(t, domain.AuditActionGrantAdmin, event.Action)
+	assert.Equal(t, domain.AuditActorSystem, event.ActorID)
+	assert.Equal(t, "user", event.TargetID)
+}
+
+func TestService_SelfAction(t *testing.T) {
+	svc, _ := newTestService(t)
+	ctx := context.Background()
+	actor := findUser(t, svc, "admin")
+
+	assert.ErrorIs(t, svc.SetDisabled(ctx, actor, "admin", true, ""), ErrSelfAction)
+	assert.ErrorIs(t, svc.DeleteUser(ctx, actor, "admin", ""), ErrSelfAction)
+	assert.False(t, findUser(t, svc, "admin").Disabled)
+	assert.Empty(t, databasetest.AuditEvents(t, svc.auditRepo))
+}
+
+func TestService_SetDisabled(t *testing.T) {
+	svc, sessions := newTestService(t)
+	ctx := context.Background()
+	actor := findUser(t, svc, "admin")
+
+	tests := []struct {
+		name     string
+		id       string
+		disabled bool
+		wantErr  error
+		action   domain.AuditAction
+	}{
+		{name: "disable", id: "user", disabled: true, action: domain.AuditActionDisableUser},
+		{name: "enable", id: "user", action: domain.AuditActionEnableUser},
+		{name: "unknown", id: "unknown", disabled: true, wantErr: ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.SetDisabled(ctx, actor, tt.id, tt.disabled, "192.0.2.1")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.disabled, findUser(t, svc, tt.id).Disabled)
+
+			events := databasetest.AuditEvents(t, svc.auditRepo)
+			event := events[len(events)-1]
+			assert.Equal(t, tt.action, event.Action)
+			assert.Equal(t, "admin", event.ActorID)
+			assert.Equal(t, "192.0.2.1", event.IP)
+		})
+	}
+
+	// Only disabling ends the sessions and access tokens
+	assert.Equal(t, []string{"user-bookmark"}, sessions.revoked)
+	assert.Equal(t, []string{"user-bookmark"}, sessions.tokensRevoked)
+}
+
+func TestService_ExtendExpiry(t *testing.T) {
+	svc, _ := newTestService(t)
+	ctx := context.Background()
+	actor := findUser(t, svc, "admin")
+	adminDeletion := actor.DeletionDate
+
+	tests := []struct {
+		name    string
+		id      string
+		days    int
+		wantErr error
+		want    time.Time
+	}{
+		{name: "no_days", id: "user", wantErr: ErrInvalidExtension},
+		// An expired account is extended from now, not from its past deletion date
+		{name: "expired", id: "user", days: 10, want: time.Now().AddDate(0, 0, 10)},
+		// An active account is extended from its deletion date
+		{name: "active", id: "admin", days: 5, want: adminDeletion.AddDate(0, 0, 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, err := svc.ExtendExpiry(ctx, actor, tt.id, tt.days, "")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.id, summary.ID)
+			assert.False(t, summary.Expired)
+			assert.WithinDuration(t, tt.want, summary.DeletionDate, time.Minute)
+		})
+	}
+
+	events := databasetest.AuditEvents(t, svc.auditRepo)
+	require.Len(t, events, 2)
+	assert.Equal(t, "10", events[0].Details["days"])
+}
+
+func TestService_CreateInvite(t *testing.T) {
+	svc, _ := newTestService(t)
+	ctx := context.Background()
+	actor := findUser(t, svc, "admin")
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		req     domain.CreateInviteRequest
+		wantErr error
+	}{
+		{name: "expired", req: domain.CreateInviteRequest{ExpiresAt: &past}, wantErr: ErrInvalidInviteRequest},
+		{name: "too_many_uses", req: domain.CreateInviteRequest{MaxUses: maxInviteUses + 1}, wantErr: ErrInvalidInviteRequest},
+		{name: "defaults", req: domain.CreateInviteRequest{Note: "friends"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, invite, err := svc.CreateInvite(ctx, actor, tt.req, "")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Regexp(t, `^[A-Z2-7]{4}(-[A-Z2-7]{4}){3}$`, code)
+			assert.Equal(t, 1, invite.MaxUses)
+			assert.Equal(t, domain.HashInviteCode(code), invite.CodeHash)
+			assert.Equal(t, "admin", invite.CreatedBy)
+		})
+	}
+
+	events := databasetest.AuditEvents(t, svc.auditRepo)
+	require.Len(t, events, 1)
+	assert.Equal(t, domain.AuditActionCreateInvite, events[0].Action)
+}
@@ -0,0 +1,42 @@
+// Package audit keeps the security audit log: logins, lockouts, token resets,
+// session revocations and administrative actions. It is stored in the database,
+// separate from the application log.
+package audit
+
+import (
+	"context"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/rs/zerolog"
+)
+
+type Service interface {
+	// Record stores an event. A failure is logged, it never fails the action being recorded.
+	Record(ctx context.Context, event domain.AuditEvent)
+	// Find returns the events matching params, newest first, and the total number of matches.
+	Find(ctx context.Context, params domain.AuditEventQueryParams) ([]domain.AuditEvent, int, error)
+}
+
+type service struct {
+	log  zerolog.Logger
+	repo domain.AuditEventRepo
+}
+
+func NewService(log logger.Logger, repo domain.AuditEventRepo) Service {
+	return &service{
+		log:  log.With().Str("module", "audit").Logger(),
+		repo: repo,
+	}
+}
+
+func (s *service) Record(ctx context.Context, event domain.AuditEvent) {
+	// The event is stored even if the request it records was cancelled
+	if err := s.repo.Store(context.WithoutCancel(ctx), event); err != nil {
+		s.log.Error().Err(err).Str("action", string(event.Action)).Str("target_id", event.TargetID).Msg("Failed to record audit event")
+	}
+}
+
+func (s *service) Find(ctx context.Context, params domain.AuditEventQueryParams) ([]domain.AuditEvent, int, error) {
+	return s.repo.Find(ctx, params)
+}
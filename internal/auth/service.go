@@ -14,8 +14,9 @@ import (
 // Service interface now matches http.authService
 type Service interface {
 	GetUserCount(ctx context.Context) (int, error)
-	GenerateUserBookmark(ctx context.Context) (hashedUUID string, err error)
-	AuthenticateUser(ctx context.Context, hashedUUID string) (*domain.User, error)
+	GenerateUserBookmark(ctx context.Context) (hashedUUID string, apiToken string, err error)
+	AuthenticateUser(ctx context.Context, hashedUUID string, password string, twoFactorCode string, ip string) (*domain.User, error)
+	RotateLegacyAPIToken(ctx context.Context, user *domain.User) (string, error)
 }
 
 type service struct {
@@ -35,24 +36,24 @@ func (s *service) GetUserCount(ctx context.Context) (int, error) {
 }
 
 // GenerateUserBookmark now calls the user service's RegisterNewUser,
-// which creates the user and returns the HashedUUID.
-func (s *service) GenerateUserBookmark(ctx context.Context) (string, error) {
-	hashedUUID, err := s.userSvc.RegisterNewUser(ctx)
+// which creates the user and returns the HashedUUID and the plain API token.
+func (s *service) GenerateUserBookmark(ctx context.Context) (string, string, error) {
+	hashedUUID, apiToken, err := s.userSvc.RegisterNewUser(ctx)
 	if err != nil {
 		s.log.Error().Err(err).Msg("Failed to generate user bookmark via user service")
-		return "", err
+		return "", "", err
 	}
 	s.log.Info().Str("hashed_uuid", hashedUUID).Msg("Successfully generated user bookmark")
-	return hashedUUID, nil
+	return hashedUUID, apiToken, nil
 }
 
-// AuthenticateUser delegates to userSvc.GetUserForAuthentication,
+// AuthenticateUser delegates to userSvc.AuthenticateByBookmark,
 // as we are authenticating with the HashedUUID.
-func (s *service) AuthenticateUser(ctx context.Context, hashedUUID string) (*domain.User, error) { // Renamed return variable to avoid conflict
+func (s *service) AuthenticateUser(ctx context.Context, hashedUUID string, password string, twoFactorCode string, ip string) (*domain.User, error) { // Renamed return variable to avoid conflict
 	// The providedUUID is the HashedUUID.
 	// AuthenticateUserByToken is for API tokens, not direct UUID login.
-	// We should use GetUserForAuthentication.
-	foundUser, err := s.userSvc.GetUserForAuthentication(ctx, hashedUUID) // Use new variable name
+	// AuthenticateByBookmark wraps GetUserForAuthentication with the lockout.
+	foundUser, err := s.userSvc.AuthenticateByBookmark(ctx, hashedUUID, password, twoFactorCode, ip) // Use new variable name
 	if err != nil {
 		s.log.Warn().Err(err).Str("hashed_uuid_prefix", hashedUUID[:min(len(hashedUUID), 8)]).Msg("Authentication failed for UUID")
 		return nil, err // Propagate error (includes gorm.ErrRecordNotFound)
@@ -66,6 +67,12 @@ func (s *service) AuthenticateUser(ctx context.Context, hashedUUID string) (*dom
 	return foundUser, nil // Return the new variable name
 }
 
+// RotateLegacyAPIToken delegates to the user service, it returns the new plain
+// token when the user still had a token in the legacy format.
+func (s *service) RotateLegacyAPIToken(ctx context.Context, user *domain.User) (string, error) {
+	return s.userSvc.RotateLegacyAPIToken(ctx, user)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,224 @@
+// Package backup decodes the library snapshots uploaded by Tachiyomi-compatible clients.
+//
+// Clients upload a gzip-compressed JSON document holding the device id and a
+// backup of the library. Only the parts the server works with are decoded,
+// everything else is ignored.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+// SyncData is the envelope sent by the client on every upload.
+type SyncData struct {
+	DeviceID string  `json:"deviceId"`
+	Backup   *Backup `json:"backup"`
+}
+
+type Backup struct {
+	Manga      []Manga    `json:"backupManga"`
+	Categories []Category `json:"backupCategories"`
+}
+
+type Manga struct {
+	Source     int64     `json:"source"`
+	URL        string    `json:"url"`
+	Title      string    `json:"title"`
+	Favorite   bool      `json:"favorite"`
+	Chapters   []Chapter `json:"chapters"`
+	Categories []int64   `json:"categories"`
+	History    []History `json:"history"`
+}
+
+type Chapter struct {
+	URL           string  `json:"url"`
+	Name          string  `json:"name"`
+	Read          bool    `json:"read"`
+	LastPageRead  int64   `json:"lastPageRead"`
+	ChapterNumber float64 `json:"chapterNumber"`
+}
+
+// History is the last time a chapter was read, LastRead is in milliseconds since epoch.
+type History struct {
+	URL          string `json:"url"`
+	LastRead     int64  `json:"lastRead"`
+	ReadDuration int64  `json:"readDuration"`
+}
+
+type Category struct {
+	Name  string `json:"name"`
+	Order int64  `json:"order"`
+	Flags int64  `json:"flags"`
+}
+
+// gzipMagic are the first bytes of every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Decode parses an uploaded snapshot, uncompressing it first if needed.
+// Both the SyncData envelope and a bare backup document are accepted.
+func Decode(data []byte) (*Backup, error) {
+	data, err := uncompress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope struct {
+		SyncData
+		Backup
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, errors.Wrap(err, "could not decode backup")
+	}
+
+	if envelope.SyncData.Backup != nil {
+		return envelope.SyncData.Backup, nil
+	}
+
+	return &envelope.Backup, nil
+}
+
+// Compress gzips a document the way clients upload it.
+func Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, errors.Wrap(err, "could not compress backup")
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.Wrap(err, "could not compress backup")
+	}
+	return buf.Bytes(), nil
+}
+
+// uncompress returns the plain JSON document of an upload.
+func uncompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open gzip stream")
+	}
+	defer reader.Close()
+
+	plain, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not uncompress backup")
+	}
+	return plain, nil
+}
+
+// rawBackup returns the fields of the backup document as raw JSON,
+// so documents can be rewritten without losing fields this package does not know.
+func rawBackup(data []byte) (map[string]json.RawMessage, error) {
+	plain, err := uncompress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(plain, &document); err != nil {
+		return nil, errors.Wrap(err, "could not decode backup")
+	}
+
+	if inner, ok := document["backup"]; ok && string(inner) != "null" {
+		var backup map[string]json.RawMessage
+		if err := json.Unmarshal(inner, &backup); err != nil {
+			return nil, errors.Wrap(err, "could not decode backup")
+		}
+		return backup, nil
+	}
+
+	return document, nil
+}
+
+// FilterCategories returns the bare backup document restricted to the manga in the named
+// categories, and to those categories. Everything else in the kept entries is left untouched.
+func FilterCategories(data []byte, names []string) ([]byte, error) {
+	document, err := rawBackup(data)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var categories []json.RawMessage
+	if raw, ok := document["backupCategories"]; ok {
+		if err := json.Unmarshal(raw, &categories); err != nil {
+			return nil, errors.Wrap(err, "could not decode categories")
+		}
+	}
+
+	// Manga reference their categories by the category order
+	orders := map[int64]bool{}
+	keptCategories := []json.RawMessage{}
+	for _, raw := range categories {
+		var category Category
+		if err := json.Unmarshal(raw, &category); err != nil {
+			return nil, errors.Wrap(err, "could not decode category")
+		}
+		if wanted[category.Name] {
+			orders[category.Order] = true
+			keptCategories = append(keptCategories, raw)
+		}
+	}
+
+	var manga []json.RawMessage
+	if raw, ok := document["backupManga"]; ok {
+		if err := json.Unmarshal(raw, &manga); err != nil {
+			return nil, errors.Wrap(err, "could not decode manga")
+		}
+	}
+
+	keptManga := []json.RawMessage{}
+	for _, raw := range manga {
+		var entry struct {
+			Categories []int64 `json:"categories"`
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, errors.Wrap(err, "could not decode manga")
+		}
+		for _, order := range entry.Categories {
+			if orders[order] {
+				keptManga = append(keptManga, raw)
+				break
+			}
+		}
+	}
+
+	if document["backupCategories"], err = json.Marshal(keptCategories); err != nil {
+		return nil, errors.Wrap(err, "could not encode categories")
+	}
+	if document["backupManga"], err = json.Marshal(keptManga); err != nil {
+		return nil, errors.Wrap(err, "could not encode manga")
+	}
+
+	filtered, err := json.Marshal(document)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode backup")
+	}
+	return filtered, nil
+}
+
+// Unwrap returns the bare backup document of an upload, without the sync envelope.
+func Unwrap(data []byte) ([]byte, error) {
+	document, err := rawBackup(data)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := json.Marshal(document)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode backup")
+	}
+	return plain, nil
+}
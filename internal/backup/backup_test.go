@@ -0,0 +1,180 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const envelopeJSON = `{
+	"deviceId": "pixel",
+	"backup": {
+		"backupManga": [{
+			"source": 2499283573021220255,
+			"url": "/manga/1",
+			"title": "Example",
+			"favorite": true,
+			"chapters": [
+				{"url": "/chapter/1", "name": "Chapter 1", "read": true, "chapterNumber": 1},
+				{"url": "/chapter/2", "name": "Chapter 2", "lastPageRead": 4, "chapterNumber": 2.5}
+			],
+			"history": [{"url": "/chapter/1", "lastRead": 1700000000000, "readDuration": 60000}]
+		}],
+		"backupCategories": [{"name": "Reading", "order": 1}]
+	}
+}`
+
+func gzipped(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "plain envelope", data: []byte(envelopeJSON)},
+		{name: "gzipped envelope", data: gzipped(t, envelopeJSON)},
+		{name: "bare backup", data: []byte(`{"backupManga": [{"source": 2499283573021220255, "url": "/manga/1", "title": "Example", "favorite": true, "chapters": [{"url": "/chapter/1", "name": "Chapter 1", "read": true, "chapterNumber": 1}, {"url": "/chapter/2", "name": "Chapter 2", "lastPageRead": 4, "chapterNumber": 2.5}], "history": [{"url": "/chapter/1", "lastRead": 1700000000000, "readDuration": 60000}]}], "backupCategories": [{"name": "Reading", "order": 1}]}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.data)
+			require.NoError(t, err)
+
+			require.Len(t, got.Manga, 1)
+			manga := got.Manga[0]
+			assert.Equal(t, int64(2499283573021220255), manga.Source)
+			assert.Equal(t, "Example", manga.Title)
+			assert.True(t, manga.Favorite)
+			require.Len(t, manga.Chapters, 2)
+			assert.True(t, manga.Chapters[0].Read)
+			assert.False(t, manga.Chapters[1].Read)
+			assert.Equal(t, 2.5, manga.Chapters[1].ChapterNumber)
+			require.Len(t, manga.History, 1)
+			assert.Equal(t, int64(1700000000000), manga.History[0].LastRead)
+			require.Len(t, got.Categories, 1)
+			assert.Equal(t, "Reading", got.Categories[0].Name)
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	_, err := Decode([]byte("not json"))
+	assert.Error(t, err)
+
+	_, err = Decode([]byte{0x1f, 0x8b, 0x00})
+	assert.Error(t, err)
+}
+
+func TestFilterCategories(t *testing.T) {
+	data := gzipped(t, `{
+		"deviceId": "pixel",
+		"backup": {
+			"backupManga": [
+				{"url": "/manga/1", "title": "Kept", "categories": [1], "unknownField": 42},
+				{"url": "/manga/2", "title": "Dropped", "categories": [2]},
+				{"url": "/manga/3", "title": "Uncategorized"}
+			],
+			"backupCategories": [{"name": "Shared", "order": 1}, {"name": "Private", "order": 2}],
+			"backupSources": [{"sourceId": 1}]
+		}
+	}`)
+
+	filtered, err := FilterCategories(data, []string{"Shared"})
+	require.NoError(t, err)
+
+	got, err := Decode(filtered)
+	require.NoError(t, err)
+	require.Len(t, got.Manga, 1)
+	assert.Equal(t, "Kept", got.Manga[0].Title)
+	require.Len(t, got.Categories, 1)
+	assert.Equal(t, "Shared", got.Categories[0].Name)
+
+	// Fields the decoder does not know survive filtering
+	assert.Contains(t, string(filtered), `"unknownField":42`)
+	assert.Contains(t, string(filtered), `"backupSources"`)
+	assert.NotContains(t, string(filtered), `"deviceId"`)
+}
+
+func TestMerge(t *testing.T) {
+	base := gzipped(t, `{
+		"deviceId": "pixel",
+		"backup": {
+			"backupManga": [{
+				"source": 1, "url": "/manga/1", "title": "Both", "categories": [1], "unknownField": 42,
+				"chapters": [
+					{"url": "/chapter/1", "name": "Chapter 1", "read": true},
+					{"url": "/chapter/2", "name": "Chapter 2", "lastPageRead": 2},
+					{"url": "/chapter/3", "name": "Chapter 3"}
+				],
+				"history": [{"url": "/chapter/1", "lastRead": 1000, "readDuration": 10}]
+			}],
+			"backupCategories": [{"name": "Reading", "order": 1}],
+			"backupSources": [{"sourceId": 1, "name": "Source"}]
+		}
+	}`)
+	other := []byte(`{
+		"backupManga": [
+			{
+				"source": 1, "url": "/manga/1", "title": "Both (other)", "favorite": true, "categories": [5],
+				"chapters": [
+					{"url": "/chapter/1", "name": "Chapter 1", "lastPageRead": 9},
+					{"url": "/chapter/2", "name": "Chapter 2", "read": true},
+					{"url": "/chapter/3", "name": "Chapter 3", "lastPageRead": 3},
+					{"url": "/chapter/4", "name": "Chapter 4", "read": true}
+				],
+				"history": [{"url": "/chapter/1", "lastRead": 2000, "readDuration": 5}, {"url": "/chapter/4", "lastRead": 3000}]
+			},
+			{"source": 2, "url": "/manga/1", "title": "Only other", "categories": [1]}
+		],
+		"backupCategories": [{"name": "Reading", "order": 5}, {"name": "Later", "order": 1}],
+		"backupSources": [{"sourceId": 1, "name": "Renamed"}, {"sourceId": 2, "name": "Other source"}]
+	}`)
+
+	merged, stats, err := Merge(base, other)
+	require.NoError(t, err)
+	assert.Equal(t, MergeStats{MangaAdded: 1, MangaMerged: 1, ChaptersAdded: 1, ChaptersUpdated: 2, CategoriesAdded: 1}, *stats)
+
+	// The merged library keeps the envelope and compression of the base
+	assert.True(t, bytes.HasPrefix(merged, gzipMagic))
+	plain, err := uncompress(merged)
+	require.NoError(t, err)
+	assert.Contains(t, string(plain), `"deviceId":"pixel"`)
+	assert.Contains(t, string(plain), `"unknownField":42`)
+	assert.Contains(t, string(plain), `"name":"Source"`)
+	assert.NotContains(t, string(plain), `"name":"Renamed"`)
+	assert.Contains(t, string(plain), `"name":"Other source"`)
+
+	got, err := Decode(merged)
+	require.NoError(t, err)
+	require.Len(t, got.Categories, 2)
+	assert.Equal(t, Category{Name: "Later", Order: 2}, got.Categories[1])
+
+	require.Len(t, got.Manga, 2)
+	both := got.Manga[0]
+	assert.Equal(t, "Both", both.Title)
+	assert.True(t, both.Favorite)
+	assert.Equal(t, []int64{1}, both.Categories)
+	require.Len(t, both.Chapters, 4)
+	assert.True(t, both.Chapters[0].Read)
+	assert.Zero(t, both.Chapters[0].LastPageRead)
+	assert.True(t, both.Chapters[1].Read)
+	assert.Equal(t, int64(3), both.Chapters[2].LastPageRead)
+	assert.Equal(t, "Chapter 4", both.Chapters[3].Name)
+	require.Len(t, both.History, 2)
+	assert.Equal(t, History{URL: "/chapter/1", LastRead: 2000, ReadDuration: 10}, both.History[0])
+
+	// Categories of added entries are mapped to the orders of the base library
+	assert.Equal(t, "Only other", got.Manga[1].Title)
+	assert.Equal(t, []int64{2}, got.Manga[1].Categories)
+}
@@ -0,0 +1,404 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+// MergeStats counts what Merge took over from the other library.
+type MergeStats struct {
+	MangaAdded      int `json:"manga_added"`      // Entries only in the other library
+	MangaMerged     int `json:"manga_merged"`     // Entries in both libraries
+	ChaptersAdded   int `json:"chapters_added"`   // Chapters of merged entries only in the other library
+	ChaptersUpdated int `json:"chapters_updated"` // Chapters the other library read or read further
+	CategoriesAdded int `json:"categories_added"` // Categories only in the other library
+}
+
+// rawObject is a JSON object decoded only one level deep, so it can be rewritten
+// without losing fields this package does not know.
+type rawObject map[string]json.RawMessage
+
+// Merge adds the library of other to the library of base. Entries only in other
+// are added with their categories, for entries in both the chapters are combined
+// with the furthest progress and the latest history. Base wins for everything
+// else. The result has the shape of base, an upload keeps its envelope and its
+// compression.
+func Merge(base []byte, other []byte) ([]byte, *MergeStats, error) {
+	plain, err := uncompress(base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var envelope rawObject
+	if err := json.Unmarshal(plain, &envelope); err != nil {
+		return nil, nil, errors.Wrap(err, "could not decode backup")
+	}
+	document := envelope
+	inner, ok := envelope["backup"]
+	wrapped := ok && string(inner) != "null"
+	if wrapped {
+		document = rawObject{}
+		if err := json.Unmarshal(inner, &document); err != nil {
+			return nil, nil, errors.Wrap(err, "could not decode backup")
+		}
+	}
+
+	otherDocument, err := rawBackup(other)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := &MergeStats{}
+	orders, err := mergeCategories(document, otherDocument, stats)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := mergeManga(document, otherDocument, orders, stats); err != nil {
+		return nil, nil, err
+	}
+	// Added manga may come from sources the base library never used
+	if err := mergeList(document, otherDocument, "backupSources", "sourceId"); err != nil {
+		return nil, nil, err
+	}
+
+	result := any(document)
+	if wrapped {
+		if envelope["backup"], err = json.Marshal(document); err != nil {
+			return nil, nil, errors.Wrap(err, "could not encode backup")
+		}
+		result = envelope
+	}
+
+	merged, err := json.Marshal(result)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not encode backup")
+	}
+	if bytes.HasPrefix(base, gzipMagic) {
+		if merged, err = Compress(merged); err != nil {
+			return nil, nil, err
+		}
+	}
+	return merged, stats, nil
+}
+
+// decodeList decodes a list field of a document, a missing field is an empty list.
+func decodeList(document rawObject, field string) ([]rawObject, error) {
+	var list []rawObject
+	if raw, ok := document[field]; ok {
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, errors.Wrap(err, "could not decode %s", field)
+		}
+	}
+	return list, nil
+}
+
+func encodeList(document rawObject, field string, list []rawObject) error {
+	if list == nil {
+		list = []rawObject{}
+	}
+	raw, err := json.Marshal(list)
+	if err != nil {
+		return errors.Wrap(err, "could not encode %s", field)
+	}
+	document[field] = raw
+	return nil
+}
+
+// decodeInto decodes the known fields of a raw object into v.
+func decodeInto(object rawObject, v any) error {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// set replaces a field of a raw object.
+func set(object rawObject, field string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "could not encode %s", field)
+	}
+	object[field] = raw
+	return nil
+}
+
+// mergeCategories adds the categories missing in document by name and returns
+// the order each category of other has in document, manga reference categories
+// by their order.
+func mergeCategories(document rawObject, other rawObject, stats *MergeStats) (map[int64]int64, error) {
+	categories, err := decodeList(document, "backupCategories")
+	if err != nil {
+		return nil, err
+	}
+	otherCategories, err := decodeList(other, "backupCategories")
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]int64{}
+	var maxOrder int64
+	for _, raw := range categories {
+		var category Category
+		if err := decodeInto(raw, &category); err != nil {
+			return nil, errors.Wrap(err, "could not decode category")
+		}
+		byName[category.Name] = category.Order
+		maxOrder = max(maxOrder, category.Order)
+	}
+
+	orders := map[int64]int64{}
+	for _, raw := range otherCategories {
+		var category Category
+		if err := decodeInto(raw, &category); err != nil {
+			return nil, errors.Wrap(err, "could not decode category")
+		}
+		if order, ok := byName[category.Name]; ok {
+			orders[category.Order] = order
+			continue
+		}
+
+		maxOrder++
+		if err := set(raw, "order", maxOrder); err != nil {
+			return nil, err
+		}
+		byName[category.Name] = maxOrder
+		orders[category.Order] = maxOrder
+		categories = append(categories, raw)
+		stats.CategoriesAdded++
+	}
+
+	return orders, encodeList(document, "backupCategories", categories)
+}
+
+// mangaKey identifies an entry across libraries, the same URL can exist on several sources.
+func mangaKey(manga Manga) string {
+	return fmt.Sprintf("%d\x00%s", manga.Source, manga.URL)
+}
+
+func mergeManga(document rawObject, other rawObject, orders map[int64]int64, stats *MergeStats) error {
+	manga, err := decodeList(document, "backupManga")
+	if err != nil {
+		return err
+	}
+	otherManga, err := decodeList(other, "backupManga")
+	if err != nil {
+		return err
+	}
+
+	index := map[string]int{}
+	for i, raw := range manga {
+		var entry Manga
+		if err := decodeInto(raw, &entry); err != nil {
+			return errors.Wrap(err, "could not decode manga")
+		}
+		index[mangaKey(entry)] = i
+	}
+
+	for _, raw := range otherManga {
+		var entry Manga
+		if err := decodeInto(raw, &entry); err != nil {
+			return errors.Wrap(err, "could not decode manga")
+		}
+
+		categories := make([]int64, 0, len(entry.Categories))
+		for _, order := range entry.Categories {
+			if mapped, ok := orders[order]; ok {
+				categories = append(categories, mapped)
+			}
+		}
+
+		i, ok := index[mangaKey(entry)]
+		if !ok {
+			if len(entry.Categories) > 0 {
+				if err := set(raw, "categories", categories); err != nil {
+					return err
+				}
+			}
+			index[mangaKey(entry)] = len(manga)
+			manga = append(manga, raw)
+			stats.MangaAdded++
+			continue
+		}
+
+		if err := mergeEntry(manga[i], entry, raw, categories, stats); err != nil {
+			return err
+		}
+		stats.MangaMerged++
+	}
+
+	return encodeList(document, "backupManga", manga)
+}
+
+// mergeEntry combines an entry of both libraries into target, the entry of the base library.
+func mergeEntry(target rawObject, entry Manga, raw rawObject, categories []int64, stats *MergeStats) error {
+	var current Manga
+	if err := decodeInto(target, &current); err != nil {
+		return errors.Wrap(err, "could not decode manga")
+	}
+
+	if entry.Favorite && !current.Favorite {
+		if err := set(target, "favorite", true); err != nil {
+			return err
+		}
+	}
+
+	union := slices.Clone(current.Categories)
+	for _, order := range categories {
+		if !slices.Contains(union, order) {
+			union = append(union, order)
+		}
+	}
+	if len(union) > len(current.Categories) {
+		if err := set(target, "categories", union); err != nil {
+			return err
+		}
+	}
+
+	if err := mergeChapters(target, raw, stats); err != nil {
+		return err
+	}
+	return mergeHistory(target, raw)
+}
+
+// mergeChapters adds the chapters missing in target and takes over read chapters and further progress.
+func mergeChapters(target rawObject, other rawObject, stats *MergeStats) error {
+	chapters, err := decodeList(target, "chapters")
+	if err != nil {
+		return err
+	}
+	otherChapters, err := decodeList(other, "chapters")
+	if err != nil {
+		return err
+	}
+
+	index := map[string]int{}
+	for i, raw := range chapters {
+		var chapter Chapter
+		if err := decodeInto(raw, &chapter); err != nil {
+			return errors.Wrap(err, "could not decode chapter")
+		}
+		index[chapter.URL] = i
+	}
+
+	for _, raw := range otherChapters {
+		var chapter Chapter
+		if err := decodeInto(raw, &chapter); err != nil {
+			return errors.Wrap(err, "could not decode chapter")
+		}
+
+		i, ok := index[chapter.URL]
+		if !ok {
+			index[chapter.URL] = len(chapters)
+			chapters = append(chapters, raw)
+			stats.ChaptersAdded++
+			continue
+		}
+
+		var current Chapter
+		if err := decodeInto(chapters[i], &current); err != nil {
+			return errors.Wrap(err, "could not decode chapter")
+		}
+		if current.Read {
+			continue
+		}
+		if chapter.Read {
+			if err := set(chapters[i], "read", true); err != nil {
+				return err
+			}
+			stats.ChaptersUpdated++
+		} else if chapter.LastPageRead > current.LastPageRead {
+			if err := set(chapters[i], "lastPageRead", chapter.LastPageRead); err != nil {
+				return err
+			}
+			stats.ChaptersUpdated++
+		}
+	}
+
+	return encodeList(target, "chapters", chapters)
+}
+
+// mergeHistory keeps the latest read time of every chapter.
+func mergeHistory(target rawObject, other rawObject) error {
+	history, err := decodeList(target, "history")
+	if err != nil {
+		return err
+	}
+	otherHistory, err := decodeList(other, "history")
+	if err != nil {
+		return err
+	}
+
+	index := map[string]int{}
+	for i, raw := range history {
+		var entry History
+		if err := decodeInto(raw, &entry); err != nil {
+			return errors.Wrap(err, "could not decode history")
+		}
+		index[entry.URL] = i
+	}
+
+	for _, raw := range otherHistory {
+		var entry History
+		if err := decodeInto(raw, &entry); err != nil {
+			return errors.Wrap(err, "could not decode history")
+		}
+
+		i, ok := index[entry.URL]
+		if !ok {
+			index[entry.URL] = len(history)
+			history = append(history, raw)
+			continue
+		}
+
+		var current History
+		if err := decodeInto(history[i], &current); err != nil {
+			return errors.Wrap(err, "could not decode history")
+		}
+		if entry.LastRead > current.LastRead {
+			if err := set(history[i], "lastRead", entry.LastRead); err != nil {
+				return err
+			}
+		}
+		if entry.ReadDuration > current.ReadDuration {
+			if err := set(history[i], "readDuration", entry.ReadDuration); err != nil {
+				return err
+			}
+		}
+	}
+
+	return encodeList(target, "history", history)
+}
+
+// mergeList adds the elements of a list in other whose key is missing in document.
+func mergeList(document rawObject, other rawObject, field string, key string) error {
+	if _, ok := other[field]; !ok {
+		return nil
+	}
+
+	list, err := decodeList(document, field)
+	if err != nil {
+		return err
+	}
+	otherList, err := decodeList(other, field)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, raw := range list {
+		seen[string(raw[key])] = true
+	}
+	for _, raw := range otherList {
+		if !seen[string(raw[key])] {
+			seen[string(raw[key])] = true
+			list = append(list, raw)
+		}
+	}
+
+	return encodeList(document, field, list)
+}
@@ -46,6 +46,14 @@ var configTemplate = `# config.toml
    # Default: ""
    #base_url = ""
  
+   # Comma-separated origins of other sites allowed to call the API from a
+   # browser with the user's cookies, e.g. "https://reader.example.com".
+   # Defaults to the origin of base_url if it is a full URL, otherwise only
+   # the web UI itself is allowed.
+   # Optional.
+   # Default: ""
+   #allowed_origins = ""
+ 
  [database]
    # Database type to use.
    # Supported: "sqlite", "postgres"
@@ -168,6 +176,217 @@ var configTemplate = `# config.toml
    # Whether to use soft delete (archive) instead of hard delete
    # Default: false (use hard delete)
    use_soft_delete = false
+ 
+ [sync]
+   # Maximum accepted size of an uploaded library in bytes.
+   # Uploads above this size are rejected with 413.
+   # Default: 104857600 (100 MiB)
+   max_payload_size = 104857600
+ 
+   # Number of days to keep entries of the sync activity log.
+   # Default: 90
+   event_retention_days = 90
+ 
+   # Maximum number of named slots per user, in addition to the default slot.
+   # Default: 5
+   max_slots = 5
+ 
+   # Maximum size in bytes of all libraries of a user together, over all slots.
+   # Uploads that would exceed it are rejected with 413. 0 disables the limit.
+   # Default: 262144000 (250 MiB)
+   max_storage_per_user = 262144000
+ 
+   # Number of replaced versions kept per slot, so an upload can be undone.
+   # The history does not count towards max_storage_per_user. 0 disables it.
+   # Default: 5
+   history_versions = 5
+ 
+ [lockout]
+   # Failed logins or API token attempts, per client IP and per credential,
+   # before further attempts are locked out.
+   # Default: 10
+   threshold = 10
+ 
+   # Time window in seconds in which failures are counted.
+   # Default: 600 (10 minutes)
+   window_seconds = 600
+ 
+   # Duration in seconds of the first lockout. Every further lockout within a
+   # day doubles the duration.
+   # Default: 60 (1 minute)
+   base_duration_seconds = 60
+ 
+   # Upper bound of the lockout duration in seconds.
+   # Default: 3600 (1 hour)
+   max_duration_seconds = 3600
+ 
+ [oidc]
+   # Enable login with an OpenID Connect provider besides the bookmark UUID.
+   # Default: false
+   enabled = false
+ 
+   # Issuer URL of the provider, it must serve /.well-known/openid-configuration.
+   # Example: "https://auth.example.com/realms/main"
+   issuer = ""
+ 
+   # Client credentials registered at the provider.
+   client_id = ""
+   client_secret = ""
+ 
+   # Public URL of the callback, register it at the provider as redirect URI.
+   # Example: "https://shiori.example.com/api/auth/oidc/callback"
+   redirect_url = ""
+ 
+   # Comma-separated scopes requested besides "openid".
+   # Default: "email"
+   scopes = "email"
+ 
+   # Create a new user on the first login of an identity that is not linked to one.
+   # Without it, users link their identity from the profile while logged in.
+   # Closed registration and [registration] max_users apply to these users.
+   # Default: false
+   auto_provision = false
+ 
+ [forward_auth]
+   # Trust a username header set by a reverse proxy such as Authelia or Authentik
+   # for web UI access. Users are created on the first request of a username.
+   # API tokens of devices are not affected.
+   # Default: false
+   enabled = false
+ 
+   # Header carrying the username.
+   # Default: "Remote-User"
+   user_header = "Remote-User"
+ 
+   # Comma-separated IPs or CIDRs of the proxies the header is accepted from.
+   # The header is ignored on requests from any other address. Client addresses
+   # in X-Forwarded-For and X-Real-IP are only accepted from these proxies as
+   # well, set them behind a reverse proxy even without forward auth.
+   # Example: "172.16.0.0/12,10.0.0.5"
+   # Default: ""
+   trusted_proxies = ""
+ 
+ [webauthn]
+   # Domain the web UI is served from, without scheme and port. Passkeys are
+   # bound to it and stay disabled while it is empty.
+   # Example: "shiori.example.com"
+   # Default: ""
+   rp_id = ""
+ 
+   # Name shown by the browser when registering or using a passkey.
+   # Default: "Shiori"
+   rp_display_name = "Shiori"
+ 
+   # Comma-separated origins the web UI is served from.
+   # Example: "https://shiori.example.com"
+   # Default: ""
+   rp_origins = ""
+ 
+ [password]
+   # Accounts can protect their bookmark login with a password or PIN.
+   # Minimum length of a password.
+   # Default: 4
+   min_length = 4
+ 
+   # Argon2id parameters for hashing passwords. Raising them upgrades existing
+   # hashes on the next successful login.
+   # Memory in KiB.
+   # Default: 65536
+   memory = 65536
+ 
+   # Default: 1
+   iterations = 1
+ 
+   # Default: 2
+   parallelism = 2
+ 
+   # Default: 16
+   salt_length = 16
+ 
+   # Default: 32
+   key_length = 32
+ 
+ [two_factor]
+   # Name shown for Shiori in authenticator apps.
+   # Default: "Shiori"
+   issuer = "Shiori"
+ 
+   # Key the TOTP secrets are encrypted with, session_secret is used when empty.
+   # Changing it makes enrolled TOTP secrets unreadable, reset them with --reset-2fa.
+   # Default: ""
+   encryption_key = ""
+ 
+ [retention]
+   # Accounts are deleted after this many days without a sync, every sync
+   # moves the deletion date out again.
+   # Default: 60
+   days = 60
+ 
+   # Days before the expiry a warning is sent to the notification channels of
+   # the account. Set to 0 to send no warnings.
+   # Default: 7
+   warning_days = 7
+ 
+   # Days an expired account stays read-only, so its data can still be
+   # downloaded, before it is deleted. Set to 0 to delete it on expiry.
+   # Default: 0
+   grace_days = 0
+ 
+ [admin]
+   # Comma-separated account IDs of the users granted the admin role on startup.
+   # The account ID is shown on the profile page, never use the bookmark here.
+   # Admins manage accounts and see the server configuration and logs. The
+   # role can also be granted with --grant-admin.
+   # Default: ""
+   users = ""
+ 
+ [registration]
+   # Who can create an account: "open" for anyone, "invite" for holders of an
+   # invite code created by an admin, "closed" for nobody. Closed registration
+   # and max_users also stop new OpenID Connect and forward auth users, invite
+   # codes and the proof of work are not asked of them.
+   # Default: "open"
+   mode = "open"
+ 
+   # Number of accounts at which registration stops. Set to 0 for no limit.
+   # Accounts are counted, not reserved: registrations arriving at the same
+   # time can exceed the limit by a few.
+   # Default: 0
+   max_users = 0
+ 
+   # Leading zero bits of the SHA-256 proof of work a client has to compute
+   # before registering, slowing down mass registration. Every bit doubles
+   # the work, 20 takes about a second in a browser. Set to 0 to disable it.
+   # Default: 0
+   proof_of_work_bits = 0
+ 
+ [audit]
+   # Days to keep the security audit log of logins, lockouts, token resets,
+   # session revocations and admin actions. Set to 0 to keep it forever.
+   # Default: 365
+   retention_days = 365
+ 
+ [tokens]
+   # Let mobile clients exchange an API token at /api/auth/token for a
+   # short-lived access token and a refresh token, which is replaced on every
+   # use. A refresh token used twice revokes every token issued from it.
+   # Default: false
+   enabled = false
+ 
+   # Minutes an access token is valid. It is checked without a database
+   # lookup, so a revoked API token keeps working until its access tokens
+   # expire. Disabled, deleted and merged accounts lose access at once.
+   # Default: 15
+   access_token_minutes = 15
+ 
+   # Days an unused refresh token stays valid.
+   # Default: 30
+   refresh_token_days = 30
+ 
+   # Key the access tokens are signed with, session_secret is used when empty.
+   # Changing it invalidates every access token, clients refresh them.
+   # Default: ""
+   signing_key = ""
  `
 
 func generateRandomString(length int) (string, error) {
@@ -288,9 +507,10 @@ func (c *AppConfig) defaults() {
 		CheckForUpdates: true,
 		SessionSecret:   "secret-session-key", // Will be overwritten by generated if not in file
 		Server: domain.ServerConfig{
-			Host:    "127.0.0.1",
-			Port:    8282,
-			BaseURL: "",
+			Host:           "127.0.0.1",
+			Port:           8282,
+			BaseURL:        "",
+			AllowedOrigins: "",
 		},
 		Database: domain.DatabaseConfig{
 			Type: "sqlite", // Default database type
@@ -328,6 +548,68 @@ func (c *AppConfig) defaults() {
 			DeleteOrphanedUUIDs: true,
 			UseSoftDelete:       false,
 		},
+		Sync: domain.SyncConfig{
+			MaxPayloadSize:     100 << 20, // 100 MiB
+			EventRetentionDays: 90,
+			MaxSlots:           5,
+			MaxStoragePerUser:  250 << 20, // 250 MiB
+			HistoryVersions:    5,
+		},
+		Lockout: domain.LockoutConfig{
+			Threshold:           10,
+			WindowSeconds:       600,  // 10 minutes
+			BaseDurationSeconds: 60,   // 1 minute
+			MaxDurationSeconds:  3600, // 1 hour
+		},
+		OIDC: domain.OIDCConfig{
+			Enabled:       false,
+			Scopes:        "email",
+			AutoProvision: false,
+		},
+		ForwardAuth: domain.ForwardAuthConfig{
+			Enabled:        false,
+			UserHeader:     "Remote-User",
+			TrustedProxies: "",
+		},
+		WebAuthn: domain.WebAuthnConfig{
+			RPID:          "",
+			RPDisplayName: "Shiori",
+			RPOrigins:     "",
+		},
+		Password: domain.PasswordConfig{
+			MinLength:   4,
+			Memory:      64 * 1024,
+			Iterations:  1,
+			Parallelism: 2,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		TwoFactor: domain.TwoFactorConfig{
+			Issuer:        "Shiori",
+			EncryptionKey: "",
+		},
+		Retention: domain.RetentionConfig{
+			Days:        60,
+			WarningDays: 7,
+			GraceDays:   0,
+		},
+		Admin: domain.AdminConfig{
+			Users: "",
+		},
+		Registration: domain.RegistrationConfig{
+			Mode:            "open",
+			MaxUsers:        0,
+			ProofOfWorkBits: 0,
+		},
+		Audit: domain.AuditConfig{
+			RetentionDays: 365,
+		},
+		Tokens: domain.TokenConfig{
+			Enabled:            false,
+			AccessTokenMinutes: 15,
+			RefreshTokenDays:   30,
+			SigningKey:         "",
+		},
 	}
 }
 
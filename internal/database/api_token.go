@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+type APITokenRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewAPITokenRepo(log logger.Logger, db *DB) domain.APITokenRepo {
+	return &APITokenRepo{
+		log: log.With().Str("repo", "api_token").Logger(),
+		db:  db,
+	}
+}
+
+// Store inserts a new token.
+func (r *APITokenRepo) Store(ctx context.Context, token *domain.APIToken) error {
+	if err := r.db.Get().WithContext(ctx).Create(token).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to store api token")
+		return errors.Wrap(err, "failed to store api token")
+	}
+
+	return nil
+}
+
+// FindByLookupID returns the token with the given lookup ID, nil if not found.
+func (r *APITokenRepo) FindByLookupID(ctx context.Context, lookupID string) (*domain.APIToken, error) {
+	var token domain.APIToken
+	result := r.db.Get().WithContext(ctx).Where("lookup_id = ?", lookupID).First(&token)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.log.Error().Err(result.Error).Str("lookup_id", lookupID).Msg("Failed to find api token by lookup ID")
+		return nil, errors.Wrap(result.Error, "failed to find api token by lookup ID")
+	}
+
+	return &token, nil
+}
+
+// FindByUser returns the tokens of a user, newest first.
+func (r *APITokenRepo) FindByUser(ctx context.Context, userHashedUUID string) ([]domain.APIToken, error) {
+	var tokens []domain.APIToken
+	result := r.db.Get().WithContext(ctx).
+		Where("user_hashed_uuid = ?", userHashedUUID).
+		Order("created_at desc").
+		Find(&tokens)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Msg("Failed to find api tokens of user")
+		return nil, errors.Wrap(result.Error, "failed to find api tokens of user")
+	}
+
+	return tokens, nil
+}
+
+// Delete removes a token, scoped to its owner.
+func (r *APITokenRepo) Delete(ctx context.Context, userHashedUUID string, id int64) error {
+	result := r.db.Get().WithContext(ctx).
+		Where("id = ? AND user_hashed_uuid = ?", id, userHashedUUID).
+		Delete(&domain.APIToken{})
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Int64("token_id", id).Msg("Failed to delete api token")
+		return errors.Wrap(result.Error, "failed to delete api token")
+	}
+	if result.RowsAffected == 0 {
+		return domain.ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+// RecordUse sets the last used time and address of a token.
+func (r *APITokenRepo) RecordUse(ctx context.Context, id int64, usedAt time.Time, ip string) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.APIToken{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_used_at": usedAt,
+			"last_used_ip": ip,
+		})
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Int64("token_id", id).Msg("Failed to record api token use")
+		return errors.Wrap(result.Error, "failed to record api token use")
+	}
+
+	return nil
+}
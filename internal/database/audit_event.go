@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+type AuditEventRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewAuditEventRepo(log logger.Logger, db *DB) domain.AuditEventRepo {
+	return &AuditEventRepo{
+		log: log.With().Str("repo", "audit_event").Logger(),
+		db:  db,
+	}
+}
+
+// Store inserts a new audit event.
+func (r *AuditEventRepo) Store(ctx context.Context, event domain.AuditEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	result := r.db.Get().WithContext(ctx).Create(&event)
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Str("action", string(event.Action)).Msg("Failed to store audit event")
+		return errors.Wrap(result.Error, "failed to store audit event")
+	}
+
+	return nil
+}
+
+// Find retrieves audit events matching the query parameters, newest first.
+func (r *AuditEventRepo) Find(ctx context.Context, params domain.AuditEventQueryParams) ([]domain.AuditEvent, int, error) {
+	var events []domain.AuditEvent
+	var totalCount int64
+
+	db := r.db.Get().WithContext(ctx).Model(&domain.AuditEvent{})
+
+	if params.UserID != "" {
+		db = db.Where("actor_id = ? OR target_id = ?", params.UserID, params.UserID)
+	}
+	if params.ActorID != "" {
+		db = db.Where("actor_id = ?", params.ActorID)
+	}
+	if params.TargetID != "" {
+		db = db.Where("target_id = ?", params.TargetID)
+	}
+	if params.Action != "" {
+		db = db.Where("action = ?", params.Action)
+	}
+
+	if err := db.Count(&totalCount).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to count audit events")
+		return nil, 0, errors.Wrap(err, "failed to count audit events")
+	}
+
+	db = db.Order("created_at desc").Order("id desc")
+
+	if params.Limit > 0 {
+		db = db.Limit(int(params.Limit))
+	}
+	if params.Offset > 0 {
+		db = db.Offset(int(params.Offset))
+	}
+
+	if err := db.Find(&events).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to find audit events")
+		return nil, 0, errors.Wrap(err, "failed to find audit events")
+	}
+
+	return events, int(totalCount), nil
+}
+
+// DeleteOlderThan removes audit events created before the given time.
+func (r *AuditEventRepo) DeleteOlderThan(ctx context.Context, before time.Time) (int, error) {
+	result := r.db.Get().WithContext(ctx).
+		Where("created_at < ?", before).
+		Delete(&domain.AuditEvent{})
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Time("before", before).Msg("Failed to delete old audit events")
+		return 0, errors.Wrap(result.Error, "failed to delete old audit events")
+	}
+
+	r.log.Debug().Int64("deleted_count", result.RowsAffected).Time("before", before).Msg("Deleted old audit events")
+	return int(result.RowsAffected), nil
+}
@@ -120,6 +120,18 @@ func (db *DB) Open() error {
 		&domain.User{},
 		&SyncData{},           // Add the new SyncData model for migration (Removed leading '+')
 		&domain.ProfileUUID{}, // Add the ProfileUUID model for migration
+		&SyncSlotData{},
+		&SyncHistoryData{},
+		&domain.SyncEvent{},
+		&domain.ReadingEvent{},
+		&domain.Share{},
+		&domain.APIToken{},
+		&domain.OIDCIdentity{},
+		&domain.ProxyIdentity{},
+		&domain.WebAuthnCredential{},
+		&domain.RecoveryCode{},
+		&domain.AuditEvent{},
+		&domain.Invite{},
 		// Add any other domain models that need tables here in the future
 	)
 	if err != nil {
@@ -128,6 +140,11 @@ func (db *DB) Open() error {
 	}
 	db.log.Info().Msg("Database auto-migrations completed.")
 
+	if err := backfillUserPublicIDs(db.handler); err != nil {
+		db.log.Error().Err(err).Msg("Failed to assign public IDs to existing users")
+		return err
+	}
+
 	return nil
 }
 
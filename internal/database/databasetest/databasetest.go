@@ -0,0 +1,57 @@
+// Package databasetest opens throwaway databases for tests, so the repositories
+// and the services built on them run against the real queries instead of
+// hand-written fakes.
+package databasetest
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/config"
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/stretchr/testify/require"
+)
+
+// New opens a migrated SQLite database in a temporary directory, it is closed
+// when the test ends. The logger writes to stderr only.
+func New(t testing.TB) (*database.DB, logger.Logger) {
+	t.Helper()
+
+	cfg := config.New(t.TempDir(), "test").Config
+	cfg.Logging.Path = "" // Log to stderr only, not into the package directory
+	cfg.Database.Type = "sqlite"
+	log := logger.New(cfg)
+
+	db, err := database.NewDB(cfg, log)
+	require.NoError(t, err)
+	require.NoError(t, db.Open())
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db, log
+}
+
+// StoreUsers stores the users. The API token hash is unique, a missing one is
+// set to the bookmark.
+func StoreUsers(t testing.TB, repo domain.UserRepo, users ...domain.User) {
+	t.Helper()
+
+	for _, user := range users {
+		if user.APITokenHash == "" {
+			user.APITokenHash = user.HashedUUID
+		}
+		require.NoError(t, repo.Store(context.Background(), user))
+	}
+}
+
+// AuditEvents returns every stored audit event, oldest first.
+func AuditEvents(t testing.TB, repo domain.AuditEventRepo) []domain.AuditEvent {
+	t.Helper()
+
+	events, _, err := repo.Find(context.Background(), domain.AuditEventQueryParams{})
+	require.NoError(t, err)
+	slices.Reverse(events)
+	return events
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+type InviteRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewInviteRepo(log logger.Logger, db *DB) domain.InviteRepo {
+	return &InviteRepo{
+		log: log.With().Str("repo", "invite").Logger(),
+		db:  db,
+	}
+}
+
+// Store inserts a new invite.
+func (r *InviteRepo) Store(ctx context.Context, invite *domain.Invite) error {
+	if err := r.db.Get().WithContext(ctx).Create(invite).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to store invite")
+		return errors.Wrap(err, "failed to store invite")
+	}
+
+	return nil
+}
+
+// FindAll returns every invite, newest first.
+func (r *InviteRepo) FindAll(ctx context.Context) ([]domain.Invite, error) {
+	var invites []domain.Invite
+	if err := r.db.Get().WithContext(ctx).Order("created_at desc").Order("id desc").Find(&invites).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to find invites")
+		return nil, errors.Wrap(err, "failed to find invites")
+	}
+
+	return invites, nil
+}
+
+// Revoke marks an invite revoked, revoked invites are not found again.
+func (r *InviteRepo) Revoke(ctx context.Context, id int64, at time.Time) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.Invite{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Int64("invite_id", id).Msg("Failed to revoke invite")
+		return errors.Wrap(result.Error, "failed to revoke invite")
+	}
+	if result.RowsAffected == 0 {
+		return domain.ErrInviteNotFound
+	}
+
+	return nil
+}
+
+// Consume counts a use of the invite in a single conditional update, so
+// concurrent registrations cannot use an invite more often than allowed.
+func (r *InviteRepo) Consume(ctx context.Context, codeHash string, now time.Time) (bool, error) {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.Invite{}).
+		Where("code_hash = ? AND uses < max_uses AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", codeHash, now).
+		Update("uses", gorm.Expr("uses + 1"))
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Msg("Failed to consume invite")
+		return false, errors.Wrap(result.Error, "failed to consume invite")
+	}
+
+	return result.RowsAffected == 1, nil
+}
@@ -36,6 +36,9 @@ func (r *NotificationRepo) Find(ctx context.Context, params domain.NotificationQ
 	db := r.db.Get().WithContext(ctx).Model(&domain.Notification{})
 
 	// --- Apply Filtering (Example - adapt based on actual params usage) ---
+	if params.UserHashedUUID != nil {
+		db = db.Where("user_hashed_uuid = ?", *params.UserHashedUUID)
+	}
 	// if params.Search != "" {
 	//    db = db.Where("name LIKE ?", "%"+params.Search+"%") // Example search
 	// }
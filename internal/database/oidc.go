@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+type OIDCIdentityRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewOIDCIdentityRepo(log logger.Logger, db *DB) domain.OIDCIdentityRepo {
+	return &OIDCIdentityRepo{
+		log: log.With().Str("repo", "oidc_identity").Logger(),
+		db:  db,
+	}
+}
+
+// Store inserts a new identity.
+func (r *OIDCIdentityRepo) Store(ctx context.Context, identity *domain.OIDCIdentity) error {
+	if err := r.db.Get().WithContext(ctx).Create(identity).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to store oidc identity")
+		return errors.Wrap(err, "failed to store oidc identity")
+	}
+
+	return nil
+}
+
+// FindBySubject returns the identity of a subject at an issuer, nil if not found.
+func (r *OIDCIdentityRepo) FindBySubject(ctx context.Context, issuer string, subject string) (*domain.OIDCIdentity, error) {
+	var identity domain.OIDCIdentity
+	result := r.db.Get().WithContext(ctx).Where("issuer = ? AND subject = ?", issuer, subject).First(&identity)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.log.Error().Err(result.Error).Str("issuer", issuer).Msg("Failed to find oidc identity by subject")
+		return nil, errors.Wrap(result.Error, "failed to find oidc identity by subject")
+	}
+
+	return &identity, nil
+}
+
+// RecordLogin sets the last login time of an identity.
+func (r *OIDCIdentityRepo) RecordLogin(ctx context.Context, id int64, loginAt time.Time) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.OIDCIdentity{}).
+		Where("id = ?", id).
+		Update("last_login_at", loginAt)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Int64("identity_id", id).Msg("Failed to record oidc login")
+		return errors.Wrap(result.Error, "failed to record oidc login")
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+type ProxyIdentityRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewProxyIdentityRepo(log logger.Logger, db *DB) domain.ProxyIdentityRepo {
+	return &ProxyIdentityRepo{
+		log: log.With().Str("repo", "proxy_identity").Logger(),
+		db:  db,
+	}
+}
+
+// Store inserts a new identity.
+func (r *ProxyIdentityRepo) Store(ctx context.Context, identity *domain.ProxyIdentity) error {
+	if err := r.db.Get().WithContext(ctx).Create(identity).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to store proxy identity")
+		return errors.Wrap(err, "failed to store proxy identity")
+	}
+
+	return nil
+}
+
+// FindByUsername returns the identity of a proxy username, nil if not found.
+func (r *ProxyIdentityRepo) FindByUsername(ctx context.Context, username string) (*domain.ProxyIdentity, error) {
+	var identity domain.ProxyIdentity
+	result := r.db.Get().WithContext(ctx).Where("username = ?", username).First(&identity)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.log.Error().Err(result.Error).Msg("Failed to find proxy identity by username")
+		return nil, errors.Wrap(result.Error, "failed to find proxy identity by username")
+	}
+
+	return &identity, nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm/clause"
+)
+
+// readingEventBatchSize keeps the number of bind parameters per insert below the SQLite limit.
+const readingEventBatchSize = 500
+
+type ReadingEventRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewReadingEventRepo(log logger.Logger, db *DB) domain.ReadingEventRepo {
+	return &ReadingEventRepo{
+		log: log.With().Str("repo", "reading_event").Logger(),
+		db:  db,
+	}
+}
+
+// StoreMany inserts the events, events already recorded are skipped.
+func (r *ReadingEventRepo) StoreMany(ctx context.Context, events []domain.ReadingEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	result := r.db.Get().WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(&events, readingEventBatchSize)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Int("count", len(events)).Msg("Failed to store reading events")
+		return errors.Wrap(result.Error, "failed to store reading events")
+	}
+
+	return nil
+}
+
+// Find returns the reading events of a user within [from, to), oldest first.
+func (r *ReadingEventRepo) Find(ctx context.Context, userHashedUUID string, from, to *time.Time) ([]domain.ReadingEvent, error) {
+	var events []domain.ReadingEvent
+
+	db := r.db.Get().WithContext(ctx).Where("user_hashed_uuid = ?", userHashedUUID)
+	if from != nil {
+		db = db.Where("read_at >= ?", *from)
+	}
+	if to != nil {
+		db = db.Where("read_at < ?", *to)
+	}
+
+	if err := db.Order("read_at asc").Find(&events).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to find reading events")
+		return nil, errors.Wrap(err, "failed to find reading events")
+	}
+
+	return events, nil
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+type RecoveryCodeRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewRecoveryCodeRepo(log logger.Logger, db *DB) domain.RecoveryCodeRepo {
+	return &RecoveryCodeRepo{
+		log: log.With().Str("repo", "recovery_code").Logger(),
+		db:  db,
+	}
+}
+
+// Replace deletes the codes of the user and stores the new ones in one transaction.
+func (r *RecoveryCodeRepo) Replace(ctx context.Context, userHashedUUID string, codeHashes []string) error {
+	err := r.db.Get().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_hashed_uuid = ?", userHashedUUID).Delete(&domain.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]domain.RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, domain.RecoveryCode{UserHashedUUID: userHashedUUID, CodeHash: hash})
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(&codes).Error
+	})
+	if err != nil {
+		r.log.Error().Err(err).Str("hashed_uuid", userHashedUUID).Msg("Failed to replace recovery codes")
+		return errors.Wrap(err, "failed to replace recovery codes")
+	}
+
+	return nil
+}
+
+// Use marks the unused code with the hash as used. The condition on used_at
+// makes concurrent uses of the same code succeed only once.
+func (r *RecoveryCodeRepo) Use(ctx context.Context, userHashedUUID string, codeHash string) (bool, error) {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.RecoveryCode{}).
+		Where("user_hashed_uuid = ? AND code_hash = ? AND used_at IS NULL", userHashedUUID, codeHash).
+		Update("used_at", time.Now())
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Str("hashed_uuid", userHashedUUID).Msg("Failed to use recovery code")
+		return false, errors.Wrap(result.Error, "failed to use recovery code")
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// CountUnused returns the number of codes of the user that were not used yet.
+func (r *RecoveryCodeRepo) CountUnused(ctx context.Context, userHashedUUID string) (int, error) {
+	var count int64
+	if err := r.db.Get().WithContext(ctx).
+		Model(&domain.RecoveryCode{}).
+		Where("user_hashed_uuid = ? AND used_at IS NULL", userHashedUUID).
+		Count(&count).Error; err != nil {
+		r.log.Error().Err(err).Str("hashed_uuid", userHashedUUID).Msg("Failed to count recovery codes")
+		return 0, errors.Wrap(err, "failed to count recovery codes")
+	}
+
+	return int(count), nil
+}
+
+// DeleteByUser deletes all codes of the user.
+func (r *RecoveryCodeRepo) DeleteByUser(ctx context.Context, userHashedUUID string) error {
+	if err := r.db.Get().WithContext(ctx).Where("user_hashed_uuid = ?", userHashedUUID).Delete(&domain.RecoveryCode{}).Error; err != nil {
+		r.log.Error().Err(err).Str("hashed_uuid", userHashedUUID).Msg("Failed to delete recovery codes")
+		return errors.Wrap(err, "failed to delete recovery codes")
+	}
+
+	return nil
+}
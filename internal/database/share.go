@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+type ShareRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewShareRepo(log logger.Logger, db *DB) domain.ShareRepo {
+	return &ShareRepo{
+		log: log.With().Str("repo", "share").Logger(),
+		db:  db,
+	}
+}
+
+// Store inserts a new share.
+func (r *ShareRepo) Store(ctx context.Context, share *domain.Share) error {
+	if err := r.db.Get().WithContext(ctx).Create(share).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to store share")
+		return errors.Wrap(err, "failed to store share")
+	}
+
+	return nil
+}
+
+// FindByTokenHash returns the share for a token hash, nil if not found.
+func (r *ShareRepo) FindByTokenHash(ctx context.Context, tokenHash string) (*domain.Share, error) {
+	var share domain.Share
+	result := r.db.Get().WithContext(ctx).Where("token_hash = ?", tokenHash).First(&share)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.log.Error().Err(result.Error).Msg("Failed to find share by token")
+		return nil, errors.Wrap(result.Error, "failed to find share by token")
+	}
+
+	return &share, nil
+}
+
+// FindByUser returns the shares of a user without their snapshot data, newest first.
+func (r *ShareRepo) FindByUser(ctx context.Context, userHashedUUID string) ([]domain.Share, error) {
+	var shares []domain.Share
+	result := r.db.Get().WithContext(ctx).
+		Omit("snapshot").
+		Where("user_hashed_uuid = ?", userHashedUUID).
+		Order("created_at desc").
+		Find(&shares)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Msg("Failed to find shares of user")
+		return nil, errors.Wrap(result.Error, "failed to find shares of user")
+	}
+
+	return shares, nil
+}
+
+// FindSnapshot returns only the snapshot data of a share, nil if not found.
+func (r *ShareRepo) FindSnapshot(ctx context.Context, userHashedUUID string, id int64) ([]byte, error) {
+	var share domain.Share
+	result := r.db.Get().WithContext(ctx).
+		Select("snapshot").
+		Where("id = ? AND user_hashed_uuid = ?", id, userHashedUUID).
+		First(&share)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.log.Error().Err(result.Error).Int64("share_id", id).Msg("Failed to find share snapshot")
+		return nil, errors.Wrap(result.Error, "failed to find share snapshot")
+	}
+
+	return share.Snapshot, nil
+}
+
+// Revoke marks a share revoked. Revoking an already revoked share keeps the original time.
+func (r *ShareRepo) Revoke(ctx context.Context, userHashedUUID string, id int64) error {
+	db := r.db.Get().WithContext(ctx)
+
+	var count int64
+	if err := db.Model(&domain.Share{}).Where("id = ? AND user_hashed_uuid = ?", id, userHashedUUID).Count(&count).Error; err != nil {
+		r.log.Error().Err(err).Int64("share_id", id).Msg("Failed to find share to revoke")
+		return errors.Wrap(err, "failed to find share to revoke")
+	}
+	if count == 0 {
+		return domain.ErrShareNotFound
+	}
+
+	result := db.Model(&domain.Share{}).
+		Where("id = ? AND user_hashed_uuid = ? AND revoked_at IS NULL", id, userHashedUUID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Int64("share_id", id).Msg("Failed to revoke share")
+		return errors.Wrap(result.Error, "failed to revoke share")
+	}
+
+	return nil
+}
+
+// RecordAccess increments the access count and sets the last access time.
+func (r *ShareRepo) RecordAccess(ctx context.Context, id int64) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.Share{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"access_count":     gorm.Expr("access_count + 1"),
+			"last_accessed_at": time.Now(),
+		})
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Int64("share_id", id).Msg("Failed to record share access")
+		return errors.Wrap(result.Error, "failed to record share access")
+	}
+
+	return nil
+}
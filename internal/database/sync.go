@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"sort"
 	"time"
 
 	// sq "github.com/Masterminds/squirrel" // No longer needed
@@ -29,6 +30,37 @@ func (SyncData) TableName() string {
 	return "sync_data"
 }
 
+// SyncSlotData represents the structure of the 'sync_slots' table, holding the named slots of a user.
+type SyncSlotData struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement;column:id"`
+	UserAPIKey string    `gorm:"column:user_api_key;uniqueIndex:idx_sync_slot_name"`
+	Name       string    `gorm:"column:name;uniqueIndex:idx_sync_slot_name"`
+	Data       []byte    `gorm:"column:data"`
+	DataETag   string    `gorm:"column:data_etag"`
+	CreatedAt  time.Time `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt  time.Time `gorm:"column:updated_at;autoUpdateTime"`
+}
+
+// TableName specifies the table name for GORM.
+func (SyncSlotData) TableName() string {
+	return "sync_slots"
+}
+
+// SyncHistoryData represents the structure of the 'sync_history' table, holding replaced versions of the slots of a user.
+type SyncHistoryData struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement;column:id"`
+	UserAPIKey string    `gorm:"column:user_api_key;index:idx_sync_history_slot,priority:1"`
+	Slot       string    `gorm:"column:slot;index:idx_sync_history_slot,priority:2"`
+	Data       []byte    `gorm:"column:data"`
+	DataETag   string    `gorm:"column:data_etag"`
+	ReplacedAt time.Time `gorm:"column:replaced_at"`
+}
+
+// TableName specifies the table name for GORM.
+func (SyncHistoryData) TableName() string {
+	return "sync_history"
+}
+
 func NewSyncRepo(log logger.Logger, db *DB) domain.SyncRepo {
 	return &SyncRepo{
 		log: log.With().Str("repo", "sync").Logger(), // Changed module name for clarity
@@ -41,14 +73,22 @@ type SyncRepo struct {
 	db  *DB
 }
 
+// slotQuery scopes a query to the row holding the given slot of a user.
+// The default slot lives in sync_data, named slots in sync_slots.
+func (r *SyncRepo) slotQuery(ctx context.Context, apiKey string, slot string) *gorm.DB {
+	db := r.db.Get().WithContext(ctx)
+	if slot == domain.DefaultSyncSlot {
+		return db.Model(&SyncData{}).Where("user_api_key = ?", apiKey)
+	}
+	return db.Model(&SyncSlotData{}).Where("user_api_key = ? AND name = ?", apiKey, slot)
+}
+
 // GetSyncDataETag retrieves only the ETag for a given API key.
-func (r *SyncRepo) GetSyncDataETag(ctx context.Context, apiKey string) (*string, error) {
+func (r *SyncRepo) GetSyncDataETag(ctx context.Context, apiKey string, slot string) (*string, error) {
 	var syncData SyncData
-	result := r.db.Get().WithContext(ctx).
-		Model(&SyncData{}).  // Specify the model
+	result := r.slotQuery(ctx, apiKey, slot).
 		Select("data_etag"). // Select only the ETag field
-		Where("user_api_key = ?", apiKey).
-		First(&syncData) // Fetch the record
+		Take(&syncData)      // Fetch the record
 
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
@@ -63,11 +103,11 @@ func (r *SyncRepo) GetSyncDataETag(ctx context.Context, apiKey string) (*string,
 }
 
 // GetSyncDataAndETag retrieves both the data and ETag.
-func (r *SyncRepo) GetSyncDataAndETag(ctx context.Context, apiKey string) ([]byte, *string, error) {
+func (r *SyncRepo) GetSyncDataAndETag(ctx context.Context, apiKey string, slot string) ([]byte, *string, error) {
 	var syncData SyncData
-	result := r.db.Get().WithContext(ctx).
-		Where("user_api_key = ?", apiKey).
-		First(&syncData) // Fetch the full record
+	result := r.slotQuery(ctx, apiKey, slot).
+		Select("data", "data_etag").
+		Take(&syncData) // Fetch the full record
 
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
@@ -78,11 +118,16 @@ func (r *SyncRepo) GetSyncDataAndETag(ctx context.Context, apiKey string) ([]byt
 		return nil, nil, errors.Wrap(result.Error, "failed to get sync data and ETag")
 	}
 
+	if syncData.Data == nil {
+		// Named slots exist before their first upload
+		return nil, nil, nil
+	}
+
 	return syncData.Data, &syncData.DataETag, nil
 }
 
 // SetSyncData creates or replaces sync data (UPSERT logic).
-func (r *SyncRepo) SetSyncData(ctx context.Context, apiKey string, data []byte) (*string, error) {
+func (r *SyncRepo) SetSyncData(ctx context.Context, apiKey string, slot string, data []byte) (*string, error) {
 	newEtag := "uuid=" + uuid.NewString()
 	now := time.Now() // Needed for manual CreatedAt if not using autoCreateTime
 
@@ -102,8 +147,7 @@ func (r *SyncRepo) SetSyncData(ctx context.Context, apiKey string, data []byte)
 	db := r.db.Get().WithContext(ctx)
 
 	// Try to update first
-	updateResult := db.Model(&SyncData{}).
-		Where("user_api_key = ?", apiKey).
+	updateResult := r.slotQuery(ctx, apiKey, slot).
 		Updates(map[string]interface{}{
 			"data":       data,
 			"data_etag":  newEtag,
@@ -115,6 +159,11 @@ func (r *SyncRepo) SetSyncData(ctx context.Context, apiKey string, data []byte)
 		return nil, errors.Wrap(updateResult.Error, "error updating sync data")
 	}
 
+	// Named slots are only created explicitly
+	if updateResult.RowsAffected == 0 && slot != domain.DefaultSyncSlot {
+		return nil, domain.ErrSyncSlotNotFound
+	}
+
 	// If no rows were affected by the update, insert a new record
 	if updateResult.RowsAffected == 0 {
 		createResult := db.Create(&syncData) // GORM handles CreatedAt/UpdatedAt via tags if present
@@ -137,15 +186,14 @@ func (r *SyncRepo) SetSyncData(ctx context.Context, apiKey string, data []byte)
 }
 
 // SetSyncDataIfMatch replaces sync data only if the provided ETag matches.
-func (r *SyncRepo) SetSyncDataIfMatch(ctx context.Context, apiKey string, etag string, data []byte) (*string, error) {
+func (r *SyncRepo) SetSyncDataIfMatch(ctx context.Context, apiKey string, slot string, etag string, data []byte) (*string, error) {
 	newEtag := "uuid=" + uuid.NewString()
 	now := time.Now()
 
 	// Perform a conditional update
-	result := r.db.Get().WithContext(ctx).
-		Model(&SyncData{}).                                        // Specify the model
-		Where("user_api_key = ? AND data_etag = ?", apiKey, etag). // Match key and ETag
-		Updates(map[string]interface{}{                            // Update specific fields
+	result := r.slotQuery(ctx, apiKey, slot).
+		Where("data_etag = ?", etag).   // Match key and ETag
+		Updates(map[string]interface{}{ // Update specific fields
 			"data":       data,
 			"data_etag":  newEtag,
 			"updated_at": now,
@@ -160,12 +208,15 @@ func (r *SyncRepo) SetSyncDataIfMatch(ctx context.Context, apiKey string, etag s
 		// ETag mismatch or record not found
 		// Check if the record exists at all to differentiate ETag mismatch from non-existence
 		var count int64
-		countResult := r.db.Get().WithContext(ctx).Model(&SyncData{}).Where("user_api_key = ?", apiKey).Count(&count)
+		countResult := r.slotQuery(ctx, apiKey, slot).Count(&count)
 		if countResult.Error == nil && count > 0 {
 			// Record exists, so it must be an ETag mismatch
 			r.log.Warn().Str("apiKey", "REDACTED").Str("expectedETag", etag).Msg("ETag mismatch detected during conditional update. Remote data likely changed.")
 			return nil, nil // Return nil, nil for ETag mismatch as per original logic
 		}
+		if countResult.Error == nil && slot != domain.DefaultSyncSlot {
+			return nil, domain.ErrSyncSlotNotFound
+		}
 		// If count error or count is 0, the record might not exist
 		r.log.Warn().Str("apiKey", "REDACTED").Str("expectedETag", etag).Msg("Conditional update failed: 0 rows affected (record not found or ETag mismatch)")
 		return nil, nil // Return nil, nil as per original logic (covers not found too)
@@ -175,3 +226,354 @@ func (r *SyncRepo) SetSyncDataIfMatch(ctx context.Context, apiKey string, etag s
 	r.log.Debug().Str("apiKey", "REDACTED").Str("oldEtag", etag).Str("newEtag", newEtag).Msg("Sync data replaced conditionally")
 	return &newEtag, nil
 }
+
+// ReplaceSyncDataIfMatch replaces sync data like SetSyncDataIfMatch and returns the data it replaced.
+// Uploads failing the precondition read no data. ETags are never reused, so the data read
+// for a matching ETag is the data the conditional update replaces.
+func (r *SyncRepo) ReplaceSyncDataIfMatch(ctx context.Context, apiKey string, slot string, etag string, data []byte) (*string, []byte, error) {
+	var rows []struct {
+		Data []byte
+	}
+	if err := r.slotQuery(ctx, apiKey, slot).Select("data").Where("data_etag = ?", etag).Scan(&rows).Error; err != nil {
+		r.log.Error().Err(err).Str("apiKey", "REDACTED").Msg("Failed to get sync data to replace")
+		return nil, nil, errors.Wrap(err, "failed to get sync data to replace")
+	}
+
+	newEtag, err := r.SetSyncDataIfMatch(ctx, apiKey, slot, etag, data)
+	if err != nil || newEtag == nil || len(rows) == 0 {
+		return newEtag, nil, err
+	}
+	return newEtag, rows[0].Data, nil
+}
+
+// GetStorageUsage returns the total stored size and the largest consumers, each slot counted on its own.
+// Sizes are computed by the database from the blob lengths, the blobs themselves are not read.
+func (r *SyncRepo) GetStorageUsage(ctx context.Context, limit int) (int64, []domain.StorageConsumer, error) {
+	db := r.db.Get().WithContext(ctx)
+
+	type usageRow struct {
+		PublicID  string
+		Name      string
+		Size      int64
+		UpdatedAt time.Time
+	}
+
+	var total int64
+	var rows []usageRow
+	for _, model := range []interface{}{&SyncData{}, &SyncSlotData{}} {
+		var size int64
+		if err := db.Model(model).Select("COALESCE(SUM(LENGTH(data)), 0)").Scan(&size).Error; err != nil {
+			r.log.Error().Err(err).Msg("Failed to sum sync data size")
+			return 0, nil, errors.Wrap(err, "failed to sum sync data size")
+		}
+		total += size
+
+		table := "sync_data"
+		if _, named := model.(*SyncSlotData); named {
+			table = "sync_slots"
+		}
+		columns := "users.public_id, LENGTH(" + table + ".data) AS size, " + table + ".updated_at"
+		if table == "sync_slots" {
+			columns += ", sync_slots.name"
+		}
+
+		var largest []usageRow
+		if err := db.Model(model).
+			Select(columns).
+			Joins("JOIN users ON users.hashed_uuid = " + table + ".user_api_key").
+			Order("size desc").
+			Limit(limit).
+			Scan(&largest).Error; err != nil {
+			r.log.Error().Err(err).Msg("Failed to find largest sync data consumers")
+			return 0, nil, errors.Wrap(err, "failed to find largest sync data consumers")
+		}
+		rows = append(rows, largest...)
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Size > rows[j].Size })
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	consumers := make([]domain.StorageConsumer, 0, len(rows))
+	for _, row := range rows {
+		consumers = append(consumers, domain.StorageConsumer{
+			UserID:    row.PublicID,
+			Slot:      row.Name,
+			Bytes:     row.Size,
+			UpdatedAt: row.UpdatedAt,
+		})
+	}
+
+	return total, consumers, nil
+}
+
+// GetUsageByUser returns the stored size and latest upload of each of the users, summed over their slots.
+// Users without any stored data are left out.
+func (r *SyncRepo) GetUsageByUser(ctx context.Context, apiKeys []string) (map[string]domain.SyncUsage, error) {
+	usage := make(map[string]domain.SyncUsage, len(apiKeys))
+	if len(apiKeys) == 0 {
+		return usage, nil
+	}
+
+	db := r.db.Get().WithContext(ctx)
+	for _, model := range []interface{}{&SyncData{}, &SyncSlotData{}} {
+		var rows []struct {
+			UserAPIKey string
+			Size       int64
+			UpdatedAt  time.Time
+		}
+		// Aggregated in Go, SQLite returns MAX() of a timestamp column as text
+		if err := db.Model(model).
+			Select("user_api_key, LENGTH(data) AS size, updated_at").
+			Where("user_api_key IN ?", apiKeys).
+			Scan(&rows).Error; err != nil {
+			r.log.Error().Err(err).Msg("Failed to get sync data usage of users")
+			return nil, errors.Wrap(err, "failed to get sync data usage of users")
+		}
+
+		for _, row := range rows {
+			u := usage[row.UserAPIKey]
+			u.Bytes += row.Size
+			if u.LastUploadAt == nil || row.UpdatedAt.After(*u.LastUploadAt) {
+				updatedAt := row.UpdatedAt
+				u.LastUploadAt = &updatedAt
+			}
+			usage[row.UserAPIKey] = u
+		}
+	}
+
+	return usage, nil
+}
+
+// ListSlots lists the default slot followed by the named slots of a user, sorted by name.
+func (r *SyncRepo) ListSlots(ctx context.Context, apiKey string) ([]domain.SyncSlot, error) {
+	db := r.db.Get().WithContext(ctx)
+
+	defaultSlot := domain.SyncSlot{Name: domain.DefaultSyncSlotName, Default: true}
+	var defaultRows []struct {
+		DataETag  string `gorm:"column:data_etag"`
+		Size      int64
+		UpdatedAt time.Time
+	}
+	if err := db.Model(&SyncData{}).
+		Select("data_etag, LENGTH(data) AS size, updated_at").
+		Where("user_api_key = ?", apiKey).
+		Scan(&defaultRows).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to get default sync slot")
+		return nil, errors.Wrap(err, "failed to get default sync slot")
+	}
+	if len(defaultRows) > 0 {
+		defaultSlot.ETag = defaultRows[0].DataETag
+		defaultSlot.Size = defaultRows[0].Size
+		defaultSlot.UpdatedAt = defaultRows[0].UpdatedAt
+	}
+
+	var rows []struct {
+		Name      string
+		DataETag  string `gorm:"column:data_etag"`
+		Size      int64
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+	if err := db.Model(&SyncSlotData{}).
+		Select("name, data_etag, COALESCE(LENGTH(data), 0) AS size, created_at, updated_at").
+		Where("user_api_key = ?", apiKey).
+		Order("name asc").
+		Scan(&rows).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to list sync slots")
+		return nil, errors.Wrap(err, "failed to list sync slots")
+	}
+
+	slots := make([]domain.SyncSlot, 0, len(rows)+1)
+	slots = append(slots, defaultSlot)
+	for _, row := range rows {
+		slots = append(slots, domain.SyncSlot{
+			Name:      row.Name,
+			Size:      row.Size,
+			ETag:      row.DataETag,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+		})
+	}
+
+	return slots, nil
+}
+
+// CountSlots counts the named slots of a user.
+func (r *SyncRepo) CountSlots(ctx context.Context, apiKey string) (int, error) {
+	var count int64
+	if err := r.db.Get().WithContext(ctx).Model(&SyncSlotData{}).Where("user_api_key = ?", apiKey).Count(&count).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to count sync slots")
+		return 0, errors.Wrap(err, "failed to count sync slots")
+	}
+	return int(count), nil
+}
+
+// slotExists reports whether a named slot exists.
+func (r *SyncRepo) slotExists(ctx context.Context, apiKey string, name string) (bool, error) {
+	var count int64
+	if err := r.slotQuery(ctx, apiKey, name).Count(&count).Error; err != nil {
+		return false, errors.Wrap(err, "failed to check sync slot")
+	}
+	return count > 0, nil
+}
+
+// CreateSlot creates an empty named slot.
+func (r *SyncRepo) CreateSlot(ctx context.Context, apiKey string, name string) (*domain.SyncSlot, error) {
+	exists, err := r.slotExists(ctx, apiKey, name)
+	if err != nil {
+		r.log.Error().Err(err).Msg("Failed to create sync slot")
+		return nil, err
+	}
+	if exists {
+		return nil, domain.ErrSyncSlotExists
+	}
+
+	slot := SyncSlotData{UserAPIKey: apiKey, Name: name}
+	if err := r.db.Get().WithContext(ctx).Create(&slot).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to create sync slot")
+		return nil, errors.Wrap(err, "failed to create sync slot")
+	}
+
+	return &domain.SyncSlot{
+		Name:      slot.Name,
+		CreatedAt: slot.CreatedAt,
+		UpdatedAt: slot.UpdatedAt,
+	}, nil
+}
+
+// RenameSlot renames a named slot.
+func (r *SyncRepo) RenameSlot(ctx context.Context, apiKey string, name string, newName string) error {
+	exists, err := r.slotExists(ctx, apiKey, newName)
+	if err != nil {
+		r.log.Error().Err(err).Msg("Failed to rename sync slot")
+		return err
+	}
+	if exists {
+		return domain.ErrSyncSlotExists
+	}
+
+	return r.db.Get().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&SyncSlotData{}).Where("user_api_key = ? AND name = ?", apiKey, name).Update("name", newName)
+		if result.Error != nil {
+			r.log.Error().Err(result.Error).Msg("Failed to rename sync slot")
+			return errors.Wrap(result.Error, "failed to rename sync slot")
+		}
+		if result.RowsAffected == 0 {
+			return domain.ErrSyncSlotNotFound
+		}
+
+		// The history moves along with the slot
+		if err := tx.Model(&SyncHistoryData{}).Where("user_api_key = ? AND slot = ?", apiKey, name).Update("slot", newName).Error; err != nil {
+			r.log.Error().Err(err).Msg("Failed to rename sync slot history")
+			return errors.Wrap(err, "failed to rename sync slot history")
+		}
+		return nil
+	})
+}
+
+// DeleteSlot deletes a named slot, its data and its history.
+func (r *SyncRepo) DeleteSlot(ctx context.Context, apiKey string, name string) error {
+	return r.db.Get().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_api_key = ? AND name = ?", apiKey, name).Delete(&SyncSlotData{})
+		if result.Error != nil {
+			r.log.Error().Err(result.Error).Msg("Failed to delete sync slot")
+			return errors.Wrap(result.Error, "failed to delete sync slot")
+		}
+		if result.RowsAffected == 0 {
+			return domain.ErrSyncSlotNotFound
+		}
+
+		if err := tx.Where("user_api_key = ? AND slot = ?", apiKey, name).Delete(&SyncHistoryData{}).Error; err != nil {
+			r.log.Error().Err(err).Msg("Failed to delete sync slot history")
+			return errors.Wrap(err, "failed to delete sync slot history")
+		}
+		return nil
+	})
+}
+
+// AddHistory keeps a replaced version of a slot and prunes the history down to the newest keep versions.
+func (r *SyncRepo) AddHistory(ctx context.Context, apiKey string, slot string, etag string, data []byte, keep int) error {
+	return r.db.Get().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		entry := SyncHistoryData{
+			UserAPIKey: apiKey,
+			Slot:       slot,
+			Data:       data,
+			DataETag:   etag,
+			ReplacedAt: time.Now(),
+		}
+		if err := tx.Create(&entry).Error; err != nil {
+			r.log.Error().Err(err).Msg("Failed to store sync history")
+			return errors.Wrap(err, "failed to store sync history")
+		}
+
+		var kept []int64
+		if err := tx.Model(&SyncHistoryData{}).
+			Where("user_api_key = ? AND slot = ?", apiKey, slot).
+			Order("id desc").
+			Limit(keep).
+			Pluck("id", &kept).Error; err != nil {
+			r.log.Error().Err(err).Msg("Failed to find sync history to keep")
+			return errors.Wrap(err, "failed to find sync history to keep")
+		}
+
+		if err := tx.Where("user_api_key = ? AND slot = ? AND id NOT IN ?", apiKey, slot, kept).
+			Delete(&SyncHistoryData{}).Error; err != nil {
+			r.log.Error().Err(err).Msg("Failed to prune sync history")
+			return errors.Wrap(err, "failed to prune sync history")
+		}
+		return nil
+	})
+}
+
+// ListHistory lists the replaced versions of a slot, newest first, without reading their data.
+func (r *SyncRepo) ListHistory(ctx context.Context, apiKey string, slot string) ([]domain.SyncHistoryEntry, error) {
+	var rows []struct {
+		ID         int64
+		DataETag   string `gorm:"column:data_etag"`
+		Size       int64
+		ReplacedAt time.Time
+	}
+	if err := r.db.Get().WithContext(ctx).Model(&SyncHistoryData{}).
+		Select("id, data_etag, COALESCE(LENGTH(data), 0) AS size, replaced_at").
+		Where("user_api_key = ? AND slot = ?", apiKey, slot).
+		Order("id desc").
+		Scan(&rows).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to list sync history")
+		return nil, errors.Wrap(err, "failed to list sync history")
+	}
+
+	entries := make([]domain.SyncHistoryEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, domain.SyncHistoryEntry{
+			ID:         row.ID,
+			ETag:       row.DataETag,
+			Size:       row.Size,
+			ReplacedAt: row.ReplacedAt,
+		})
+	}
+
+	return entries, nil
+}
+
+// GetHistoryData returns the data of a replaced version of a slot.
+func (r *SyncRepo) GetHistoryData(ctx context.Context, apiKey string, slot string, id int64) ([]byte, error) {
+	var entry SyncHistoryData
+	err := r.db.Get().WithContext(ctx).
+		Select("data").
+		Where("id = ? AND user_api_key = ? AND slot = ?", id, apiKey, slot).
+		Take(&entry).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrSyncHistoryNotFound
+		}
+		r.log.Error().Err(err).Msg("Failed to get sync history data")
+		return nil, errors.Wrap(err, "failed to get sync history data")
+	}
+
+	if entry.Data == nil {
+		entry.Data = []byte{}
+	}
+	return entry.Data, nil
+}
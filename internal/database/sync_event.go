@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+type SyncEventRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewSyncEventRepo(log logger.Logger, db *DB) domain.SyncEventRepo {
+	return &SyncEventRepo{
+		log: log.With().Str("repo", "sync_event").Logger(),
+		db:  db,
+	}
+}
+
+// Store inserts a new sync event.
+func (r *SyncEventRepo) Store(ctx context.Context, event domain.SyncEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	result := r.db.Get().WithContext(ctx).Create(&event)
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Str("request_id", event.RequestID).Msg("Failed to store sync event")
+		return errors.Wrap(result.Error, "failed to store sync event")
+	}
+
+	return nil
+}
+
+// Find retrieves sync events matching the query parameters, newest first.
+func (r *SyncEventRepo) Find(ctx context.Context, params domain.SyncEventQueryParams) ([]domain.SyncEvent, int, error) {
+	var events []domain.SyncEvent
+	var totalCount int64
+
+	db := r.db.Get().WithContext(ctx).Model(&domain.SyncEvent{})
+
+	if params.UserHashedUUID != nil {
+		db = db.Where("user_hashed_uuid = ?", *params.UserHashedUUID)
+	}
+	if params.From != nil {
+		db = db.Where("created_at >= ?", *params.From)
+	}
+	if params.To != nil {
+		db = db.Where("created_at < ?", *params.To)
+	}
+	if len(params.Outcomes) > 0 {
+		db = db.Where("outcome IN ?", params.Outcomes)
+	}
+	if params.Method != "" {
+		db = db.Where("method = ?", params.Method)
+	}
+	if params.Slot != nil {
+		db = db.Where("slot = ?", *params.Slot)
+	}
+
+	if err := db.Count(&totalCount).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to count sync events")
+		return nil, 0, errors.Wrap(err, "failed to count sync events")
+	}
+
+	db = db.Order("created_at desc")
+
+	if params.Limit > 0 {
+		db = db.Limit(int(params.Limit))
+	}
+	if params.Offset > 0 {
+		db = db.Offset(int(params.Offset))
+	}
+
+	if err := db.Find(&events).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to find sync events")
+		return nil, 0, errors.Wrap(err, "failed to find sync events")
+	}
+
+	return events, int(totalCount), nil
+}
+
+// DeleteOlderThan removes sync events created before the given time.
+func (r *SyncEventRepo) DeleteOlderThan(ctx context.Context, before time.Time) (int, error) {
+	result := r.db.Get().WithContext(ctx).
+		Where("created_at < ?", before).
+		Delete(&domain.SyncEvent{})
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Time("before", before).Msg("Failed to delete old sync events")
+		return 0, errors.Wrap(result.Error, "failed to delete old sync events")
+	}
+
+	r.log.Debug().Int64("deleted_count", result.RowsAffected).Time("before", before).Msg("Deleted old sync events")
+	return int(result.RowsAffected), nil
+}
+
+// CountActiveUsers counts the distinct users with at least one sync event since the given time.
+func (r *SyncEventRepo) CountActiveUsers(ctx context.Context, since time.Time) (int, error) {
+	var count int64
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.SyncEvent{}).
+		Where("created_at >= ?", since).
+		Distinct("user_hashed_uuid").
+		Count(&count)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Msg("Failed to count active users")
+		return 0, errors.Wrap(result.Error, "failed to count active users")
+	}
+
+	return int(count), nil
+}
+
+// CountOutcomes counts sync events per outcome since the given time.
+func (r *SyncEventRepo) CountOutcomes(ctx context.Context, since time.Time, method string) (map[domain.SyncOutcome]int, error) {
+	var rows []struct {
+		Outcome domain.SyncOutcome
+		Count   int
+	}
+
+	db := r.db.Get().WithContext(ctx).
+		Model(&domain.SyncEvent{}).
+		Select("outcome, COUNT(*) AS count").
+		Where("created_at >= ?", since)
+
+	if method != "" {
+		db = db.Where("method = ?", method)
+	}
+
+	if err := db.Group("outcome").Scan(&rows).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to count sync event outcomes")
+		return nil, errors.Wrap(err, "failed to count sync event outcomes")
+	}
+
+	counts := make(map[domain.SyncOutcome]int, len(rows))
+	for _, row := range rows {
+		counts[row.Outcome] = row.Count
+	}
+
+	return counts, nil
+}
+
+// dayColumn is the UTC day of created_at as YYYY-MM-DD, the databases store timestamps differently.
+func (r *SyncEventRepo) dayColumn() string {
+	if r.db.Driver == "postgres" {
+		return "to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')"
+	}
+	// SQLite converts the stored offset to UTC
+	return "date(created_at)"
+}
+
+// SummarizeUser aggregates the sync events of a user since the given time. Events are stored
+// in order, so the latest event of a group is the one with the highest ID.
+func (r *SyncEventRepo) SummarizeUser(ctx context.Context, userHashedUUID string, since time.Time) (*domain.SyncEventSummary, error) {
+	db := r.db.Get().WithContext(ctx)
+	events := func() *gorm.DB {
+		return db.Model(&domain.SyncEvent{}).Where("user_hashed_uuid = ? AND created_at >= ?", userHashedUUID, since)
+	}
+	day := r.dayColumn()
+
+	summary := &domain.SyncEventSummary{}
+	if err := events().
+		Select(day + " AS date, device, method, outcome, COUNT(*) AS count").
+		Group(day + ", device, method, outcome").
+		Order("date").
+		Scan(&summary.Counts).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to count sync events of user")
+		return nil, errors.Wrap(err, "failed to count sync events of user")
+	}
+
+	latestByDevice := events().Select("MAX(id)").Group("device")
+	if err := db.Where("id IN (?)", latestByDevice).Find(&summary.LatestByDevice).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to find latest sync events per device")
+		return nil, errors.Wrap(err, "failed to find latest sync events per device")
+	}
+
+	latestUploads := events().
+		Select("MAX(id)").
+		Where("method = ? AND outcome = ?", http.MethodPut, domain.SyncOutcomeOK).
+		Group(day)
+	if err := db.Where("id IN (?)", latestUploads).Order("id").Find(&summary.LatestUploads).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to find latest uploads per day")
+		return nil, errors.Wrap(err, "failed to find latest uploads per day")
+	}
+
+	var latestSuccess []domain.SyncEvent
+	if err := events().
+		Where("outcome IN ?", []domain.SyncOutcome{domain.SyncOutcomeOK, domain.SyncOutcomeNotModified}).
+		Order("id desc").
+		Limit(1).
+		Find(&latestSuccess).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to find latest successful sync")
+		return nil, errors.Wrap(err, "failed to find latest successful sync")
+	}
+	if len(latestSuccess) > 0 {
+		summary.LatestSuccess = &latestSuccess[0]
+	}
+
+	return summary, nil
+}
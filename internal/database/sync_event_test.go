@@ -0,0 +1,59 @@
+package database_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncEventRepo_SummarizeUser(t *testing.T) {
+	ctx := context.Background()
+	db, log := databasetest.New(t)
+	repo := database.NewSyncEventRepo(log, db)
+
+	// 00:30 in UTC+2 is still the previous day in UTC
+	east := time.FixedZone("UTC+2", 2*60*60)
+	day1 := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2026, 10, 19, 0, 30, 0, 0, east)
+
+	events := []domain.SyncEvent{
+		{UserHashedUUID: "user", Device: "phone", Method: http.MethodPut, Outcome: domain.SyncOutcomeOK, PayloadSize: 10, CreatedAt: day1},
+		{UserHashedUUID: "user", Device: "phone", Method: http.MethodPut, Outcome: domain.SyncOutcomeOK, PayloadSize: 20, CreatedAt: day1.Add(time.Hour)},
+		{UserHashedUUID: "user", Device: "tablet", Method: http.MethodPut, Outcome: domain.SyncOutcomePreconditionFailed, CreatedAt: day1.Add(2 * time.Hour)},
+		{UserHashedUUID: "user", Device: "tablet", Method: http.MethodGet, Outcome: domain.SyncOutcomeNotModified, CreatedAt: day2},
+		{UserHashedUUID: "user", Device: "phone", Method: http.MethodGet, Outcome: domain.SyncOutcomeError, CreatedAt: day2.Add(time.Minute)},
+		{UserHashedUUID: "other", Device: "phone", Method: http.MethodPut, Outcome: domain.SyncOutcomeOK, CreatedAt: day2},
+		{UserHashedUUID: "user", Device: "old", Method: http.MethodPut, Outcome: domain.SyncOutcomeOK, CreatedAt: day1.AddDate(0, 0, -10)},
+	}
+	for _, event := range events {
+		require.NoError(t, repo.Store(ctx, event))
+	}
+
+	summary, err := repo.SummarizeUser(ctx, "user", day1.Add(-time.Hour))
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []domain.SyncEventCount{
+		{Date: "2026-10-17", Device: "phone", Method: http.MethodPut, Outcome: domain.SyncOutcomeOK, Count: 2},
+		{Date: "2026-10-17", Device: "tablet", Method: http.MethodPut, Outcome: domain.SyncOutcomePreconditionFailed, Count: 1},
+		{Date: "2026-10-18", Device: "tablet", Method: http.MethodGet, Outcome: domain.SyncOutcomeNotModified, Count: 1},
+		{Date: "2026-10-18", Device: "phone", Method: http.MethodGet, Outcome: domain.SyncOutcomeError, Count: 1},
+	}, summary.Counts)
+
+	require.Len(t, summary.LatestUploads, 1)
+	assert.Equal(t, int64(20), summary.LatestUploads[0].PayloadSize)
+
+	require.Len(t, summary.LatestByDevice, 2)
+	for _, event := range summary.LatestByDevice {
+		assert.True(t, day2.Equal(event.CreatedAt) || day2.Add(time.Minute).Equal(event.CreatedAt), event.Device)
+	}
+
+	require.NotNil(t, summary.LatestSuccess)
+	assert.True(t, day2.Equal(summary.LatestSuccess.CreatedAt))
+}
@@ -0,0 +1,174 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncRepo_GetStorageUsage(t *testing.T) {
+	ctx := context.Background()
+	db, log := databasetest.New(t)
+	users := database.NewUserRepo(log, db)
+	repo := database.NewSyncRepo(log, db)
+
+	require.NoError(t, users.Store(ctx, domain.User{HashedUUID: "bookmark-one", PublicID: "public-one", APITokenHash: "hash-one"}))
+	require.NoError(t, users.Store(ctx, domain.User{HashedUUID: "bookmark-two", PublicID: "public-two", APITokenHash: "hash-two"}))
+
+	_, err := repo.SetSyncData(ctx, "bookmark-one", domain.DefaultSyncSlot, []byte("12345"))
+	require.NoError(t, err)
+	_, err = repo.CreateSlot(ctx, "bookmark-two", "tablet")
+	require.NoError(t, err)
+	_, err = repo.SetSyncData(ctx, "bookmark-two", "tablet", []byte("1234567"))
+	require.NoError(t, err)
+
+	total, consumers, err := repo.GetStorageUsage(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), total)
+	require.Len(t, consumers, 2)
+
+	// Users are reported by their PublicID, never by the bookmark
+	assert.Equal(t, "public-two", consumers[0].UserID)
+	assert.Equal(t, "tablet", consumers[0].Slot)
+	assert.Equal(t, int64(7), consumers[0].Bytes)
+	assert.Equal(t, "public-one", consumers[1].UserID)
+	assert.Empty(t, consumers[1].Slot)
+}
+
+func TestSyncRepo_ReplaceSyncDataIfMatch(t *testing.T) {
+	ctx := context.Background()
+	db, log := databasetest.New(t)
+	repo := database.NewSyncRepo(log, db)
+
+	etag, err := repo.SetSyncData(ctx, "bookmark", domain.DefaultSyncSlot, []byte("first"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		etag         string
+		wantReplaced bool
+		wantPrevious []byte
+	}{
+		{name: "mismatch", etag: "uuid=stale"},
+		{name: "match", etag: *etag, wantReplaced: true, wantPrevious: []byte("first")},
+		{name: "replaced_etag", etag: *etag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newEtag, previous, err := repo.ReplaceSyncDataIfMatch(ctx, "bookmark", domain.DefaultSyncSlot, tt.etag, []byte("second"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantReplaced, newEtag != nil)
+			assert.Equal(t, tt.wantPrevious, previous)
+		})
+	}
+
+	data, _, err := repo.GetSyncDataAndETag(ctx, "bookmark", domain.DefaultSyncSlot)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), data)
+}
+
+func TestSyncRepo_Slots(t *testing.T) {
+	ctx := context.Background()
+	db, log := databasetest.New(t)
+	repo := database.NewSyncRepo(log, db)
+
+	_, err := repo.CreateSlot(ctx, "bookmark", "tablet")
+	require.NoError(t, err)
+	_, err = repo.CreateSlot(ctx, "bookmark", "phone")
+	require.NoError(t, err)
+	// Slots of other users do not count
+	_, err = repo.CreateSlot(ctx, "other", "tablet")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		run     func() error
+		wantErr error
+	}{
+		{name: "create_taken", run: func() error { _, err := repo.CreateSlot(ctx, "bookmark", "tablet"); return err }, wantErr: domain.ErrSyncSlotExists},
+		{name: "upload_to_missing", run: func() error { _, err := repo.SetSyncData(ctx, "bookmark", "missing", []byte("data")); return err }, wantErr: domain.ErrSyncSlotNotFound},
+		{name: "upload", run: func() error { _, err := repo.SetSyncData(ctx, "bookmark", "tablet", []byte("data")); return err }},
+		{name: "rename_to_taken", run: func() error { return repo.RenameSlot(ctx, "bookmark", "tablet", "phone") }, wantErr: domain.ErrSyncSlotExists},
+		{name: "rename_missing", run: func() error { return repo.RenameSlot(ctx, "bookmark", "missing", "other") }, wantErr: domain.ErrSyncSlotNotFound},
+		{name: "rename", run: func() error { return repo.RenameSlot(ctx, "bookmark", "tablet", "ereader") }},
+		{name: "delete_missing", run: func() error { return repo.DeleteSlot(ctx, "bookmark", "tablet") }, wantErr: domain.ErrSyncSlotNotFound},
+		{name: "delete", run: func() error { return repo.DeleteSlot(ctx, "bookmark", "phone") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	count, err := repo.CountSlots(ctx, "bookmark")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	slots, err := repo.ListSlots(ctx, "bookmark")
+	require.NoError(t, err)
+	require.Len(t, slots, 2)
+	assert.True(t, slots[0].Default)
+	assert.Equal(t, "ereader", slots[1].Name)
+	assert.Equal(t, int64(4), slots[1].Size)
+
+	data, etag, err := repo.GetSyncDataAndETag(ctx, "bookmark", "ereader")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	assert.Equal(t, *etag, slots[1].ETag)
+}
+
+func TestSyncRepo_History(t *testing.T) {
+	ctx := context.Background()
+	db, log := databasetest.New(t)
+	repo := database.NewSyncRepo(log, db)
+
+	_, err := repo.CreateSlot(ctx, "bookmark", "tablet")
+	require.NoError(t, err)
+	for _, version := range []string{"one", "two", "three"} {
+		require.NoError(t, repo.AddHistory(ctx, "bookmark", "tablet", "uuid="+version, []byte(version), 2))
+	}
+	require.NoError(t, repo.AddHistory(ctx, "bookmark", domain.DefaultSyncSlot, "uuid=default", []byte("default"), 2))
+
+	// Only the newest versions are kept, each slot on its own
+	history, err := repo.ListHistory(ctx, "bookmark", "tablet")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "uuid=three", history[0].ETag)
+	assert.Equal(t, int64(5), history[0].Size)
+	assert.Equal(t, "uuid=two", history[1].ETag)
+
+	data, err := repo.GetHistoryData(ctx, "bookmark", "tablet", history[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("two"), data)
+
+	// Entries are only found through the slot they belong to
+	_, err = repo.GetHistoryData(ctx, "bookmark", domain.DefaultSyncSlot, history[1].ID)
+	assert.ErrorIs(t, err, domain.ErrSyncHistoryNotFound)
+	_, err = repo.GetHistoryData(ctx, "other", "tablet", history[1].ID)
+	assert.ErrorIs(t, err, domain.ErrSyncHistoryNotFound)
+
+	// The history follows a renamed slot and is deleted with it
+	require.NoError(t, repo.RenameSlot(ctx, "bookmark", "tablet", "ereader"))
+	history, err = repo.ListHistory(ctx, "bookmark", "ereader")
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	require.NoError(t, repo.DeleteSlot(ctx, "bookmark", "ereader"))
+	history, err = repo.ListHistory(ctx, "bookmark", "ereader")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	history, err = repo.ListHistory(ctx, "bookmark", domain.DefaultSyncSlot)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+}
@@ -11,6 +11,40 @@ import (
 	"gorm.io/gorm"
 )
 
+// userDependents are the tables keyed by the HashedUUID of their user, with
+// the column holding it. API tokens are handled separately, they are never moved.
+var userDependents = []struct {
+	table  string
+	column string
+}{
+	{"notifications", "user_hashed_uuid"},
+	{"profile_uuids", "user_id"},
+	{"sync_data", "user_api_key"},
+	{"sync_slots", "user_api_key"},
+	{"sync_history", "user_api_key"},
+	{"sync_events", "user_hashed_uuid"},
+	{"reading_events", "user_hashed_uuid"},
+	{"shares", "user_hashed_uuid"},
+	{"oidc_identities", "user_hashed_uuid"},
+	{"proxy_identities", "user_hashed_uuid"},
+	{"webauthn_credentials", "user_hashed_uuid"},
+	{"recovery_codes", "user_hashed_uuid"},
+}
+
+// mergedDependents are moved to the remaining user when two accounts are merged.
+// Libraries are merged separately, the other rows are deleted with the merged
+// account: passkeys are bound to its user handle and identities and shares are
+// linked again by the user if needed.
+var mergedDependents = []struct {
+	table  string
+	column string
+}{
+	{"notifications", "user_hashed_uuid"},
+	{"api_tokens", "user_hashed_uuid"},
+	{"reading_events", "user_hashed_uuid"},
+	{"sync_events", "user_hashed_uuid"},
+}
+
 type UserRepo struct {
 	log zerolog.Logger
 	db  *DB
@@ -57,6 +91,10 @@ func (r *UserRepo) FindByHashedUUID(ctx context.Context, hashedUUID string) (*do
 }
 
 func (r *UserRepo) Store(ctx context.Context, user domain.User) error {
+	if user.PublicID == "" {
+		user.PublicID = domain.NewPublicID()
+	}
+
 	// GORM's Create will attempt to insert the user.
 	// It uses the HashedUUID as the primary key.
 	result := r.db.Get().WithContext(ctx).Create(&user)
@@ -93,12 +131,214 @@ func (r *UserRepo) FindByAPITokenHash(ctx context.Context, tokenHash string) (*d
 	return &user, nil
 }
 
+// FindByAPITokenLookupID finds a user by the lookup ID of their API token.
+func (r *UserRepo) FindByAPITokenLookupID(ctx context.Context, lookupID string) (*domain.User, error) {
+	var user domain.User
+	result := r.db.Get().WithContext(ctx).Where("api_token_lookup_id = ?", lookupID).First(&user)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.log.Error().Err(result.Error).Str("lookup_id", lookupID).Msg("Failed to find user by API token lookup ID")
+		return nil, errors.Wrap(result.Error, "failed to find user by API token lookup ID")
+	}
+
+	return &user, nil
+}
+
+// UpdateUUIDLoginDisabled sets whether the user may log in with the bookmark UUID.
+// The passkeys are counted within the same transaction, a passkey removed
+// concurrently cannot leave the user without a way to log in.
+func (r *UserRepo) UpdateUUIDLoginDisabled(ctx context.Context, hashedUUID string, disabled bool) error {
+	err := r.db.Get().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, hashedUUID); err != nil {
+			return err
+		}
+
+		if disabled {
+			var passkeys int64
+			if err := tx.Model(&domain.WebAuthnCredential{}).Where("user_hashed_uuid = ?", hashedUUID).Count(&passkeys).Error; err != nil {
+				return errors.Wrap(err, "failed to count passkeys of user")
+			}
+			if passkeys == 0 {
+				return domain.ErrNoWebAuthnCredential
+			}
+		}
+
+		if err := tx.Model(&domain.User{}).Where("hashed_uuid = ?", hashedUUID).Update("uuid_login_disabled", disabled).Error; err != nil {
+			return errors.Wrap(err, "failed to update uuid login setting")
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, domain.ErrNoWebAuthnCredential) {
+		r.log.Error().Err(err).Str("hashed_uuid", hashedUUID).Msg("Failed to update uuid login setting")
+	}
+
+	return err
+}
+
+// lockUser takes the write lock on the row of a user within tx. Transactions
+// checking the passkeys against the bookmark UUID login of a user wait for
+// each other. A no-op update locks the row on PostgreSQL and the database on SQLite.
+func lockUser(tx *gorm.DB, hashedUUID string) error {
+	result := tx.Exec("UPDATE users SET uuid_login_disabled = uuid_login_disabled WHERE hashed_uuid = ?", hashedUUID)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "failed to lock user")
+	}
+	if result.RowsAffected == 0 {
+		return errors.Wrap(gorm.ErrRecordNotFound, "user with hashed_uuid %s not found", hashedUUID)
+	}
+	return nil
+}
+
+// UpdateDisabled blocks the user from authenticating or allows it again.
+func (r *UserRepo) UpdateDisabled(ctx context.Context, hashedUUID string, disabled bool) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.User{}).
+		Where("hashed_uuid = ?", hashedUUID).
+		Update("disabled", disabled)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Str("hashed_uuid", hashedUUID).Msg("Failed to update disabled state")
+		return errors.Wrap(result.Error, "failed to update disabled state")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.Wrap(gorm.ErrRecordNotFound, "user with hashed_uuid %s not found for disabled state update", hashedUUID)
+	}
+
+	return nil
+}
+
+// UpdateScopes replaces the scopes of the user, the user token is left as is.
+func (r *UserRepo) UpdateScopes(ctx context.Context, hashedUUID string, scopes string) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.User{}).
+		Where("hashed_uuid = ?", hashedUUID).
+		Update("scopes", scopes)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Str("hashed_uuid", hashedUUID).Msg("Failed to update user scopes")
+		return errors.Wrap(result.Error, "failed to update user scopes")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.Wrap(gorm.ErrRecordNotFound, "user with hashed_uuid %s not found for scopes update", hashedUUID)
+	}
+
+	return nil
+}
+
+// FindByPublicID finds a user by the ID shown to administrators.
+func (r *UserRepo) FindByPublicID(ctx context.Context, publicID string) (*domain.User, error) {
+	var user domain.User
+	result := r.db.Get().WithContext(ctx).Where("public_id = ?", publicID).First(&user)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.log.Error().Err(result.Error).Str("public_id", publicID).Msg("Failed to find user by public ID")
+		return nil, errors.Wrap(result.Error, "failed to find user by public ID")
+	}
+
+	return &user, nil
+}
+
+// FindUsers returns a page of users, the latest deletion date and so the most
+// recently synced first, and the total number of users.
+func (r *UserRepo) FindUsers(ctx context.Context, params domain.UserQueryParams) ([]domain.User, int, error) {
+	var users []domain.User
+	var totalCount int64
+
+	db := r.db.Get().WithContext(ctx).Model(&domain.User{})
+
+	if err := db.Count(&totalCount).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to count users")
+		return nil, 0, errors.Wrap(err, "failed to count users")
+	}
+
+	db = db.Order("deletion_date desc").Order("public_id")
+
+	if params.Limit > 0 {
+		db = db.Limit(int(params.Limit))
+	}
+	if params.Offset > 0 {
+		db = db.Offset(int(params.Offset))
+	}
+
+	if err := db.Find(&users).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to find users")
+		return nil, 0, errors.Wrap(err, "failed to find users")
+	}
+
+	return users, int(totalCount), nil
+}
+
+// backfillUserPublicIDs assigns a PublicID to users created before it existed.
+func backfillUserPublicIDs(db *gorm.DB) error {
+	var hashedUUIDs []string
+	if err := db.Model(&domain.User{}).Where("public_id = ''").Pluck("hashed_uuid", &hashedUUIDs).Error; err != nil {
+		return errors.Wrap(err, "failed to find users without public ID")
+	}
+
+	for _, hashedUUID := range hashedUUIDs {
+		if err := db.Model(&domain.User{}).Where("hashed_uuid = ?", hashedUUID).Update("public_id", domain.NewPublicID()).Error; err != nil {
+			return errors.Wrap(err, "failed to assign public ID")
+		}
+	}
+	return nil
+}
+
+// UpdatePasswordHash sets the password hash of the user, an empty hash removes the password.
+func (r *UserRepo) UpdatePasswordHash(ctx context.Context, hashedUUID string, passwordHash string) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.User{}).
+		Where("hashed_uuid = ?", hashedUUID).
+		Update("password_hash", passwordHash)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Str("hashed_uuid", hashedUUID).Msg("Failed to update password hash")
+		return errors.Wrap(result.Error, "failed to update password hash")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.Wrap(gorm.ErrRecordNotFound, "user with hashed_uuid %s not found for password update", hashedUUID)
+	}
+
+	return nil
+}
+
+// UpdateTOTP sets the encrypted TOTP secret of the user and whether it is enabled.
+func (r *UserRepo) UpdateTOTP(ctx context.Context, hashedUUID string, encryptedSecret string, enabled bool) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.User{}).
+		Where("hashed_uuid = ?", hashedUUID).
+		Updates(map[string]any{"totp_secret": encryptedSecret, "totp_enabled": enabled})
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Str("hashed_uuid", hashedUUID).Msg("Failed to update TOTP")
+		return errors.Wrap(result.Error, "failed to update TOTP")
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.Wrap(gorm.ErrRecordNotFound, "user with hashed_uuid %s not found for TOTP update", hashedUUID)
+	}
+
+	return nil
+}
+
 // UpdateDeletionDate updates the deletion date for a user identified by their hashed UUID.
+// The expiry warning is reset, the user is warned again about the new date.
 func (r *UserRepo) UpdateDeletionDate(ctx context.Context, hashedUUID string, newDeletionDate time.Time) error {
 	result := r.db.Get().WithContext(ctx).
-		Model(&domain.User{}).                   // Specify the model
-		Where("hashed_uuid = ?", hashedUUID).    // Condition using hashed UUID
-		Update("deletion_date", newDeletionDate) // Update specific column
+		Model(&domain.User{}).                // Specify the model
+		Where("hashed_uuid = ?", hashedUUID). // Condition using hashed UUID
+		Updates(map[string]interface{}{
+			"deletion_date":       newDeletionDate,
+			"expiry_warning_sent": false,
+		})
 
 	if result.Error != nil {
 		r.log.Error().Err(result.Error).Str("hashed_uuid", hashedUUID).Msg("Failed to update user deletion date")
@@ -133,37 +373,103 @@ func (r *UserRepo) FindExpiredUserIDs(ctx context.Context, now time.Time) ([]str
 	return userHashedUUIDs, nil
 }
 
-// DeleteUserAndAssociatedData deletes a user identified by their hashed UUID.
-// Assumes ON DELETE CASCADE constraints handle associated data in other tables.
+// FindUsersToWarn finds the users expiring between now and before who were not warned yet.
+func (r *UserRepo) FindUsersToWarn(ctx context.Context, now time.Time, before time.Time) ([]domain.User, error) {
+	var users []domain.User
+	result := r.db.Get().WithContext(ctx).
+		Where("deletion_date > ? AND deletion_date <= ? AND expiry_warning_sent = ?", now, before, false).
+		Find(&users)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Msg("Failed to find users to warn about their expiry")
+		return nil, errors.Wrap(result.Error, "failed to find users to warn about their expiry")
+	}
+
+	return users, nil
+}
+
+// MarkExpiryWarningSent records that a user was warned about their deletion date.
+func (r *UserRepo) MarkExpiryWarningSent(ctx context.Context, hashedUUID string) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.User{}).
+		Where("hashed_uuid = ?", hashedUUID).
+		Update("expiry_warning_sent", true)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Str("hashed_uuid", hashedUUID).Msg("Failed to mark expiry warning as sent")
+		return errors.Wrap(result.Error, "failed to mark expiry warning as sent")
+	}
+
+	return nil
+}
+
+// DeleteUserAndAssociatedData deletes a user identified by their hashed UUID
+// and every row keyed by it in one transaction.
 func (r *UserRepo) DeleteUserAndAssociatedData(ctx context.Context, hashedUUID string) error {
-	db := r.db.Get().WithContext(ctx)
+	err := r.db.Get().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return r.deleteUser(tx, hashedUUID)
+	})
+	if err != nil {
+		r.log.Error().Err(err).Str("hashed_uuid", hashedUUID).Msg("Failed to delete user")
+		return err
+	}
 
-	// GORM's Delete needs a Where clause when not using the default 'ID' primary key.
-	result := db.Where("hashed_uuid = ?", hashedUUID).Delete(&domain.User{})
+	r.log.Info().Str("hashed_uuid", hashedUUID).Msg("Successfully deleted user and associated data")
+	return nil
+}
+
+// deleteUser deletes a user and every row keyed by its HashedUUID within tx.
+func (r *UserRepo) deleteUser(tx *gorm.DB, hashedUUID string) error {
+	for _, dependent := range userDependents {
+		if err := tx.Exec("DELETE FROM "+dependent.table+" WHERE "+dependent.column+" = ?", hashedUUID).Error; err != nil {
+			return errors.Wrap(err, "failed to delete %s of user", dependent.table)
+		}
+	}
+	if err := tx.Where("user_hashed_uuid = ?", hashedUUID).Delete(&domain.APIToken{}).Error; err != nil {
+		return errors.Wrap(err, "failed to delete API tokens of user")
+	}
 
+	// GORM's Delete needs a Where clause when not using the default 'ID' primary key.
+	result := tx.Where("hashed_uuid = ?", hashedUUID).Delete(&domain.User{})
 	if result.Error != nil {
-		r.log.Error().Err(result.Error).Str("hashed_uuid", hashedUUID).Msg("Failed to delete user")
 		return errors.Wrap(result.Error, "failed to delete user")
 	}
-
 	if result.RowsAffected == 0 {
 		r.log.Warn().Str("hashed_uuid", hashedUUID).Msg("DeleteUser query affected 0 rows, user HashedUUID might not exist")
 		// Return error indicating user not found
 		return errors.Wrap(gorm.ErrRecordNotFound, "user with hashed_uuid %s not found for deletion", hashedUUID)
 	}
+	return nil
+}
+
+// MergeInto moves the rows of mergedDependents to the remaining user and deletes the merged one.
+func (r *UserRepo) MergeInto(ctx context.Context, fromHashedUUID string, intoHashedUUID string) error {
+	err := r.db.Get().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, dependent := range mergedDependents {
+			if err := tx.Table(dependent.table).Where(dependent.column+" = ?", fromHashedUUID).Update(dependent.column, intoHashedUUID).Error; err != nil {
+				return errors.Wrap(err, "failed to move %s to merged user", dependent.table)
+			}
+		}
+		return r.deleteUser(tx, fromHashedUUID)
+	})
+	if err != nil {
+		r.log.Error().Err(err).Str("hashed_uuid", fromHashedUUID).Msg("Failed to merge user")
+		return err
+	}
 
-	r.log.Info().Str("hashed_uuid", hashedUUID).Msg("Successfully deleted user (assuming CASCADE for related data)")
+	r.log.Info().Str("hashed_uuid", intoHashedUUID).Str("merged_hashed_uuid", fromHashedUUID).Msg("Successfully merged user")
 	return nil
 }
 
-// UpdateTokenAndScopes updates the API token hash and scopes for a user.
-func (r *UserRepo) UpdateTokenAndScopes(ctx context.Context, hashedUUID string, apiTokenHash string, scopes string) error {
+// UpdateTokenAndScopes updates the API token lookup ID, hash and scopes for a user.
+func (r *UserRepo) UpdateTokenAndScopes(ctx context.Context, hashedUUID string, lookupID string, apiTokenHash string, scopes string) error {
 	result := r.db.Get().WithContext(ctx).
 		Model(&domain.User{}).
 		Where("hashed_uuid = ?", hashedUUID).
 		Updates(map[string]interface{}{
-			"api_token_hash": apiTokenHash,
-			"scopes":         scopes,
+			"api_token_lookup_id": lookupID,
+			"api_token_hash":      apiTokenHash,
+			"scopes":              scopes,
 		})
 
 	if result.Error != nil {
@@ -179,3 +485,40 @@ func (r *UserRepo) UpdateTokenAndScopes(ctx context.Context, hashedUUID string,
 	r.log.Debug().Str("hashed_uuid", hashedUUID).Msg("Successfully updated user token and scopes")
 	return nil
 }
+
+// RotateHashedUUID moves a user to a new HashedUUID. The new user row is stored
+// first, so foreign keys to users hold while the dependent rows are re-keyed.
+func (r *UserRepo) RotateHashedUUID(ctx context.Context, oldHashedUUID string, newUser domain.User) error {
+	err := r.db.Get().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newUser).Error; err != nil {
+			return errors.Wrap(err, "failed to store rotated user")
+		}
+
+		for _, dependent := range userDependents {
+			if err := tx.Table(dependent.table).Where(dependent.column+" = ?", oldHashedUUID).Update(dependent.column, newUser.HashedUUID).Error; err != nil {
+				return errors.Wrap(err, "failed to move %s to rotated user", dependent.table)
+			}
+		}
+
+		// Named tokens were handed out for the old bookmark, they are invalidated with it
+		if err := tx.Where("user_hashed_uuid = ?", oldHashedUUID).Delete(&domain.APIToken{}).Error; err != nil {
+			return errors.Wrap(err, "failed to delete API tokens of rotated user")
+		}
+
+		result := tx.Where("hashed_uuid = ?", oldHashedUUID).Delete(&domain.User{})
+		if result.Error != nil {
+			return errors.Wrap(result.Error, "failed to delete rotated user")
+		}
+		if result.RowsAffected == 0 {
+			return errors.Wrap(gorm.ErrRecordNotFound, "user with hashed_uuid %s not found for rotation", oldHashedUUID)
+		}
+		return nil
+	})
+	if err != nil {
+		r.log.Error().Err(err).Str("hashed_uuid", oldHashedUUID).Msg("Failed to rotate user HashedUUID")
+		return err
+	}
+
+	r.log.Info().Str("hashed_uuid", newUser.HashedUUID).Msg("Successfully rotated user HashedUUID")
+	return nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+type WebAuthnCredentialRepo struct {
+	log zerolog.Logger
+	db  *DB
+}
+
+func NewWebAuthnCredentialRepo(log logger.Logger, db *DB) domain.WebAuthnCredentialRepo {
+	return &WebAuthnCredentialRepo{
+		log: log.With().Str("repo", "webauthn_credential").Logger(),
+		db:  db,
+	}
+}
+
+// Store inserts a new passkey.
+func (r *WebAuthnCredentialRepo) Store(ctx context.Context, credential *domain.WebAuthnCredential) error {
+	if err := r.db.Get().WithContext(ctx).Create(credential).Error; err != nil {
+		r.log.Error().Err(err).Msg("Failed to store passkey")
+		return errors.Wrap(err, "failed to store passkey")
+	}
+
+	return nil
+}
+
+// FindByUser returns the passkeys of a user, oldest first.
+func (r *WebAuthnCredentialRepo) FindByUser(ctx context.Context, userHashedUUID string) ([]domain.WebAuthnCredential, error) {
+	var credentials []domain.WebAuthnCredential
+	result := r.db.Get().WithContext(ctx).
+		Where("user_hashed_uuid = ?", userHashedUUID).
+		Order("created_at asc").
+		Find(&credentials)
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Msg("Failed to find passkeys of user")
+		return nil, errors.Wrap(result.Error, "failed to find passkeys of user")
+	}
+
+	return credentials, nil
+}
+
+// FindByCredentialID returns the passkey with the given credential ID, nil if not found.
+func (r *WebAuthnCredentialRepo) FindByCredentialID(ctx context.Context, credentialID string) (*domain.WebAuthnCredential, error) {
+	var credential domain.WebAuthnCredential
+	result := r.db.Get().WithContext(ctx).Where("credential_id = ?", credentialID).First(&credential)
+
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		r.log.Error().Err(result.Error).Msg("Failed to find passkey by credential ID")
+		return nil, errors.Wrap(result.Error, "failed to find passkey by credential ID")
+	}
+
+	return &credential, nil
+}
+
+// RecordUse replaces the stored credential and sets the last used time of a passkey.
+func (r *WebAuthnCredentialRepo) RecordUse(ctx context.Context, id int64, credential []byte, usedAt time.Time) error {
+	result := r.db.Get().WithContext(ctx).
+		Model(&domain.WebAuthnCredential{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"credential":   credential,
+			"last_used_at": usedAt,
+		})
+
+	if result.Error != nil {
+		r.log.Error().Err(result.Error).Int64("passkey_id", id).Msg("Failed to record passkey use")
+		return errors.Wrap(result.Error, "failed to record passkey use")
+	}
+
+	return nil
+}
+
+// Delete removes a passkey, scoped to its owner. The last passkey of a user
+// who turned the bookmark UUID login off is kept, the remaining passkeys are
+// counted within the same transaction as the delete.
+func (r *WebAuthnCredentialRepo) Delete(ctx context.Context, userHashedUUID string, id int64) error {
+	err := r.db.Get().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, userHashedUUID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return domain.ErrWebAuthnCredentialNotFound
+			}
+			return err
+		}
+
+		result := tx.Where("id = ? AND user_hashed_uuid = ?", id, userHashedUUID).Delete(&domain.WebAuthnCredential{})
+		if result.Error != nil {
+			return errors.Wrap(result.Error, "failed to delete passkey")
+		}
+		if result.RowsAffected == 0 {
+			return domain.ErrWebAuthnCredentialNotFound
+		}
+
+		var user domain.User
+		if err := tx.Select("uuid_login_disabled").Where("hashed_uuid = ?", userHashedUUID).First(&user).Error; err != nil {
+			return errors.Wrap(err, "failed to read uuid login setting")
+		}
+		if user.UUIDLoginDisabled {
+			var remaining int64
+			if err := tx.Model(&domain.WebAuthnCredential{}).Where("user_hashed_uuid = ?", userHashedUUID).Count(&remaining).Error; err != nil {
+				return errors.Wrap(err, "failed to count passkeys of user")
+			}
+			if remaining == 0 {
+				// Rolls the delete back
+				return domain.ErrLastWebAuthnCredential
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, domain.ErrWebAuthnCredentialNotFound) && !errors.Is(err, domain.ErrLastWebAuthnCredential) {
+		r.log.Error().Err(err).Int64("passkey_id", id).Msg("Failed to delete passkey")
+	}
+
+	return err
+}
@@ -0,0 +1,83 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebAuthnCredentialRepo_Delete(t *testing.T) {
+	tests := []struct {
+		name         string
+		passkeys     int
+		uuidDisabled bool
+		id           int64 // 0 deletes the first passkey
+		wantErr      error
+		wantLeft     int
+	}{
+		{name: "uuid_login_enabled", passkeys: 1, wantLeft: 0},
+		{name: "last_passkey", passkeys: 1, uuidDisabled: true, wantErr: domain.ErrLastWebAuthnCredential, wantLeft: 1},
+		{name: "one_of_two", passkeys: 2, uuidDisabled: true, wantLeft: 1},
+		{name: "unknown", passkeys: 1, uuidDisabled: true, id: 99, wantErr: domain.ErrWebAuthnCredentialNotFound, wantLeft: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db, log := databasetest.New(t)
+			users := database.NewUserRepo(log, db)
+			repo := database.NewWebAuthnCredentialRepo(log, db)
+
+			require.NoError(t, users.Store(ctx, domain.User{HashedUUID: "bookmark", PublicID: "public", APITokenHash: "hash"}))
+			for i := range tt.passkeys {
+				require.NoError(t, repo.Store(ctx, &domain.WebAuthnCredential{UserHashedUUID: "bookmark", Name: "Passkey", CredentialID: string(rune('a' + i))}))
+			}
+			if tt.uuidDisabled {
+				require.NoError(t, users.UpdateUUIDLoginDisabled(ctx, "bookmark", true))
+			}
+			passkeys, err := repo.FindByUser(ctx, "bookmark")
+			require.NoError(t, err)
+			id := tt.id
+			if id == 0 {
+				id = passkeys[0].ID
+			}
+
+			err = repo.Delete(ctx, "bookmark", id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			passkeys, err = repo.FindByUser(ctx, "bookmark")
+			require.NoError(t, err)
+			assert.Len(t, passkeys, tt.wantLeft)
+		})
+	}
+}
+
+func TestUserRepo_UpdateUUIDLoginDisabled(t *testing.T) {
+	ctx := context.Background()
+	db, log := databasetest.New(t)
+	users := database.NewUserRepo(log, db)
+	passkeys := database.NewWebAuthnCredentialRepo(log, db)
+	require.NoError(t, users.Store(ctx, domain.User{HashedUUID: "bookmark", PublicID: "public", APITokenHash: "hash"}))
+
+	// Without a passkey there would be no way to log in
+	assert.ErrorIs(t, users.UpdateUUIDLoginDisabled(ctx, "bookmark", true), domain.ErrNoWebAuthnCredential)
+
+	require.NoError(t, passkeys.Store(ctx, &domain.WebAuthnCredential{UserHashedUUID: "bookmark", Name: "Passkey", CredentialID: "a"}))
+	require.NoError(t, users.UpdateUUIDLoginDisabled(ctx, "bookmark", true))
+	u, err := users.FindByHashedUUID(ctx, "bookmark")
+	require.NoError(t, err)
+	assert.True(t, u.UUIDLoginDisabled)
+
+	// Turning it back on needs no passkey
+	require.NoError(t, users.UpdateUUIDLoginDisabled(ctx, "bookmark", false))
+
+	assert.Error(t, users.UpdateUUIDLoginDisabled(ctx, "unknown", false))
+}
@@ -0,0 +1,331 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+const (
+	// CodeTTL is how long a device has to be approved and pick up its token.
+	CodeTTL = 10 * time.Minute
+	// PollInterval is the minimum time between two polls of a device.
+	PollInterval = 5 * time.Second
+
+	deviceCodeBytes = 32
+	userCodeLength  = 8
+	// userCodeAlphabet has no vowels and no characters that are easily confused, see RFC 8628 section 6.1.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	maxNameLength    = 64
+	defaultName      = "Device"
+
+	deviceKeyPrefix   = "device:code:"
+	userCodeKeyPrefix = "device:user:"
+	pollKeyPrefix     = "device:poll:"
+)
+
+var (
+	ErrInvalidRequest       = pkgErrors.New("invalid device authorization request")
+	ErrInvalidUserCode      = pkgErrors.New("unknown or expired user code")
+	ErrAuthorizationPending = pkgErrors.New("authorization pending")
+	ErrSlowDown             = pkgErrors.New("polling too fast")
+	ErrAccessDenied         = pkgErrors.New("authorization denied")
+	ErrExpiredToken         = pkgErrors.New("device code expired")
+	ErrScopeNotGranted      = pkgErrors.New("requested scope is not granted to you")
+)
+
+// userService is the part of user.Service issuing API tokens.
+type userService interface {
+	CreateAPIToken(ctx context.Context, hashedUUID string, allowed domain.ScopeSet, req domain.CreateAPITokenRequest) (string, *domain.APIToken, error)
+}
+
+type Service interface {
+	// Request starts a pairing and returns the secret device code the device polls with.
+	Request(ctx context.Context, name string, scopes []string) (deviceCode string, authorization *domain.DeviceAuthorization, err error)
+	// Approve lets the device of a user code obtain an API token of the user, limited to the allowed scopes.
+	Approve(ctx context.Context, userCode string, userHashedUUID string, allowed domain.ScopeSet) (*domain.DeviceAuthorization, error)
+	// Deny rejects the device of a user code.
+	Deny(ctx context.Context, userCode string) (*domain.DeviceAuthorization, error)
+	// Poll returns the API token once the device code was approved, it is only returned once.
+	// Until then it returns ErrAuthorizationPending, ErrSlowDown, ErrAccessDenied or ErrExpiredToken.
+	Poll(ctx context.Context, deviceCode string) (plainToken string, token *domain.APIToken, err error)
+}
+
+// record is a device authorization as stored in Valkey, with the approving user.
+type record struct {
+	domain.DeviceAuthorization
+	UserHashedUUID string   `json:"user_hashed_uuid,omitempty"`
+	AllowedScopes  []string `json:"allowed_scopes,omitempty"`
+}
+
+type service struct {
+	log     zerolog.Logger
+	client  valkeyClient.Client
+	userSvc userService
+}
+
+func NewService(log logger.Logger, userSvc userService, client valkeyClient.Client) Service {
+	return &service{
+		log:     log.With().Str("module", "device").Logger(),
+		client:  client,
+		userSvc: userSvc,
+	}
+}
+
+// deviceCodeID returns the ID a device code is stored under, the code itself is not stored.
+func deviceCodeID(deviceCode string) string {
+	sum := sha256.Sum256([]byte(deviceCode))
+	return hex.EncodeToString(sum[:])
+}
+
+// newUserCode returns a random code formatted as XXXX-XXXX.
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// normalizeUserCode accepts user codes typed in lower case or without the dash.
+func normalizeUserCode(userCode string) string {
+	code := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(userCode))
+	if len(code) != userCodeLength {
+		return ""
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+func (s *service) Request(ctx context.Context, name string, scopes []string) (string, *domain.DeviceAuthorization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultName
+	}
+	if len(name) > maxNameLength {
+		return "", nil, pkgErrors.Wrap(ErrInvalidRequest, "name is longer than %d characters", maxNameLength)
+	}
+	for _, scope := range scopes {
+		if _, ok := domain.ParseScope(scope); !ok {
+			return "", nil, pkgErrors.Wrap(ErrInvalidRequest, "unknown scope %q", scope)
+		}
+	}
+
+	raw := make([]byte, deviceCodeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not generate device code")
+	}
+	deviceCode := hex.EncodeToString(raw)
+	id := deviceCodeID(deviceCode)
+
+	userCode, err := newUserCode()
+	if err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not generate user code")
+	}
+
+	now := time.Now()
+	rec := &record{DeviceAuthorization: domain.DeviceAuthorization{
+		UserCode:  userCode,
+		Name:      name,
+		Scopes:    scopes,
+		Status:    domain.DeviceAuthorizationPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(CodeTTL),
+	}}
+	encoded, err := json.Marshal(rec)
+	if err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not encode device authorization")
+	}
+
+	// A colliding user code of another pending device is not overwritten
+	err = s.client.Do(ctx, s.client.B().Set().Key(userCodeKeyPrefix+userCode).Value(id).Nx().Ex(CodeTTL).Build()).Error()
+	if errors.Is(err, valkeyClient.Nil) {
+		return "", nil, pkgErrors.New("user code collision, retry")
+	}
+	if err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not store user code")
+	}
+	if err := s.client.Do(ctx, s.client.B().Set().Key(deviceKeyPrefix+id).Value(string(encoded)).Ex(CodeTTL).Build()).Error(); err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not store device authorization")
+	}
+
+	s.log.Info().Str("name", name).Str("user_code", userCode).Msg("device authorization requested")
+	return deviceCode, &rec.DeviceAuthorization, nil
+}
+
+// get returns a stored authorization, nil if it does not exist or expired.
+func (s *service) get(ctx context.Context, id string) (*record, error) {
+	value, err := s.client.Do(ctx, s.client.B().Get().Key(deviceKeyPrefix+id).Build()).ToString()
+	if err != nil {
+		if errors.Is(err, valkeyClient.Nil) {
+			return nil, nil
+		}
+		return nil, pkgErrors.Wrap(err, "could not read device authorization")
+	}
+
+	var rec record
+	if err := json.Unmarshal([]byte(value), &rec); err != nil {
+		return nil, pkgErrors.Wrap(err, "could not decode device authorization")
+	}
+	return &rec, nil
+}
+
+// decide takes the user code, so it is decided on once, and stores the
+// authorization changed by apply. The expiry of the device code is kept.
+func (s *service) decide(ctx context.Context, userCode string, apply func(rec *record) error) (*record, error) {
+	userCode = normalizeUserCode(userCode)
+	if userCode == "" {
+		return nil, ErrInvalidUserCode
+	}
+
+	id, err := s.client.Do(ctx, s.client.B().Getdel().Key(userCodeKeyPrefix+userCode).Build()).ToString()
+	if errors.Is(err, valkeyClient.Nil) {
+		return nil, ErrInvalidUserCode
+	}
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not read user code")
+	}
+
+	rec, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.Status != domain.DeviceAuthorizationPending {
+		return nil, ErrInvalidUserCode
+	}
+
+	if err := apply(rec); err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(rec)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not encode device authorization")
+	}
+	// XX, a device authorization that expired meanwhile is not revived
+	if err := s.client.Do(ctx, s.client.B().Set().Key(deviceKeyPrefix+id).Value(string(encoded)).Xx().Keepttl().Build()).Error(); err != nil {
+		if errors.Is(err, valkeyClient.Nil) {
+			return nil, ErrInvalidUserCode
+		}
+		return nil, pkgErrors.Wrap(err, "could not store device authorization")
+	}
+
+	return rec, nil
+}
+
+func (s *service) Approve(ctx context.Context, userCode string, userHashedUUID string, allowed domain.ScopeSet) (*domain.DeviceAuthorization, error) {
+	rec, err := s.decide(ctx, userCode, func(rec *record) error {
+		// Checked now so the user sees it, the token is only created when the device polls
+		for _, name := range rec.Scopes {
+			if scope, _ := domain.ParseScope(name); !allowed.Has(scope) {
+				return pkgErrors.Wrap(ErrScopeNotGranted, "scope %q", name)
+			}
+		}
+
+		rec.Status = domain.DeviceAuthorizationApproved
+		rec.UserHashedUUID = userHashedUUID
+		rec.AllowedScopes = allowed.Names()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info().Str("hashed_uuid", userHashedUUID).Str("name", rec.Name).Msg("device authorization approved")
+	return &rec.DeviceAuthorization, nil
+}
+
+func (s *service) Deny(ctx context.Context, userCode string) (*domain.DeviceAuthorization, error) {
+	rec, err := s.decide(ctx, userCode, func(rec *record) error {
+		rec.Status = domain.DeviceAuthorizationDenied
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info().Str("name", rec.Name).Msg("device authorization denied")
+	return &rec.DeviceAuthorization, nil
+}
+
+func (s *service) Poll(ctx context.Context, deviceCode string) (string, *domain.APIToken, error) {
+	if deviceCode == "" {
+		return "", nil, ErrExpiredToken
+	}
+	id := deviceCodeID(deviceCode)
+
+	err := s.client.Do(ctx, s.client.B().Set().Key(pollKeyPrefix+id).Value("1").Nx().Px(PollInterval).Build()).Error()
+	if errors.Is(err, valkeyClient.Nil) {
+		return "", nil, ErrSlowDown
+	}
+	if err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not record device poll")
+	}
+
+	rec, err := s.get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if rec == nil {
+		return "", nil, ErrExpiredToken
+	}
+
+	switch rec.Status {
+	case domain.DeviceAuthorizationPending:
+		return "", nil, ErrAuthorizationPending
+	case domain.DeviceAuthorizationDenied:
+		s.delete(ctx, id)
+		return "", nil, ErrAccessDenied
+	}
+
+	// Only the poll deleting the authorization issues the token
+	deleted, err := s.client.Do(ctx, s.client.B().Del().Key(deviceKeyPrefix+id).Build()).AsInt64()
+	if err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not delete device authorization")
+	}
+	if deleted == 0 {
+		return "", nil, ErrExpiredToken
+	}
+
+	plainToken, token, err := s.userSvc.CreateAPIToken(ctx, rec.UserHashedUUID, domain.NewScopeSet(scopesOf(rec.AllowedScopes)...), domain.CreateAPITokenRequest{
+		Name:   rec.Name,
+		Scopes: rec.Scopes,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.log.Info().Str("hashed_uuid", rec.UserHashedUUID).Int64("token_id", token.ID).Msg("device paired")
+	return plainToken, token, nil
+}
+
+func (s *service) delete(ctx context.Context, id string) {
+	if err := s.client.Do(ctx, s.client.B().Del().Key(deviceKeyPrefix+id).Build()).Error(); err != nil {
+		s.log.Error().Err(err).Msg("could not delete device authorization")
+	}
+}
+
+func scopesOf(names []string) []domain.Scope {
+	scopes := make([]domain.Scope, 0, len(names))
+	for _, name := range names {
+		if scope, ok := domain.ParseScope(name); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
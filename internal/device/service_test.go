@@ -0,0 +1,122 @@
+package device
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/flurbudurbur/Shiori/internal/config"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+type fakeUserService struct {
+	created []domain.CreateAPITokenRequest
+}
+
+func (s *fakeUserService) CreateAPIToken(_ context.Context, hashedUUID string, _ domain.ScopeSet, req domain.CreateAPITokenRequest) (string, *domain.APIToken, error) {
+	s.created = append(s.created, req)
+	return "shi_token", &domain.APIToken{ID: int64(len(s.created)), UserHashedUUID: hashedUUID, Name: req.Name, Scopes: req.Scopes}, nil
+}
+
+func newTestService(t *testing.T) (Service, *fakeUserService, *miniredis.Miniredis) {
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	users := &fakeUserService{}
+	logCfg := config.New(t.TempDir(), "test").Config
+	logCfg.Logging.Path = "" // Log to stderr only, not into the package directory
+	log := logger.New(logCfg)
+	return NewService(log, users, client), users, valkey
+}
+
+func TestService_Approve(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    func(userCode string) string // What the user types
+		scopes  []domain.Scope               // The scopes of the approving user
+		wantErr error
+	}{
+		{name: "approved", code: func(c string) string { return c }, scopes: domain.DefaultScopes},
+		// User codes are accepted in lower case and without the dash
+		{name: "typed", code: func(c string) string { return " " + strings.ToLower(c[:4]+c[5:]) + " " }, scopes: domain.DefaultScopes},
+		{name: "unknown", code: func(string) string { return "BCDF-GHJK" }, scopes: domain.DefaultScopes, wantErr: ErrInvalidUserCode},
+		{name: "scope_not_granted", code: func(c string) string { return c }, scopes: []domain.Scope{domain.ScopeSyncWrite}, wantErr: ErrScopeNotGranted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			svc, _, _ := newTestService(t)
+			_, authorization, err := svc.Request(ctx, "Phone", []string{"sync:read"})
+			require.NoError(t, err)
+			assert.Regexp(t, `^[B-Z]{4}-[B-Z]{4}$`, authorization.UserCode)
+
+			approved, err := svc.Approve(ctx, tt.code(authorization.UserCode), "bookmark", domain.NewScopeSet(tt.scopes...))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, domain.DeviceAuthorizationApproved, approved.Status)
+
+			_, err = svc.Approve(ctx, authorization.UserCode, "other", domain.NewScopeSet(domain.DefaultScopes...))
+			assert.ErrorIs(t, err, ErrInvalidUserCode, "a user code is decided on once")
+		})
+	}
+}
+
+func TestService_Poll(t *testing.T) {
+	ctx := context.Background()
+	svc, users, valkey := newTestService(t)
+
+	deviceCode, authorization, err := svc.Request(ctx, "Phone", []string{"sync:read"})
+	require.NoError(t, err)
+
+	_, _, err = svc.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrAuthorizationPending)
+
+	// Polling again within the interval is throttled
+	_, _, err = svc.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrSlowDown)
+
+	_, err = svc.Approve(ctx, authorization.UserCode, "bookmark", domain.NewScopeSet(domain.DefaultScopes...))
+	require.NoError(t, err)
+
+	valkey.FastForward(PollInterval)
+	plainToken, token, err := svc.Poll(ctx, deviceCode)
+	require.NoError(t, err)
+	assert.Equal(t, "shi_token", plainToken)
+	assert.Equal(t, "bookmark", token.UserHashedUUID)
+	assert.Equal(t, []domain.CreateAPITokenRequest{{Name: "Phone", Scopes: []string{"sync:read"}}}, users.created)
+
+	valkey.FastForward(PollInterval)
+	_, _, err = svc.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrExpiredToken, "the token is issued once")
+}
+
+func TestService_Deny(t *testing.T) {
+	ctx := context.Background()
+	svc, users, valkey := newTestService(t)
+
+	deviceCode, authorization, err := svc.Request(ctx, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Device", authorization.Name)
+
+	_, err = svc.Deny(ctx, authorization.UserCode)
+	require.NoError(t, err)
+
+	_, _, err = svc.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	assert.Empty(t, users.created)
+
+	valkey.FastForward(CodeTTL + time.Second)
+	_, _, err = svc.Poll(ctx, deviceCode)
+	assert.ErrorIs(t, err, ErrExpiredToken)
+}
@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// TokenPair is a short-lived access token with the refresh token that renews
+// it. Refresh tokens are single use, every refresh returns a new pair of the
+// same token family.
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+	Scopes           ScopeSet
+}
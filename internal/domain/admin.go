@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// UserSummary is a user as listed to administrators. It is identified by the
+// PublicID, the bookmark is a credential and never part of it.
+type UserSummary struct {
+	ID           string     `json:"id"`
+	Admin        bool       `json:"admin"`
+	Disabled     bool       `json:"disabled"`
+	Scopes       []string   `json:"scopes"`
+	DeletionDate time.Time  `json:"deletion_date"`
+	Expired      bool       `json:"expired"`
+	StorageBytes int64      `json:"storage_bytes"`
+	LastSyncAt   *time.Time `json:"last_sync_at,omitempty"` // Latest upload to any slot
+	HasPassword  bool       `json:"has_password"`
+	TOTPEnabled  bool       `json:"totp_enabled"`
+}
@@ -0,0 +1,54 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+var ErrAPITokenNotFound = errors.Sentinel("api token not found")
+
+type APITokenRepo interface {
+	Store(ctx context.Context, token *APIToken) error
+	// FindByLookupID returns the token with the given lookup ID, nil if there is none.
+	FindByLookupID(ctx context.Context, lookupID string) (*APIToken, error)
+	// FindByUser returns the tokens of a user, newest first.
+	FindByUser(ctx context.Context, userHashedUUID string) ([]APIToken, error)
+	// Delete removes a token of a user, returns ErrAPITokenNotFound if the user has no such token.
+	Delete(ctx context.Context, userHashedUUID string, id int64) error
+	// RecordUse stores when and from where a token was last used.
+	RecordUse(ctx context.Context, id int64, usedAt time.Time, ip string) error
+}
+
+// APIToken is a named credential of a user, so every device or script can be
+// revoked on its own. Tokens share the shi_<lookupID>_<secret> format of the
+// user token, only the SHA-256 of the secret is stored.
+type APIToken struct {
+	ID             int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	UserHashedUUID string     `json:"-" gorm:"column:user_hashed_uuid;index"`
+	Name           string     `json:"name" gorm:"column:name"`
+	LookupID       string     `json:"-" gorm:"column:lookup_id;uniqueIndex"`
+	SecretHash     string     `json:"-" gorm:"column:secret_hash"`
+	Scopes         []string   `json:"scopes" gorm:"column:scopes;serializer:json"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty" gorm:"column:last_used_at"`
+	LastUsedIP     string     `json:"last_used_ip,omitempty" gorm:"column:last_used_ip"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty" gorm:"column:expires_at"`
+}
+
+// TableName specifies the database table name for the APIToken model
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+// Expired reports whether the token has passed its expiry.
+func (t APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type AuditEventRepo interface {
+	Store(ctx context.Context, event AuditEvent) error
+	// Find returns the events matching params, newest first, and the total number of matches.
+	Find(ctx context.Context, params AuditEventQueryParams) ([]AuditEvent, int, error)
+	// DeleteOlderThan removes events created before the given time, returns the number removed.
+	DeleteOlderThan(ctx context.Context, before time.Time) (int, error)
+}
+
+// AuditAction names what an audit event records.
+type AuditAction string
+
+const (
+	AuditActionGrantAdmin     AuditAction = "admin.grant"
+	AuditActionExtendExpiry   AuditAction = "admin.user.extend_expiry"
+	AuditActionDisableUser    AuditAction = "admin.user.disable"
+	AuditActionEnableUser     AuditAction = "admin.user.enable"
+	AuditActionResetUserToken AuditAction = "admin.user.reset_token"
+	AuditActionDeleteUser     AuditAction = "admin.user.delete"
+	AuditActionCreateInvite   AuditAction = "admin.invite.create"
+	AuditActionRevokeInvite   AuditAction = "admin.invite.revoke"
+	AuditActionUpdateConfig   AuditAction = "admin.config.update"
+
+	AuditActionLogin       AuditAction = "auth.login"
+	AuditActionLoginFailed AuditAction = "auth.login_failed"
+	AuditActionLockout     AuditAction = "auth.lockout"
+	// AuditActionRefreshTokenReuse records a rotated refresh token used again, its token family is revoked.
+	AuditActionRefreshTokenReuse AuditAction = "auth.refresh_token_reuse"
+	AuditActionRegister          AuditAction = "user.register"
+	AuditActionResetToken        AuditAction = "user.reset_token"
+	AuditActionMergeAccount      AuditAction = "user.merge"
+	AuditActionRevokeSession     AuditAction = "session.revoke"
+	AuditActionRevokeSessions    AuditAction = "session.revoke_others"
+)
+
+// AuditActorSystem is the actor of actions taken on startup or from the command line.
+const AuditActorSystem = "system"
+
+// AuditEvent records a security relevant action, such as a login or an
+// administrative action. Users are referenced by their PublicID, events
+// outlive the users they are about and hold no credentials. The actor is
+// empty when it is unknown, as for a login with an unknown bookmark.
+type AuditEvent struct {
+	ID       int64       `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	Action   AuditAction `json:"action" gorm:"column:action;index"`
+	ActorID  string      `json:"actor_id" gorm:"column:actor_id;index"`
+	TargetID string      `json:"target_id,omitempty" gorm:"column:target_id;index"`
+	IP       string      `json:"ip,omitempty" gorm:"column:ip"`
+	// Details holds action specific values, such as the new deletion date.
+	Details   map[string]string `json:"details,omitempty" gorm:"column:details;serializer:json"`
+	CreatedAt time.Time         `json:"created_at" gorm:"column:created_at;autoCreateTime;index"`
+}
+
+// TableName specifies the database table name for the AuditEvent model
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+type AuditEventQueryParams struct {
+	// UserID matches events with the user as actor or target.
+	UserID   string
+	ActorID  string
+	TargetID string
+	Action   AuditAction
+	Limit    uint64
+	Offset   uint64
+}
@@ -1,10 +1,14 @@
 package domain
 
+import "time"
+
 // ServerConfig holds server-related settings
 type ServerConfig struct {
 	Host    string `mapstructure:"host"`
 	Port    int    `mapstructure:"port"`
 	BaseURL string `mapstructure:"base_url"` // Changed tag from baseUrl to base_url
+	// AllowedOrigins are comma-separated origins of other sites allowed to call the API with credentials
+	AllowedOrigins string `mapstructure:"allowed_origins"`
 }
 
 // PostgresConfig holds PostgreSQL-specific settings
@@ -56,6 +60,108 @@ type UUIDCleanupConfig struct {
 	UseSoftDelete       bool   `mapstructure:"use_soft_delete"`
 }
 
+// SyncConfig holds settings for the sync endpoints and their audit log
+type SyncConfig struct {
+	MaxPayloadSize     int64 `mapstructure:"max_payload_size"`     // Maximum accepted upload size in bytes
+	EventRetentionDays int   `mapstructure:"event_retention_days"` // Days to keep sync audit events
+	MaxSlots           int   `mapstructure:"max_slots"`            // Named slots per user, besides the default slot
+	MaxStoragePerUser  int64 `mapstructure:"max_storage_per_user"` // Bytes stored per user over all slots, 0 for no limit
+	HistoryVersions    int   `mapstructure:"history_versions"`     // Replaced versions kept per slot, 0 disables the history
+}
+
+// LockoutConfig holds the brute-force protection settings for logins and API tokens
+type LockoutConfig struct {
+	Threshold           int `mapstructure:"threshold"`             // Failures within the window before a lockout
+	WindowSeconds       int `mapstructure:"window_seconds"`        // Time window in which failures are counted
+	BaseDurationSeconds int `mapstructure:"base_duration_seconds"` // First lockout duration, doubled on every repeated lockout
+	MaxDurationSeconds  int `mapstructure:"max_duration_seconds"`  // Upper bound of the lockout duration
+}
+
+// OIDCConfig holds the optional OpenID Connect login settings
+type OIDCConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	Issuer        string `mapstructure:"issuer"` // Issuer URL, the provider is discovered from its /.well-known/openid-configuration
+	ClientID      string `mapstructure:"client_id"`
+	ClientSecret  string `mapstructure:"client_secret"`
+	RedirectURL   string `mapstructure:"redirect_url"`   // Public URL of /api/auth/oidc/callback
+	Scopes        string `mapstructure:"scopes"`         // Comma-separated scopes requested besides openid
+	AutoProvision bool   `mapstructure:"auto_provision"` // Create a user for identities not linked to one
+}
+
+// ForwardAuthConfig holds the settings for authentication by a trusted reverse proxy
+type ForwardAuthConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	UserHeader     string `mapstructure:"user_header"`     // Header carrying the username, e.g. Remote-User
+	TrustedProxies string `mapstructure:"trusted_proxies"` // Comma-separated IPs or CIDRs the header and forwarded client addresses are accepted from
+}
+
+// WebAuthnConfig holds the passkey login settings
+type WebAuthnConfig struct {
+	RPID          string `mapstructure:"rp_id"`           // Domain of the web UI without scheme and port, empty disables passkeys
+	RPDisplayName string `mapstructure:"rp_display_name"` // Name shown by the browser when using a passkey
+	RPOrigins     string `mapstructure:"rp_origins"`      // Comma-separated origins the web UI is served from
+}
+
+// PasswordConfig holds the argon2id parameters for account passwords, hashes
+// made with other parameters are upgraded on the next successful login
+type PasswordConfig struct {
+	MinLength   int    `mapstructure:"min_length"`  // Minimum length, short PINs are allowed
+	Memory      uint32 `mapstructure:"memory"`      // Memory used in KiB
+	Iterations  uint32 `mapstructure:"iterations"`  // Passes over the memory
+	Parallelism uint8  `mapstructure:"parallelism"` // Threads used
+	SaltLength  uint32 `mapstructure:"salt_length"` // Salt length in bytes
+	KeyLength   uint32 `mapstructure:"key_length"`  // Hash length in bytes
+}
+
+// TwoFactorConfig holds the TOTP settings
+type TwoFactorConfig struct {
+	Issuer        string `mapstructure:"issuer"`         // Name shown in authenticator apps
+	EncryptionKey string `mapstructure:"encryption_key"` // Key TOTP secrets are encrypted with, session_secret when empty
+}
+
+// RetentionConfig holds how long inactive accounts are kept
+type RetentionConfig struct {
+	Days        int `mapstructure:"days"`         // Days an account is kept after its last sync
+	WarningDays int `mapstructure:"warning_days"` // Days before the expiry users are notified, 0 disables warnings
+	GraceDays   int `mapstructure:"grace_days"`   // Days an expired account stays read-only before deletion, 0 deletes it on expiry
+}
+
+// ExpiresAt returns the expiry of an account last synced at t.
+func (c RetentionConfig) ExpiresAt(t time.Time) time.Time {
+	return t.AddDate(0, 0, c.Days)
+}
+
+// DeletesAt returns when an account expiring at expiry is deleted, after its grace period.
+func (c RetentionConfig) DeletesAt(expiry time.Time) time.Time {
+	return expiry.AddDate(0, 0, c.GraceDays)
+}
+
+// AdminConfig holds who administers the instance
+type AdminConfig struct {
+	Users string `mapstructure:"users"` // Comma-separated account IDs (PublicID) granted the admin role on startup
+}
+
+// RegistrationConfig holds who can create an account with the register endpoint,
+// the mode and the user limit also apply to OIDC and forward auth provisioning
+type RegistrationConfig struct {
+	Mode            string `mapstructure:"mode"`               // open, invite or closed
+	MaxUsers        int    `mapstructure:"max_users"`          // Accounts at which registration stops, a soft limit, 0 for no limit
+	ProofOfWorkBits int    `mapstructure:"proof_of_work_bits"` // Difficulty of the registration challenge, 0 disables it
+}
+
+// AuditConfig holds how long the security audit log is kept
+type AuditConfig struct {
+	RetentionDays int `mapstructure:"retention_days"` // Days to keep audit events, 0 keeps them forever
+}
+
+// TokenConfig holds the optional short-lived access tokens for mobile clients
+type TokenConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`              // Exchange API tokens at /api/auth/token for access and refresh tokens
+	AccessTokenMinutes int    `mapstructure:"access_token_minutes"` // Lifetime of an access token
+	RefreshTokenDays   int    `mapstructure:"refresh_token_days"`   // Days an unused refresh token stays valid
+	SigningKey         string `mapstructure:"signing_key"`          // Key access tokens are signed with, session_secret when empty
+}
+
 // Config holds the application's configuration, mapped from config.toml
 type Config struct {
 	Version         string // No tag needed, not from config file
@@ -63,12 +169,24 @@ type Config struct {
 	CheckForUpdates bool   `mapstructure:"check_for_updates"` // Changed tag from checkForUpdates
 	SessionSecret   string `mapstructure:"session_secret"`    // Changed tag from sessionSecret
 
-	Server      ServerConfig      `mapstructure:"server"`       // Nested Server config
-	Database    DatabaseConfig    `mapstructure:"database"`     // Nested Database config
-	Logging     LoggingConfig     `mapstructure:"logging"`      // Nested Logging config
-	Valkey      ValkeyConfig      `mapstructure:"valkey"`       // Nested Valkey config
-	RateLimit   RateLimitConfig   `mapstructure:"rate_limits"`   // Nested Rate Limit config
-	UUIDCleanup UUIDCleanupConfig `mapstructure:"uuid_cleanup"` // Nested UUID Cleanup config
+	Server       ServerConfig       `mapstructure:"server"`       // Nested Server config
+	Database     DatabaseConfig     `mapstructure:"database"`     // Nested Database config
+	Logging      LoggingConfig      `mapstructure:"logging"`      // Nested Logging config
+	Valkey       ValkeyConfig       `mapstructure:"valkey"`       // Nested Valkey config
+	RateLimit    RateLimitConfig    `mapstructure:"rate_limits"`  // Nested Rate Limit config
+	UUIDCleanup  UUIDCleanupConfig  `mapstructure:"uuid_cleanup"` // Nested UUID Cleanup config
+	Sync         SyncConfig         `mapstructure:"sync"`         // Nested Sync config
+	Lockout      LockoutConfig      `mapstructure:"lockout"`      // Nested Lockout config
+	OIDC         OIDCConfig         `mapstructure:"oidc"`         // Nested OpenID Connect config
+	ForwardAuth  ForwardAuthConfig  `mapstructure:"forward_auth"` // Nested forward auth config
+	WebAuthn     WebAuthnConfig     `mapstructure:"webauthn"`     // Nested passkey config
+	Password     PasswordConfig     `mapstructure:"password"`     // Nested account password config
+	TwoFactor    TwoFactorConfig    `mapstructure:"two_factor"`   // Nested TOTP config
+	Retention    RetentionConfig    `mapstructure:"retention"`    // Nested account retention config
+	Admin        AdminConfig        `mapstructure:"admin"`        // Nested administration config
+	Registration RegistrationConfig `mapstructure:"registration"` // Nested registration config
+	Audit        AuditConfig        `mapstructure:"audit"`        // Nested audit log config
+	Tokens       TokenConfig        `mapstructure:"tokens"`       // Nested access token config
 }
 
 // ConfigUpdate struct remains for potential partial updates via API,
@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"time"
+)
+
+type DeviceAuthorizationStatus string
+
+const (
+	DeviceAuthorizationPending  DeviceAuthorizationStatus = "pending"
+	DeviceAuthorizationApproved DeviceAuthorizationStatus = "approved"
+	DeviceAuthorizationDenied   DeviceAuthorizationStatus = "denied"
+)
+
+// DeviceAuthorization is a pairing request of a device, stored in Valkey until
+// it expires or the device picked up its API token. The device polls with a
+// secret device code, the user approves the short user code in the web UI.
+type DeviceAuthorization struct {
+	UserCode  string                    `json:"user_code"`
+	Name      string                    `json:"name"`   // Name of the API token issued to the device
+	Scopes    []string                  `json:"scopes"` // Requested scopes, empty for the API token defaults
+	Status    DeviceAuthorizationStatus `json:"status"`
+	CreatedAt time.Time                 `json:"created_at"`
+	ExpiresAt time.Time                 `json:"expires_at"`
+}
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+var ErrInviteNotFound = errors.Sentinel("invite not found")
+
+type InviteRepo interface {
+	Store(ctx context.Context, invite *Invite) error
+	// FindAll returns every invite, newest first.
+	FindAll(ctx context.Context) ([]Invite, error)
+	// Revoke ends an invite, it returns ErrInviteNotFound for unknown or revoked invites.
+	Revoke(ctx context.Context, id int64, at time.Time) error
+	// Consume uses the invite with the code hash once, it reports false if there is no usable invite.
+	Consume(ctx context.Context, codeHash string, now time.Time) (bool, error)
+}
+
+// Invite lets its holders register while registration is invite only.
+// Only the SHA-256 of the code is stored.
+type Invite struct {
+	ID        int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	CodeHash  string     `json:"-" gorm:"column:code_hash;uniqueIndex"`
+	Note      string     `json:"note" gorm:"column:note"`
+	MaxUses   int        `json:"max_uses" gorm:"column:max_uses"`
+	Uses      int        `json:"uses" gorm:"column:uses;not null;default:0"`
+	CreatedBy string     `json:"created_by" gorm:"column:created_by"` // PublicID of the admin
+	ExpiresAt *time.Time `json:"expires_at,omitempty" gorm:"column:expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" gorm:"column:revoked_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+// TableName specifies the database table name for the Invite model
+func (Invite) TableName() string {
+	return "invites"
+}
+
+type CreateInviteRequest struct {
+	Note      string     `json:"note"`
+	MaxUses   int        `json:"max_uses"` // 1 when omitted
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// HashInviteCode returns the value stored for an invite code. Codes are
+// compared without separators and case, as they are typed in by hand.
+func HashInviteCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
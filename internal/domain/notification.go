@@ -75,6 +75,8 @@ const (
 	NotificationEventSyncFailed         NotificationEvent = "SYNC_FAILED"
 	NotificationEventSyncError          NotificationEvent = "SYNC_ERROR"
 	NotificationEventTest               NotificationEvent = "TEST"
+	// NotificationEventAccountExpiring is sent to the channels of a user before their account expires
+	NotificationEventAccountExpiring NotificationEvent = "ACCOUNT_EXPIRING"
 )
 
 type NotificationEventArr []NotificationEvent
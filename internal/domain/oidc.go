@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type OIDCIdentityRepo interface {
+	Store(ctx context.Context, identity *OIDCIdentity) error
+	// FindBySubject returns the identity of a subject at an issuer, nil if there is none.
+	FindBySubject(ctx context.Context, issuer string, subject string) (*OIDCIdentity, error)
+	// RecordLogin stores when an identity was last used to log in.
+	RecordLogin(ctx context.Context, id int64, loginAt time.Time) error
+}
+
+// OIDCIdentity links the subject of an OpenID Connect provider to a user, so
+// the user can log in at the provider instead of with the bookmark UUID.
+type OIDCIdentity struct {
+	ID             int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	Issuer         string     `json:"issuer" gorm:"column:issuer;uniqueIndex:idx_oidc_identities_issuer_subject"`
+	Subject        string     `json:"-" gorm:"column:subject;uniqueIndex:idx_oidc_identities_issuer_subject"`
+	UserHashedUUID string     `json:"-" gorm:"column:user_hashed_uuid;index"`
+	Email          string     `json:"email,omitempty" gorm:"column:email"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	LastLoginAt    *time.Time `json:"last_login_at,omitempty" gorm:"column:last_login_at"`
+}
+
+// TableName specifies the database table name for the OIDCIdentity model
+func (OIDCIdentity) TableName() string {
+	return "oidc_identities"
+}
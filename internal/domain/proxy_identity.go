@@ -0,0 +1,26 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type ProxyIdentityRepo interface {
+	Store(ctx context.Context, identity *ProxyIdentity) error
+	// FindByUsername returns the identity of a username sent by the proxy, nil if there is none.
+	FindByUsername(ctx context.Context, username string) (*ProxyIdentity, error)
+}
+
+// ProxyIdentity maps a username asserted by a trusted reverse proxy, e.g. the
+// Remote-User header of Authelia, to the user it was provisioned for.
+type ProxyIdentity struct {
+	ID             int64     `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	Username       string    `json:"username" gorm:"column:username;uniqueIndex"`
+	UserHashedUUID string    `json:"-" gorm:"column:user_hashed_uuid;index"`
+	CreatedAt      time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+// TableName specifies the database table name for the ProxyIdentity model
+func (ProxyIdentity) TableName() string {
+	return "proxy_identities"
+}
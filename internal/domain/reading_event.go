@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type ReadingEventRepo interface {
+	// StoreMany inserts the events, skipping events that were already recorded.
+	StoreMany(ctx context.Context, events []ReadingEvent) error
+	// Find returns the events of a user read within [from, to), oldest first.
+	Find(ctx context.Context, userHashedUUID string, from, to *time.Time) ([]ReadingEvent, error)
+}
+
+// ReadingEvent is a single chapter read, derived from successive sync uploads.
+type ReadingEvent struct {
+	ID             int64     `json:"-" gorm:"primaryKey;autoIncrement;column:id"`
+	UserHashedUUID string    `json:"-" gorm:"column:user_hashed_uuid;uniqueIndex:idx_reading_event;index:idx_reading_event_user_read_at,priority:1"`
+	Slot           string    `json:"slot" gorm:"column:slot"` // sync slot the upload went to, empty for the default slot
+	Source         int64     `json:"source" gorm:"column:source;uniqueIndex:idx_reading_event"`
+	MangaURL       string    `json:"manga_url" gorm:"column:manga_url"`
+	MangaTitle     string    `json:"manga_title" gorm:"column:manga_title"`
+	ChapterURL     string    `json:"chapter_url" gorm:"column:chapter_url;uniqueIndex:idx_reading_event"`
+	ChapterNumber  float64   `json:"chapter_number" gorm:"column:chapter_number"`
+	ReadAt         time.Time `json:"read_at" gorm:"column:read_at;uniqueIndex:idx_reading_event;index:idx_reading_event_user_read_at,priority:2"`
+	ReadDuration   int64     `json:"read_duration" gorm:"column:read_duration"` // milliseconds, 0 if unknown
+	// Inferred is set when the chapter was marked read without a history entry,
+	// ReadAt is then the time of the upload that first showed it as read.
+	Inferred bool `json:"inferred" gorm:"column:inferred"`
+}
+
+// TableName specifies the database table name for the ReadingEvent model
+func (ReadingEvent) TableName() string {
+	return "reading_events"
+}
+
+// ReadingStatsGroup is the bucket reading events are grouped into.
+type ReadingStatsGroup string
+
+const (
+	ReadingStatsGroupDay     ReadingStatsGroup = "day"
+	ReadingStatsGroupWeek    ReadingStatsGroup = "week"
+	ReadingStatsGroupMonth   ReadingStatsGroup = "month"
+	ReadingStatsGroupHour    ReadingStatsGroup = "hour"
+	ReadingStatsGroupWeekday ReadingStatsGroup = "weekday"
+	ReadingStatsGroupSeries  ReadingStatsGroup = "series"
+)
+
+type ReadingStatsQuery struct {
+	From     *time.Time
+	To       *time.Time
+	Group    ReadingStatsGroup
+	Location *time.Location
+}
+
+type ReadingStats struct {
+	Group           ReadingStatsGroup    `json:"group"`
+	TimeZone        string               `json:"tz"`
+	ChaptersRead    int                  `json:"chapters_read"`
+	ReadingDuration int64                `json:"reading_duration"` // milliseconds
+	Buckets         []ReadingStatsBucket `json:"buckets"`
+}
+
+// ReadingStatsBucket holds the totals of one group, Key depends on the group:
+// a date (YYYY-MM-DD) for day, the monday of the week for week, YYYY-MM for month,
+// 0-23 for hour, the english day name for weekday and the title for series.
+type ReadingStatsBucket struct {
+	Key             string `json:"key"`
+	ChaptersRead    int    `json:"chapters_read"`
+	ReadingDuration int64  `json:"reading_duration"`
+}
+
+type ReadingStreaks struct {
+	TimeZone      string  `json:"tz"`
+	Current       int     `json:"current"`
+	Longest       int     `json:"longest"`
+	LongestStart  *string `json:"longest_start,omitempty"` // YYYY-MM-DD
+	LongestEnd    *string `json:"longest_end,omitempty"`
+	LastReadingOn *string `json:"last_reading_on,omitempty"`
+}
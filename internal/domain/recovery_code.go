@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type RecoveryCodeRepo interface {
+	// Replace deletes the codes of a user and stores new code hashes.
+	Replace(ctx context.Context, userHashedUUID string, codeHashes []string) error
+	// Use marks an unused code as used, it reports false if there was none with the hash.
+	Use(ctx context.Context, userHashedUUID string, codeHash string) (bool, error)
+	CountUnused(ctx context.Context, userHashedUUID string) (int, error)
+	DeleteByUser(ctx context.Context, userHashedUUID string) error
+}
+
+// RecoveryCode is a single-use code that replaces a TOTP code, only its hash is stored.
+type RecoveryCode struct {
+	ID             int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	UserHashedUUID string     `json:"-" gorm:"column:user_hashed_uuid;index"`
+	CodeHash       string     `json:"-" gorm:"column:code_hash"`
+	UsedAt         *time.Time `json:"used_at,omitempty" gorm:"column:used_at"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+// TableName specifies the database table name for the RecoveryCode model
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// TwoFactorStatus describes the two-factor authentication of a user.
+type TwoFactorStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
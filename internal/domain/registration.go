@@ -0,0 +1,35 @@
+package domain
+
+import "time"
+
+// RegistrationMode is who can create an account with the register endpoint.
+type RegistrationMode string
+
+const (
+	RegistrationModeOpen   RegistrationMode = "open"
+	RegistrationModeInvite RegistrationMode = "invite"
+	RegistrationModeClosed RegistrationMode = "closed"
+)
+
+// RegistrationStatus tells clients whether and how they can register.
+type RegistrationStatus struct {
+	Mode RegistrationMode `json:"mode"`
+	// Open is false when registration is closed or the user limit is reached.
+	Open            bool `json:"open"`
+	InviteRequired  bool `json:"invite_required"`
+	ProofOfWorkBits int  `json:"proof_of_work_bits,omitempty"`
+}
+
+// RegistrationChallenge is a proof of work, solved by a nonce for which the
+// SHA-256 of Challenge followed by the nonce starts with Bits zero bits.
+type RegistrationChallenge struct {
+	Challenge string    `json:"challenge"`
+	Bits      int       `json:"bits"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type RegisterRequest struct {
+	InviteCode string `json:"invite_code,omitempty"`
+	Challenge  string `json:"challenge,omitempty"`
+	Nonce      string `json:"nonce,omitempty"`
+}
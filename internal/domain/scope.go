@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// Scope is a permission granted to a user or an API token.
+type Scope string
+
+const (
+	ScopeSyncRead           Scope = "sync:read"
+	ScopeSyncWrite          Scope = "sync:write"
+	ScopeNotificationsWrite Scope = "notifications:write"
+	ScopeAdmin              Scope = "admin"
+)
+
+// KnownScopes lists every scope in a stable order.
+var KnownScopes = []Scope{ScopeSyncRead, ScopeSyncWrite, ScopeNotificationsWrite, ScopeAdmin}
+
+// DefaultScopes are granted to new users. Admin is never granted by default.
+var DefaultScopes = []Scope{ScopeSyncRead, ScopeSyncWrite, ScopeNotificationsWrite}
+
+// legacyScopeNames maps the names used before scopes were namespaced.
+var legacyScopeNames = map[string]Scope{
+	"read":  ScopeSyncRead,
+	"write": ScopeSyncWrite,
+}
+
+// ParseScope returns the scope for a name, accepting the legacy read and write names.
+func ParseScope(name string) (Scope, bool) {
+	name = strings.TrimSpace(name)
+	if scope, ok := legacyScopeNames[name]; ok {
+		return scope, true
+	}
+	if slices.Contains(KnownScopes, Scope(name)) {
+		return Scope(name), true
+	}
+	return "", false
+}
+
+// ScopeSet is a set of scopes.
+type ScopeSet map[Scope]struct{}
+
+// NewScopeSet returns a set holding the given scopes.
+func NewScopeSet(scopes ...Scope) ScopeSet {
+	set := make(ScopeSet, len(scopes))
+	for _, scope := range scopes {
+		set[scope] = struct{}{}
+	}
+	return set
+}
+
+// ParseScopes reads scopes as stored on a user. Both a JSON array of names and
+// the legacy object of names to booleans ({"read": true, "write": false}) are
+// accepted. Unknown names and malformed input grant nothing.
+func ParseScopes(raw string) ScopeSet {
+	var names []string
+	if err := json.Unmarshal([]byte(raw), &names); err != nil {
+		var legacy map[string]bool
+		if err := json.Unmarshal([]byte(raw), &legacy); err != nil {
+			return ScopeSet{}
+		}
+		for name, granted := range legacy {
+			if granted {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return ScopeSetFromNames(names)
+}
+
+// ScopeSetFromNames returns the set of known scopes among names.
+func ScopeSetFromNames(names []string) ScopeSet {
+	set := ScopeSet{}
+	for _, name := range names {
+		if scope, ok := ParseScope(name); ok {
+			set[scope] = struct{}{}
+		}
+	}
+	return set
+}
+
+// IsLegacyScopes reports whether raw uses the legacy object format.
+func IsLegacyScopes(raw string) bool {
+	return strings.HasPrefix(strings.TrimSpace(raw), "{")
+}
+
+func (s ScopeSet) Has(scope Scope) bool {
+	_, ok := s[scope]
+	return ok
+}
+
+// Intersect returns the scopes present in both sets.
+func (s ScopeSet) Intersect(other ScopeSet) ScopeSet {
+	result := ScopeSet{}
+	for scope := range s {
+		if other.Has(scope) {
+			result[scope] = struct{}{}
+		}
+	}
+	return result
+}
+
+// List returns the scopes in the order of KnownScopes.
+func (s ScopeSet) List() []Scope {
+	list := []Scope{}
+	for _, scope := range KnownScopes {
+		if s.Has(scope) {
+			list = append(list, scope)
+		}
+	}
+	return list
+}
+
+// Names returns the scope names in the order of KnownScopes.
+func (s ScopeSet) Names() []string {
+	names := []string{}
+	for _, scope := range s.List() {
+		names = append(names, string(scope))
+	}
+	return names
+}
+
+// String returns the set as a JSON array, the format scopes are stored in.
+func (s ScopeSet) String() string {
+	encoded, _ := json.Marshal(s.Names())
+	return string(encoded)
+}
@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []Scope
+	}{
+		{name: "array", raw: `["sync:read","notifications:write"]`, want: []Scope{ScopeSyncRead, ScopeNotificationsWrite}},
+		{name: "legacy default", raw: `{"read": true, "write": false}`, want: []Scope{ScopeSyncRead}},
+		{name: "legacy admin", raw: `{"read": true, "write": true, "admin": true}`, want: []Scope{ScopeSyncRead, ScopeSyncWrite, ScopeAdmin}},
+		{name: "legacy names in array", raw: `["read","write"]`, want: []Scope{ScopeSyncRead, ScopeSyncWrite}},
+		{name: "unknown scopes ignored", raw: `["sync:read","root"]`, want: []Scope{ScopeSyncRead}},
+		{name: "malformed", raw: `admin`, want: []Scope{}},
+		{name: "empty", raw: ``, want: []Scope{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseScopes(tt.raw).List())
+		})
+	}
+}
+
+func TestScopeSet(t *testing.T) {
+	user := NewScopeSet(ScopeSyncRead, ScopeSyncWrite)
+	token := ScopeSetFromNames([]string{"sync:read", "admin"})
+
+	effective := user.Intersect(token)
+	assert.True(t, effective.Has(ScopeSyncRead))
+	assert.False(t, effective.Has(ScopeSyncWrite))
+	assert.False(t, effective.Has(ScopeAdmin))
+
+	assert.Equal(t, `["sync:read","sync:write"]`, user.String())
+	assert.Equal(t, user.List(), ParseScopes(user.String()).List())
+	assert.True(t, IsLegacyScopes(`{"read": true}`))
+	assert.False(t, IsLegacyScopes(user.String()))
+}
@@ -0,0 +1,22 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+var ErrSessionNotFound = errors.Sentinel("session not found")
+
+// Session is a web UI login stored in Valkey. The cookie holds a random token,
+// the session is stored under the SHA-256 of it, which is also its public ID.
+type Session struct {
+	ID             string    `json:"id"`
+	UserHashedUUID string    `json:"-"`
+	UserAgent      string    `json:"user_agent"`
+	IP             string    `json:"ip"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastSeenAt     time.Time `json:"last_seen_at"`
+	// Current marks the session of the request listing the sessions, it is not stored.
+	Current bool `json:"current"`
+}
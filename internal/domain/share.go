@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+var ErrShareNotFound = errors.Sentinel("share not found")
+
+type ShareRepo interface {
+	Store(ctx context.Context, share *Share) error
+	// FindByTokenHash returns the share for a token, nil if there is none.
+	FindByTokenHash(ctx context.Context, tokenHash string) (*Share, error)
+	// FindByUser returns the shares created by a user, newest first.
+	FindByUser(ctx context.Context, userHashedUUID string) ([]Share, error)
+	// FindSnapshot returns the snapshot data of a share of a user, nil if there is none.
+	FindSnapshot(ctx context.Context, userHashedUUID string, id int64) ([]byte, error)
+	// Revoke marks a share of a user revoked, returns ErrShareNotFound if the user has no such share.
+	Revoke(ctx context.Context, userHashedUUID string, id int64) error
+	// RecordAccess increments the access count of a share.
+	RecordAccess(ctx context.Context, id int64) error
+}
+
+// ShareScope is the part of a library a share gives access to.
+type ShareScope string
+
+const (
+	// ShareScopeLibrary shares the current state of a whole slot.
+	ShareScopeLibrary ShareScope = "library"
+	// ShareScopeCategories shares the current state of some categories of a slot.
+	ShareScopeCategories ShareScope = "categories"
+	// ShareScopeSnapshot shares a copy of a slot taken when the share was created.
+	ShareScopeSnapshot ShareScope = "snapshot"
+)
+
+// Share is a read-only link to a library, opened with an unguessable token.
+// Only the SHA-256 of the token is stored.
+type Share struct {
+	ID             int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	UserHashedUUID string     `json:"-" gorm:"column:user_hashed_uuid;index"`
+	TokenHash      string     `json:"-" gorm:"column:token_hash;uniqueIndex"`
+	Name           string     `json:"name" gorm:"column:name"`
+	Scope          ShareScope `json:"scope" gorm:"column:scope"`
+	Slot           string     `json:"slot,omitempty" gorm:"column:slot"`
+	Categories     []string   `json:"categories,omitempty" gorm:"column:categories;serializer:json"`
+	SnapshotETag   string     `json:"snapshot_etag,omitempty" gorm:"column:snapshot_etag"`
+	Snapshot       []byte     `json:"-" gorm:"column:snapshot"`
+	PassphraseHash string     `json:"-" gorm:"column:passphrase_hash"`
+	HasPassphrase  bool       `json:"has_passphrase" gorm:"-"`
+	AccessCount    int64      `json:"access_count" gorm:"column:access_count"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty" gorm:"column:last_accessed_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty" gorm:"column:expires_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty" gorm:"column:revoked_at"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+// TableName specifies the database table name for the Share model
+func (Share) TableName() string {
+	return "shares"
+}
+
+// Protected reports whether opening the share requires a passphrase.
+func (s Share) Protected() bool {
+	return s.PassphraseHash != ""
+}
+
+// Active reports whether the share can still be opened.
+func (s Share) Active(now time.Time) bool {
+	return s.RevokedAt == nil && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt))
+}
+
+type CreateShareRequest struct {
+	Name         string     `json:"name"`
+	Scope        ShareScope `json:"scope"`
+	Slot         string     `json:"slot"`
+	Categories   []string   `json:"categories"`
+	SnapshotETag string     `json:"snapshot_etag"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	Passphrase   string     `json:"passphrase"`
+}
+
+// SharedLibrary is the read-only JSON view of a shared library.
+type SharedLibrary struct {
+	Name       string        `json:"name,omitempty"`
+	Categories []string      `json:"categories"`
+	Manga      []SharedManga `json:"manga"`
+	SharedAt   time.Time     `json:"shared_at"`
+	Scope      ShareScope    `json:"scope"`
+}
+
+type SharedManga struct {
+	Title           string   `json:"title"`
+	Source          int64    `json:"source"`
+	URL             string   `json:"url"`
+	Favorite        bool     `json:"favorite"`
+	Categories      []string `json:"categories"`
+	ChaptersTotal   int      `json:"chapters_total"`
+	ChaptersRead    int      `json:"chapters_read"`
+	LastChapterRead float64  `json:"last_chapter_read"`
+}
@@ -0,0 +1,57 @@
+package domain
+
+import "time"
+
+// UserSyncStats summarises how a single user's devices sync, derived from the sync audit log.
+type UserSyncStats struct {
+	WindowDays         int                `json:"window_days"`
+	LastSuccessfulSync *time.Time         `json:"last_successful_sync,omitempty"`
+	CurrentPayloadSize int64              `json:"current_payload_size"`
+	PayloadSizeTrend   []PayloadSizePoint `json:"payload_size_trend"`
+	SyncsPerDay        []DailySyncCount   `json:"syncs_per_day"`
+	Devices            []DeviceSyncStats  `json:"devices"`
+}
+
+// PayloadSizePoint is the size of the last successful upload of a day.
+type PayloadSizePoint struct {
+	Date string `json:"date"` // YYYY-MM-DD, UTC
+	Size int64  `json:"size"`
+}
+
+// DailySyncCount counts the sync requests of a day.
+type DailySyncCount struct {
+	Date      string `json:"date"` // YYYY-MM-DD, UTC
+	Uploads   int    `json:"uploads"`
+	Downloads int    `json:"downloads"`
+	Conflicts int    `json:"conflicts"`
+}
+
+// DeviceSyncStats counts uploads and conflicts per device.
+type DeviceSyncStats struct {
+	Device       string    `json:"device"`
+	Uploads      int       `json:"uploads"`
+	Conflicts    int       `json:"conflicts"`
+	ConflictRate float64   `json:"conflict_rate"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// ServerStats summarises instance-wide usage for administrators.
+type ServerStats struct {
+	WindowDays             int                 `json:"window_days"`
+	TotalUsers             int                 `json:"total_users"`
+	ActiveUsers            int                 `json:"active_users"`
+	TotalStorageBytes      int64               `json:"total_storage_bytes"`
+	TopConsumers           []StorageConsumer   `json:"top_consumers"`
+	Uploads                int                 `json:"uploads"`
+	PreconditionFailedRate float64             `json:"precondition_failed_rate"`
+	Outcomes               map[SyncOutcome]int `json:"outcomes"`
+}
+
+// StorageConsumer is a user ranked by the size of their stored library.
+// Users are identified by their PublicID, never by their bookmark.
+type StorageConsumer struct {
+	UserID    string    `json:"user_id"`        // PublicID of the user
+	Slot      string    `json:"slot,omitempty"` // empty for the default slot
+	Bytes     int64     `json:"bytes"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
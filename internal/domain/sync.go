@@ -3,19 +3,83 @@ package domain
 import (
 	"context"
 	"time"
+
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+// DefaultSyncSlot is the slot served by /api/sync/content, stored in the sync_data table.
+const DefaultSyncSlot = ""
+
+// DefaultSyncSlotName is how the default slot is listed, it cannot be used for a named slot.
+const DefaultSyncSlotName = "default"
+
+var (
+	ErrSyncSlotNotFound = errors.Sentinel("sync slot not found")
+	ErrSyncSlotExists   = errors.Sentinel("sync slot already exists")
+
+	ErrSyncHistoryNotFound = errors.Sentinel("sync history entry not found")
 )
 
 type SyncRepo interface {
 	// Get etag of sync data.
 	// For avoid memory usage, only the etag will be returned.
-	GetSyncDataETag(ctx context.Context, userHashedUUID string) (*string, error) // Changed apiKey to userHashedUUID
+	GetSyncDataETag(ctx context.Context, userHashedUUID string, slot string) (*string, error)
 	// Get sync data and etag
-	GetSyncDataAndETag(ctx context.Context, userHashedUUID string) ([]byte, *string, error) // Changed apiKey to userHashedUUID
+	GetSyncDataAndETag(ctx context.Context, userHashedUUID string, slot string) ([]byte, *string, error)
 	// Create or replace sync data, returns the new etag.
-	SetSyncData(ctx context.Context, userHashedUUID string, data []byte) (*string, error) // Changed apiKey to userHashedUUID
+	// Named slots must exist, ErrSyncSlotNotFound is returned otherwise.
+	SetSyncData(ctx context.Context, userHashedUUID string, slot string, data []byte) (*string, error)
 	// Replace sync data only if the etag matches,
 	// returns the new etag if updated, or nil if not.
-	SetSyncDataIfMatch(ctx context.Context, userHashedUUID string, etag string, data []byte) (*string, error) // Changed apiKey to userHashedUUID
+	SetSyncDataIfMatch(ctx context.Context, userHashedUUID string, slot string, etag string, data []byte) (*string, error)
+	// Replace sync data only if the etag matches like SetSyncDataIfMatch, also returns the replaced data.
+	// The replaced data is only read when the etag matches.
+	ReplaceSyncDataIfMatch(ctx context.Context, userHashedUUID string, slot string, etag string, data []byte) (*string, []byte, error)
+	// Get the total size of all stored sync data and the largest consumers,
+	// computed from the stored blob lengths without reading the blobs.
+	GetStorageUsage(ctx context.Context, limit int) (int64, []StorageConsumer, error)
+	// GetUsageByUser returns the stored size and latest upload per user, users without data are left out.
+	GetUsageByUser(ctx context.Context, userHashedUUIDs []string) (map[string]SyncUsage, error)
+	// List the slots of a user, the default slot first.
+	ListSlots(ctx context.Context, userHashedUUID string) ([]SyncSlot, error)
+	// Count the named slots of a user.
+	CountSlots(ctx context.Context, userHashedUUID string) (int, error)
+	// Create an empty named slot, returns ErrSyncSlotExists if the name is taken.
+	CreateSlot(ctx context.Context, userHashedUUID string, name string) (*SyncSlot, error)
+	// Rename a named slot, returns ErrSyncSlotNotFound or ErrSyncSlotExists.
+	RenameSlot(ctx context.Context, userHashedUUID string, name string, newName string) error
+	// Delete a named slot and its data, returns ErrSyncSlotNotFound if it does not exist.
+	DeleteSlot(ctx context.Context, userHashedUUID string, name string) error
+	// Keep a replaced version of a slot in its history, only the newest keep versions are retained.
+	AddHistory(ctx context.Context, userHashedUUID string, slot string, etag string, data []byte, keep int) error
+	// List the history of a slot without the data, newest first.
+	ListHistory(ctx context.Context, userHashedUUID string, slot string) ([]SyncHistoryEntry, error)
+	// Get the data of a history entry of a slot, returns ErrSyncHistoryNotFound if there is none.
+	GetHistoryData(ctx context.Context, userHashedUUID string, slot string, id int64) ([]byte, error)
+}
+
+// SyncSlot describes a stored library without its data.
+type SyncSlot struct {
+	Name      string    `json:"name"`
+	Default   bool      `json:"default"`
+	Size      int64     `json:"size"`
+	ETag      string    `json:"etag,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// SyncHistoryEntry describes a replaced version of a slot without its data.
+type SyncHistoryEntry struct {
+	ID         int64     `json:"id"`
+	ETag       string    `json:"etag"`
+	Size       int64     `json:"size"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// SyncUsage is the storage taken by a user over all of their slots.
+type SyncUsage struct {
+	Bytes        int64
+	LastUploadAt *time.Time
 }
 
 // SyncData represents the synchronization data for a user.
@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type SyncEventRepo interface {
+	// Store persists a single sync event.
+	Store(ctx context.Context, event SyncEvent) error
+	// Find returns the events matching params and the total count before pagination.
+	Find(ctx context.Context, params SyncEventQueryParams) ([]SyncEvent, int, error)
+	// DeleteOlderThan removes events created before the given time, returns the number removed.
+	DeleteOlderThan(ctx context.Context, before time.Time) (int, error)
+	// CountActiveUsers counts the distinct users with at least one event since the given time.
+	CountActiveUsers(ctx context.Context, since time.Time) (int, error)
+	// CountOutcomes counts events per outcome since the given time, optionally restricted to one method.
+	CountOutcomes(ctx context.Context, since time.Time, method string) (map[SyncOutcome]int, error)
+	// SummarizeUser aggregates the events of a user since the given time, see SyncEventSummary.
+	SummarizeUser(ctx context.Context, userHashedUUID string, since time.Time) (*SyncEventSummary, error)
+}
+
+// SyncOutcome is the HTTP status a sync request finished with.
+type SyncOutcome int
+
+const (
+	SyncOutcomeOK                 SyncOutcome = 200
+	SyncOutcomeNotModified        SyncOutcome = 304
+	SyncOutcomeValidationFailed   SyncOutcome = 400
+	SyncOutcomeNotFound           SyncOutcome = 404
+	SyncOutcomePreconditionFailed SyncOutcome = 412
+	SyncOutcomePayloadTooLarge    SyncOutcome = 413
+	SyncOutcomeError              SyncOutcome = 500
+)
+
+// SyncEvent is a single entry of the sync audit log, one per GET or PUT of sync content.
+type SyncEvent struct {
+	ID             int64       `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	UserHashedUUID string      `json:"-" gorm:"column:user_hashed_uuid;index"`
+	Device         string      `json:"device" gorm:"column:device"`
+	IP             string      `json:"ip" gorm:"column:ip"`
+	RequestID      string      `json:"request_id" gorm:"column:request_id"`
+	Method         string      `json:"method" gorm:"column:method"`
+	Slot           string      `json:"slot,omitempty" gorm:"column:slot"` // empty for the default slot
+	PayloadSize    int64       `json:"payload_size" gorm:"column:payload_size"`
+	PreviousETag   string      `json:"previous_etag,omitempty" gorm:"column:previous_etag"`
+	NewETag        string      `json:"new_etag,omitempty" gorm:"column:new_etag"`
+	Outcome        SyncOutcome `json:"outcome" gorm:"column:outcome;index"`
+	Reason         string      `json:"reason,omitempty" gorm:"column:reason"`
+	CreatedAt      time.Time   `json:"created_at" gorm:"column:created_at;index"`
+}
+
+// TableName specifies the database table name for the SyncEvent model
+func (SyncEvent) TableName() string {
+	return "sync_events"
+}
+
+type SyncEventQueryParams struct {
+	UserHashedUUID *string
+	From           *time.Time
+	To             *time.Time
+	Outcomes       []SyncOutcome
+	Method         string
+	Slot           *string
+	Limit          uint64
+	Offset         uint64
+}
+
+// SyncEventCount counts the events of one UTC day from one device with the same method and outcome.
+type SyncEventCount struct {
+	Date    string // YYYY-MM-DD, UTC
+	Device  string
+	Method  string
+	Outcome SyncOutcome
+	Count   int
+}
+
+// SyncEventSummary is the sync audit log of a user aggregated by the database,
+// so statistics do not need to load every event of the window.
+type SyncEventSummary struct {
+	Counts         []SyncEventCount
+	LatestByDevice []SyncEvent // The latest event of every device
+	LatestUploads  []SyncEvent // The latest successful upload of every UTC day, oldest first
+	LatestSuccess  *SyncEvent  // The latest request answered with 200 or 304, nil if there is none
+}
@@ -2,6 +2,10 @@ package domain
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"time"
 )
 
@@ -10,17 +14,108 @@ type UserRepo interface {
 	FindByHashedUUID(ctx context.Context, hashedUUID string) (*User, error)
 	Store(ctx context.Context, user User) error
 	// Update method removed from interface as its implementation was removed.
+	// UpdateDeletionDate moves the expiry of a user, a warning is sent again for the new date.
 	UpdateDeletionDate(ctx context.Context, hashedUUID string, newDeletionDate time.Time) error // Changed userID to hashedUUID
-	FindExpiredUserIDs(ctx context.Context, now time.Time) ([]string, error) // Changed []int to []string for HashedUUIDs
+	FindExpiredUserIDs(ctx context.Context, now time.Time) ([]string, error)                    // Changed []int to []string for HashedUUIDs
+	// FindUsersToWarn returns the users expiring between now and before who were not warned about it yet.
+	FindUsersToWarn(ctx context.Context, now time.Time, before time.Time) ([]User, error)
+	// MarkExpiryWarningSent records that the user was warned about their current deletion date.
+	MarkExpiryWarningSent(ctx context.Context, hashedUUID string) error
 	FindByAPITokenHash(ctx context.Context, tokenHash string) (*User, error)
+	// FindByAPITokenLookupID returns the user owning an API token, nil if there is none.
+	FindByAPITokenLookupID(ctx context.Context, lookupID string) (*User, error)
 	DeleteUserAndAssociatedData(ctx context.Context, hashedUUID string) error // Changed userID to hashedUUID
-	UpdateTokenAndScopes(ctx context.Context, hashedUUID string, apiTokenHash string, scopes string) error
+	UpdateTokenAndScopes(ctx context.Context, hashedUUID string, lookupID string, apiTokenHash string, scopes string) error
+	// UpdateUUIDLoginDisabled turns the login with the bookmark UUID off or back on. Turning it
+	// off returns ErrNoWebAuthnCredential if the user has no passkey to log in with instead.
+	UpdateUUIDLoginDisabled(ctx context.Context, hashedUUID string, disabled bool) error
+	// UpdatePasswordHash sets the argon2id hash of the account password, an empty hash removes the password.
+	UpdatePasswordHash(ctx context.Context, hashedUUID string, passwordHash string) error
+	// UpdateTOTP sets the encrypted TOTP secret and whether it is required at login, an empty secret removes it.
+	UpdateTOTP(ctx context.Context, hashedUUID string, encryptedSecret string, enabled bool) error
+	// RotateHashedUUID stores newUser in place of the user with oldHashedUUID and moves every row keyed
+	// by the old HashedUUID to it in one transaction. Named API tokens are deleted instead of moved.
+	RotateHashedUUID(ctx context.Context, oldHashedUUID string, newUser User) error
+	// MergeInto moves the notification channels, named API tokens, reading history and sync log of the
+	// user with fromHashedUUID to the user with intoHashedUUID and deletes the former with the rest of
+	// its data in one transaction. The libraries are merged beforehand.
+	MergeInto(ctx context.Context, fromHashedUUID string, intoHashedUUID string) error
+	// FindUsers returns a page of users, the latest deletion date first, and the total number of users.
+	FindUsers(ctx context.Context, params UserQueryParams) ([]User, int, error)
+	// FindByPublicID returns the user with the given PublicID, nil if there is none.
+	FindByPublicID(ctx context.Context, publicID string) (*User, error)
+	// UpdateScopes replaces the scopes of a user, stored as a JSON array.
+	UpdateScopes(ctx context.Context, hashedUUID string, scopes string) error
+	// UpdateDisabled blocks the user from authenticating or allows it again.
+	UpdateDisabled(ctx context.Context, hashedUUID string, disabled bool) error
+}
+
+type UserQueryParams struct {
+	Limit  uint64
+	Offset uint64
 }
 
 // User represents a user in the system, identified by a hashed UUID.
 type User struct {
-	HashedUUID   string    `json:"-" gorm:"primaryKey;column:hashed_uuid"` // Primary Key
-	APITokenHash string    `json:"-" gorm:"column:api_token_hash;unique"`  // Don't expose hash via JSON, ensure uniqueness
-	Scopes       string    `json:"scopes" gorm:"column:scopes;type:jsonb"` // Store as JSONB
-	DeletionDate time.Time `json:"deletion_date" gorm:"column:deletion_date"`
+	HashedUUID   string `json:"-" gorm:"primaryKey;column:hashed_uuid"` // Primary Key
+	APITokenHash string `json:"-" gorm:"column:api_token_hash;unique"`  // Don't expose hash via JSON, ensure uniqueness
+	// APITokenLookupID is the indexed public part of the API token, nil for legacy bcrypt tokens.
+	APITokenLookupID *string   `json:"-" gorm:"column:api_token_lookup_id;uniqueIndex"`
+	Scopes           string    `json:"scopes" gorm:"column:scopes;type:jsonb"` // Store as JSONB
+	DeletionDate     time.Time `json:"deletion_date" gorm:"column:deletion_date"`
+	// UUIDLoginDisabled turns off the login with the bookmark UUID, the user logs in with a passkey instead.
+	UUIDLoginDisabled bool `json:"uuid_login_disabled" gorm:"column:uuid_login_disabled;not null;default:false"`
+	// PasswordHash is the argon2id hash of the optional password required with the bookmark UUID.
+	PasswordHash string `json:"-" gorm:"column:password_hash;not null;default:''"`
+	// TOTPSecret is the encrypted TOTP secret, set from the start of the enrolment.
+	TOTPSecret string `json:"-" gorm:"column:totp_secret;not null;default:''"`
+	// TOTPEnabled requires a TOTP or recovery code with the bookmark UUID, once the enrolment is confirmed.
+	TOTPEnabled bool `json:"totp_enabled" gorm:"column:totp_enabled;not null;default:false"`
+	// WebAuthnUserHandle pins the base64url WebAuthn user ID, so passkeys survive a bookmark rotation.
+	WebAuthnUserHandle string `json:"-" gorm:"column:webauthn_user_handle;not null;default:''"`
+	// ExpiryWarningSent is set once the user was warned about the DeletionDate.
+	ExpiryWarningSent bool `json:"-" gorm:"column:expiry_warning_sent;not null;default:false"`
+	// PublicID identifies the user to administrators and in audit events, unlike the HashedUUID it is no credential.
+	PublicID string `json:"id" gorm:"column:public_id;index;not null;default:''"`
+	// Disabled blocks every login and API token of the user, set by an administrator.
+	Disabled bool `json:"disabled" gorm:"column:disabled;not null;default:false"`
+}
+
+// NewPublicID returns a random PublicID for a user.
+func NewPublicID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Expired reports whether the user is past the DeletionDate, the account is
+// read-only until the grace period ends and it is deleted.
+func (u User) Expired(now time.Time) bool {
+	return now.After(u.DeletionDate)
+}
+
+// WebAuthnHandle returns the WebAuthn user ID stored on passkeys. Unless pinned it is
+// derived from the HashedUUID, which must not be handed to authenticators itself.
+func (u User) WebAuthnHandle() []byte {
+	if handle, err := base64.RawURLEncoding.DecodeString(u.WebAuthnUserHandle); err == nil && len(handle) > 0 {
+		return handle
+	}
+	sum := sha256.Sum256([]byte("shiori-webauthn:" + u.HashedUUID))
+	return sum[:]
+}
+
+// IsAdmin reports whether the user has the admin role, granted as the admin scope.
+func (u User) IsAdmin() bool {
+	return ParseScopes(u.Scopes).Has(ScopeAdmin)
+}
+
+// HasPassword reports whether the bookmark login also requires a password.
+func (u User) HasPassword() bool {
+	return u.PasswordHash != ""
+}
+
+// HasLegacyAPIToken reports whether the user still has a token issued before
+// the shi_<lookupID>_<secret> format, such a token can no longer be verified.
+func (u User) HasLegacyAPIToken() bool {
+	return u.APITokenLookupID == nil || *u.APITokenLookupID == ""
 }
@@ -0,0 +1,19 @@
+package domain
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUser_WebAuthnHandle(t *testing.T) {
+	user := User{HashedUUID: "bookmark"}
+	derived := user.WebAuthnHandle()
+	assert.Len(t, derived, 32)
+
+	// A pinned handle survives a new HashedUUID
+	rotated := User{HashedUUID: "rotated", WebAuthnUserHandle: base64.RawURLEncoding.EncodeToString(derived)}
+	assert.Equal(t, derived, rotated.WebAuthnHandle())
+	assert.NotEqual(t, derived, User{HashedUUID: "rotated"}.WebAuthnHandle())
+}
@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+var (
+	ErrWebAuthnCredentialNotFound = errors.Sentinel("passkey not found")
+	// ErrLastWebAuthnCredential is returned when deleting the last passkey of a user who turned the bookmark UUID login off.
+	ErrLastWebAuthnCredential = errors.Sentinel("last passkey of a user without bookmark UUID login")
+	// ErrNoWebAuthnCredential is returned when turning the bookmark UUID login off for a user without passkeys.
+	ErrNoWebAuthnCredential = errors.Sentinel("user has no passkey")
+)
+
+type WebAuthnCredentialRepo interface {
+	Store(ctx context.Context, credential *WebAuthnCredential) error
+	// FindByUser returns the passkeys of a user, oldest first.
+	FindByUser(ctx context.Context, userHashedUUID string) ([]WebAuthnCredential, error)
+	// FindByCredentialID returns the passkey with the given credential ID, nil if there is none.
+	FindByCredentialID(ctx context.Context, credentialID string) (*WebAuthnCredential, error)
+	// RecordUse stores the credential after a login, its sign counter changes with every use.
+	RecordUse(ctx context.Context, id int64, credential []byte, usedAt time.Time) error
+	// Delete removes a passkey of a user, returns ErrWebAuthnCredentialNotFound if the user has no such passkey
+	// and ErrLastWebAuthnCredential if it is the last one of a user who turned the bookmark UUID login off.
+	Delete(ctx context.Context, userHashedUUID string, id int64) error
+}
+
+// WebAuthnCredential is a passkey registered for a user. The credential itself,
+// with public key and sign counter, is stored as the JSON of the go-webauthn
+// credential, the credential ID is kept separately to look passkeys up on login.
+type WebAuthnCredential struct {
+	ID             int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	UserHashedUUID string     `json:"-" gorm:"column:user_hashed_uuid;index"`
+	Name           string     `json:"name" gorm:"column:name"`
+	CredentialID   string     `json:"-" gorm:"column:credential_id;uniqueIndex"` // base64url of the raw credential ID
+	Credential     []byte     `json:"-" gorm:"column:credential"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty" gorm:"column:last_used_at"`
+}
+
+// TableName specifies the database table name for the WebAuthnCredential model
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
@@ -0,0 +1,281 @@
+// Package export writes everything stored about a user into a zip archive,
+// so users can take their data with them.
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// redacted replaces secrets of notification channels in the archive
+const redacted = "REDACTED"
+
+// sessionLister lists the web sessions of a user, see session.Service.
+type sessionLister interface {
+	List(ctx context.Context, userHashedUUID string) ([]domain.Session, error)
+}
+
+type Service interface {
+	// Write streams the archive of a user to w. Backups are stored as uploaded,
+	// gzip-compressed JSON, everything else as JSON with secrets left out.
+	Write(ctx context.Context, user *domain.User, w io.Writer) error
+}
+
+type service struct {
+	log              zerolog.Logger
+	syncRepo         domain.SyncRepo
+	shareRepo        domain.ShareRepo
+	notificationRepo domain.NotificationRepo
+	apiTokenRepo     domain.APITokenRepo
+	passkeyRepo      domain.WebAuthnCredentialRepo
+	syncEventRepo    domain.SyncEventRepo
+	readingEventRepo domain.ReadingEventRepo
+	auditEventRepo   domain.AuditEventRepo
+	sessions         sessionLister
+}
+
+func NewService(log logger.Logger, syncRepo domain.SyncRepo, shareRepo domain.ShareRepo, notificationRepo domain.NotificationRepo, apiTokenRepo domain.APITokenRepo, passkeyRepo domain.WebAuthnCredentialRepo, syncEventRepo domain.SyncEventRepo, readingEventRepo domain.ReadingEventRepo, auditEventRepo domain.AuditEventRepo, sessions sessionLister) Service {
+	return &service{
+		log:              log.With().Str("module", "export").Logger(),
+		syncRepo:         syncRepo,
+		shareRepo:        shareRepo,
+		notificationRepo: notificationRepo,
+		apiTokenRepo:     apiTokenRepo,
+		passkeyRepo:      passkeyRepo,
+		syncEventRepo:    syncEventRepo,
+		readingEventRepo: readingEventRepo,
+		auditEventRepo:   auditEventRepo,
+		sessions:         sessions,
+	}
+}
+
+// profile is the account itself, the user token and password are never exported.
+type profile struct {
+	*domain.User
+	HasPassword bool      `json:"has_password"`
+	ExportedAt  time.Time `json:"exported_at"`
+}
+
+// devices are the ways a user is signed in.
+type devices struct {
+	Sessions []domain.Session            `json:"sessions"`
+	Passkeys []domain.WebAuthnCredential `json:"passkeys"`
+}
+
+func (s *service) Write(ctx context.Context, user *domain.User, w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	if err := writeJSON(archive, "profile.json", profile{User: user, HasPassword: user.HasPassword(), ExportedAt: time.Now()}); err != nil {
+		return err
+	}
+	if err := s.writeBackups(ctx, archive, user.HashedUUID); err != nil {
+		return err
+	}
+	if err := s.writeShares(ctx, archive, user.HashedUUID); err != nil {
+		return err
+	}
+	if err := s.writeNotifications(ctx, archive, user.HashedUUID); err != nil {
+		return err
+	}
+
+	tokens, err := s.apiTokenRepo.FindByUser(ctx, user.HashedUUID)
+	if err != nil {
+		return errors.Wrap(err, "failed to find API tokens")
+	}
+	if err := writeJSON(archive, "api_tokens.json", tokens); err != nil {
+		return err
+	}
+
+	sessions, err := s.sessions.List(ctx, user.HashedUUID)
+	if err != nil {
+		return errors.Wrap(err, "failed to list sessions")
+	}
+	passkeys, err := s.passkeyRepo.FindByUser(ctx, user.HashedUUID)
+	if err != nil {
+		return errors.Wrap(err, "failed to find passkeys")
+	}
+	if err := writeJSON(archive, "devices.json", devices{Sessions: sessions, Passkeys: passkeys}); err != nil {
+		return err
+	}
+
+	// Limit 0 returns all events of the user
+	events, _, err := s.syncEventRepo.Find(ctx, domain.SyncEventQueryParams{UserHashedUUID: &user.HashedUUID})
+	if err != nil {
+		return errors.Wrap(err, "failed to find sync events")
+	}
+	if err := writeJSON(archive, "audit/sync_events.json", events); err != nil {
+		return err
+	}
+
+	securityEvents, _, err := s.auditEventRepo.Find(ctx, domain.AuditEventQueryParams{UserID: user.PublicID})
+	if err != nil {
+		return errors.Wrap(err, "failed to find audit events")
+	}
+	if err := writeJSON(archive, "audit/security_events.json", securityEvents); err != nil {
+		return err
+	}
+
+	readingEvents, err := s.readingEventRepo.Find(ctx, user.HashedUUID, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to find reading events")
+	}
+	if err := writeJSON(archive, "reading_events.json", readingEvents); err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return errors.Wrap(err, "failed to finish archive")
+	}
+
+	s.log.Info().Str("hashed_uuid", user.HashedUUID).Msg("Exported account data")
+	return nil
+}
+
+// writeBackups stores the current backup of every slot, the default slot as backup.json.gz,
+// and the versions kept in the history of each slot.
+func (s *service) writeBackups(ctx context.Context, archive *zip.Writer, userHashedUUID string) error {
+	slots, err := s.syncRepo.ListSlots(ctx, userHashedUUID)
+	if err != nil {
+		return errors.Wrap(err, "failed to list sync slots")
+	}
+
+	// History entries are listed by the name the slot is listed with
+	history := make(map[string][]domain.SyncHistoryEntry, len(slots))
+	for _, slot := range slots {
+		name := slot.Name
+		path := "slots/" + url.PathEscape(slot.Name) + ".json.gz"
+		if slot.Default {
+			name = domain.DefaultSyncSlot
+			path = "backup.json.gz"
+		}
+
+		entries, err := s.writeHistory(ctx, archive, userHashedUUID, name, slot.Name)
+		if err != nil {
+			return err
+		}
+		history[slot.Name] = entries
+
+		data, _, err := s.syncRepo.GetSyncDataAndETag(ctx, userHashedUUID, name)
+		if err != nil {
+			return errors.Wrap(err, "failed to get sync data of slot %q", slot.Name)
+		}
+		if data == nil {
+			continue
+		}
+		if err := writeStored(archive, path, data); err != nil {
+			return err
+		}
+	}
+
+	if err := writeJSON(archive, "history.json", history); err != nil {
+		return err
+	}
+	return writeJSON(archive, "slots.json", slots)
+}
+
+// writeHistory stores the replaced versions of a slot as history/<slot>/<id>.json.gz.
+func (s *service) writeHistory(ctx context.Context, archive *zip.Writer, userHashedUUID string, slot string, listedName string) ([]domain.SyncHistoryEntry, error) {
+	entries, err := s.syncRepo.ListHistory(ctx, userHashedUUID, slot)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list history of slot %q", listedName)
+	}
+
+	// Entries are listed without their data, it is loaded one version at a time
+	for _, entry := range entries {
+		data, err := s.syncRepo.GetHistoryData(ctx, userHashedUUID, slot, entry.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get history of slot %q", listedName)
+		}
+		if err := writeStored(archive, fmt.Sprintf("history/%s/%d.json.gz", url.PathEscape(listedName), entry.ID), data); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// writeShares stores the shares and the snapshots retained for them.
+func (s *service) writeShares(ctx context.Context, archive *zip.Writer, userHashedUUID string) error {
+	shares, err := s.shareRepo.FindByUser(ctx, userHashedUUID)
+	if err != nil {
+		return errors.Wrap(err, "failed to find shares")
+	}
+
+	// Shares are listed without their snapshots, they are loaded one at a time
+	for _, share := range shares {
+		if share.Scope != domain.ShareScopeSnapshot {
+			continue
+		}
+		snapshot, err := s.shareRepo.FindSnapshot(ctx, userHashedUUID, share.ID)
+		if err != nil {
+			return errors.Wrap(err, "failed to find share snapshot")
+		}
+		if len(snapshot) == 0 {
+			continue
+		}
+		if err := writeStored(archive, fmt.Sprintf("snapshots/share-%d.json.gz", share.ID), snapshot); err != nil {
+			return err
+		}
+	}
+
+	return writeJSON(archive, "shares.json", shares)
+}
+
+// writeNotifications stores the notification channels with their credentials redacted.
+func (s *service) writeNotifications(ctx context.Context, archive *zip.Writer, userHashedUUID string) error {
+	notifications, _, err := s.notificationRepo.Find(ctx, domain.NotificationQueryParams{UserHashedUUID: &userHashedUUID})
+	if err != nil {
+		return errors.Wrap(err, "failed to find notifications")
+	}
+
+	for i := range notifications {
+		notifications[i] = redactNotification(notifications[i])
+	}
+
+	return writeJSON(archive, "notifications.json", notifications)
+}
+
+// redactNotification blanks the fields of a channel that hold credentials.
+func redactNotification(n domain.Notification) domain.Notification {
+	for _, field := range []*string{&n.Token, &n.Webhook, &n.Password} {
+		if *field != "" {
+			*field = redacted
+		}
+	}
+	return n
+}
+
+func writeJSON(archive *zip.Writer, name string, v any) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "failed to add %s", name)
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return errors.Wrap(err, "failed to write %s", name)
+	}
+	return nil
+}
+
+// writeStored adds already compressed data without compressing it again.
+func writeStored(archive *zip.Writer, name string, data []byte) error {
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return errors.Wrap(err, "failed to add %s", name)
+	}
+	if _, err := f.Write(data); err != nil {
+		return errors.Wrap(err, "failed to write %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSessions struct{}
+
+func (fakeSessions) List(ctx context.Context, userHashedUUID string) ([]domain.Session, error) {
+	return []domain.Session{{ID: "session", UserAgent: "firefox"}}, nil
+}
+
+func TestService_Write(t *testing.T) {
+	db, log := databasetest.New(t)
+	ctx := context.Background()
+	user := &domain.User{HashedUUID: "user", PublicID: "public", APITokenHash: "secret"}
+
+	users := database.NewUserRepo(log, db)
+	syncRepo := database.NewSyncRepo(log, db)
+	shareRepo := database.NewShareRepo(log, db)
+	notificationRepo := database.NewNotificationRepo(log, db)
+	apiTokenRepo := database.NewAPITokenRepo(log, db)
+	auditEventRepo := database.NewAuditEventRepo(log, db)
+
+	databasetest.StoreUsers(t, users, *user)
+	_, err := syncRepo.SetSyncData(ctx, user.HashedUUID, domain.DefaultSyncSlot, []byte("default"))
+	require.NoError(t, err)
+	for _, slot := range []string{"tablet/old", "empty"} {
+		_, err = syncRepo.CreateSlot(ctx, user.HashedUUID, slot)
+		require.NoError(t, err)
+	}
+	_, err = syncRepo.SetSyncData(ctx, user.HashedUUID, "tablet/old", []byte("tablet"))
+	require.NoError(t, err)
+	require.NoError(t, syncRepo.AddHistory(ctx, user.HashedUUID, domain.DefaultSyncSlot, "uuid=first", []byte("first"), 5))
+	require.NoError(t, syncRepo.AddHistory(ctx, user.HashedUUID, "tablet/old", "uuid=older", []byte("older"), 5))
+	require.NoError(t, shareRepo.Store(ctx, &domain.Share{UserHashedUUID: user.HashedUUID, TokenHash: "live", Name: "live", Scope: domain.ShareScopeLibrary}))
+	require.NoError(t, shareRepo.Store(ctx, &domain.Share{UserHashedUUID: user.HashedUUID, TokenHash: "frozen", Name: "frozen", Scope: domain.ShareScopeSnapshot, Snapshot: []byte("snapshot")}))
+	_, err = notificationRepo.Store(ctx, domain.Notification{UserHashedUUID: user.HashedUUID, Name: "discord", Webhook: "https://discord.com/api/webhooks/secret", Username: "shiori"})
+	require.NoError(t, err)
+	require.NoError(t, apiTokenRepo.Store(ctx, &domain.APIToken{UserHashedUUID: user.HashedUUID, Name: "tachiyomi", LookupID: "lookup", SecretHash: "hash"}))
+	require.NoError(t, auditEventRepo.Store(ctx, domain.AuditEvent{Action: domain.AuditActionLogin, ActorID: "public", TargetID: "public"}))
+
+	svc := NewService(log, syncRepo, shareRepo, notificationRepo, apiTokenRepo,
+		database.NewWebAuthnCredentialRepo(log, db), database.NewSyncEventRepo(log, db),
+		database.NewReadingEventRepo(log, db), auditEventRepo, fakeSessions{})
+
+	var buf bytes.Buffer
+	require.NoError(t, svc.Write(ctx, user, &buf))
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	files := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		files[f.Name] = string(content)
+	}
+
+	assert.Equal(t, "default", files["backup.json.gz"])
+	assert.Equal(t, "tablet", files["slots/tablet%2Fold.json.gz"])
+	assert.NotContains(t, files, "slots/empty.json.gz")
+	assert.Equal(t, "first", files["history/default/1.json.gz"])
+	assert.Equal(t, "older", files["history/tablet%2Fold/2.json.gz"])
+
+	// Every slot is listed in the history index, by the name it is listed with
+	var history map[string][]domain.SyncHistoryEntry
+	require.NoError(t, json.Unmarshal([]byte(files["history.json"]), &history))
+	assert.Len(t, history, 3)
+	require.Len(t, history[domain.DefaultSyncSlotName], 1)
+	assert.Equal(t, "uuid=first", history[domain.DefaultSyncSlotName][0].ETag)
+	require.Len(t, history["tablet/old"], 1)
+	assert.Equal(t, int64(2), history["tablet/old"][0].ID)
+	assert.Empty(t, history["empty"])
+	assert.Equal(t, "snapshot", files["snapshots/share-2.json.gz"])
+	assert.NotContains(t, files, "snapshots/share-1.json.gz")
+	for _, name := range []string{"profile.json", "slots.json", "shares.json", "notifications.json", "api_tokens.json", "devices.json", "audit/sync_events.json", "reading_events.json"} {
+		assert.Contains(t, files, name)
+	}
+
+	var notifications []domain.Notification
+	require.NoError(t, json.Unmarshal([]byte(files["notifications.json"]), &notifications))
+	require.Len(t, notifications, 1)
+	assert.Equal(t, redacted, notifications[0].Webhook)
+	assert.Empty(t, notifications[0].Token)
+	assert.Equal(t, "shiori", notifications[0].Username)
+
+	// Credentials of the account never end up in the archive
+	for name, content := range files {
+		assert.NotContains(t, content, "discord.com/api/webhooks/secret", name)
+		assert.NotContains(t, content, "secret\"", name)
+		assert.NotContains(t, content, "lookup", name)
+	}
+	assert.Contains(t, files["devices.json"], "firefox")
+	assert.Contains(t, files["audit/security_events.json"], string(domain.AuditActionLogin))
+}
@@ -0,0 +1,198 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/merge"
+	"github.com/flurbudurbur/Shiori/internal/user"
+	"github.com/rs/zerolog"
+)
+
+// exportService writes the data of a user as a zip archive, see export.Service.
+type exportService interface {
+	Write(ctx context.Context, user *domain.User, w io.Writer) error
+}
+
+// accountService deletes accounts, see user.Service.
+type accountService interface {
+	DeleteAccount(ctx context.Context, user *domain.User, bookmark string, sessionIDs []string) error
+}
+
+// mergeService merges another account into the current one, see merge.Service.
+type mergeService interface {
+	Merge(ctx context.Context, user *domain.User, req merge.Request, ip string) (*merge.Summary, error)
+}
+
+type accountHandler struct {
+	log      zerolog.Logger
+	encoder  encoder
+	config   *domain.Config
+	sessions sessionService
+	tokens   accessTokenService
+	exports  exportService
+	service  accountService
+	merges   mergeService
+}
+
+func newAccountHandler(encoder encoder, log zerolog.Logger, config *domain.Config, sessions sessionService, tokens accessTokenService, exports exportService, service accountService, merges mergeService) *accountHandler {
+	return &accountHandler{
+		log:      log.With().Str("handler", "account").Logger(),
+		encoder:  encoder,
+		config:   config,
+		sessions: sessions,
+		tokens:   tokens,
+		exports:  exports,
+		service:  service,
+		merges:   merges,
+	}
+}
+
+type deleteAccountRequest struct {
+	Bookmark string `json:"bookmark"` // Confirms the deletion, the bookmark UUID of the account
+}
+
+// accountUser returns the user allowed to export, merge or delete the account, named API tokens are not.
+func (h accountHandler) accountUser(w http.ResponseWriter, r *http.Request) (*domain.User, bool) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		h.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if apiToken, ok := ctx.Value(APITokenContextKey).(*domain.APIToken); ok && apiToken != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Forbidden: the account cannot be exported, merged or deleted with a named API token", Status: http.StatusForbidden}, http.StatusForbidden)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// export streams a zip archive of all data of the user.
+func (h accountHandler) export(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="shiori-export-%s.zip"`, time.Now().Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := h.exports.Write(r.Context(), u, w); err != nil {
+		h.log.Error().Err(err).Str("hashed_uuid", u.HashedUUID).Msg("Failed to export account")
+		// The archive is partly sent already, abort so the download fails instead of ending truncated
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// delete removes the account and all of its data and ends every session.
+func (h accountHandler) delete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Bookmark == "" {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "The bookmark is required to confirm the deletion", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := h.sessions.List(ctx, u.HashedUUID)
+	if err != nil {
+		h.log.Error().Err(err).Str("hashed_uuid", u.HashedUUID).Msg("Failed to list sessions for account deletion")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	sessionIDs := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		sessionIDs = append(sessionIDs, session.ID)
+	}
+
+	if err := h.service.DeleteAccount(ctx, u, req.Bookmark, sessionIDs); err != nil {
+		if errors.Is(err, user.ErrBookmarkMismatch) {
+			h.encoder.StatusResponse(ctx, w, errorResponse{Message: "The bookmark does not match this account", Status: http.StatusForbidden}, http.StatusForbidden)
+			return
+		}
+		h.log.Error().Err(err).Str("hashed_uuid", u.HashedUUID).Msg("Failed to delete account")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	// Sessions of the deleted account no longer find their user, ending them is cleanup
+	if err := h.sessions.RevokeAll(ctx, u.HashedUUID, ""); err != nil {
+		h.log.Error().Err(err).Str("hashed_uuid", u.HashedUUID).Msg("Failed to end sessions of deleted account")
+	}
+	if err := h.tokens.RevokeUser(ctx, u.HashedUUID); err != nil {
+		h.log.Error().Err(err).Str("hashed_uuid", u.HashedUUID).Msg("Failed to revoke access tokens of deleted account")
+	}
+
+	cookie := newSessionCookie(r, h.config.Server.BaseURL)
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+
+	h.encoder.NoContent(w)
+}
+
+// merge merges the account of another bookmark into this one. With dry_run it
+// only answers what would be merged, otherwise the other account is deleted.
+func (h accountHandler) merge(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req merge.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	summary, err := h.merges.Merge(ctx, u, req, getClientIP(r))
+	if err != nil {
+		if writeLockout(w, err) {
+			return
+		}
+
+		var (
+			status  int
+			message string
+		)
+		switch {
+		case errors.Is(err, merge.ErrBookmarkRequired), errors.Is(err, merge.ErrSameAccount):
+			status, message = http.StatusBadRequest, err.Error()
+		case errors.Is(err, user.ErrAuthenticationFailed):
+			status, message = http.StatusForbidden, "The bookmark, password or two-factor code is not valid"
+		case errors.Is(err, user.ErrUUIDLoginDisabled):
+			status, message = http.StatusForbidden, "Login with the bookmark UUID is disabled for the other account"
+		case errors.Is(err, user.ErrUserExpired), errors.Is(err, user.ErrUserDisabled):
+			status, message = http.StatusForbidden, "The other account is expired or disabled"
+		case errors.Is(err, user.ErrPasswordRequired):
+			status, message = http.StatusUnauthorized, "password required"
+		case errors.Is(err, user.ErrTwoFactorRequired):
+			status, message = http.StatusUnauthorized, "two-factor code required"
+		case errors.Is(err, user.ErrInvalidTwoFactorCode):
+			status, message = http.StatusUnauthorized, "invalid two-factor code"
+		case errors.Is(err, merge.ErrSlotLimitReached), errors.Is(err, merge.ErrLibraryChanged):
+			status, message = http.StatusConflict, err.Error()
+		default:
+			h.log.Error().Err(err).Str("hashed_uuid", u.HashedUUID).Msg("Failed to merge account")
+			h.encoder.StatusInternalError(w)
+			return
+		}
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: message, Status: status}, status)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, summary, http.StatusOK)
+}
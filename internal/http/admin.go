@@ -0,0 +1,344 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/flurbudurbur/Shiori/internal/admin"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+type adminService interface {
+	GetServerStats(ctx context.Context, windowDays int) (*domain.ServerStats, error)
+}
+
+// userAdminService manages accounts on behalf of an administrator, see admin.Service.
+type userAdminService interface {
+	ListUsers(ctx context.Context, params domain.UserQueryParams) ([]domain.UserSummary, int, error)
+	ExtendExpiry(ctx context.Context, actor *domain.User, publicID string, days int, ip string) (*domain.UserSummary, error)
+	SetDisabled(ctx context.Context, actor *domain.User, publicID string, disabled bool, ip string) error
+	ResetToken(ctx context.Context, actor *domain.User, publicID string, ip string) error
+	DeleteUser(ctx context.Context, actor *domain.User, publicID string, ip string) error
+	ListAuditEvents(ctx context.Context, params domain.AuditEventQueryParams) ([]domain.AuditEvent, int, error)
+	CreateInvite(ctx context.Context, actor *domain.User, req domain.CreateInviteRequest, ip string) (string, *domain.Invite, error)
+	ListInvites(ctx context.Context) ([]domain.Invite, error)
+	RevokeInvite(ctx context.Context, actor *domain.User, id int64, ip string) error
+}
+
+type adminHandler struct {
+	log     zerolog.Logger
+	encoder encoder
+	service adminService
+	users   userAdminService
+}
+
+func newAdminHandler(encoder encoder, log zerolog.Logger, service adminService, users userAdminService) *adminHandler {
+	return &adminHandler{
+		log:     log.With().Str("handler", "admin").Logger(),
+		encoder: encoder,
+		service: service,
+		users:   users,
+	}
+}
+
+func (h adminHandler) Routes(r chi.Router) {
+	r.Get("/stats", h.stats)
+	r.Get("/audit", h.audit)
+	r.Route("/users", func(r chi.Router) {
+		r.Get("/", h.listUsers)
+		r.Route("/{userID}", func(r chi.Router) {
+			r.Delete("/", h.deleteUser)
+			r.Post("/extend", h.extendExpiry)
+			r.Post("/disable", h.disableUser)
+			r.Post("/enable", h.enableUser)
+			r.Post("/reset-token", h.resetToken)
+		})
+	})
+	r.Route("/invites", func(r chi.Router) {
+		r.Get("/", h.listInvites)
+		r.Post("/", h.createInvite)
+		r.Delete("/{inviteID}", h.revokeInvite)
+	})
+}
+
+// stats returns instance-wide usage statistics.
+func (h adminHandler) stats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	days, err := parseStatsWindow(r)
+	if err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	stats, err := h.service.GetServerStats(ctx, days)
+	if err != nil {
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, stats, http.StatusOK)
+}
+
+const (
+	defaultAdminPageLimit = 50
+	maxAdminPageLimit     = 500
+)
+
+// parsePage reads the optional "limit" and "offset" query parameters.
+func parsePage(r *http.Request) (uint64, uint64, error) {
+	query := r.URL.Query()
+	limit := uint64(defaultAdminPageLimit)
+	var offset uint64
+
+	if value := query.Get("limit"); value != "" {
+		l, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || l == 0 {
+			return 0, 0, errors.New("invalid 'limit'")
+		}
+		limit = min(l, maxAdminPageLimit)
+	}
+
+	if value := query.Get("offset"); value != "" {
+		o, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, 0, errors.New("invalid 'offset'")
+		}
+		offset = o
+	}
+
+	return limit, offset, nil
+}
+
+type userListResponse struct {
+	Users []domain.UserSummary `json:"users"`
+	Count int                  `json:"count"`
+}
+
+// listUsers returns a page of users with their storage usage, last sync and expiry.
+func (h adminHandler) listUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	users, count, err := h.users.ListUsers(ctx, domain.UserQueryParams{Limit: limit, Offset: offset})
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to list users")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, userListResponse{Users: users, Count: count}, http.StatusOK)
+}
+
+type extendExpiryRequest struct {
+	Days int `json:"days"`
+}
+
+func (h adminHandler) extendExpiry(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+
+	var req extendExpiryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	summary, err := h.users.ExtendExpiry(ctx, actor, chi.URLParam(r, "userID"), req.Days, getClientIP(r))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, summary, http.StatusOK)
+}
+
+func (h adminHandler) disableUser(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, true)
+}
+
+func (h adminHandler) enableUser(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, false)
+}
+
+func (h adminHandler) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.users.SetDisabled(r.Context(), actor, chi.URLParam(r, "userID"), disabled, getClientIP(r)); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+// resetToken invalidates the user token, the user retrieves a new one from their profile.
+func (h adminHandler) resetToken(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.users.ResetToken(r.Context(), actor, chi.URLParam(r, "userID"), getClientIP(r)); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+func (h adminHandler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.users.DeleteUser(r.Context(), actor, chi.URLParam(r, "userID"), getClientIP(r)); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+type auditEventsResponse struct {
+	Events []domain.AuditEvent `json:"events"`
+	Count  int                 `json:"count"`
+}
+
+// audit returns the audit trail, newest first, optionally filtered by user, actor, target and action.
+func (h adminHandler) audit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	events, count, err := h.users.ListAuditEvents(ctx, domain.AuditEventQueryParams{
+		UserID:   query.Get("user"),
+		ActorID:  query.Get("actor"),
+		TargetID: query.Get("target"),
+		Action:   domain.AuditAction(query.Get("action")),
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to list audit events")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	if events == nil {
+		events = []domain.AuditEvent{}
+	}
+
+	h.encoder.StatusResponse(ctx, w, auditEventsResponse{Events: events, Count: count}, http.StatusOK)
+}
+
+type createInviteResponse struct {
+	Code   string         `json:"code"` // Only returned once
+	Invite *domain.Invite `json:"invite"`
+}
+
+func (h adminHandler) listInvites(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	invites, err := h.users.ListInvites(ctx)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to list invites")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	if invites == nil {
+		invites = []domain.Invite{}
+	}
+
+	h.encoder.StatusResponse(ctx, w, invites, http.StatusOK)
+}
+
+// createInvite mints a single or multi-use invite code for invite only registration.
+func (h adminHandler) createInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+
+	var req domain.CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	code, invite, err := h.users.CreateInvite(ctx, actor, req, getClientIP(r))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, createInviteResponse{Code: code, Invite: invite}, http.StatusCreated)
+}
+
+func (h adminHandler) revokeInvite(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.actor(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "inviteID"), 10, 64)
+	if err != nil {
+		h.encoder.StatusResponse(r.Context(), w, errorResponse{Message: "Invalid invite ID", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.users.RevokeInvite(r.Context(), actor, id, getClientIP(r)); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+// actor returns the administrator making the request.
+func (h adminHandler) actor(w http.ResponseWriter, r *http.Request) (*domain.User, bool) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		h.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
+		return nil, false
+	}
+	return user, true
+}
+
+func (h adminHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	ctx := r.Context()
+	switch {
+	case errors.Is(err, admin.ErrUserNotFound), errors.Is(err, domain.ErrInviteNotFound):
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusNotFound}, http.StatusNotFound)
+	case errors.Is(err, admin.ErrInvalidExtension), errors.Is(err, admin.ErrInvalidInviteRequest):
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+	case errors.Is(err, admin.ErrSelfAction):
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusConflict}, http.StatusConflict)
+	default:
+		h.log.Error().Err(err).Str("user_id", chi.URLParam(r, "userID")).Msg("Admin action failed")
+		h.encoder.StatusInternalError(w)
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// auditService records security events and lists them, see audit.Service.
+type auditService interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+	Find(ctx context.Context, params domain.AuditEventQueryParams) ([]domain.AuditEvent, int, error)
+}
+
+// newAuditEvent prepares an audit log entry for an action the user took on their own account.
+func newAuditEvent(r *http.Request, action domain.AuditAction, user *domain.User, details map[string]string) domain.AuditEvent {
+	return domain.AuditEvent{
+		Action:   action,
+		ActorID:  user.PublicID,
+		TargetID: user.PublicID,
+		IP:       getClientIP(r),
+		Details:  details,
+	}
+}
+
+type auditHandler struct {
+	log     zerolog.Logger
+	encoder encoder
+	service auditService
+}
+
+func newAuditHandler(encoder encoder, log zerolog.Logger, service auditService) *auditHandler {
+	return &auditHandler{
+		log:     log.With().Str("handler", "audit").Logger(),
+		encoder: encoder,
+		service: service,
+	}
+}
+
+// Routes lists the security events of the authenticated user, administrators see all events at /admin/audit.
+func (h auditHandler) Routes(r chi.Router) {
+	r.Get("/", h.list)
+}
+
+// list returns the events the user took part in, newest first, optionally filtered by action.
+func (h auditHandler) list(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	events, count, err := h.service.Find(ctx, domain.AuditEventQueryParams{
+		UserID: user.PublicID,
+		Action: domain.AuditAction(r.URL.Query().Get("action")),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to list audit events")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	if events == nil {
+		events = []domain.AuditEvent{}
+	}
+
+	h.encoder.StatusResponse(ctx, w, auditEventsResponse{Events: events, Count: count}, http.StatusOK)
+}
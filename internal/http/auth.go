@@ -4,12 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors" // Use standard errors package
+	"io"
 	"net/http"
 
 	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/registration"
 	"github.com/flurbudurbur/Shiori/internal/user" // Import user service package for error types
 	"github.com/go-chi/chi/v5"
-	"github.com/gorilla/sessions"
 	"github.com/rs/zerolog"
 	"gorm.io/gorm" // Import gorm for ErrRecordNotFound
 )
@@ -17,11 +18,31 @@ import (
 // authService interface matching the required methods from user.Service
 type authService interface {
 	GetUserCount(ctx context.Context) (int, error)
-	// GenerateUserBookmark (conceptually our RegisterNewUser) now returns the HashedUUID (bookmark string)
-	GenerateUserBookmark(ctx context.Context) (hashedUUID string, err error)
+	// GenerateUserBookmark (conceptually our RegisterNewUser) returns the HashedUUID (bookmark string)
+	// and the plain API token of the new user.
+	GenerateUserBookmark(ctx context.Context) (hashedUUID string, apiToken string, err error)
 	// AuthenticateUser uses the HashedUUID to fetch and authenticate a user.
 	// This maps to user.Service.GetUserForAuthentication.
-	AuthenticateUser(ctx context.Context, hashedUUID string) (*domain.User, error)
+	// Failed attempts count towards the lockout of the client ip and the bookmark.
+	// The password and two-factor code are only checked for users who set them up,
+	// ErrPasswordRequired and ErrTwoFactorRequired are returned when they are missing.
+	AuthenticateUser(ctx context.Context, hashedUUID string, password string, twoFactorCode string, ip string) (*domain.User, error)
+	// RotateLegacyAPIToken replaces an API token in the legacy format, returns "" if there was none.
+	RotateLegacyAPIToken(ctx context.Context, user *domain.User) (string, error)
+}
+
+// oidcService logs users in at an OpenID Connect provider, see oidc.Service.
+type oidcService interface {
+	Enabled() bool
+	AuthCodeURL(ctx context.Context, linkUserHashedUUID string) (state string, url string, err error)
+	Callback(ctx context.Context, state string, code string) (*domain.User, error)
+}
+
+// registrationService decides who may register, see registration.Service.
+type registrationService interface {
+	Status(ctx context.Context) (*domain.RegistrationStatus, error)
+	NewChallenge(ctx context.Context) (*domain.RegistrationChallenge, error)
+	Admit(ctx context.Context, req domain.RegisterRequest) error
 }
 
 type authHandler struct {
@@ -30,39 +51,69 @@ type authHandler struct {
 	config  *domain.Config
 	service authService // Use the local interface definition
 
-	cookieStore *sessions.CookieStore
+	sessions     sessionService
+	oidc         oidcService
+	registration registrationService
+	audit        auditService
 }
 
 // newAuthHandler constructor uses the local authService interface
-func newAuthHandler(encoder encoder, log zerolog.Logger, config *domain.Config, cookieStore *sessions.CookieStore, service authService) *authHandler {
+func newAuthHandler(encoder encoder, log zerolog.Logger, config *domain.Config, sessions sessionService, oidc oidcService, registration registrationService, audit auditService, service authService) *authHandler {
 	return &authHandler{
-		log:         log,
-		encoder:     encoder,
-		config:      config,
-		service:     service,
-		cookieStore: cookieStore,
+		log:          log,
+		encoder:      encoder,
+		config:       config,
+		service:      service,
+		sessions:     sessions,
+		oidc:         oidc,
+		registration: registration,
+		audit:        audit,
 	}
 }
 
 func (h authHandler) Routes(r chi.Router) {
 	r.Post("/login", h.login)
 	r.Post("/logout", h.logout)
-	r.Post("/register", h.register)                 // Use bookmark generation as registration
 	r.Post("/login-uuid", h.loginWithUUID)          // New endpoint for UUID login
 	r.Get("/register/status", h.registrationStatus) // Renamed from canOnboard
 	r.Get("/validate", h.validate)
+	r.Get("/oidc/status", h.oidcStatus)
+	r.Get("/oidc/login", h.oidcLogin)
+	r.Get("/oidc/callback", h.oidcCallback)
+}
+
+// RegistrationRoutes create users or proof of work challenges and are rate limited.
+func (h authHandler) RegistrationRoutes(r chi.Router) {
+	r.Post("/register", h.register) // Use bookmark generation as registration
+	r.Post("/register/challenge", h.registrationChallenge)
 	r.Post("/generate-bookmark", h.register) // Add route for bookmark generation, which also logs in
 }
 
 // loginRequest defines the expected JSON body for the login endpoint
 type loginRequest struct {
-	UUID string `json:"uuid"`
+	UUID     string `json:"uuid"`
+	Password string `json:"password,omitempty"` // Only required by accounts that set a password
+	// TwoFactorCode is a TOTP or recovery code, only required by accounts with two-factor authentication
+	TwoFactorCode string `json:"two_factor_code,omitempty"`
 }
 
 // loginResponse defines the JSON response for successful login
 type loginResponse struct {
 	User  *domain.User `json:"user"`  // Include user details on login
 	Token string       `json:"token"` // Add token field for frontend compatibility
+	// APIToken is only set when a legacy API token was replaced during login
+	APIToken string `json:"api_token,omitempty"`
+}
+
+// rotateLegacyToken forces the reset of an API token that predates the prefixed
+// format. A failure is logged but does not fail the login.
+func (h authHandler) rotateLegacyToken(ctx context.Context, authenticatedUser *domain.User) string {
+	apiToken, err := h.service.RotateLegacyAPIToken(ctx, authenticatedUser)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Auth: Failed to replace legacy API token")
+		return ""
+	}
+	return apiToken
 }
 
 func (h authHandler) login(w http.ResponseWriter, r *http.Request) {
@@ -83,20 +134,8 @@ func (h authHandler) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.cookieStore.Options.HttpOnly = true
-	h.cookieStore.Options.SameSite = http.SameSiteLaxMode
-	h.cookieStore.Options.Path = h.config.Server.BaseURL // Access via nested Server struct
-
-	fwdProto := r.Header.Get("X-Forwarded-Proto")
-	if fwdProto == "https" {
-		h.cookieStore.Options.Secure = true
-		h.cookieStore.Options.SameSite = http.SameSiteStrictMode
-	}
-
-	session, _ := h.cookieStore.Get(r, "user_session")
-
 	// AuthenticateUser now expects HashedUUID, which is the UUID from the request
-	authenticatedUser, err := h.service.AuthenticateUser(ctx, data.UUID)
+	authenticatedUser, err := h.service.AuthenticateUser(ctx, data.UUID, data.Password, data.TwoFactorCode, getClientIP(r))
 	if err != nil {
 		uuidPrefix := data.UUID
 		if len(uuidPrefix) > 8 {
@@ -104,10 +143,16 @@ func (h authHandler) login(w http.ResponseWriter, r *http.Request) {
 		}
 		h.log.Warn().Err(err).Msgf("Auth: Failed login attempt uuid_prefix: [%s] ip: %s", uuidPrefix, ReadUserIP(r))
 
+		if writeLockout(w, err) || h.writeLoginChallenge(ctx, w, err) {
+			return
+		}
+
 		if errors.Is(err, user.ErrAuthenticationFailed) || errors.Is(err, gorm.ErrRecordNotFound) { // Use gorm.ErrRecordNotFound
 			h.encoder.StatusResponse(ctx, w, nil, http.StatusUnauthorized)
 		} else if errors.Is(err, user.ErrUserExpired) {
 			h.encoder.StatusResponse(ctx, w, nil, http.StatusUnauthorized)
+		} else if errors.Is(err, user.ErrUserDisabled) {
+			h.encoder.StatusResponse(ctx, w, errorResponse{Message: "This account has been disabled", Status: http.StatusForbidden}, http.StatusForbidden)
 		} else {
 			h.encoder.StatusResponse(ctx, w, nil, http.StatusInternalServerError)
 		}
@@ -119,14 +164,17 @@ func (h authHandler) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set user as authenticated
-	session.Values["authenticated"] = true
-	session.Values["user_uuid"] = authenticatedUser.HashedUUID // Use HashedUUID
-	session.Save(r, w)
+	// Start a server-side session for the web UI
+	if err := h.startSession(w, r, authenticatedUser, "bookmark"); err != nil {
+		h.log.Error().Err(err).Msg("Auth: Failed to start session")
+		h.encoder.StatusInternalError(w)
+		return
+	}
 
 	h.encoder.StatusResponse(ctx, w, loginResponse{
-		User:  authenticatedUser,
-		Token: authenticatedUser.HashedUUID, // Use HashedUUID as the token
+		User:     authenticatedUser,
+		Token:    authenticatedUser.HashedUUID, // Use HashedUUID as the token
+		APIToken: h.rotateLegacyToken(ctx, authenticatedUser),
 	}, http.StatusOK)
 }
 
@@ -148,20 +196,8 @@ func (h authHandler) loginWithUUID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.cookieStore.Options.HttpOnly = true
-	h.cookieStore.Options.SameSite = http.SameSiteLaxMode
-	h.cookieStore.Options.Path = h.config.Server.BaseURL
-
-	fwdProto := r.Header.Get("X-Forwarded-Proto")
-	if fwdProto == "https" {
-		h.cookieStore.Options.Secure = true
-		h.cookieStore.Options.SameSite = http.SameSiteStrictMode
-	}
-
-	session, _ := h.cookieStore.Get(r, "user_session")
-
 	// AuthenticateUser now expects HashedUUID
-	authenticatedUser, err := h.service.AuthenticateUser(ctx, data.UUID)
+	authenticatedUser, err := h.service.AuthenticateUser(ctx, data.UUID, data.Password, data.TwoFactorCode, getClientIP(r))
 	if err != nil {
 		uuidPrefix := data.UUID
 		if len(uuidPrefix) > 8 {
@@ -169,10 +205,16 @@ func (h authHandler) loginWithUUID(w http.ResponseWriter, r *http.Request) {
 		}
 		h.log.Warn().Err(err).Msgf("Auth: Failed login-uuid attempt uuid_prefix: [%s] ip: %s", uuidPrefix, ReadUserIP(r))
 
+		if writeLockout(w, err) || h.writeLoginChallenge(ctx, w, err) {
+			return
+		}
+
 		if errors.Is(err, user.ErrAuthenticationFailed) || errors.Is(err, gorm.ErrRecordNotFound) { // Use gorm.ErrRecordNotFound
 			h.encoder.StatusResponse(ctx, w, nil, http.StatusUnauthorized)
 		} else if errors.Is(err, user.ErrUserExpired) {
 			h.encoder.StatusResponse(ctx, w, nil, http.StatusUnauthorized)
+		} else if errors.Is(err, user.ErrUserDisabled) {
+			h.encoder.StatusResponse(ctx, w, errorResponse{Message: "This account has been disabled", Status: http.StatusForbidden}, http.StatusForbidden)
 		} else {
 			h.encoder.StatusResponse(ctx, w, nil, http.StatusInternalServerError)
 		}
@@ -184,45 +226,113 @@ func (h authHandler) loginWithUUID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set user as authenticated
-	session.Values["authenticated"] = true
-	session.Values["user_uuid"] = authenticatedUser.HashedUUID // Use HashedUUID
-	session.Save(r, w)
+	// Start a server-side session for the web UI
+	if err := h.startSession(w, r, authenticatedUser, "bookmark"); err != nil {
+		h.log.Error().Err(err).Msg("Auth: Failed to start session")
+		h.encoder.StatusInternalError(w)
+		return
+	}
 
 	// Return user information and token upon successful login
 	// Use the HashedUUID as the token since that's what the frontend expects
 	h.encoder.StatusResponse(ctx, w, loginResponse{
-		User:  authenticatedUser,
-		Token: authenticatedUser.HashedUUID, // Use HashedUUID as the token
+		User:     authenticatedUser,
+		Token:    authenticatedUser.HashedUUID, // Use HashedUUID as the token
+		APIToken: h.rotateLegacyToken(ctx, authenticatedUser),
 	}, http.StatusOK)
 }
 
+// writeLoginChallenge answers logins with a valid bookmark that need another
+// credential, the client asks for it and retries. It reports whether it wrote a response.
+func (h authHandler) writeLoginChallenge(ctx context.Context, w http.ResponseWriter, err error) bool {
+	var (
+		status  = http.StatusUnauthorized
+		message string
+	)
+	switch {
+	case errors.Is(err, user.ErrUUIDLoginDisabled):
+		status = http.StatusForbidden
+		message = "Login with the bookmark UUID is disabled, use a passkey"
+	case errors.Is(err, user.ErrPasswordRequired):
+		message = "password required"
+	case errors.Is(err, user.ErrTwoFactorRequired):
+		message = "two-factor code required"
+	case errors.Is(err, user.ErrInvalidTwoFactorCode):
+		message = "invalid two-factor code"
+	default:
+		return false
+	}
+
+	h.encoder.StatusResponse(ctx, w, errorResponse{Message: message, Status: status}, status)
+	return true
+}
+
 func (h authHandler) logout(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	session, _ := h.cookieStore.Get(r, "user_session")
-
-	// Revoke users authentication
-	session.Values["authenticated"] = false
-	delete(session.Values, "user_uuid") // Remove UUID from session
-	session.Save(r, w)
+	// End the server-side session, the cookie is cleared either way
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := h.sessions.Logout(ctx, cookie.Value); err != nil {
+			h.log.Error().Err(err).Msg("Auth: Failed to end session")
+		}
+	}
+	h.clearSessionCookie(w, r)
 
 	h.encoder.StatusResponse(ctx, w, nil, http.StatusNoContent)
 }
 
-// registrationStatus checks if registration is generally possible (it always is now).
+// registrationStatus tells the client whether it can register and what it needs to.
+// Clients that only look at the status code see 403 when registration is not possible.
 func (h authHandler) registrationStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	// Since multiple users (UUIDs) are allowed, registration is always possible.
-	// The old check for userCount > 0 is removed.
-	h.encoder.StatusResponse(ctx, w, nil, http.StatusNoContent)
+
+	status, err := h.registration.Status(ctx)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Auth: Failed to get registration status")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	code := http.StatusOK
+	if !status.Open {
+		code = http.StatusForbidden
+	}
+	h.encoder.StatusResponse(ctx, w, status, code)
+}
+
+// registrationChallenge issues the proof of work a registration has to solve,
+// 204 means none is required.
+func (h authHandler) registrationChallenge(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	challenge, err := h.registration.NewChallenge(ctx)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Auth: Failed to issue registration challenge")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	if challenge == nil {
+		h.encoder.NoContent(w)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, challenge, http.StatusOK)
 }
 
 func (h authHandler) validate(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	session, _ := h.cookieStore.Get(r, "user_session")
+	if forwardAuthenticated(ctx) {
+		h.encoder.StatusResponse(ctx, w, nil, http.StatusNoContent)
+		return
+	}
 
-	// Check if user is authenticated
-	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
+	// Check if the session cookie belongs to a live session
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		http.Error(w, "Forbidden", http.StatusUnauthorized)
+		return
+	}
+	session, err := h.sessions.Authenticate(ctx, cookie.Value, getClientIP(r))
+	if err != nil || session == nil {
 		http.Error(w, "Forbidden", http.StatusUnauthorized)
 		return
 	}
@@ -231,6 +341,32 @@ func (h authHandler) validate(w http.ResponseWriter, r *http.Request) {
 	h.encoder.StatusResponse(ctx, w, nil, http.StatusNoContent)
 }
 
+// startSession creates a server-side session for the user and sets its cookie.
+// The login is recorded in the audit log with the method the user logged in with.
+func (h authHandler) startSession(w http.ResponseWriter, r *http.Request, authenticatedUser *domain.User, method string) error {
+	if err := startSession(w, r, h.log, h.sessions, h.config.Server.BaseURL, authenticatedUser); err != nil {
+		return err
+	}
+	h.audit.Record(r.Context(), newAuditEvent(r, domain.AuditActionLogin, authenticatedUser, map[string]string{"method": method}))
+	return nil
+}
+
+func (h authHandler) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	cookie := h.sessionCookie(r)
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// sessionCookie returns the session cookie without value, see newSessionCookie.
+func (h authHandler) sessionCookie(r *http.Request) *http.Cookie {
+	return newSessionCookie(r, h.config.Server.BaseURL)
+}
+
+// basePath returns the path the web UI is served under.
+func (h authHandler) basePath() string {
+	return basePath(h.config.Server.BaseURL)
+}
+
 func ReadUserIP(r *http.Request) string {
 	IPAddress := r.Header.Get("X-Real-Ip")
 	if IPAddress == "" {
@@ -244,17 +380,45 @@ func ReadUserIP(r *http.Request) string {
 
 // generateBookmarkResponse defines the JSON response for the bookmark generation endpoint
 type generateBookmarkResponse struct {
-	UUID  string       `json:"uuid"`
-	User  *domain.User `json:"user,omitempty"`  // Include user details for auto-login
-	Token string       `json:"token,omitempty"` // Include token for frontend compatibility
+	UUID     string       `json:"uuid"`
+	User     *domain.User `json:"user,omitempty"`  // Include user details for auto-login
+	Token    string       `json:"token,omitempty"` // Include token for frontend compatibility
+	APIToken string       `json:"api_token"`       // Only returned once, it cannot be retrieved later
 }
 
 // register handles requests to generate a new user bookmark UUID and auto-authenticates.
 func (h authHandler) register(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	// The body is optional, it is only needed for invite codes and proofs of work
+	var req domain.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.registration.Admit(ctx, req); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, registration.ErrRegistrationClosed),
+			errors.Is(err, registration.ErrUserLimitReached),
+			errors.Is(err, registration.ErrInviteRequired),
+			errors.Is(err, registration.ErrInvalidInvite):
+			status = http.StatusForbidden
+		case errors.Is(err, registration.ErrProofOfWorkRequired),
+			errors.Is(err, registration.ErrInvalidProofOfWork):
+			status = http.StatusBadRequest
+		default:
+			h.log.Error().Err(err).Msg("Auth: Failed to check registration")
+			h.encoder.StatusInternalError(w)
+			return
+		}
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: status}, status)
+		return
+	}
+
 	// Call the service method to generate the UUID
-	generatedUUID, err := h.service.GenerateUserBookmark(ctx)
+	generatedUUID, apiToken, err := h.service.GenerateUserBookmark(ctx)
 	if err != nil {
 		h.log.Error().Err(err).Msg("Auth: Failed to generate user bookmark UUID")
 		h.encoder.StatusResponse(ctx, w, map[string]string{"error": "Failed to generate bookmark"}, http.StatusInternalServerError)
@@ -262,46 +426,36 @@ func (h authHandler) register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Automatically authenticate the user with the new UUID
-	authenticatedUser, err := h.service.AuthenticateUser(ctx, generatedUUID)
+	authenticatedUser, err := h.service.AuthenticateUser(ctx, generatedUUID, "", "", getClientIP(r))
 	if err != nil {
 		// This should ideally not happen if GenerateUserBookmark creates a valid user
 		h.log.Error().Err(err).Str("uuid", generatedUUID).Msg("Auth: Failed to auto-authenticate user after bookmark generation")
 		// Still return the UUID, but log the auth error. Frontend might need to retry login.
 		h.encoder.StatusResponse(ctx, w, generateBookmarkResponse{
-			UUID: generatedUUID,
+			UUID:     generatedUUID,
+			APIToken: apiToken,
 			// No Token field since authentication failed
 		}, http.StatusOK) // Or an error?
 		return
 	}
 
-	// Set up session for the auto-authenticated user
-	h.cookieStore.Options.HttpOnly = true
-	h.cookieStore.Options.SameSite = http.SameSiteLaxMode
-	h.cookieStore.Options.Path = h.config.Server.BaseURL
-
-	fwdProto := r.Header.Get("X-Forwarded-Proto")
-	if fwdProto == "https" {
-		h.cookieStore.Options.Secure = true
-		h.cookieStore.Options.SameSite = http.SameSiteStrictMode
-	}
+	h.audit.Record(ctx, newAuditEvent(r, domain.AuditActionRegister, authenticatedUser, nil))
 
-	session, _ := h.cookieStore.Get(r, "user_session")
-	session.Values["authenticated"] = true
-	session.Values["user_uuid"] = authenticatedUser.HashedUUID // Use HashedUUID
-	err = session.Save(r, w)
-	if err != nil {
+	// Set up session for the auto-authenticated user
+	if err := h.startSession(w, r, authenticatedUser, "register"); err != nil {
 		h.log.Error().Err(err).Msg("Auth: Failed to save session during registration/auto-login")
 		// Even if session save fails, we should still inform the user about the UUID.
 		// The client can then attempt to login manually.
-		h.encoder.StatusResponse(ctx, w, generateBookmarkResponse{UUID: generatedUUID}, http.StatusOK)
+		h.encoder.StatusResponse(ctx, w, generateBookmarkResponse{UUID: generatedUUID, APIToken: apiToken}, http.StatusOK)
 		return
 	}
 
 	h.log.Info().Str("uuid", generatedUUID).Msg("Auth: Successfully generated user bookmark UUID and auto-authenticated")
 	response := generateBookmarkResponse{
-		UUID:  generatedUUID,
-		User:  authenticatedUser,
-		Token: authenticatedUser.HashedUUID, // Add token for consistency
+		UUID:     generatedUUID,
+		User:     authenticatedUser,
+		Token:    authenticatedUser.HashedUUID, // Add token for consistency
+		APIToken: apiToken,
 	}
 	h.encoder.StatusResponse(ctx, w, response, http.StatusOK)
 }
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// bookmarkService issues a new bookmark for a user, see user.Service.
+type bookmarkService interface {
+	RotateBookmark(ctx context.Context, user *domain.User, sessionIDs []string) (hashedUUID string, plainToken string, err error)
+}
+
+type bookmarkHandler struct {
+	log      zerolog.Logger
+	encoder  encoder
+	config   *domain.Config
+	sessions sessionService
+	tokens   accessTokenService
+	service  bookmarkService
+}
+
+func newBookmarkHandler(encoder encoder, log zerolog.Logger, config *domain.Config, sessions sessionService, tokens accessTokenService, service bookmarkService) *bookmarkHandler {
+	return &bookmarkHandler{
+		log:      log.With().Str("handler", "bookmark").Logger(),
+		encoder:  encoder,
+		config:   config,
+		sessions: sessions,
+		tokens:   tokens,
+		service:  service,
+	}
+}
+
+func (h bookmarkHandler) Routes(r chi.Router) {
+	r.Post("/", h.rotate)
+}
+
+type rotateBookmarkResponse struct {
+	UUID     string `json:"uuid"`      // The new bookmark, only returned once
+	APIToken string `json:"api_token"` // Replaces the user token, only returned once
+}
+
+// rotate moves the user to a new bookmark. Every session, API token and access
+// token of the old bookmark ends, the web UI continues in a new session.
+func (h bookmarkHandler) rotate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		h.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
+		return
+	}
+	if currentSessionID(ctx) == "" {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "The bookmark can only be rotated from the web UI", Status: http.StatusForbidden}, http.StatusForbidden)
+		return
+	}
+
+	sessions, err := h.sessions.List(ctx, user.HashedUUID)
+	if err != nil {
+		h.log.Error().Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to list sessions for bookmark rotation")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	sessionIDs := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		sessionIDs = append(sessionIDs, session.ID)
+	}
+
+	hashedUUID, apiToken, err := h.service.RotateBookmark(ctx, user, sessionIDs)
+	if err != nil {
+		h.log.Error().Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to rotate bookmark")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	// Sessions of the old bookmark no longer find their user, ending them is cleanup
+	if err := h.sessions.RevokeAll(ctx, user.HashedUUID, ""); err != nil {
+		h.log.Error().Err(err).Str("hashed_uuid", hashedUUID).Msg("Failed to end sessions of rotated bookmark")
+	}
+	// Access tokens are not checked against the user, they would keep acting on the old bookmark
+	if err := h.tokens.RevokeUser(ctx, user.HashedUUID); err != nil {
+		h.log.Error().Err(err).Str("hashed_uuid", hashedUUID).Msg("Failed to revoke access tokens of rotated bookmark")
+	}
+
+	rotated := *user
+	rotated.HashedUUID = hashedUUID
+	if err := startSession(w, r, h.log, h.sessions, h.config.Server.BaseURL, &rotated); err != nil {
+		// The new bookmark is only shown now, it is returned even if the user has to log in again
+		h.log.Error().Err(err).Str("hashed_uuid", hashedUUID).Msg("Failed to start session for rotated bookmark")
+	}
+
+	h.encoder.StatusResponse(ctx, w, rotateBookmarkResponse{UUID: hashedUUID, APIToken: apiToken}, http.StatusOK)
+}
@@ -3,6 +3,7 @@ package http
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/flurbudurbur/Shiori/internal/config"
 	"github.com/flurbudurbur/Shiori/internal/domain"
@@ -70,16 +71,31 @@ func (h configHandler) updateConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The new values are recorded in the audit log
+	changes := map[string]string{}
+
 	if data.CheckForUpdates != nil {
 		h.cfg.Config.CheckForUpdates = *data.CheckForUpdates
+		changes["check_for_updates"] = strconv.FormatBool(*data.CheckForUpdates)
 	}
 
 	if data.LogLevel != nil {
 		h.cfg.Config.Logging.Level = *data.LogLevel // Access via nested Logging struct
+		changes["log_level"] = *data.LogLevel
 	}
 
 	if data.LogPath != nil {
 		h.cfg.Config.Logging.Path = *data.LogPath // Access via nested Logging struct
+		changes["log_path"] = *data.LogPath
+	}
+
+	if user, ok := r.Context().Value(UserContextKey).(*domain.User); ok && user != nil && len(changes) > 0 {
+		h.server.auditService.Record(r.Context(), domain.AuditEvent{
+			Action:  domain.AuditActionUpdateConfig,
+			ActorID: user.PublicID,
+			IP:      getClientIP(r),
+			Details: changes,
+		})
 	}
 
 	// NOTE: The UpdateConfig method was removed as it was incompatible with the nested TOML structure.
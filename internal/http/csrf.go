@@ -0,0 +1,100 @@
+package http
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// csrfCookieName and csrfHeaderName are the names the web UI's HTTP client uses by default.
+	csrfCookieName = "XSRF-TOKEN"
+	csrfHeaderName = "X-XSRF-TOKEN"
+	csrfTokenBytes = 32
+)
+
+// CSRF protects state-changing requests authenticated by the session cookie or
+// forward auth with a double submit token. The token is set in a cookie the web
+// UI reads and sends back in a header, other sites can do neither. Requests
+// without a session cookie, such as API token requests, carry no ambient
+// credentials and are exempt.
+func (s *Server) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if cookie, err := r.Cookie(csrfCookieName); err == nil {
+			token = cookie.Value
+		}
+
+		if token == "" {
+			b := make([]byte, csrfTokenBytes)
+			if _, err := rand.Read(b); err != nil {
+				s.log.Error().Err(err).Msg("Failed to generate CSRF token")
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			token = hex.EncodeToString(b)
+
+			cookie := newSessionCookie(r, s.config.Config.Server.BaseURL)
+			cookie.Name = csrfCookieName
+			cookie.Value = token
+			cookie.HttpOnly = false // Read by the web UI
+			http.SetCookie(w, cookie)
+		}
+
+		if !safeMethod(r.Method) && cookieAuthenticated(r) {
+			header := r.Header.Get(csrfHeaderName)
+			if header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+				s.log.Warn().Str("method", r.Method).Str("path", r.URL.Path).Msg("Rejected request with missing or invalid CSRF token")
+				http.Error(w, "Forbidden: missing or invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// safeMethod reports whether the method must not change state.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// cookieAuthenticated reports whether the browser authenticates the request on
+// its own. The session wins over an Authorization header, see Authenticate.
+func cookieAuthenticated(r *http.Request) bool {
+	if forwardAuthenticated(r.Context()) {
+		return true
+	}
+	_, err := r.Cookie(sessionCookieName)
+	return err == nil
+}
+
+// originAllowed returns whether an origin may make cross-origin requests with
+// credentials: the configured origins, or else the origin of an absolute base
+// URL. Without either only the origin serving the web UI can make them.
+func originAllowed(configured string, baseURL string) func(origin string) bool {
+	origins := map[string]struct{}{}
+	for _, origin := range strings.Split(configured, ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins[strings.ToLower(origin)] = struct{}{}
+		}
+	}
+
+	if len(origins) == 0 {
+		if u, err := url.Parse(baseURL); err == nil && u.Scheme != "" && u.Host != "" {
+			origins[strings.ToLower(u.Scheme+"://"+u.Host)] = struct{}{}
+		}
+	}
+
+	return func(origin string) bool {
+		_, ok := origins[strings.ToLower(origin)]
+		return ok
+	}
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/config"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCSRFToken = "0123456789abcdef"
+
+func TestServer_CSRF(t *testing.T) {
+	s := &Server{log: zerolog.Nop(), config: config.New(t.TempDir(), "test")}
+
+	tests := []struct {
+		name          string
+		method        string
+		session       bool // A session cookie is sent
+		forwardAuth   bool // ForwardAuth authenticated the request
+		authorization string
+		header        string
+		want          int
+	}{
+		{name: "safe_method", method: http.MethodGet, session: true, want: http.StatusOK},
+		{name: "session_with_token", method: http.MethodPost, session: true, header: testCSRFToken, want: http.StatusOK},
+		{name: "session_without_token", method: http.MethodPost, session: true, want: http.StatusForbidden},
+		{name: "session_with_wrong_token", method: http.MethodDelete, session: true, header: "fedcba9876543210", want: http.StatusForbidden},
+		{name: "session_wins_over_bearer", method: http.MethodPost, session: true, authorization: "Bearer shi_token", want: http.StatusForbidden},
+		{name: "bearer_exempt", method: http.MethodPost, authorization: "Bearer shi_token", want: http.StatusOK},
+		{name: "anonymous_exempt", method: http.MethodPut, want: http.StatusOK},
+		{name: "forward_auth_with_token", method: http.MethodPost, forwardAuth: true, header: testCSRFToken, want: http.StatusOK},
+		{name: "forward_auth_without_token", method: http.MethodPost, forwardAuth: true, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/sync", nil)
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: testCSRFToken})
+			if tt.session {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "session"})
+			}
+			if tt.forwardAuth {
+				r = r.WithContext(context.WithValue(r.Context(), UserContextKey, &domain.User{HashedUUID: "bookmark"}))
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.header != "" {
+				r.Header.Set(csrfHeaderName, tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			s.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(w, r)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestServer_CSRFIssuesToken(t *testing.T) {
+	s := &Server{log: zerolog.Nop(), config: config.New(t.TempDir(), "test")}
+	handler := s.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, csrfCookieName, cookies[0].Name)
+	assert.Len(t, cookies[0].Value, 2*csrfTokenBytes)
+	assert.False(t, cookies[0].HttpOnly, "the web UI reads the token")
+
+	// A token the browser already has is kept
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Empty(t, w.Result().Cookies())
+}
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		baseURL    string
+		origin     string
+		want       bool
+	}{
+		{name: "configured", configured: "https://app.example.com, https://other.example.com/", origin: "https://other.example.com", want: true},
+		{name: "configured_case_insensitive", configured: "https://App.Example.com", origin: "https://app.example.com", want: true},
+		{name: "not_configured", configured: "https://app.example.com", origin: "https://evil.example.com"},
+		{name: "configured_ignores_base_url", configured: "https://app.example.com", baseURL: "https://shiori.example.com/", origin: "https://shiori.example.com"},
+		{name: "base_url_fallback", baseURL: "https://shiori.example.com/shiori/", origin: "https://shiori.example.com", want: true},
+		{name: "base_url_other_scheme", baseURL: "https://shiori.example.com/", origin: "http://shiori.example.com"},
+		{name: "relative_base_url", baseURL: "/shiori/", origin: "https://shiori.example.com"},
+		{name: "nothing_configured", origin: "https://shiori.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, originAllowed(tt.configured, tt.baseURL)(tt.origin))
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/flurbudurbur/Shiori/internal/device"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// deviceService pairs devices by short user codes, see device.Service.
+type deviceService interface {
+	Request(ctx context.Context, name string, scopes []string) (deviceCode string, authorization *domain.DeviceAuthorization, err error)
+	Approve(ctx context.Context, userCode string, userHashedUUID string, allowed domain.ScopeSet) (*domain.DeviceAuthorization, error)
+	Deny(ctx context.Context, userCode string) (*domain.DeviceAuthorization, error)
+	Poll(ctx context.Context, deviceCode string) (plainToken string, token *domain.APIToken, err error)
+}
+
+type deviceHandler struct {
+	log     zerolog.Logger
+	encoder encoder
+	config  *domain.Config
+	service deviceService
+}
+
+func newDeviceHandler(encoder encoder, log zerolog.Logger, config *domain.Config, service deviceService) *deviceHandler {
+	return &deviceHandler{
+		log:     log.With().Str("handler", "device").Logger(),
+		encoder: encoder,
+		config:  config,
+		service: service,
+	}
+}
+
+// CodeRoutes start a pairing, the device has no credentials yet.
+func (h deviceHandler) CodeRoutes(r chi.Router) {
+	r.Post("/code", h.requestCode)
+}
+
+// TokenRoutes are polled by the device, the device code is the credential.
+func (h deviceHandler) TokenRoutes(r chi.Router) {
+	r.Post("/token", h.pollToken)
+}
+
+// Routes decide on a pairing, only from a web session.
+func (h deviceHandler) Routes(r chi.Router) {
+	r.Post("/approve", h.approve)
+	r.Post("/deny", h.deny)
+}
+
+type deviceCodeRequest struct {
+	Name   string   `json:"name"`   // Name of the API token issued to the device
+	Scopes []string `json:"scopes"` // Optional, defaults to the scopes of new API tokens
+}
+
+// deviceCodeResponse follows the device authorization response of RFC 8628.
+type deviceCodeResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type deviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+type deviceTokenResponse struct {
+	APIToken string           `json:"api_token"` // Only returned once
+	Details  *domain.APIToken `json:"details"`
+}
+
+// deviceTokenErrorResponse is the error format of RFC 8628 section 3.5 clients poll for.
+type deviceTokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type userCodeRequest struct {
+	UserCode string `json:"user_code"`
+}
+
+func (h deviceHandler) requestCode(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req deviceCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	deviceCode, authorization, err := h.service.Request(ctx, req.Name, req.Scopes)
+	if err != nil {
+		if errors.Is(err, device.ErrInvalidRequest) {
+			h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+			return
+		}
+		h.log.Error().Err(err).Msg("Failed to start device authorization")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, deviceCodeResponse{
+		DeviceCode:      deviceCode,
+		UserCode:        authorization.UserCode,
+		VerificationURI: h.verificationURI(r),
+		ExpiresIn:       int(device.CodeTTL.Seconds()),
+		Interval:        int(device.PollInterval.Seconds()),
+	}, http.StatusOK)
+}
+
+// verificationURI returns the web UI page where a user code is entered.
+func (h deviceHandler) verificationURI(r *http.Request) string {
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + strings.TrimSuffix(basePath(h.config.Server.BaseURL), "/") + "/device"
+}
+
+func (h deviceHandler) pollToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req deviceTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, deviceTokenErrorResponse{Error: "invalid_request"}, http.StatusBadRequest)
+		return
+	}
+
+	plainToken, token, err := h.service.Poll(ctx, req.DeviceCode)
+	if err != nil {
+		var code string
+		switch {
+		case errors.Is(err, device.ErrAuthorizationPending):
+			code = "authorization_pending"
+		case errors.Is(err, device.ErrSlowDown):
+			code = "slow_down"
+		case errors.Is(err, device.ErrAccessDenied):
+			code = "access_denied"
+		case errors.Is(err, device.ErrExpiredToken):
+			code = "expired_token"
+		default:
+			h.log.Error().Err(err).Msg("Failed to issue device API token")
+			h.encoder.StatusResponse(ctx, w, deviceTokenErrorResponse{Error: "server_error", ErrorDescription: "the API token could not be created"}, http.StatusInternalServerError)
+			return
+		}
+		h.encoder.StatusResponse(ctx, w, deviceTokenErrorResponse{Error: code}, http.StatusBadRequest)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, deviceTokenResponse{APIToken: plainToken, Details: token}, http.StatusOK)
+}
+
+func (h deviceHandler) approve(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, func(ctx context.Context, user *domain.User, userCode string) (*domain.DeviceAuthorization, error) {
+		return h.service.Approve(ctx, userCode, user.HashedUUID, scopesFromContext(ctx))
+	})
+}
+
+func (h deviceHandler) deny(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, func(ctx context.Context, _ *domain.User, userCode string) (*domain.DeviceAuthorization, error) {
+		return h.service.Deny(ctx, userCode)
+	})
+}
+
+// decide runs an approval or denial of the user code in the request body.
+// Pairing hands out a new credential, so only web sessions may decide.
+func (h deviceHandler) decide(w http.ResponseWriter, r *http.Request, decision func(ctx context.Context, user *domain.User, userCode string) (*domain.DeviceAuthorization, error)) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+	if currentSessionID(ctx) == "" {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Devices can only be paired from the web UI", Status: http.StatusForbidden}, http.StatusForbidden)
+		return
+	}
+
+	var req userCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	authorization, err := decision(ctx, user, req.UserCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, device.ErrInvalidUserCode):
+			h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusNotFound}, http.StatusNotFound)
+		case errors.Is(err, device.ErrScopeNotGranted):
+			h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusForbidden}, http.StatusForbidden)
+		default:
+			h.log.Error().Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to decide on device authorization")
+			h.encoder.StatusInternalError(w)
+		}
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, authorization, http.StatusOK)
+}
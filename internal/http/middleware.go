@@ -2,14 +2,19 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/flurbudurbur/Shiori/internal/accesstoken"
 	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/registration"
 	userService "github.com/flurbudurbur/Shiori/internal/user" // Import user service for errors
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog"
@@ -21,6 +26,16 @@ type ContextKey string
 const (
 	// UserContextKey is the key for storing user information in the context.
 	UserContextKey ContextKey = "user"
+	// APITokenContextKey is the key for the named API token a request was authenticated with, if any.
+	APITokenContextKey ContextKey = "api_token"
+	// ScopesContextKey is the key for the domain.ScopeSet granted to the request.
+	ScopesContextKey ContextKey = "scopes"
+	// SessionContextKey is the key for the ID of the web UI session a request was authenticated with, if any.
+	SessionContextKey ContextKey = "session_id"
+	// PeerAddrContextKey is the key for the address of the connecting peer, before RealIP replaced it.
+	PeerAddrContextKey ContextKey = "peer_addr"
+	// ForwardedHTTPSContextKey is set when a trusted proxy received the request over https, see RealIP.
+	ForwardedHTTPSContextKey ContextKey = "forwarded_https"
 
 	// Default rate limit key prefix in Valkey
 	rateLimitKeyPrefix = "rate_limit:"
@@ -30,23 +45,226 @@ const (
 // This is typically used for web UI authentication.
 func (s *Server) IsAuthenticated(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// check session
-		session, _ := s.cookieStore.Get(r, "user_session") // Error ignored as per original, consider logging
+		if forwardAuthenticated(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		// Check if user is authenticated
-		if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
+		ctx, ok := s.authenticateSession(r)
+		if !ok {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
 
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Authenticate accepts a web UI session cookie and falls back to an API token
+// in the Authorization header, see AuthenticateAPIToken.
+func (s *Server) Authenticate(next http.Handler) http.Handler {
+	bearer := s.AuthenticateAPIToken(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if forwardAuthenticated(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ctx, ok := s.authenticateSession(r); ok {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		bearer.ServeHTTP(w, r)
+	})
+}
+
+// PeerAddr stores the address of the connecting peer in the context, it must
+// run before RealIP replaces RemoteAddr with the forwarded client address.
+func PeerAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), PeerAddrContextKey, r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// peerAddr returns the address of the connecting peer, see PeerAddr.
+func peerAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(PeerAddrContextKey).(string); ok {
+		return addr
+	}
+	return r.RemoteAddr
+}
+
+// RealIP replaces RemoteAddr with the client address forwarded by a trusted
+// proxy, see forward_auth.trusted_proxies, and records whether the proxy was
+// reached over https. Forwarded headers from any other peer are ignored, a
+// client cannot choose the address its failed logins are counted for.
+func (s *Server) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") && s.proxyAuthService.Trusted(r.RemoteAddr) {
+			r = r.WithContext(context.WithValue(r.Context(), ForwardedHTTPSContextKey, true))
+		}
+		if ip := s.forwardedFor(r); ip != "" {
+			r.RemoteAddr = ip
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// isHTTPS reports whether the client connected over https, directly or to a trusted proxy.
+func isHTTPS(r *http.Request) bool {
+	forwarded, _ := r.Context().Value(ForwardedHTTPSContextKey).(bool)
+	return r.TLS != nil || forwarded
+}
+
+// forwardedFor returns the client address forwarded by a trusted peer, empty if
+// there is none. X-Forwarded-For is read from the right, the first address not
+// belonging to a trusted proxy is the client, addresses left of it are set by
+// the client itself.
+func (s *Server) forwardedFor(r *http.Request) string {
+	if !s.proxyAuthService.Trusted(r.RemoteAddr) {
+		return ""
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				return ""
+			}
+			if i == 0 || !s.proxyAuthService.Trusted(hop) {
+				return hop
+			}
+		}
+	}
+
+	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xrip) != nil {
+		return xrip
+	}
+	return ""
+}
+
+// ForwardAuth authenticates web UI requests by the username header of a
+// trusted reverse proxy. The user gets a regular session, which is replaced
+// when the proxy asserts another user. Requests with an Authorization header
+// are left to the API token authentication.
+func (s *Server) ForwardAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.proxyAuthService.Enabled() || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		username := strings.TrimSpace(r.Header.Get(s.proxyAuthService.UserHeader()))
+		if username == "" || !s.proxyAuthService.Trusted(peerAddr(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		authenticatedUser, err := s.proxyAuthService.Authenticate(r.Context(), username)
+		if err != nil {
+			if errors.Is(err, registration.ErrRegistrationClosed) || errors.Is(err, registration.ErrUserLimitReached) {
+				s.log.Warn().Err(err).Str("username", username).Msg("Forward auth user not provisioned")
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			s.log.Error().Err(err).Str("username", username).Msg("Forward auth failed")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		session, err := s.forwardAuthSession(w, r, authenticatedUser)
+		if err != nil {
+			s.log.Error().Err(err).Str("username", username).Msg("Failed to start forward auth session")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), UserContextKey, authenticatedUser)
+		ctx = context.WithValue(ctx, SessionContextKey, session.ID)
+		ctx = context.WithValue(ctx, ScopesContextKey, effectiveScopes(authenticatedUser, nil))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// forwardAuthSession returns the session of the request if it belongs to the
+// user, otherwise it starts a new one and sets its cookie.
+func (s *Server) forwardAuthSession(w http.ResponseWriter, r *http.Request, authenticatedUser *domain.User) (*domain.Session, error) {
+	ctx := r.Context()
+
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		session, err := s.sessionService.Authenticate(ctx, cookie.Value, getClientIP(r))
+		if err != nil {
+			return nil, err
+		}
+		if session != nil {
+			if session.UserHashedUUID == authenticatedUser.HashedUUID {
+				return session, nil
+			}
+			// The proxy now asserts another user, the old session must not outlive that
+			if err := s.sessionService.Revoke(ctx, session.UserHashedUUID, session.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	token, session, err := s.sessionService.Create(ctx, authenticatedUser.HashedUUID, r.UserAgent(), getClientIP(r))
+	if err != nil {
+		return nil, err
+	}
+
+	cookie := newSessionCookie(r, s.config.Config.Server.BaseURL)
+	cookie.Value = token
+	http.SetCookie(w, cookie)
+	return session, nil
+}
+
+// forwardAuthenticated reports whether ForwardAuth already authenticated the request.
+func forwardAuthenticated(ctx context.Context) bool {
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	return ok && user != nil
+}
+
+// authenticateSession returns the request context with the user and session
+// placed in it, ok is false when the request has no live session.
+func (s *Server) authenticateSession(r *http.Request) (context.Context, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+
+	session, err := s.sessionService.Authenticate(r.Context(), cookie.Value, getClientIP(r))
+	if err != nil {
+		s.log.Error().Err(err).Msg("Failed to look up session")
+		return nil, false
+	}
+	if session == nil {
+		return nil, false
+	}
+
+	authenticatedUser, err := s.userService.GetUserForAuthentication(r.Context(), session.UserHashedUUID)
+	if err != nil || authenticatedUser == nil {
+		// The user expired or was deleted, the session is of no use anymore
+		if err := s.sessionService.Revoke(r.Context(), session.UserHashedUUID, session.ID); err != nil {
+			s.log.Error().Err(err).Msg("Failed to revoke session of missing user")
+		}
+		return nil, false
+	}
+
+	ctx := context.WithValue(r.Context(), UserContextKey, authenticatedUser)
+	ctx = context.WithValue(ctx, SessionContextKey, session.ID)
+	// Sessions act for the user, with all of their scopes
+	ctx = context.WithValue(ctx, ScopesContextKey, effectiveScopes(authenticatedUser, nil))
+	return ctx, true
+}
+
 // AuthenticateAPIToken creates a middleware for API token authentication.
-// It expects a Bearer token in the Authorization header and validates it against
-// the APITokenHash stored for users using bcrypt comparison.
-// This middleware implements an INEFFICIENT iterative user lookup as a temporary measure.
+// It expects a Bearer token of the form shi_<lookupID>_<secret> in the Authorization
+// header, the user service looks the user up by lookup ID and verifies the secret.
+// Access tokens are accepted as well when they are enabled, see authenticateAccessToken.
 func (s *Server) AuthenticateAPIToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger := s.log.With().Str("middleware", "AuthenticateAPIToken").Logger()
@@ -66,22 +284,28 @@ func (s *Server) AuthenticateAPIToken(next http.Handler) http.Handler {
 		}
 		plainToken := parts[1]
 
+		if s.accessTokenService.Enabled() && isAccessToken(plainToken) {
+			s.authenticateAccessToken(w, r, next, plainToken)
+			return
+		}
+
 		// Authenticate using the user service
-		// Note: The user.Service's AuthenticateUserByToken still contains the inefficient iteration warning.
-		// This change moves the call to the service layer, but the underlying inefficiency remains until
-		// user.Service.AuthenticateUserByToken is refactored.
-		authenticatedUser, err := s.userService.AuthenticateUserByToken(r.Context(), plainToken)
+		authenticatedUser, apiToken, err := s.userService.AuthenticateUserByToken(r.Context(), plainToken, getClientIP(r))
 		if err != nil {
 			// Log the specific error from the service
 			logger.Warn().Err(err).Msg("API token authentication failed")
 
 			// Handle specific authentication errors from user service
-			if strings.Contains(err.Error(), userService.ErrAuthenticationFailed.Error()) { // Check underlying error string
+			if errors.Is(err, userService.ErrAuthenticationFailed) {
 				http.Error(w, "Unauthorized: Invalid API token", http.StatusUnauthorized)
-			} else if strings.Contains(err.Error(), userService.ErrUserExpired.Error()) {
+			} else if errors.Is(err, userService.ErrUserExpired) {
 				http.Error(w, "Unauthorized: User account expired", http.StatusUnauthorized)
-			} else if strings.Contains(err.Error(), userService.ErrUserLockedOut.Error()) {
-				http.Error(w, "Unauthorized: Account locked", http.StatusForbidden) // Or StatusUnauthorized
+			} else if errors.Is(err, userService.ErrUserDisabled) {
+				http.Error(w, "Forbidden: User account disabled", http.StatusForbidden)
+			} else if errors.Is(err, userService.ErrTokenExpired) {
+				http.Error(w, "Unauthorized: API token expired", http.StatusUnauthorized)
+			} else if writeLockout(w, err) {
+				return
 			} else {
 				// For other errors (e.g., database issues during auth), return a generic internal server error
 				http.Error(w, "Internal Server Error during authentication", http.StatusInternalServerError)
@@ -89,48 +313,183 @@ func (s *Server) AuthenticateAPIToken(next http.Handler) http.Handler {
 			return
 		}
 
-		// If authenticatedUser is nil and no error, it implies token was not found by the service logic
-		// (though current service AuthenticateUserByToken returns ErrAuthenticationFailed in this case)
+		// Defensive, the service returns ErrAuthenticationFailed when no user owns the token
 		if authenticatedUser == nil {
 			logger.Debug().Msg("API token authentication returned no user without explicit error.")
 			http.Error(w, "Unauthorized: Invalid API token", http.StatusUnauthorized)
 			return
 		}
 
-		// Note: The user expiry check is now expected to be handled within s.userService.AuthenticateUserByToken
-		// If it's not, it should be added there or re-added here.
-		// For example, if AuthenticateUserByToken returns a user even if expired:
-		// if !authenticatedUser.DeletionDate.IsZero() && authenticatedUser.DeletionDate.Before(time.Now().UTC()) {
-		// 	logger.Info().Str("user_hashed_uuid", authenticatedUser.HashedUUID).Time("deletion_date", authenticatedUser.DeletionDate).Msg("User account authenticated but is expired")
-		// 	http.Error(w, "Unauthorized: User account expired", http.StatusUnauthorized)
-		// 	return
-		// }
-
 		logger.Info().Str("user_hashed_uuid", authenticatedUser.HashedUUID).Msg("User successfully authenticated via API token")
 
 		// Store user information in the request context.
 		// Consider storing only essential info (e.g., HashedUUID, Scopes) if the full User object is large or contains sensitive data not needed by handlers.
 		ctx := context.WithValue(r.Context(), UserContextKey, authenticatedUser)
+		if apiToken != nil {
+			ctx = context.WithValue(ctx, APITokenContextKey, apiToken)
+		}
+		ctx = context.WithValue(ctx, ScopesContextKey, effectiveScopes(authenticatedUser, apiToken))
 
-		// Placeholder for Scope Enforcement:
-		// TODO: Implement robust scope enforcement based on authenticatedUser.Scopes.
-		// This check should occur *after* successful authentication.
-		// Example:
-		// requiredScope := getRequiredScopeForHandler(r) // Hypothetical: determines scope needed for r.URL.Path & r.Method
-		// if !userHasScope(authenticatedUser.Scopes, requiredScope) { // Hypothetical: checks if requiredScope is in authenticatedUser.Scopes (which is JSON string)
-		//     logger.Warn().
-		//         Str("user_hashed_uuid", authenticatedUser.HashedUUID).
-		//         Str("required_scope", requiredScope).
-		//         Str("user_scopes", authenticatedUser.Scopes).
-		//         Msg("User lacks required scope for the requested resource")
-		//     http.Error(w, "Forbidden: Insufficient scope", http.StatusForbidden)
-		//     return
-		// }
-
+		// Scopes are enforced per route group with RequireScope and RequireScopeByMethod
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// isAccessToken reports whether a bearer token is a JWT rather than an API
+// token, which has no dots.
+func isAccessToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// authenticateAccessToken serves a request carrying an access token. The token
+// is checked by its signature, expiry and token family in Valkey, the user is
+// loaded so handlers see the whole account. Disabling, deleting or moving the
+// user revokes the family, see accesstoken.Service.
+func (s *Server) authenticateAccessToken(w http.ResponseWriter, r *http.Request, next http.Handler, accessToken string) {
+	authenticatedUser, claims, err := s.accessTokenService.Authenticate(r.Context(), accessToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, accesstoken.ErrUserExpired):
+			http.Error(w, "Unauthorized: User account expired", http.StatusUnauthorized)
+		case errors.Is(err, accesstoken.ErrAccessTokenExpired):
+			// Clients refresh the access token on invalid_token, see RFC 6750 section 3.1
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized: Access token expired", http.StatusUnauthorized)
+		case errors.Is(err, accesstoken.ErrInvalidAccessToken), errors.Is(err, accesstoken.ErrTokenFamilyRevoked), errors.Is(err, accesstoken.ErrDisabled):
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized: Invalid access token", http.StatusUnauthorized)
+		default:
+			s.log.Error().Err(err).Msg("Failed to authenticate access token")
+			http.Error(w, "Internal Server Error during authentication", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), UserContextKey, authenticatedUser)
+	if claims.APITokenID != 0 {
+		// Handlers refuse some account changes to named API tokens, the access token stands in for its API token
+		ctx = context.WithValue(ctx, APITokenContextKey, &domain.APIToken{
+			ID:             claims.APITokenID,
+			UserHashedUUID: authenticatedUser.HashedUUID,
+			Name:           claims.DeviceName,
+			Scopes:         claims.Scopes().Names(),
+		})
+	}
+	ctx = context.WithValue(ctx, ScopesContextKey, domain.ParseScopes(authenticatedUser.Scopes))
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// writeLockout answers 429 with Retry-After if err is a lockout, it reports whether it did.
+func writeLockout(w http.ResponseWriter, err error) bool {
+	var lockout *userService.LockoutError
+	if !errors.As(err, &lockout) {
+		return false
+	}
+
+	seconds := int64(math.Ceil(lockout.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+	http.Error(w, "Too Many Requests: too many failed attempts", http.StatusTooManyRequests)
+	return true
+}
+
+// scopeErrorResponse is returned when a request lacks a scope.
+type scopeErrorResponse struct {
+	Message      string       `json:"message"`
+	Status       int          `json:"status"`
+	MissingScope domain.Scope `json:"missing_scope"`
+}
+
+// scopesFromContext returns the scopes of the authenticated request, an empty set if there are none.
+func scopesFromContext(ctx context.Context) domain.ScopeSet {
+	if scopes, ok := ctx.Value(ScopesContextKey).(domain.ScopeSet); ok {
+		return scopes
+	}
+	return domain.ScopeSet{}
+}
+
+// effectiveScopes returns the scopes of a request. A named API token can only
+// narrow down the scopes of its user, never extend them.
+func effectiveScopes(user *domain.User, apiToken *domain.APIToken) domain.ScopeSet {
+	scopes := domain.ParseScopes(user.Scopes)
+	if apiToken != nil {
+		scopes = scopes.Intersect(domain.ScopeSetFromNames(apiToken.Scopes))
+	}
+	return scopes
+}
+
+// RequireScope creates a middleware that rejects requests lacking any of the
+// given scopes with 403, naming the first missing scope.
+// It must run after AuthenticateAPIToken.
+func (s *Server) RequireScope(scopes ...domain.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			granted := scopesFromContext(r.Context())
+			for _, scope := range scopes {
+				if !granted.Has(scope) {
+					s.denyScope(w, r, scope)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScopeByMethod creates a middleware requiring the read scope for safe
+// methods (GET, HEAD, OPTIONS) and the write scope for every other method.
+func (s *Server) RequireScopeByMethod(read domain.Scope, write domain.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			required := write
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				required = read
+			}
+
+			if !scopesFromContext(r.Context()).Has(required) {
+				s.denyScope(w, r, required)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ReadOnlyWhenExpired refuses changes from users past their deletion date. The
+// account stays readable during the grace period, so its data can be downloaded.
+func (s *Server) ReadOnlyWhenExpired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if user, ok := r.Context().Value(UserContextKey).(*domain.User); ok && user != nil && user.Expired(time.Now()) {
+			http.Error(w, "Forbidden: the account expired and is read-only until it is deleted", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) denyScope(w http.ResponseWriter, r *http.Request, scope domain.Scope) {
+	logger := s.log.Warn().Str("scope", string(scope)).Str("path", r.URL.Path)
+	if user, ok := r.Context().Value(UserContextKey).(*domain.User); ok && user != nil {
+		logger = logger.Str("user_hashed_uuid", user.HashedUUID)
+	}
+	logger.Msg("Denied request lacking scope")
+
+	encoder{}.StatusResponse(r.Context(), w, scopeErrorResponse{
+		Message:      fmt.Sprintf("Forbidden: missing scope %s", scope),
+		Status:       http.StatusForbidden,
+		MissingScope: scope,
+	}, http.StatusForbidden)
+}
+
 // LoggerMiddleware provides structured logging for HTTP requests.
 func LoggerMiddleware(logger *zerolog.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -270,29 +629,12 @@ func (s *Server) getClientIdentifier(r *http.Request) (string, string) {
 	return ip, "ip_address"
 }
 
-// getClientIP extracts the client IP address from the request.
-// It handles various headers that might contain the real client IP when behind proxies.
+// getClientIP returns the client IP address of a request. Behind a trusted
+// proxy RealIP has replaced RemoteAddr with the forwarded address.
 func getClientIP(r *http.Request) string {
-	// Check for X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			clientIP := strings.TrimSpace(ips[0])
-			if clientIP != "" {
-				return clientIP
-			}
-		}
-	}
-
-	// Check for X-Real-IP header
-	if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
-		return strings.TrimSpace(xrip)
-	}
-
-	// Get IP from RemoteAddr
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		// If error, just return RemoteAddr as is
+		// RealIP stores the address without a port
 		return r.RemoteAddr
 	}
 
@@ -304,15 +646,12 @@ func getClientIP(r *http.Request) string {
 func (s *Server) isExemptFromRateLimit(r *http.Request, identifier string, identifierType string) bool {
 	// Check for exempt roles if identifier is a user ID
 	if identifierType == "user_id" {
-		if user, ok := r.Context().Value(UserContextKey).(*domain.User); ok && user != nil {
-			// Check if user has an exempt role
-			// This is a simplified check - in a real system, you'd check against actual roles
-			exemptRoles := strings.Split(s.config.Config.RateLimit.ExemptRoles, ",")
-			for _, role := range exemptRoles {
-				role = strings.TrimSpace(role)
-				if role != "" && strings.Contains(user.Scopes, role) {
-					return true
-				}
+		// Exempt roles are matched against the scopes granted to the request
+		scopes := scopesFromContext(r.Context())
+		for _, role := range strings.Split(s.config.Config.RateLimit.ExemptRoles, ",") {
+			role = strings.TrimSpace(role)
+			if role != "" && scopes.Has(domain.Scope(role)) {
+				return true
 			}
 		}
 	}
@@ -0,0 +1,233 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/flurbudurbur/Shiori/internal/accesstoken"
+	"github.com/flurbudurbur/Shiori/internal/audit"
+	"github.com/flurbudurbur/Shiori/internal/config"
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/internal/proxyauth"
+	"github.com/flurbudurbur/Shiori/internal/user"
+	"github.com/flurbudurbur/Shiori/pkg/argon2id"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+func TestServer_RealIP(t *testing.T) {
+	cfg := config.New(t.TempDir(), "test").Config
+	cfg.Logging.Path = ""
+	s := &Server{proxyAuthService: proxyauth.NewService(logger.New(cfg), domain.ForwardAuthConfig{TrustedProxies: "10.0.0.0/8"}, nil, nil, nil)}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+		wantHTTPS  bool
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "untrusted_peer_forwarding", remoteAddr: "203.0.113.7:5000", headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, want: "203.0.113.7"},
+		{name: "trusted_proxy", remoteAddr: "10.0.0.2:5000", headers: map[string]string{"X-Forwarded-For": "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted_proxy_chain", remoteAddr: "10.0.0.2:5000", headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.3"}, want: "198.51.100.1"},
+		{name: "spoofed_by_client", remoteAddr: "10.0.0.2:5000", headers: map[string]string{"X-Forwarded-For": "192.0.2.1, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "only_proxies", remoteAddr: "10.0.0.2:5000", headers: map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"}, want: "10.0.0.4"},
+		{name: "malformed", remoteAddr: "10.0.0.2:5000", headers: map[string]string{"X-Forwarded-For": "unknown"}, want: "10.0.0.2"},
+		{name: "real_ip", remoteAddr: "10.0.0.2:5000", headers: map[string]string{"X-Real-IP": "198.51.100.2"}, want: "198.51.100.2"},
+		{name: "untrusted_peer_https", remoteAddr: "203.0.113.7:5000", headers: map[string]string{"X-Forwarded-Proto": "https"}, want: "203.0.113.7"},
+		{name: "trusted_proxy_https", remoteAddr: "10.0.0.2:5000", headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"}, want: "198.51.100.1", wantHTTPS: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			var got string
+			var https bool
+			s.RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = getClientIP(r)
+				https = isHTTPS(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantHTTPS, https)
+		})
+	}
+}
+
+func TestWriteLockout(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		want       bool
+		retryAfter string
+	}{
+		{name: "lockout", err: &user.LockoutError{RetryAfter: time.Minute}, want: true, retryAfter: "60"},
+		{name: "rounded_up", err: &user.LockoutError{RetryAfter: 1500 * time.Millisecond}, want: true, retryAfter: "2"},
+		{name: "at_least_a_second", err: &user.LockoutError{}, want: true, retryAfter: "1"},
+		{name: "wrapped", err: fmt.Errorf("login: %w", &user.LockoutError{RetryAfter: time.Second}), want: true, retryAfter: "1"},
+		{name: "other_error", err: user.ErrAuthenticationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			assert.Equal(t, tt.want, writeLockout(w, tt.err))
+			assert.Equal(t, tt.retryAfter, w.Header().Get("Retry-After"))
+			if tt.want {
+				assert.Equal(t, http.StatusTooManyRequests, w.Code)
+			}
+		})
+	}
+}
+
+// countingUserService authenticates any API token as one user and counts the lookups made.
+type countingUserService struct {
+	user    *domain.User
+	lookups int
+}
+
+func (s *countingUserService) AuthenticateUserByToken(context.Context, string, string) (*domain.User, *domain.APIToken, error) {
+	s.lookups++
+	return s.user, nil, nil
+}
+
+func (s *countingUserService) GetUserForAuthentication(context.Context, string) (*domain.User, error) {
+	s.lookups++
+	return s.user, nil
+}
+
+func TestServer_AuthenticateAccessToken(t *testing.T) {
+	ctx := context.Background()
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	cfg := config.New(t.TempDir(), "test").Config
+	cfg.Logging.Path = ""
+	cfg.Tokens.Enabled = true
+	users := &countingUserService{user: &domain.User{
+		HashedUUID:   "bookmark",
+		PublicID:     "public",
+		Scopes:       domain.NewScopeSet(domain.ScopeSyncRead).String(),
+		DeletionDate: time.Now().AddDate(0, 0, 30),
+	}}
+	tokens := accesstoken.NewService(logger.New(cfg), cfg, users, nil, nil, client)
+	// The server has no user service, a lookup would panic
+	s := &Server{log: zerolog.Nop(), accessTokenService: tokens}
+
+	pair, err := tokens.Exchange(ctx, "shi_lookup_secret", "192.0.2.1")
+	require.NoError(t, err)
+	users.lookups = 0
+
+	serve := func() (*httptest.ResponseRecorder, context.Context) {
+		r := httptest.NewRequest(http.MethodGet, "/api/sync", nil)
+		r.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		w := httptest.NewRecorder()
+		var reqCtx context.Context
+		s.AuthenticateAPIToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqCtx = r.Context()
+		})).ServeHTTP(w, r)
+		return w, reqCtx
+	}
+
+	w, reqCtx := serve()
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, reqCtx)
+	authenticated := reqCtx.Value(UserContextKey).(*domain.User)
+	assert.Equal(t, "bookmark", authenticated.HashedUUID)
+	assert.Equal(t, "public", authenticated.PublicID)
+	assert.Equal(t, domain.NewScopeSet(domain.ScopeSyncRead), scopesFromContext(reqCtx))
+	assert.Equal(t, 1, users.lookups, "the user of an access token is loaded once per request")
+
+	// Revoking the user ends the family, the client is told to refresh
+	require.NoError(t, tokens.RevokeUser(ctx, "bookmark"))
+	w, reqCtx = serve()
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Nil(t, reqCtx)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "invalid_token")
+	assert.Equal(t, 1, users.lookups, "a revoked family is refused before the user is loaded")
+}
+
+// TestServer_AccessTokenAccountChanges checks that handlers behind an access
+// token of the user token see the stored password and two-factor state.
+func TestServer_AccessTokenAccountChanges(t *testing.T) {
+	ctx := context.Background()
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	db, log := databasetest.New(t)
+	cfg := config.New(t.TempDir(), "test").Config
+	cfg.Tokens.Enabled = true
+	cfg.Password = domain.PasswordConfig{MinLength: 4, Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	repo := database.NewUserRepo(log, db)
+	apiTokens := database.NewAPITokenRepo(log, db)
+	auditService := audit.NewService(log, database.NewAuditEventRepo(log, db))
+	users := user.NewService(repo, apiTokens, user.NewValkeyRateLimiterStore(client, cfg.Lockout), log, nil, nil, database.NewRecoveryCodeRepo(log, db), auditService, cfg)
+
+	bookmark, plainToken, err := users.RegisterNewUser(ctx)
+	require.NoError(t, err)
+	registered, err := repo.FindByHashedUUID(ctx, bookmark)
+	require.NoError(t, err)
+	require.NoError(t, users.SetPassword(ctx, registered, "", "1234"))
+	require.NoError(t, repo.UpdateTOTP(ctx, bookmark, "secret", true))
+
+	tokens := accesstoken.NewService(log, cfg, users, apiTokens, auditService, client)
+	pair, err := tokens.Exchange(ctx, plainToken, "192.0.2.1")
+	require.NoError(t, err)
+
+	s := &Server{log: zerolog.Nop(), accessTokenService: tokens}
+	userResource := NewUserResource(users, auditService, zerolog.Nop(), encoder{})
+	r := chi.NewRouter()
+	r.Use(s.AuthenticateAPIToken)
+	r.Put("/profile/password", userResource.handleSetPassword)
+	r.Route("/two-factor", newTwoFactorHandler(encoder{}, zerolog.Nop(), users).Routes)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{name: "password_without_current", method: http.MethodPut, path: "/profile/password", body: `{"password":"5678"}`, wantStatus: http.StatusForbidden},
+		// A new enrolment would replace the secret and turn two-factor authentication off
+		{name: "totp_enrolment", method: http.MethodPost, path: "/two-factor/totp", wantStatus: http.StatusConflict},
+		{name: "two_factor_disable_without_code", method: http.MethodDelete, path: "/two-factor/", body: `{}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+		})
+	}
+
+	stored, err := repo.FindByHashedUUID(ctx, bookmark)
+	require.NoError(t, err)
+	match, _, err := argon2id.CheckHash("1234", stored.PasswordHash)
+	require.NoError(t, err)
+	assert.True(t, match, "the password is unchanged")
+	assert.True(t, stored.TOTPEnabled)
+	assert.Equal(t, "secret", stored.TOTPSecret)
+}
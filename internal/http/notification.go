@@ -41,7 +41,7 @@ func (h notificationHandler) Routes(r chi.Router) {
 
 func (h notificationHandler) list(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	user, ok := ctx.Value("user").(*domain.User)
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
 	if !ok || user == nil {
 		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
 		return
@@ -67,7 +67,7 @@ func (h notificationHandler) store(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, ok := ctx.Value("user").(*domain.User)
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
 	if !ok || user == nil {
 		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
 		return
@@ -94,7 +94,7 @@ func (h notificationHandler) update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, ok := ctx.Value("user").(*domain.User)
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
 	if !ok || user == nil {
 		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
 		return
@@ -146,7 +146,7 @@ func (h notificationHandler) test(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If testing a notification requires user context (e.g., to use user-specific settings)
-	user, ok := ctx.Value("user").(*domain.User)
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
 	if !ok || user == nil {
 		// If user context is strictly required for testing, return error
 		// http.Error(w, "Unauthorized: User not found in context for test", http.StatusUnauthorized)
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/flurbudurbur/Shiori/internal/oidc"
+	"github.com/flurbudurbur/Shiori/internal/registration"
+)
+
+// oidcStateCookieName binds an OIDC login to the browser that started it.
+const oidcStateCookieName = "shiori_oidc_state"
+
+type oidcStatusResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// oidcStatus tells the web UI whether to offer the OIDC login.
+func (h authHandler) oidcStatus(w http.ResponseWriter, r *http.Request) {
+	h.encoder.StatusResponse(r.Context(), w, oidcStatusResponse{Enabled: h.oidc.Enabled()}, http.StatusOK)
+}
+
+// oidcLogin redirects the browser to the provider. With ?link=true the
+// identity is linked to the user of the current session instead.
+func (h authHandler) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !h.oidc.Enabled() {
+		h.encoder.StatusNotFound(ctx, w)
+		return
+	}
+
+	var linkUserHashedUUID string
+	if r.URL.Query().Get("link") == "true" {
+		cookie, err := r.Cookie(sessionCookieName)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		session, err := h.sessions.Authenticate(ctx, cookie.Value, getClientIP(r))
+		if err != nil || session == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		linkUserHashedUUID = session.UserHashedUUID
+	}
+
+	state, redirectURL, err := h.oidc.AuthCodeURL(ctx, linkUserHashedUUID)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Auth: Failed to start OIDC login")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	cookie := h.oidcStateCookie(r)
+	cookie.Value = state
+	cookie.MaxAge = 600
+	http.SetCookie(w, cookie)
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// oidcCallback completes the login at the provider, starts a session and
+// redirects to the web UI. Failures redirect with an oidc_error query parameter.
+func (h authHandler) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !h.oidc.Enabled() {
+		h.encoder.StatusNotFound(ctx, w)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+
+	cookie, cookieErr := r.Cookie(oidcStateCookieName)
+	clear := h.oidcStateCookie(r)
+	clear.MaxAge = -1
+	http.SetCookie(w, clear)
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		h.log.Warn().Str("error", providerErr).Str("description", query.Get("error_description")).Msg("Auth: OIDC provider returned an error")
+		h.redirectOIDCError(w, r, "denied")
+		return
+	}
+
+	// The state must come back to the browser that started the login
+	if cookieErr != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		h.redirectOIDCError(w, r, "invalid_state")
+		return
+	}
+
+	authenticatedUser, err := h.oidc.Callback(ctx, state, query.Get("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidState):
+			h.redirectOIDCError(w, r, "invalid_state")
+		case errors.Is(err, oidc.ErrIdentityNotLinked):
+			h.redirectOIDCError(w, r, "not_linked")
+		case errors.Is(err, oidc.ErrIdentityLinked):
+			h.redirectOIDCError(w, r, "already_linked")
+		case errors.Is(err, registration.ErrRegistrationClosed),
+			errors.Is(err, registration.ErrUserLimitReached):
+			h.redirectOIDCError(w, r, "registration_closed")
+		default:
+			h.log.Error().Err(err).Msg("Auth: OIDC login failed")
+			h.redirectOIDCError(w, r, "failed")
+		}
+		return
+	}
+
+	if err := h.startSession(w, r, authenticatedUser, "oidc"); err != nil {
+		h.log.Error().Err(err).Msg("Auth: Failed to start session after OIDC login")
+		h.redirectOIDCError(w, r, "failed")
+		return
+	}
+
+	http.Redirect(w, r, h.basePath(), http.StatusFound)
+}
+
+func (h authHandler) redirectOIDCError(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, h.basePath()+"?"+url.Values{"oidc_error": {code}}.Encode(), http.StatusFound)
+}
+
+// oidcStateCookie returns the state cookie without value. It is Lax even on
+// https, the callback is a cross-site navigation from the provider.
+func (h authHandler) oidcStateCookie(r *http.Request) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookieName,
+		Path:     h.basePath(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   isHTTPS(r),
+	}
+}
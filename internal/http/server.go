@@ -1,17 +1,18 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 
-	"github.com/flurbudurbur/Shiori/web"
 	"github.com/flurbudurbur/Shiori/internal/config"
 	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/domain"
 	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/web"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/gorilla/sessions"
 	"github.com/r3labs/sse/v2"
 	"github.com/rs/cors"
 	"github.com/rs/zerolog"
@@ -26,13 +27,20 @@ type valkeyService interface {
 	Close()
 }
 
+// proxyAuthService resolves users asserted by a trusted reverse proxy, see proxyauth.Service.
+type proxyAuthService interface {
+	Enabled() bool
+	UserHeader() string
+	Trusted(peerAddr string) bool
+	Authenticate(ctx context.Context, username string) (*domain.User, error)
+}
+
 type Server struct {
 	log zerolog.Logger
 	sse *sse.Server
 	db  *database.DB
 
-	config      *config.AppConfig
-	cookieStore *sessions.CookieStore
+	config *config.AppConfig
 
 	version string
 	commit  string
@@ -43,6 +51,19 @@ type Server struct {
 	updateService       updateService
 	userService         userservice.Service // Use aliased user.Service
 	syncService         syncService
+	readingService      readingService
+	shareService        shareService
+	sessionService      sessionService
+	oidcService         oidcService
+	proxyAuthService    proxyAuthService
+	passkeyService      passkeyService
+	deviceService       deviceService
+	exportService       exportService
+	userAdminService    userAdminService
+	registrationService registrationService
+	auditService        auditService
+	accessTokenService  accessTokenService
+	mergeService        mergeService
 	valkeyService       valkeyService // Valkey service for rate limiting
 }
 
@@ -59,6 +80,19 @@ func NewServer(
 	updateSvc updateService,
 	userSvc userservice.Service, // Use aliased user.Service
 	syncService syncService,
+	readingService readingService,
+	shareService shareService,
+	sessionService sessionService,
+	oidcService oidcService,
+	proxyAuthService proxyAuthService,
+	passkeyService passkeyService,
+	deviceService deviceService,
+	exportService exportService,
+	userAdminService userAdminService,
+	registrationService registrationService,
+	auditService auditService,
+	accessTokenService accessTokenService,
+	mergeService mergeService,
 	valkeyService valkeyService, // Valkey service for rate limiting
 ) Server {
 	// The logger passed in is logger.Logger, but s.log is zerolog.Logger.
@@ -74,13 +108,24 @@ func NewServer(
 		commit:  commit,
 		date:    date,
 
-		cookieStore: sessions.NewCookieStore([]byte(config.Config.SessionSecret)),
-
 		authService:         authService,
 		notificationService: notificationSvc,
 		updateService:       updateSvc,
 		userService:         userSvc,
 		syncService:         syncService,
+		readingService:      readingService,
+		shareService:        shareService,
+		sessionService:      sessionService,
+		oidcService:         oidcService,
+		proxyAuthService:    proxyAuthService,
+		passkeyService:      passkeyService,
+		deviceService:       deviceService,
+		exportService:       exportService,
+		userAdminService:    userAdminService,
+		registrationService: registrationService,
+		auditService:        auditService,
+		accessTokenService:  accessTokenService,
+		mergeService:        mergeService,
 		valkeyService:       valkeyService,
 	}
 }
@@ -105,14 +150,17 @@ func (s Server) Handler() http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(PeerAddr) // Before RealIP, forward auth trusts the connecting proxy only
+	r.Use(s.RealIP) // Forwarded client addresses are only accepted from trusted proxies
 	r.Use(middleware.Recoverer)
 	r.Use(LoggerMiddleware(&s.log)) // Pass the zerolog.Logger
 
 	c := cors.New(cors.Options{
 		AllowCredentials:   true,
 		AllowedMethods:     []string{"HEAD", "OPTIONS", "GET", "POST", "PUT", "PATCH", "DELETE"},
-		AllowOriginFunc:    func(origin string) bool { return true },
+		AllowedHeaders:     []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", "X-Device-Name", "X-Share-Passphrase", "X-Requested-With", csrfHeaderName},
+		ExposedHeaders:     []string{"ETag", "Retry-After"},
+		AllowOriginFunc:    originAllowed(s.config.Config.Server.AllowedOrigins, s.config.Config.Server.BaseURL),
 		OptionsPassthrough: true,
 		// Enable Debugging for testing, consider disabling in production
 		Debug: false,
@@ -123,21 +171,72 @@ func (s Server) Handler() http.Handler {
 	encoder := encoder{}
 
 	r.Route("/api", func(r chi.Router) {
-		r.Route("/auth", newAuthHandler(encoder, s.log, s.config.Config, s.cookieStore, s.authService).Routes)
+		r.Use(s.ForwardAuth)
+		r.Use(s.CSRF) // After ForwardAuth, its sessions need the token as well
+
+		r.Route("/auth", func(r chi.Router) {
+			auth := newAuthHandler(encoder, s.log, s.config.Config, s.sessionService, s.oidcService, s.registrationService, s.auditService, s.authService)
+			auth.Routes(r)
+			r.Group(func(r chi.Router) {
+				r.Use(s.RateLimiter) // Every challenge is kept in Valkey until it expires
+				auth.RegistrationRoutes(r)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(s.RateLimiter) // Every refresh token is kept in Valkey until it expires
+				newTokenHandler(encoder, s.log, s.accessTokenService).Routes(r)
+			})
+
+			passkeys := newWebAuthnHandler(encoder, s.log, s.config.Config, s.sessionService, s.auditService, s.passkeyService)
+			r.Route("/webauthn", func(r chi.Router) {
+				r.Group(func(r chi.Router) {
+					r.Use(s.RateLimiter) // Every login ceremony is kept in Valkey until it expires
+					passkeys.PublicRoutes(r)
+				})
+				r.Group(func(r chi.Router) {
+					r.Use(s.Authenticate)
+					r.Use(s.ReadOnlyWhenExpired)
+					passkeys.Routes(r)
+				})
+			})
+
+			devices := newDeviceHandler(encoder, s.log, s.config.Config, s.deviceService)
+			r.Route("/device", func(r chi.Router) {
+				r.Group(func(r chi.Router) {
+					r.Use(s.RateLimiter) // Every pairing is kept in Valkey until it expires
+					devices.CodeRoutes(r)
+				})
+				// Polling is throttled per device code instead, see device.PollInterval
+				devices.TokenRoutes(r)
+				r.Group(func(r chi.Router) {
+					r.Use(s.Authenticate)
+					r.Use(s.ReadOnlyWhenExpired)
+					r.Use(s.RateLimiter)
+					devices.Routes(r)
+				})
+			})
+		})
 		r.Route("/healthz", newHealthHandler(encoder, s.db).Routes)
 
+		shares := newShareHandler(encoder, s.log, s.shareService)
+
+		// Shared libraries are public, the share token is the credential
+		sharedRouter := r.Group(nil)
+		sharedRouter.Use(s.RateLimiter)
+		sharedRouter.Route("/shared", shares.PublicRoutes)
+
 		// Route for UUID generation - apply rate limiting
 		uuidRouter := r.Group(nil)
 		uuidRouter.Use(s.RateLimiter) // Apply rate limiting middleware
 		uuidRouter.Post("/v1/utils/uuid", s.handleGetUUID)
 
 		// Authenticated routes group
-		authedRouter := r.Group(nil)             // Create a new group for authenticated routes
-		authedRouter.Use(s.AuthenticateAPIToken) // Apply API token authentication middleware
+		authedRouter := r.Group(nil)     // Create a new group for authenticated routes
+		authedRouter.Use(s.Authenticate) // Apply session or API token authentication middleware
+		authedRouter.Use(s.ReadOnlyWhenExpired)
 
 		// User-specific routes (profile, token management)
 		// Pass s.log (which is zerolog.Logger) to NewUserResource
-		userResource := NewUserResource(s.userService, s.log, encoder)
+		userResource := NewUserResource(s.userService, s.auditService, s.log, encoder)
 
 		// Create a rate-limited router for profile-related endpoints
 		profileRouter := authedRouter.Group(nil)
@@ -146,15 +245,57 @@ func (s Server) Handler() http.Handler {
 		// Apply rate limiting to profile-related endpoints
 		profileRouter.Post("/profile/api-token", userResource.handleResetAndGetUserToken)
 		profileRouter.Get("/profile", userResource.handleGetProfile) // New route for getting profile data
+		profileRouter.Get("/profile/tokens", userResource.handleListAPITokens)
+		profileRouter.Post("/profile/tokens", userResource.handleCreateAPIToken)
+		profileRouter.Delete("/profile/tokens/{id}", userResource.handleRevokeAPIToken)
+		profileRouter.Get("/profile/password", userResource.handleGetPasswordStatus)
+		profileRouter.Put("/profile/password", userResource.handleSetPassword)
+		profileRouter.Delete("/profile/password", userResource.handleRemovePassword)
+		profileRouter.Route("/profile/2fa", newTwoFactorHandler(encoder, s.log, s.userService).Routes)
+		profileRouter.Route("/profile/sessions", newSessionHandler(encoder, s.log, s.auditService, s.sessionService).Routes)
+		profileRouter.Route("/profile/audit", newAuditHandler(encoder, s.log, s.auditService).Routes)
+		profileRouter.Route("/profile/rotate-bookmark", newBookmarkHandler(encoder, s.log, s.config.Config, s.sessionService, s.accessTokenService, s.userService).Routes)
+
+		accounts := newAccountHandler(encoder, s.log, s.config.Config, s.sessionService, s.accessTokenService, s.exportService, s.userService, s.mergeService)
+		profileRouter.Get("/profile/export", accounts.export)
+		profileRouter.Post("/profile/merge", accounts.merge)
+
+		// Expired accounts are read-only but can still be deleted during the grace period
+		deleteRouter := r.Group(nil)
+		deleteRouter.Use(s.Authenticate)
+		deleteRouter.Use(s.RateLimiter)
+		deleteRouter.Delete("/profile", accounts.delete)
+
+		// Server configuration and logs are for administrators only
+		serverRouter := authedRouter.Group(nil)
+		serverRouter.Use(s.RequireScope(domain.ScopeAdmin))
+		serverRouter.Route("/config", newConfigHandler(encoder, s, s.config).Routes)
+		serverRouter.Route("/logs", newLogsHandler(s.config).Routes)
+
+		notificationRouter := authedRouter.Group(nil)
+		notificationRouter.Use(s.RequireScope(domain.ScopeNotificationsWrite))
+		notificationRouter.Route("/notification", newNotificationHandler(encoder, s.notificationService).Routes)
 
-		authedRouter.Route("/config", newConfigHandler(encoder, s, s.config).Routes)
-		authedRouter.Route("/logs", newLogsHandler(s.config).Routes)
-		authedRouter.Route("/notification", newNotificationHandler(encoder, s.notificationService).Routes)
 		authedRouter.Route("/updates", newUpdateHandler(encoder, s.updateService).Routes)
 		// Apply rate limiting to sync endpoints as they can trigger UUID generation
 		syncRouter := authedRouter.Group(nil)
+		syncRouter.Use(s.RequireScopeByMethod(domain.ScopeSyncRead, domain.ScopeSyncWrite))
 		syncRouter.Use(s.RateLimiter) // Apply rate limiting middleware
-		syncRouter.Route("/sync", newSyncHandler(encoder, s.syncService, s.userService).Routes)
+		syncRouter.Route("/sync", newSyncHandler(encoder, s.log, s.config, s.syncService, s.userService).Routes)
+
+		shareRouter := authedRouter.Group(nil)
+		shareRouter.Use(s.RequireScopeByMethod(domain.ScopeSyncRead, domain.ScopeSyncWrite))
+		shareRouter.Use(s.RateLimiter)
+		shareRouter.Route("/shares", shares.Routes)
+
+		statsRouter := authedRouter.Group(nil)
+		statsRouter.Use(s.RequireScope(domain.ScopeSyncRead))
+		statsRouter.Use(s.RateLimiter)
+		statsRouter.Route("/stats", newStatsHandler(encoder, s.readingService).Routes)
+
+		adminRouter := authedRouter.Group(nil)
+		adminRouter.Use(s.RequireScope(domain.ScopeAdmin))
+		adminRouter.Route("/admin", newAdminHandler(encoder, s.log, s.syncService, s.userAdminService).Routes)
 
 		authedRouter.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
 			// inject CORS headers to bypass checks
@@ -0,0 +1,161 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// sessionCookieName is the cookie holding the token of a web UI session.
+const sessionCookieName = "shiori_session"
+
+type sessionService interface {
+	Create(ctx context.Context, userHashedUUID string, userAgent string, ip string) (string, *domain.Session, error)
+	Authenticate(ctx context.Context, token string, ip string) (*domain.Session, error)
+	List(ctx context.Context, userHashedUUID string) ([]domain.Session, error)
+	Revoke(ctx context.Context, userHashedUUID string, id string) error
+	RevokeAll(ctx context.Context, userHashedUUID string, exceptID string) error
+	Logout(ctx context.Context, token string) error
+}
+
+type sessionHandler struct {
+	log     zerolog.Logger
+	encoder encoder
+	service sessionService
+	audit   auditService
+}
+
+func newSessionHandler(encoder encoder, log zerolog.Logger, audit auditService, service sessionService) *sessionHandler {
+	return &sessionHandler{
+		log:     log.With().Str("handler", "session").Logger(),
+		encoder: encoder,
+		service: service,
+		audit:   audit,
+	}
+}
+
+// Routes manages the web UI sessions of the authenticated user.
+func (h sessionHandler) Routes(r chi.Router) {
+	r.Get("/", h.list)
+	r.Delete("/", h.revokeOthers)
+	r.Delete("/{id}", h.revoke)
+}
+
+// startSession creates a session for the user and sets its cookie, the session
+// the browser had before is ended.
+func startSession(w http.ResponseWriter, r *http.Request, log zerolog.Logger, sessions sessionService, baseURL string, authenticatedUser *domain.User) error {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := sessions.Logout(r.Context(), cookie.Value); err != nil {
+			log.Error().Err(err).Msg("Auth: Failed to end previous session")
+		}
+	}
+
+	token, _, err := sessions.Create(r.Context(), authenticatedUser.HashedUUID, r.UserAgent(), getClientIP(r))
+	if err != nil {
+		return err
+	}
+
+	cookie := newSessionCookie(r, baseURL)
+	cookie.Value = token
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// newSessionCookie returns the session cookie without value. The cookie lives as
+// long as the browser, the session itself expires server-side.
+func newSessionCookie(r *http.Request, baseURL string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     basePath(baseURL),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if isHTTPS(r) {
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteStrictMode
+	}
+	return cookie
+}
+
+// basePath returns the path the web UI is served under.
+func basePath(baseURL string) string {
+	if baseURL == "" {
+		return "/"
+	}
+	return baseURL
+}
+
+// currentSessionID returns the ID of the session the request was authenticated with, empty for API tokens.
+func currentSessionID(ctx context.Context) string {
+	id, _ := ctx.Value(SessionContextKey).(string)
+	return id
+}
+
+func (h sessionHandler) list(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.service.List(ctx, user.HashedUUID)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to list sessions")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	current := currentSessionID(ctx)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	h.encoder.StatusResponse(ctx, w, sessions, http.StatusOK)
+}
+
+func (h sessionHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if err := h.service.Revoke(ctx, user.HashedUUID, id); err != nil {
+		if errors.Is(err, domain.ErrSessionNotFound) {
+			h.encoder.StatusNotFound(ctx, w)
+			return
+		}
+		h.log.Error().Err(err).Msg("Failed to revoke session")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	h.audit.Record(ctx, newAuditEvent(r, domain.AuditActionRevokeSession, user, map[string]string{"session_id": id}))
+
+	h.encoder.NoContent(w)
+}
+
+// revokeOthers logs out every session of the user except the one making the request.
+func (h sessionHandler) revokeOthers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.RevokeAll(ctx, user.HashedUUID, currentSessionID(ctx)); err != nil {
+		h.log.Error().Err(err).Msg("Failed to revoke sessions")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	h.audit.Record(ctx, newAuditEvent(r, domain.AuditActionRevokeSessions, user, nil))
+
+	h.encoder.NoContent(w)
+}
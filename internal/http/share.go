@@ -0,0 +1,201 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/flurbudurbur/Shiori/internal/backup"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/share"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+type shareService interface {
+	Create(ctx context.Context, userHashedUUID string, req domain.CreateShareRequest) (string, *domain.Share, error)
+	List(ctx context.Context, userHashedUUID string) ([]domain.Share, error)
+	Revoke(ctx context.Context, userHashedUUID string, id int64) error
+	Open(ctx context.Context, token string, passphrase string) (*domain.Share, []byte, error)
+	Library(share *domain.Share, document []byte) (*domain.SharedLibrary, error)
+}
+
+type shareHandler struct {
+	log     zerolog.Logger
+	encoder encoder
+	service shareService
+}
+
+func newShareHandler(encoder encoder, log zerolog.Logger, service shareService) *shareHandler {
+	return &shareHandler{
+		log:     log.With().Str("handler", "share").Logger(),
+		encoder: encoder,
+		service: service,
+	}
+}
+
+// Routes manages the shares of the authenticated user.
+func (h shareHandler) Routes(r chi.Router) {
+	r.Get("/", h.list)
+	r.Post("/", h.create)
+	r.Delete("/{id}", h.revoke)
+}
+
+// PublicRoutes serves shared libraries, the token is the only credential.
+func (h shareHandler) PublicRoutes(r chi.Router) {
+	r.Get("/{token}", h.open)
+}
+
+// shareResponse is returned once on creation, the token cannot be retrieved later.
+type shareResponse struct {
+	Token string        `json:"token"`
+	URL   string        `json:"url"`
+	Share *domain.Share `json:"share"`
+}
+
+func (h shareHandler) list(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	shares, err := h.service.List(ctx, user.HashedUUID)
+	if err != nil {
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	if shares == nil {
+		shares = []domain.Share{}
+	}
+
+	h.encoder.StatusResponse(ctx, w, shares, http.StatusOK)
+}
+
+func (h shareHandler) create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req domain.CreateShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	token, created, err := h.service.Create(ctx, user.HashedUUID, req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, share.ErrInvalidShare):
+			status = http.StatusBadRequest
+		case errors.Is(err, share.ErrNoData):
+			status = http.StatusNotFound
+		case errors.Is(err, share.ErrSnapshotChanged):
+			status = http.StatusPreconditionFailed
+		default:
+			h.log.Error().Err(err).Msg("Failed to create share")
+			h.encoder.StatusInternalError(w)
+			return
+		}
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: status}, status)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, shareResponse{
+		Token: token,
+		URL:   "/api/shared/" + token,
+		Share: created,
+	}, http.StatusCreated)
+}
+
+func (h shareHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid share id", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Revoke(ctx, user.HashedUUID, id); err != nil {
+		if errors.Is(err, domain.ErrShareNotFound) {
+			h.encoder.StatusNotFound(ctx, w)
+			return
+		}
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+// open serves a shared library. The format query parameter selects the
+// JSON library view (json, default) or the gzipped backup document (backup).
+// Protected shares expect the passphrase in the X-Share-Passphrase header.
+func (h shareHandler) open(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "backup" {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid 'format', expected json or backup", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	// The token is part of the URL, keep it out of caches and referrers
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	shared, document, err := h.service.Open(ctx, chi.URLParam(r, "token"), r.Header.Get("X-Share-Passphrase"))
+	if err != nil {
+		if writeLockout(w, err) {
+			return
+		}
+
+		switch {
+		case errors.Is(err, domain.ErrShareNotFound):
+			h.encoder.StatusNotFound(ctx, w)
+		case errors.Is(err, share.ErrShareGone):
+			h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusGone}, http.StatusGone)
+		case errors.Is(err, share.ErrPassphraseRequired):
+			h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusUnauthorized}, http.StatusUnauthorized)
+		default:
+			h.log.Error().Err(err).Msg("Failed to open share")
+			h.encoder.StatusInternalError(w)
+		}
+		return
+	}
+
+	if format == "backup" {
+		compressed, err := backup.Compress(document)
+		if err != nil {
+			h.encoder.StatusInternalError(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="library.json.gz"`)
+		w.WriteHeader(http.StatusOK)
+		w.Write(compressed)
+		return
+	}
+
+	library, err := h.service.Library(shared, document)
+	if err != nil {
+		h.log.Error().Err(err).Int64("share_id", shared.ID).Msg("Failed to render shared library")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, library, http.StatusOK)
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/reading"
+	"github.com/go-chi/chi/v5"
+)
+
+type readingService interface {
+	GetStats(ctx context.Context, userHashedUUID string, query domain.ReadingStatsQuery) (*domain.ReadingStats, error)
+	GetStreaks(ctx context.Context, userHashedUUID string, loc *time.Location) (*domain.ReadingStreaks, error)
+}
+
+type statsHandler struct {
+	encoder encoder
+	service readingService
+}
+
+func newStatsHandler(encoder encoder, service readingService) *statsHandler {
+	return &statsHandler{
+		encoder: encoder,
+		service: service,
+	}
+}
+
+func (h statsHandler) Routes(r chi.Router) {
+	r.Get("/reading", h.reading)
+	r.Get("/reading/streaks", h.streaks)
+}
+
+// parseLocation reads the optional "tz" query parameter, an IANA time zone name. Defaults to UTC.
+func parseLocation(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, errors.New("invalid 'tz', expected an IANA time zone name")
+	}
+	return loc, nil
+}
+
+// parseRangeBound accepts either RFC3339 or a plain date, which is interpreted in loc.
+func parseRangeBound(value string, loc *time.Location) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// reading returns reading totals of the authenticated user.
+func (h statsHandler) reading(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	badRequest := func(message string) {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: message, Status: http.StatusBadRequest}, http.StatusBadRequest)
+	}
+
+	loc, err := parseLocation(r)
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+
+	query := domain.ReadingStatsQuery{Location: loc}
+	if query.Group, err = reading.ParseGroup(r.URL.Query().Get("group")); err != nil {
+		badRequest("invalid 'group', expected one of day, week, month, hour, weekday, series")
+		return
+	}
+	if query.From, err = parseRangeBound(r.URL.Query().Get("from"), loc); err != nil {
+		badRequest("invalid 'from', expected RFC3339 or YYYY-MM-DD")
+		return
+	}
+	if query.To, err = parseRangeBound(r.URL.Query().Get("to"), loc); err != nil {
+		badRequest("invalid 'to', expected RFC3339 or YYYY-MM-DD")
+		return
+	}
+
+	stats, err := h.service.GetStats(ctx, user.HashedUUID, query)
+	if err != nil {
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, stats, http.StatusOK)
+}
+
+// streaks returns the reading streaks of the authenticated user.
+func (h statsHandler) streaks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	loc, err := parseLocation(r)
+	if err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	streaks, err := h.service.GetStreaks(ctx, user.HashedUUID, loc)
+	if err != nil {
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, streaks, http.StatusOK)
+}
@@ -2,32 +2,45 @@ package http
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/flurbudurbur/Shiori/internal/config"
 	"github.com/flurbudurbur/Shiori/internal/domain"
 	"github.com/flurbudurbur/Shiori/internal/sync"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
 )
 
 type syncService = sync.Service
 
-// profileUUIDManager defines the interface for promoting profile UUIDs
+// profileUUIDManager defines the interface for promoting profile UUIDs and
+// keeping the accounts of syncing users
 type profileUUIDManager interface {
 	PromoteProfileUUID(ctx context.Context, userID string, profileUUID string) error
-	GetOrGenerateProfileUUID(ctx context.Context, sessionID string) (string, error)
+	GetOrGenerateProfileUUID(ctx context.Context, userID string, sessionID string) (string, error)
+	ExtendRetention(ctx context.Context, user *domain.User) error
 }
 
 type syncHandler struct {
+	log         zerolog.Logger
 	encoder     encoder
+	cfg         *config.AppConfig
 	syncService syncService
 	uuidManager profileUUIDManager
 }
 
-func newSyncHandler(encoder encoder, syncService syncService, uuidManager profileUUIDManager) *syncHandler {
+func newSyncHandler(encoder encoder, log zerolog.Logger, cfg *config.AppConfig, syncService syncService, uuidManager profileUUIDManager) *syncHandler {
 	return &syncHandler{
+		log:         log.With().Str("handler", "sync").Logger(),
 		encoder:     encoder,
+		cfg:         cfg,
 		syncService: syncService,
 		uuidManager: uuidManager,
 	}
@@ -36,21 +49,96 @@ func newSyncHandler(encoder encoder, syncService syncService, uuidManager profil
 func (h syncHandler) Routes(r chi.Router) {
 	r.Get("/content", h.getContent)
 	r.Put("/content", h.putContent)
+	r.Get("/activity", h.activity)
+	r.Get("/stats", h.stats)
+	r.Get("/history", h.listHistory)
+	r.Post("/history/{id}/restore", h.restoreHistory)
+
+	r.Route("/slots", func(r chi.Router) {
+		r.Get("/", h.listSlots)
+		r.Post("/", h.createSlot)
+		r.Route("/{name}", func(r chi.Router) {
+			r.Patch("/", h.renameSlot)
+			r.Delete("/", h.deleteSlot)
+			r.Get("/content", h.getContent)
+			r.Put("/content", h.putContent)
+			r.Get("/history", h.listHistory)
+			r.Post("/history/{id}/restore", h.restoreHistory)
+		})
+	})
+}
+
+// slotFromRequest returns the slot addressed by the request, the default slot outside of /slots/{name}.
+func slotFromRequest(r *http.Request) string {
+	name := chi.URLParam(r, "name")
+	if strings.EqualFold(name, domain.DefaultSyncSlotName) {
+		return domain.DefaultSyncSlot
+	}
+	return name
+}
+
+// newSyncEvent prepares an audit log entry with the request metadata filled in.
+func newSyncEvent(r *http.Request, userHashedUUID string, slot string) domain.SyncEvent {
+	return domain.SyncEvent{
+		UserHashedUUID: userHashedUUID,
+		Slot:           slot,
+		Device:         deviceFromRequest(r),
+		IP:             getClientIP(r),
+		RequestID:      middleware.GetReqID(r.Context()),
+		Method:         r.Method,
+		CreatedAt:      time.Now(),
+	}
+}
+
+// deviceFromRequest returns a best-effort name for the device that sent the request.
+// Clients can identify themselves with the X-Device-Name header, otherwise the name of
+// the API token or the User-Agent is used.
+func deviceFromRequest(r *http.Request) string {
+	if name := strings.TrimSpace(r.Header.Get("X-Device-Name")); name != "" {
+		return name
+	}
+	if token, ok := r.Context().Value(APITokenContextKey).(*domain.APIToken); ok && token != nil {
+		return token.Name
+	}
+	return r.UserAgent()
+}
+
+// extendRetention moves the deletion date of a syncing user, only after a successful read or write.
+// Failures are logged but never fail the sync request.
+func (h syncHandler) extendRetention(ctx context.Context, user *domain.User) {
+	if err := h.uuidManager.ExtendRetention(ctx, user); err != nil {
+		h.log.Error().Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to extend account retention")
+	}
+}
+
+// recordEvent stores the audit log entry. Failures are logged but never fail the sync request.
+func (h syncHandler) recordEvent(ctx context.Context, event domain.SyncEvent, outcome domain.SyncOutcome, reason string) {
+	event.Outcome = outcome
+	event.Reason = reason
+
+	if err := h.syncService.RecordEvent(ctx, event); err != nil {
+		h.log.Error().Err(err).Str("request_id", event.RequestID).Int("outcome", int(outcome)).Msg("Failed to record sync event")
+	}
 }
 
 func (h syncHandler) getContent(w http.ResponseWriter, r *http.Request) {
-	user, ok := r.Context().Value("user").(*domain.User)
+	user, ok := r.Context().Value(UserContextKey).(*domain.User)
 	if !ok || user == nil {
 		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
 		return
 	}
 	userHashedUUID := user.HashedUUID
+	slot := slotFromRequest(r)
 	etag := r.Header.Get("If-None-Match")
 
+	event := newSyncEvent(r, userHashedUUID, slot)
+	event.PreviousETag = etag
+
 	if etag != "" {
-		etagInDb, err := h.syncService.GetSyncDataETag(r.Context(), userHashedUUID)
+		etagInDb, err := h.syncService.GetSyncDataETag(r.Context(), userHashedUUID, slot)
 		if err != nil {
-			log.Println(err)
+			h.log.Error().Err(err).Str("request_id", event.RequestID).Msg("Failed to get sync data ETag")
+			h.recordEvent(r.Context(), event, domain.SyncOutcomeError, "etag_lookup_failed")
 			h.encoder.StatusInternalError(w)
 			return
 		}
@@ -58,69 +146,116 @@ func (h syncHandler) getContent(w http.ResponseWriter, r *http.Request) {
 		if etagInDb != nil && etag == *etagInDb {
 			// nothing changed after last request
 			// see: https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/If-None-Match
+			event.NewETag = *etagInDb
+			h.recordEvent(r.Context(), event, domain.SyncOutcomeNotModified, "")
+			h.extendRetention(r.Context(), user)
 			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 	}
 
-	syncData, syncDataETag, err := h.syncService.GetSyncDataAndETag(r.Context(), userHashedUUID)
+	syncData, syncDataETag, err := h.syncService.GetSyncDataAndETag(r.Context(), userHashedUUID, slot)
 
 	if err != nil {
+		h.log.Error().Err(err).Str("request_id", event.RequestID).Msg("Failed to get sync data")
+		h.recordEvent(r.Context(), event, domain.SyncOutcomeError, "read_failed")
 		h.encoder.StatusInternalError(w)
 		return
 	}
 
 	if syncData == nil {
+		h.recordEvent(r.Context(), event, domain.SyncOutcomeNotFound, "no_data")
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
 	if syncDataETag != nil {
+		event.NewETag = *syncDataETag
 		w.Header().Set("ETag", *syncDataETag)
 	}
 
+	event.PayloadSize = int64(len(syncData))
+	h.recordEvent(r.Context(), event, domain.SyncOutcomeOK, "")
+	h.extendRetention(r.Context(), user)
+
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(syncData)
 	w.WriteHeader(http.StatusOK)
+	w.Write(syncData)
 }
 
 func (h syncHandler) putContent(w http.ResponseWriter, r *http.Request) {
-	user, ok := r.Context().Value("user").(*domain.User)
+	user, ok := r.Context().Value(UserContextKey).(*domain.User)
 	if !ok || user == nil {
 		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
 		return
 	}
 	userHashedUUID := user.HashedUUID
+	slot := slotFromRequest(r)
 	etag := r.Header.Get("If-Match")
 
-	// Read data from request body
+	event := newSyncEvent(r, userHashedUUID, slot)
+	event.PreviousETag = etag
+
+	// Read data from request body, bounded by the configured maximum payload size
+	if maxSize := h.cfg.Config.Sync.MaxPayloadSize; maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
 	requestData, err := io.ReadAll(r.Body)
+	event.PayloadSize = int64(len(requestData))
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.log.Warn().Str("request_id", event.RequestID).Int64("limit", maxBytesErr.Limit).Msg("Sync payload exceeds maximum size")
+			h.recordEvent(r.Context(), event, domain.SyncOutcomePayloadTooLarge, "payload_too_large")
+			h.encoder.StatusResponse(r.Context(), w, errorResponse{Message: "payload too large", Status: http.StatusRequestEntityTooLarge}, http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.recordEvent(r.Context(), event, domain.SyncOutcomeValidationFailed, "unreadable_body")
 		h.encoder.StatusResponse(r.Context(), w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	var newEtag *string
 	if etag != "" {
-		newEtag, err = h.syncService.SetSyncDataIfMatch(r.Context(), userHashedUUID, etag, requestData)
+		newEtag, err = h.syncService.SetSyncDataIfMatch(r.Context(), userHashedUUID, slot, etag, requestData)
 	} else {
-		newEtag, err = h.syncService.SetSyncData(r.Context(), userHashedUUID, requestData)
+		// Unconditional writes still record which version they replaced
+		if previous, etagErr := h.syncService.GetSyncDataETag(r.Context(), userHashedUUID, slot); etagErr == nil && previous != nil {
+			event.PreviousETag = *previous
+		}
+		newEtag, err = h.syncService.SetSyncData(r.Context(), userHashedUUID, slot, requestData)
 	}
 
 	// This is a "data sync" event - promote the profile UUID to the persistent database
 	// First, get the profile UUID from the session (or generate one if it doesn't exist)
-	profileUUID, uuidErr := h.uuidManager.GetOrGenerateProfileUUID(r.Context(), userHashedUUID)
+	sessionID := currentSessionID(r.Context())
+	if sessionID == "" {
+		sessionID = userHashedUUID
+	}
+	profileUUID, uuidErr := h.uuidManager.GetOrGenerateProfileUUID(r.Context(), userHashedUUID, sessionID)
 	if uuidErr != nil {
 		// Log the error but don't fail the sync operation
-		log.Printf("Failed to get profile UUID for promotion: %v", uuidErr)
+		h.log.Error().Err(uuidErr).Msg("Failed to get profile UUID for promotion")
 	} else {
 		// Promote the UUID to the persistent database
 		if promoteErr := h.uuidManager.PromoteProfileUUID(r.Context(), userHashedUUID, profileUUID); promoteErr != nil {
 			// Log the error but don't fail the sync operation
-			log.Printf("Failed to promote profile UUID to persistent database: %v", promoteErr)
+			h.log.Error().Err(promoteErr).Msg("Failed to promote profile UUID to persistent database")
 		}
 	}
+	if errors.Is(err, sync.ErrQuotaExceeded) {
+		h.recordEvent(r.Context(), event, domain.SyncOutcomePayloadTooLarge, "quota_exceeded")
+		h.encoder.StatusResponse(r.Context(), w, errorResponse{Message: "storage quota exceeded", Status: http.StatusRequestEntityTooLarge}, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, domain.ErrSyncSlotNotFound) {
+		h.recordEvent(r.Context(), event, domain.SyncOutcomeNotFound, "slot_not_found")
+		h.encoder.StatusResponse(r.Context(), w, errorResponse{Message: "slot not found", Status: http.StatusNotFound}, http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		h.log.Error().Err(err).Str("request_id", event.RequestID).Msg("Failed to store sync data")
+		h.recordEvent(r.Context(), event, domain.SyncOutcomeError, "write_failed")
 		h.encoder.StatusInternalError(w)
 		// It's important to return here if an error occurs, otherwise, it will proceed to write headers.
 		return
@@ -129,9 +264,341 @@ func (h syncHandler) putContent(w http.ResponseWriter, r *http.Request) {
 	if newEtag == nil {
 		// syncdata was changed from other clients
 		// see: https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/If-Match
+		h.recordEvent(r.Context(), event, domain.SyncOutcomePreconditionFailed, "etag_mismatch")
 		w.WriteHeader(http.StatusPreconditionFailed)
 	} else {
+		event.NewETag = *newEtag
+		h.recordEvent(r.Context(), event, domain.SyncOutcomeOK, "")
+		h.extendRetention(r.Context(), user)
 		w.Header().Set("ETag", *newEtag)
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// syncActivityResponse is returned by the sync activity endpoint.
+type syncActivityResponse struct {
+	Events []domain.SyncEvent `json:"events"`
+	Count  int                `json:"count"`
+}
+
+// activity lists the sync audit log of the authenticated user.
+// Supported query parameters: from, to (RFC 3339), outcome (comma separated status codes),
+// method (GET or PUT), slot ("default" or a slot name), limit and offset.
+func (h syncHandler) activity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := parseSyncEventQuery(r)
+	if err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+	params.UserHashedUUID = &user.HashedUUID
+
+	events, count, err := h.syncService.FindEvents(ctx, params)
+	if err != nil {
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	if events == nil {
+		events = []domain.SyncEvent{}
+	}
+
+	h.encoder.StatusResponse(ctx, w, syncActivityResponse{Events: events, Count: count}, http.StatusOK)
+}
+
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 500
+)
+
+// parseSyncEventQuery reads the activity filters from the query string.
+func parseSyncEventQuery(r *http.Request) (domain.SyncEventQueryParams, error) {
+	query := r.URL.Query()
+	params := domain.SyncEventQueryParams{Limit: defaultActivityLimit}
+
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return params, errors.New("invalid 'from' timestamp, expected RFC 3339")
+		}
+		params.From = &t
+	}
+
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return params, errors.New("invalid 'to' timestamp, expected RFC 3339")
+		}
+		params.To = &t
+	}
+
+	if outcomes := query.Get("outcome"); outcomes != "" {
+		for _, o := range strings.Split(outcomes, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(o))
+			if err != nil {
+				return params, errors.New("invalid 'outcome', expected comma separated status codes")
+			}
+			params.Outcomes = append(params.Outcomes, domain.SyncOutcome(code))
+		}
+	}
+
+	if method := strings.ToUpper(query.Get("method")); method != "" {
+		if method != http.MethodGet && method != http.MethodPut {
+			return params, errors.New("invalid 'method', expected GET or PUT")
+		}
+		params.Method = method
+	}
+
+	if slot := query.Get("slot"); slot != "" {
+		if strings.EqualFold(slot, domain.DefaultSyncSlotName) {
+			slot = domain.DefaultSyncSlot
+		}
+		params.Slot = &slot
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.ParseUint(limit, 10, 64)
+		if err != nil || l == 0 {
+			return params, errors.New("invalid 'limit'")
+		}
+		params.Limit = min(l, maxActivityLimit)
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		o, err := strconv.ParseUint(offset, 10, 64)
+		if err != nil {
+			return params, errors.New("invalid 'offset'")
+		}
+		params.Offset = o
+	}
+
+	return params, nil
+}
+
+const (
+	defaultStatsWindowDays = 30
+	maxStatsWindowDays     = 365
+)
+
+// parseStatsWindow reads the optional "days" query parameter.
+func parseStatsWindow(r *http.Request) (int, error) {
+	days := defaultStatsWindowDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		d, err := strconv.Atoi(value)
+		if err != nil || d <= 0 || d > maxStatsWindowDays {
+			return 0, errors.New("invalid 'days', expected a number between 1 and 365")
+		}
+		days = d
+	}
+	return days, nil
+}
+
+// stats returns sync statistics of the authenticated user.
+func (h syncHandler) stats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	days, err := parseStatsWindow(r)
+	if err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	stats, err := h.syncService.GetUserStats(ctx, user.HashedUUID, days)
+	if err != nil {
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, stats, http.StatusOK)
+}
+
+// listSlots lists the slots of the authenticated user.
+func (h syncHandler) listSlots(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	slots, err := h.syncService.ListSlots(ctx, user.HashedUUID)
+	if err != nil {
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, slots, http.StatusOK)
+}
+
+type slotRequest struct {
+	Name string `json:"name"`
+}
+
+// slotErrorResponse maps slot errors to their status code, returns false for unexpected errors.
+func (h syncHandler) slotErrorResponse(ctx context.Context, w http.ResponseWriter, err error) bool {
+	var status int
+	switch {
+	case errors.Is(err, sync.ErrInvalidSlotName):
+		status = http.StatusBadRequest
+	case errors.Is(err, sync.ErrDefaultSlotLocked):
+		status = http.StatusBadRequest
+	case errors.Is(err, sync.ErrSlotLimitReached):
+		status = http.StatusForbidden
+	case errors.Is(err, domain.ErrSyncSlotNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, domain.ErrSyncSlotExists):
+		status = http.StatusConflict
+	case errors.Is(err, domain.ErrSyncHistoryNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, sync.ErrQuotaExceeded):
+		status = http.StatusRequestEntityTooLarge
+	default:
+		return false
+	}
+
+	h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: status}, status)
+	return true
+}
+
+// createSlot creates an empty named slot.
+func (h syncHandler) createSlot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req slotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	slot, err := h.syncService.CreateSlot(ctx, user.HashedUUID, req.Name)
+	if err != nil {
+		if !h.slotErrorResponse(ctx, w, err) {
+			h.encoder.StatusInternalError(w)
+		}
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, slot, http.StatusCreated)
+}
+
+// renameSlot renames a named slot.
+func (h syncHandler) renameSlot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req slotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.syncService.RenameSlot(ctx, user.HashedUUID, slotFromRequest(r), req.Name); err != nil {
+		if !h.slotErrorResponse(ctx, w, err) {
+			h.encoder.StatusInternalError(w)
+		}
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+// deleteSlot deletes a named slot and its data.
+func (h syncHandler) deleteSlot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.syncService.DeleteSlot(ctx, user.HashedUUID, slotFromRequest(r)); err != nil {
+		if !h.slotErrorResponse(ctx, w, err) {
+			h.encoder.StatusInternalError(w)
+		}
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+// listHistory lists the replaced versions of a slot.
+func (h syncHandler) listHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	history, err := h.syncService.ListHistory(ctx, user.HashedUUID, slotFromRequest(r))
+	if err != nil {
+		if !h.slotErrorResponse(ctx, w, err) {
+			h.encoder.StatusInternalError(w)
+		}
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, history, http.StatusOK)
+}
+
+type restoreHistoryResponse struct {
+	ETag string `json:"etag"`
+}
+
+// restoreHistory makes a replaced version the current content of its slot again.
+// The restore is recorded in the sync activity log like an upload.
+func (h syncHandler) restoreHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid history id", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	slot := slotFromRequest(r)
+	event := newSyncEvent(r, user.HashedUUID, slot)
+	if previous, etagErr := h.syncService.GetSyncDataETag(ctx, user.HashedUUID, slot); etagErr == nil && previous != nil {
+		event.PreviousETag = *previous
+	}
+
+	newEtag, err := h.syncService.RestoreHistory(ctx, user.HashedUUID, slot, id)
+	if err != nil {
+		if !h.slotErrorResponse(ctx, w, err) {
+			h.log.Error().Err(err).Str("request_id", event.RequestID).Msg("Failed to restore sync history")
+			h.recordEvent(ctx, event, domain.SyncOutcomeError, "restore_failed")
+			h.encoder.StatusInternalError(w)
+		}
+		return
+	}
+
+	event.NewETag = *newEtag
+	h.recordEvent(ctx, event, domain.SyncOutcomeOK, "restore")
+	w.Header().Set("ETag", *newEtag)
+	h.encoder.StatusResponse(ctx, w, restoreHistoryResponse{ETag: *newEtag}, http.StatusOK)
+}
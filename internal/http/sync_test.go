@@ -0,0 +1,148 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/config"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/sync"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// slotService answers every slot operation and upload with err.
+type slotService struct {
+	sync.Service
+	err error
+}
+
+func (s *slotService) ListSlots(ctx context.Context, userHashedUUID string) ([]domain.SyncSlot, error) {
+	return []domain.SyncSlot{{Name: domain.DefaultSyncSlotName, Default: true}}, s.err
+}
+
+func (s *slotService) CreateSlot(ctx context.Context, userHashedUUID string, name string) (*domain.SyncSlot, error) {
+	return &domain.SyncSlot{Name: name}, s.err
+}
+
+func (s *slotService) RenameSlot(ctx context.Context, userHashedUUID string, name string, newName string) error {
+	return s.err
+}
+
+func (s *slotService) DeleteSlot(ctx context.Context, userHashedUUID string, name string) error {
+	return s.err
+}
+
+func (s *slotService) GetSyncDataETag(ctx context.Context, userHashedUUID string, slot string) (*string, error) {
+	return nil, nil
+}
+
+func (s *slotService) GetSyncDataAndETag(ctx context.Context, userHashedUUID string, slot string) ([]byte, *string, error) {
+	etag := "uuid=current"
+	return []byte("library"), &etag, s.err
+}
+
+func (s *slotService) SetSyncData(ctx context.Context, userHashedUUID string, slot string, data []byte) (*string, error) {
+	etag := "uuid=new"
+	return &etag, s.err
+}
+
+func (s *slotService) ListHistory(ctx context.Context, userHashedUUID string, slot string) ([]domain.SyncHistoryEntry, error) {
+	return []domain.SyncHistoryEntry{{ID: 1, ETag: "uuid=old"}}, s.err
+}
+
+func (s *slotService) RestoreHistory(ctx context.Context, userHashedUUID string, slot string, id int64) (*string, error) {
+	etag := "uuid=restored"
+	return &etag, s.err
+}
+
+func (s *slotService) RecordEvent(ctx context.Context, event domain.SyncEvent) error {
+	return nil
+}
+
+// uuidManager counts how often the retention of the user was extended.
+type uuidManager struct {
+	extended int
+}
+
+func (*uuidManager) PromoteProfileUUID(ctx context.Context, userID string, profileUUID string) error {
+	return nil
+}
+
+func (*uuidManager) GetOrGenerateProfileUUID(ctx context.Context, userID string, sessionID string) (string, error) {
+	return "profile", nil
+}
+
+func (m *uuidManager) ExtendRetention(ctx context.Context, user *domain.User) error {
+	m.extended++
+	return nil
+}
+
+func TestSyncHandler_Slots(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		err        error
+		anonymous  bool
+		wantStatus int
+		// Retention is only extended after a successful read or write
+		wantExtended bool
+	}{
+		{name: "list", method: http.MethodGet, path: "/slots/", wantStatus: http.StatusOK},
+		{name: "list_anonymous", method: http.MethodGet, path: "/slots/", anonymous: true, wantStatus: http.StatusUnauthorized},
+		{name: "create", method: http.MethodPost, path: "/slots/", body: `{"name":"tablet"}`, wantStatus: http.StatusCreated},
+		{name: "create_invalid_body", method: http.MethodPost, path: "/slots/", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "create_invalid_name", method: http.MethodPost, path: "/slots/", body: `{"name":"a/b"}`, err: sync.ErrInvalidSlotName, wantStatus: http.StatusBadRequest},
+		{name: "create_over_limit", method: http.MethodPost, path: "/slots/", body: `{"name":"tablet"}`, err: sync.ErrSlotLimitReached, wantStatus: http.StatusForbidden},
+		{name: "create_taken", method: http.MethodPost, path: "/slots/", body: `{"name":"tablet"}`, err: domain.ErrSyncSlotExists, wantStatus: http.StatusConflict},
+		{name: "rename_default", method: http.MethodPatch, path: "/slots/default/", body: `{"name":"tablet"}`, err: sync.ErrDefaultSlotLocked, wantStatus: http.StatusBadRequest},
+		{name: "rename", method: http.MethodPatch, path: "/slots/tablet/", body: `{"name":"phone"}`, wantStatus: http.StatusNoContent},
+		{name: "delete_missing", method: http.MethodDelete, path: "/slots/tablet/", err: domain.ErrSyncSlotNotFound, wantStatus: http.StatusNotFound},
+		{name: "delete", method: http.MethodDelete, path: "/slots/tablet/", wantStatus: http.StatusNoContent},
+		{name: "upload", method: http.MethodPut, path: "/slots/tablet/content", body: "library", wantStatus: http.StatusOK, wantExtended: true},
+		// Clients clear a library by uploading nothing
+		{name: "upload_empty", method: http.MethodPut, path: "/slots/tablet/content", wantStatus: http.StatusOK, wantExtended: true},
+		{name: "upload_missing_slot", method: http.MethodPut, path: "/slots/tablet/content", body: "library", err: domain.ErrSyncSlotNotFound, wantStatus: http.StatusNotFound},
+		{name: "upload_over_quota", method: http.MethodPut, path: "/slots/tablet/content", body: "library", err: sync.ErrQuotaExceeded, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "download", method: http.MethodGet, path: "/slots/tablet/content", wantStatus: http.StatusOK, wantExtended: true},
+		{name: "download_failed", method: http.MethodGet, path: "/slots/tablet/content", err: assert.AnError, wantStatus: http.StatusInternalServerError},
+		{name: "history", method: http.MethodGet, path: "/slots/tablet/history", wantStatus: http.StatusOK},
+		{name: "history_default", method: http.MethodGet, path: "/history", wantStatus: http.StatusOK},
+		{name: "history_missing_slot", method: http.MethodGet, path: "/slots/tablet/history", err: domain.ErrSyncSlotNotFound, wantStatus: http.StatusNotFound},
+		{name: "restore", method: http.MethodPost, path: "/slots/tablet/history/1/restore", wantStatus: http.StatusOK},
+		{name: "restore_default", method: http.MethodPost, path: "/history/1/restore", wantStatus: http.StatusOK},
+		{name: "restore_invalid_id", method: http.MethodPost, path: "/history/first/restore", wantStatus: http.StatusBadRequest},
+		{name: "restore_missing_entry", method: http.MethodPost, path: "/history/2/restore", err: domain.ErrSyncHistoryNotFound, wantStatus: http.StatusNotFound},
+		{name: "restore_over_quota", method: http.MethodPost, path: "/history/1/restore", err: sync.ErrQuotaExceeded, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New(t.TempDir(), "test")
+			service := &slotService{err: tt.err}
+			manager := &uuidManager{}
+			handler := newSyncHandler(encoder{}, zerolog.Nop(), cfg, service, manager)
+
+			r := chi.NewRouter()
+			if !tt.anonymous {
+				r.Use(func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						ctx := context.WithValue(r.Context(), UserContextKey, &domain.User{HashedUUID: "bookmark"})
+						next.ServeHTTP(w, r.WithContext(ctx))
+					})
+				})
+			}
+			handler.Routes(r)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Equal(t, tt.wantExtended, manager.extended > 0)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/accesstoken"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/user"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// accessTokenService issues and verifies short-lived access tokens, see accesstoken.Service.
+type accessTokenService interface {
+	Enabled() bool
+	Exchange(ctx context.Context, plainToken string, ip string) (*domain.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string, ip string) (*domain.TokenPair, error)
+	Authenticate(ctx context.Context, accessToken string) (*domain.User, *accesstoken.Claims, error)
+	RevokeUser(ctx context.Context, userHashedUUID string) error
+}
+
+const (
+	grantTypeAPIToken     = "api_token"
+	grantTypeRefreshToken = "refresh_token"
+)
+
+type tokenHandler struct {
+	log     zerolog.Logger
+	encoder encoder
+	service accessTokenService
+}
+
+func newTokenHandler(encoder encoder, log zerolog.Logger, service accessTokenService) *tokenHandler {
+	return &tokenHandler{
+		log:     log.With().Str("handler", "token").Logger(),
+		encoder: encoder,
+		service: service,
+	}
+}
+
+// Routes exchange credentials for access tokens, the credential is in the request body.
+func (h tokenHandler) Routes(r chi.Router) {
+	r.Post("/token", h.token)
+}
+
+// tokenRequest exchanges an API token (grant_type api_token) or a refresh token (grant_type refresh_token).
+type tokenRequest struct {
+	GrantType    string `json:"grant_type"`
+	APIToken     string `json:"api_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// tokenResponse follows the access token response of RFC 6749 section 5.1.
+type tokenResponse struct {
+	AccessToken           string `json:"access_token"`
+	TokenType             string `json:"token_type"`
+	ExpiresIn             int    `json:"expires_in"`
+	RefreshToken          string `json:"refresh_token"`
+	RefreshTokenExpiresIn int    `json:"refresh_token_expires_in"`
+	Scope                 string `json:"scope"`
+}
+
+// token answers errors in the format of RFC 6749 section 5.2, the same one device polling uses.
+func (h tokenHandler) token(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !h.service.Enabled() {
+		h.encoder.StatusNotFound(ctx, w)
+		return
+	}
+
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, deviceTokenErrorResponse{Error: "invalid_request"}, http.StatusBadRequest)
+		return
+	}
+
+	var pair *domain.TokenPair
+	var err error
+	switch req.GrantType {
+	case grantTypeAPIToken:
+		pair, err = h.service.Exchange(ctx, req.APIToken, getClientIP(r))
+	case grantTypeRefreshToken:
+		pair, err = h.service.Refresh(ctx, req.RefreshToken, getClientIP(r))
+	default:
+		h.encoder.StatusResponse(ctx, w, deviceTokenErrorResponse{Error: "unsupported_grant_type"}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.writeError(ctx, w, err)
+		return
+	}
+
+	now := time.Now()
+	h.encoder.StatusResponse(ctx, w, tokenResponse{
+		AccessToken:           pair.AccessToken,
+		TokenType:             "Bearer",
+		ExpiresIn:             int(pair.AccessExpiresAt.Sub(now).Seconds()),
+		RefreshToken:          pair.RefreshToken,
+		RefreshTokenExpiresIn: int(pair.RefreshExpiresAt.Sub(now).Seconds()),
+		Scope:                 strings.Join(pair.Scopes.Names(), " "),
+	}, http.StatusOK)
+}
+
+func (h tokenHandler) writeError(ctx context.Context, w http.ResponseWriter, err error) {
+	if writeLockout(w, err) {
+		return
+	}
+
+	var description string
+	switch {
+	case errors.Is(err, user.ErrAuthenticationFailed):
+		description = "invalid API token"
+	case errors.Is(err, user.ErrTokenExpired):
+		description = "API token expired"
+	case errors.Is(err, user.ErrUserExpired):
+		description = "user account expired"
+	case errors.Is(err, user.ErrUserDisabled):
+		description = "user account disabled"
+	case errors.Is(err, accesstoken.ErrInvalidRefreshToken), errors.Is(err, accesstoken.ErrRefreshTokenReused):
+		description = err.Error()
+	default:
+		h.log.Error().Err(err).Msg("Failed to issue access token")
+		h.encoder.StatusResponse(ctx, w, deviceTokenErrorResponse{Error: "server_error", ErrorDescription: "the access token could not be issued"}, http.StatusInternalServerError)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, deviceTokenErrorResponse{Error: "invalid_grant", ErrorDescription: description}, http.StatusBadRequest)
+}
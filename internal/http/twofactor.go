@@ -0,0 +1,188 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/user"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// twoFactorService manages the TOTP of a user, see user.Service.
+type twoFactorService interface {
+	TwoFactorStatus(ctx context.Context, user *domain.User) (*domain.TwoFactorStatus, error)
+	BeginTOTPEnrolment(ctx context.Context, user *domain.User) (secret string, uri string, err error)
+	ConfirmTOTPEnrolment(ctx context.Context, user *domain.User, code string, ip string) ([]string, error)
+	RegenerateRecoveryCodes(ctx context.Context, user *domain.User, code string, ip string) ([]string, error)
+	DisableTwoFactor(ctx context.Context, user *domain.User, code string, ip string) error
+}
+
+type twoFactorHandler struct {
+	log     zerolog.Logger
+	encoder encoder
+	service twoFactorService
+}
+
+func newTwoFactorHandler(encoder encoder, log zerolog.Logger, service twoFactorService) *twoFactorHandler {
+	return &twoFactorHandler{
+		log:     log.With().Str("handler", "two_factor").Logger(),
+		encoder: encoder,
+		service: service,
+	}
+}
+
+func (h twoFactorHandler) Routes(r chi.Router) {
+	r.Get("/", h.status)
+	r.Delete("/", h.disable)
+	r.Post("/totp", h.beginEnrolment)
+	r.Post("/totp/confirm", h.confirmEnrolment)
+	r.Post("/recovery-codes", h.regenerateRecoveryCodes)
+}
+
+type totpEnrolmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI to show as QR code
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"` // TOTP code, or a recovery code where accepted
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"` // Only returned once
+}
+
+func (h twoFactorHandler) status(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := h.service.TwoFactorStatus(ctx, user)
+	if err != nil {
+		h.writeError(w, r, user, err)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, status, http.StatusOK)
+}
+
+func (h twoFactorHandler) beginEnrolment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := h.service.BeginTOTPEnrolment(ctx, user)
+	if err != nil {
+		h.writeError(w, r, user, err)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, totpEnrolmentResponse{Secret: secret, URI: uri}, http.StatusOK)
+}
+
+func (h twoFactorHandler) confirmEnrolment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.service.ConfirmTOTPEnrolment(ctx, user, req.Code, getClientIP(r))
+	if err != nil {
+		h.writeError(w, r, user, err)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, recoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+}
+
+func (h twoFactorHandler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(ctx, user, req.Code, getClientIP(r))
+	if err != nil {
+		h.writeError(w, r, user, err)
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, recoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+}
+
+func (h twoFactorHandler) disable(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DisableTwoFactor(ctx, user, req.Code, getClientIP(r)); err != nil {
+		h.writeError(w, r, user, err)
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+// accountUser returns the user allowed to manage two-factor authentication, named API tokens are not.
+func (h twoFactorHandler) accountUser(w http.ResponseWriter, r *http.Request) (*domain.User, bool) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		h.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if apiToken, ok := ctx.Value(APITokenContextKey).(*domain.APIToken); ok && apiToken != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Forbidden: two-factor authentication cannot be managed with a named API token", Status: http.StatusForbidden}, http.StatusForbidden)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (h twoFactorHandler) writeError(w http.ResponseWriter, r *http.Request, u *domain.User, err error) {
+	ctx := r.Context()
+	if writeLockout(w, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, user.ErrTwoFactorRequired), errors.Is(err, user.ErrInvalidTwoFactorCode), errors.Is(err, user.ErrTOTPNotEnrolled):
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+	case errors.Is(err, user.ErrTwoFactorEnabled), errors.Is(err, user.ErrTwoFactorNotEnabled):
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusConflict}, http.StatusConflict)
+	default:
+		h.log.Error().Err(err).Str("hashed_uuid", u.HashedUUID).Msg("Failed to manage two-factor authentication")
+		h.encoder.StatusInternalError(w)
+	}
+}
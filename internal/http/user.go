@@ -1,10 +1,14 @@
 package http
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/flurbudurbur/Shiori/internal/domain"
 	userservice "github.com/flurbudurbur/Shiori/internal/user" // Renamed import for clarity
+	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog"
 )
 
@@ -13,14 +17,16 @@ type UserResource struct {
 	userService userservice.Service // Use userservice.Service from internal/user package
 	log         zerolog.Logger      // Use zerolog.Logger directly
 	encoder     encoder             // Assuming 'encoder' is a defined type/interface for JSON responses
+	audit       auditService
 }
 
 // NewUserResource creates a new UserResource.
-func NewUserResource(userService userservice.Service, log zerolog.Logger, enc encoder) *UserResource {
+func NewUserResource(userService userservice.Service, audit auditService, log zerolog.Logger, enc encoder) *UserResource {
 	return &UserResource{
 		userService: userService,
 		log:         log.With().Str("resource", "user").Logger(), // Specialize logger for this resource
 		encoder:     enc,
+		audit:       audit,
 	}
 }
 
@@ -29,13 +35,19 @@ func (ur *UserResource) handleResetAndGetUserToken(w http.ResponseWriter, r *htt
 	ctx := r.Context()
 
 	// 1. Retrieve authenticated user from context
-	user, ok := ctx.Value("user").(*domain.User) // Assuming "user" is the key used by auth middleware
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
 	if !ok || user == nil {
 		ur.log.Warn().Msg("User not found in context or type assertion failed for token reset")
 		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
 		return
 	}
 
+	// A named token must not be able to trade itself for the unrestricted user token
+	if apiToken, ok := ctx.Value(APITokenContextKey).(*domain.APIToken); ok && apiToken != nil {
+		ur.encoder.StatusResponse(ctx, w, errorResponse{Message: "Forbidden: the user token cannot be reset with a named API token", Status: http.StatusForbidden}, http.StatusForbidden)
+		return
+	}
+
 	ur.log.Debug().Str("hashed_uuid", user.HashedUUID).Msg("Attempting to reset and retrieve token for user")
 
 	// 2. Call the service method
@@ -46,6 +58,7 @@ func (ur *UserResource) handleResetAndGetUserToken(w http.ResponseWriter, r *htt
 		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Failed to reset API token"}, http.StatusInternalServerError)
 		return
 	}
+	ur.audit.Record(ctx, newAuditEvent(r, domain.AuditActionResetToken, user, nil))
 
 	// 3. Return the plain token in the response
 	response := map[string]string{"api_token": plainToken}
@@ -59,41 +72,24 @@ func (ur *UserResource) handleGetProfile(w http.ResponseWriter, r *http.Request)
 	ctx := r.Context()
 
 	// 1. Retrieve authenticated user from context (primarily to ensure user is authenticated)
-	user, ok := ctx.Value("user").(*domain.User) // Assuming "user" is the key used by auth middleware
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
 	if !ok || user == nil {
 		ur.log.Warn().Msg("User not found in context for profile access")
 		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
 		return
 	}
 
-	// 2. Obtain a session identifier.
-	//    Attempt to get a specific sessionID from context.
-	//    If not available, this part needs clarification or use user.HashedUUID as a fallback (with a note).
-	var sessionID string
-	sessionIDVal := ctx.Value("sessionID") // Assuming "sessionID" is a key used by session middleware
-	if sessionIDVal != nil {
-		sessionID, ok = sessionIDVal.(string)
-		if !ok || sessionID == "" {
-			ur.log.Warn().Str("hashed_uuid", user.HashedUUID).Msg("SessionID found in context but is not a non-empty string. Falling back to HashedUUID for profile UUID key.")
-			// Fallback or error? For now, let's try to proceed with HashedUUID as a session-like identifier for this specific UUID.
-			// This is an assumption if a true ephemeral session ID isn't readily available.
-			sessionID = user.HashedUUID // Using HashedUUID as a stand-in if specific sessionID is not found/valid.
-		}
-	} else {
-		ur.log.Warn().Str("hashed_uuid", user.HashedUUID).Msg("SessionID not found in context. Falling back to HashedUUID for profile UUID key.")
-		sessionID = user.HashedUUID // Fallback
-	}
-
-	if sessionID == "" { // Should not happen if user is present and HashedUUID is mandatory
-		ur.log.Error().Str("hashed_uuid", user.HashedUUID).Msg("Critical: sessionID became empty even after fallback for profile UUID generation.")
-		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Internal server error: could not determine session identifier"}, http.StatusInternalServerError)
-		return
+	// 2. Obtain a session identifier, requests made with an API token have none
+	//    and share the profile UUID keyed by the HashedUUID.
+	sessionID := currentSessionID(ctx)
+	if sessionID == "" {
+		sessionID = user.HashedUUID
 	}
 
 	ur.log.Debug().Str("hashed_uuid", user.HashedUUID).Str("session_id_for_key", sessionID).Msg("Attempting to get/generate profile UUID")
 
 	// 3. Call the user service to get/generate the profile UUID
-	profileUUID, err := ur.userService.GetOrGenerateProfileUUID(ctx, sessionID)
+	profileUUID, err := ur.userService.GetOrGenerateProfileUUID(ctx, user.HashedUUID, sessionID)
 	if err != nil {
 		ur.log.Error().Err(err).Str("session_id_for_key", sessionID).Msg("Failed to get or generate profile UUID")
 		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Failed to process profile request"}, http.StatusInternalServerError)
@@ -121,3 +117,186 @@ func (ur *UserResource) handleGetProfile(w http.ResponseWriter, r *http.Request)
 	}
 	ur.encoder.StatusResponse(ctx, w, response, http.StatusOK)
 }
+
+// createAPITokenResponse is returned once on creation, the token cannot be retrieved later.
+type createAPITokenResponse struct {
+	Token   string           `json:"token"`
+	Details *domain.APIToken `json:"details"`
+}
+
+// handleListAPITokens lists the named API tokens of the user.
+func (ur *UserResource) handleListAPITokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := ur.userService.ListAPITokens(ctx, user.HashedUUID)
+	if err != nil {
+		ur.log.Error().Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to list API tokens")
+		ur.encoder.StatusInternalError(w)
+		return
+	}
+	if tokens == nil {
+		tokens = []domain.APIToken{}
+	}
+
+	ur.encoder.StatusResponse(ctx, w, tokens, http.StatusOK)
+}
+
+// handleCreateAPIToken creates a named API token and returns its plain value once.
+func (ur *UserResource) handleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
+		return
+	}
+
+	var req domain.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ur.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	plainToken, token, err := ur.userService.CreateAPIToken(ctx, user.HashedUUID, scopesFromContext(ctx), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, userservice.ErrInvalidAPITokenRequest):
+			ur.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+		case errors.Is(err, userservice.ErrAPITokenLimitReached):
+			ur.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusConflict}, http.StatusConflict)
+		default:
+			ur.log.Error().Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to create API token")
+			ur.encoder.StatusInternalError(w)
+		}
+		return
+	}
+
+	ur.encoder.StatusResponse(ctx, w, createAPITokenResponse{Token: plainToken, Details: token}, http.StatusCreated)
+}
+
+// handleRevokeAPIToken deletes a named API token of the user.
+func (ur *UserResource) handleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		ur.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid token id", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	if err := ur.userService.RevokeAPIToken(ctx, user.HashedUUID, id); err != nil {
+		if errors.Is(err, domain.ErrAPITokenNotFound) {
+			ur.encoder.StatusNotFound(ctx, w)
+			return
+		}
+		ur.log.Error().Err(err).Str("hashed_uuid", user.HashedUUID).Int64("token_id", id).Msg("Failed to revoke API token")
+		ur.encoder.StatusInternalError(w)
+		return
+	}
+
+	ur.encoder.NoContent(w)
+}
+
+type passwordStatusResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+type passwordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+}
+
+// handleGetPasswordStatus reports whether the bookmark login requires a password.
+func (ur *UserResource) handleGetPasswordStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
+		return
+	}
+
+	ur.encoder.StatusResponse(ctx, w, passwordStatusResponse{Enabled: user.HasPassword()}, http.StatusOK)
+}
+
+// handleSetPassword sets or changes the password required with the bookmark.
+func (ur *UserResource) handleSetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ur.passwordUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req passwordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ur.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	if err := ur.userService.SetPassword(ctx, user, req.CurrentPassword, req.Password); err != nil {
+		ur.writePasswordError(w, r, user, err)
+		return
+	}
+
+	ur.encoder.NoContent(w)
+}
+
+// handleRemovePassword removes the password, the bookmark alone logs in again.
+func (ur *UserResource) handleRemovePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := ur.passwordUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req passwordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ur.encoder.StatusResponse(ctx, w, errorResponse{Message: "invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	if err := ur.userService.RemovePassword(ctx, user, req.CurrentPassword); err != nil {
+		ur.writePasswordError(w, r, user, err)
+		return
+	}
+
+	ur.encoder.NoContent(w)
+}
+
+// passwordUser returns the user allowed to manage the password, named API tokens are not.
+func (ur *UserResource) passwordUser(w http.ResponseWriter, r *http.Request) (*domain.User, bool) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		ur.encoder.StatusResponse(ctx, w, map[string]string{"error": "Unauthorized: User context not available"}, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if apiToken, ok := ctx.Value(APITokenContextKey).(*domain.APIToken); ok && apiToken != nil {
+		ur.encoder.StatusResponse(ctx, w, errorResponse{Message: "Forbidden: the password cannot be changed with a named API token", Status: http.StatusForbidden}, http.StatusForbidden)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (ur *UserResource) writePasswordError(w http.ResponseWriter, r *http.Request, user *domain.User, err error) {
+	ctx := r.Context()
+	switch {
+	case errors.Is(err, userservice.ErrInvalidPassword):
+		ur.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusBadRequest}, http.StatusBadRequest)
+	case errors.Is(err, userservice.ErrPasswordMismatch):
+		ur.encoder.StatusResponse(ctx, w, errorResponse{Message: err.Error(), Status: http.StatusForbidden}, http.StatusForbidden)
+	default:
+		ur.log.Error().Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to update password")
+		ur.encoder.StatusInternalError(w)
+	}
+}
@@ -0,0 +1,274 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/passkey"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/rs/zerolog"
+)
+
+type passkeyService interface {
+	Enabled() bool
+	BeginRegistration(ctx context.Context, user *domain.User) (*protocol.CredentialCreation, error)
+	FinishRegistration(ctx context.Context, user *domain.User, name string, response []byte) (*domain.WebAuthnCredential, error)
+	BeginLogin(ctx context.Context) (ceremonyID string, assertion *protocol.CredentialAssertion, err error)
+	FinishLogin(ctx context.Context, ceremonyID string, response []byte) (*domain.User, error)
+	ListCredentials(ctx context.Context, userHashedUUID string) ([]domain.WebAuthnCredential, error)
+	DeleteCredential(ctx context.Context, user *domain.User, id int64) error
+	SetUUIDLoginDisabled(ctx context.Context, user *domain.User, disabled bool) error
+}
+
+type webauthnHandler struct {
+	log      zerolog.Logger
+	encoder  encoder
+	config   *domain.Config
+	service  passkeyService
+	sessions sessionService
+	audit    auditService
+}
+
+func newWebAuthnHandler(encoder encoder, log zerolog.Logger, config *domain.Config, sessions sessionService, audit auditService, service passkeyService) *webauthnHandler {
+	return &webauthnHandler{
+		log:      log.With().Str("handler", "webauthn").Logger(),
+		encoder:  encoder,
+		config:   config,
+		service:  service,
+		sessions: sessions,
+		audit:    audit,
+	}
+}
+
+// PublicRoutes are the passkey login ceremony, it needs no authentication.
+func (h webauthnHandler) PublicRoutes(r chi.Router) {
+	r.Get("/status", h.status)
+	r.Post("/login/begin", h.beginLogin)
+	r.Post("/login/finish", h.finishLogin)
+}
+
+// Routes manage the passkeys of the authenticated user, only from a web session.
+func (h webauthnHandler) Routes(r chi.Router) {
+	r.Post("/register/begin", h.beginRegistration)
+	r.Post("/register/finish", h.finishRegistration)
+	r.Get("/credentials", h.listCredentials)
+	r.Delete("/credentials/{id}", h.deleteCredential)
+	r.Put("/uuid-login", h.updateUUIDLogin)
+}
+
+type webauthnStatusResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+type beginLoginResponse struct {
+	SessionID string                        `json:"session_id"`
+	Options   *protocol.CredentialAssertion `json:"options"`
+}
+
+type finishLoginRequest struct {
+	SessionID  string          `json:"session_id"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type finishRegistrationRequest struct {
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type uuidLoginRequest struct {
+	Disabled bool `json:"disabled"`
+}
+
+func (h webauthnHandler) status(w http.ResponseWriter, r *http.Request) {
+	h.encoder.StatusResponse(r.Context(), w, webauthnStatusResponse{Enabled: h.service.Enabled()}, http.StatusOK)
+}
+
+func (h webauthnHandler) beginLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ceremonyID, assertion, err := h.service.BeginLogin(ctx)
+	if err != nil {
+		h.writeError(ctx, w, err, "Failed to begin passkey login")
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, beginLoginResponse{SessionID: ceremonyID, Options: assertion}, http.StatusOK)
+}
+
+func (h webauthnHandler) finishLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req finishLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	authenticatedUser, err := h.service.FinishLogin(ctx, req.SessionID, req.Credential)
+	if err != nil {
+		h.writeError(ctx, w, err, "Failed to finish passkey login")
+		return
+	}
+
+	if err := startSession(w, r, h.log, h.sessions, h.config.Server.BaseURL, authenticatedUser); err != nil {
+		h.log.Error().Err(err).Msg("Failed to start session after passkey login")
+		h.encoder.StatusInternalError(w)
+		return
+	}
+	h.audit.Record(ctx, newAuditEvent(r, domain.AuditActionLogin, authenticatedUser, map[string]string{"method": "passkey"}))
+
+	h.encoder.StatusResponse(ctx, w, loginResponse{
+		User:  authenticatedUser,
+		Token: authenticatedUser.HashedUUID,
+	}, http.StatusOK)
+}
+
+// sessionUser returns the user of a request authenticated with a web session.
+// Passkeys are bound to the browser, API tokens cannot manage them.
+func (h webauthnHandler) sessionUser(w http.ResponseWriter, r *http.Request) (*domain.User, bool) {
+	ctx := r.Context()
+	user, ok := ctx.Value(UserContextKey).(*domain.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized: User not found in context", http.StatusUnauthorized)
+		return nil, false
+	}
+	if currentSessionID(ctx) == "" {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Passkeys can only be managed from the web UI", Status: http.StatusForbidden}, http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+func (h webauthnHandler) beginRegistration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	creation, err := h.service.BeginRegistration(ctx, user)
+	if err != nil {
+		h.writeError(ctx, w, err, "Failed to begin passkey registration")
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, creation, http.StatusOK)
+}
+
+func (h webauthnHandler) finishRegistration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req finishRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	credential, err := h.service.FinishRegistration(ctx, user, req.Name, req.Credential)
+	if err != nil {
+		h.writeError(ctx, w, err, "Failed to finish passkey registration")
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, credential, http.StatusCreated)
+}
+
+func (h webauthnHandler) listCredentials(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	credentials, err := h.service.ListCredentials(ctx, user.HashedUUID)
+	if err != nil {
+		h.writeError(ctx, w, err, "Failed to list passkeys")
+		return
+	}
+
+	h.encoder.StatusResponse(ctx, w, credentials, http.StatusOK)
+}
+
+func (h webauthnHandler) deleteCredential(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		h.encoder.StatusNotFound(ctx, w)
+		return
+	}
+
+	if err := h.service.DeleteCredential(ctx, user, id); err != nil {
+		h.writeError(ctx, w, err, "Failed to remove passkey")
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+func (h webauthnHandler) updateUUIDLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req uuidLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.encoder.StatusResponse(ctx, w, errorResponse{Message: "Invalid request body", Status: http.StatusBadRequest}, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetUUIDLoginDisabled(ctx, user, req.Disabled); err != nil {
+		h.writeError(ctx, w, err, "Failed to update bookmark UUID login")
+		return
+	}
+
+	h.encoder.NoContent(w)
+}
+
+// writeError maps passkey errors to responses, unexpected ones are logged with msg.
+func (h webauthnHandler) writeError(ctx context.Context, w http.ResponseWriter, err error, msg string) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, passkey.ErrDisabled), errors.Is(err, domain.ErrWebAuthnCredentialNotFound):
+		h.encoder.StatusNotFound(ctx, w)
+		return
+	case errors.Is(err, passkey.ErrInvalidCeremony), errors.Is(err, passkey.ErrInvalidName):
+		status = http.StatusBadRequest
+	case errors.Is(err, passkey.ErrLoginFailed):
+		status = http.StatusUnauthorized
+	case errors.Is(err, passkey.ErrLimitReached), errors.Is(err, passkey.ErrNoPasskey), errors.Is(err, passkey.ErrLastPasskey):
+		status = http.StatusConflict
+	default:
+		h.log.Error().Err(err).Msg(msg)
+		h.encoder.StatusInternalError(w)
+		return
+	}
+
+	h.log.Debug().Err(err).Msg(msg)
+	h.encoder.StatusResponse(ctx, w, errorResponse{Message: passkeyErrorMessage(err), Status: status}, status)
+}
+
+// passkeyErrorMessage returns the message of the passkey error err wraps, without the verification details.
+func passkeyErrorMessage(err error) string {
+	for _, known := range []error{passkey.ErrInvalidCeremony, passkey.ErrInvalidName, passkey.ErrLoginFailed, passkey.ErrLimitReached, passkey.ErrNoPasskey, passkey.ErrLastPasskey} {
+		if errors.Is(err, known) {
+			return known.Error()
+		}
+	}
+	return err.Error()
+}
@@ -0,0 +1,270 @@
+// Package merge merges two accounts of the same person into one, for users who
+// registered once per device. The libraries of the other account are merged
+// into the libraries of the remaining one, its notification channels, named API
+// tokens, reading history and sync log are moved and the account is deleted.
+package merge
+
+import (
+	"context"
+
+	"github.com/flurbudurbur/Shiori/internal/backup"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrBookmarkRequired = pkgErrors.New("the bookmark of the account to merge is required")
+	ErrSameAccount      = pkgErrors.New("the bookmark belongs to this account")
+	ErrSlotLimitReached = pkgErrors.New("merging would exceed the slot limit")
+	ErrLibraryChanged   = pkgErrors.New("a library changed during the merge")
+)
+
+// userService is the part of user.Service checking the credentials of the other account.
+type userService interface {
+	AuthenticateByBookmark(ctx context.Context, hashedUUID string, password string, twoFactorCode string, ip string) (*domain.User, error)
+}
+
+// sessionService is the part of session.Service ending the sessions of the merged account.
+type sessionService interface {
+	RevokeAll(ctx context.Context, userHashedUUID string, exceptID string) error
+}
+
+// tokenService is the part of accesstoken.Service ending the access tokens of the merged account.
+type tokenService interface {
+	RevokeUser(ctx context.Context, userHashedUUID string) error
+}
+
+type auditRecorder interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
+
+type Service interface {
+	// Merge merges the account of req.Bookmark into user, the bookmark has to pass the login
+	// checks including its password and two-factor code. With req.DryRun nothing is changed,
+	// the summary previews the merge.
+	Merge(ctx context.Context, user *domain.User, req Request, ip string) (*Summary, error)
+}
+
+// Request proves the ownership of the account merged into the current one.
+type Request struct {
+	Bookmark      string `json:"bookmark"`
+	Password      string `json:"password"`
+	TwoFactorCode string `json:"two_factor_code"`
+	DryRun        bool   `json:"dry_run"`
+}
+
+// Summary describes what a merge takes over from the other account.
+type Summary struct {
+	DryRun               bool          `json:"dry_run"`
+	Slots                []SlotSummary `json:"slots"`
+	NotificationChannels int           `json:"notification_channels"`
+	APITokens            int           `json:"api_tokens"` // Named API tokens, including paired devices
+}
+
+// SlotSummary describes the merge of a library into the slot of the same name.
+type SlotSummary struct {
+	Name    string            `json:"name"`
+	Created bool              `json:"created"` // The slot did not exist in the remaining account
+	Library backup.MergeStats `json:"library"`
+
+	slot   string  // Slot key, empty for the default slot
+	data   []byte  // Merged library
+	etag   *string // ETag of the library it replaces, nil if there is none
+	create bool    // Whether the slot has to be created first
+}
+
+type service struct {
+	log              zerolog.Logger
+	cfg              *domain.Config
+	userRepo         domain.UserRepo
+	syncRepo         domain.SyncRepo
+	notificationRepo domain.NotificationRepo
+	apiTokenRepo     domain.APITokenRepo
+	users            userService
+	sessions         sessionService
+	tokens           tokenService
+	audit            auditRecorder
+}
+
+func NewService(log logger.Logger, cfg *domain.Config, userRepo domain.UserRepo, syncRepo domain.SyncRepo, notificationRepo domain.NotificationRepo, apiTokenRepo domain.APITokenRepo, users userService, sessions sessionService, tokens tokenService, audit auditRecorder) Service {
+	return &service{
+		log:              log.With().Str("module", "merge").Logger(),
+		cfg:              cfg,
+		userRepo:         userRepo,
+		syncRepo:         syncRepo,
+		notificationRepo: notificationRepo,
+		apiTokenRepo:     apiTokenRepo,
+		users:            users,
+		sessions:         sessions,
+		tokens:           tokens,
+		audit:            audit,
+	}
+}
+
+func (s *service) Merge(ctx context.Context, user *domain.User, req Request, ip string) (*Summary, error) {
+	if req.Bookmark == "" {
+		return nil, ErrBookmarkRequired
+	}
+
+	// Failures count towards the lockout of the bookmark like a login
+	other, err := s.users.AuthenticateByBookmark(ctx, req.Bookmark, req.Password, req.TwoFactorCode, ip)
+	if err != nil {
+		return nil, err
+	}
+	if other.HashedUUID == user.HashedUUID {
+		return nil, ErrSameAccount
+	}
+
+	summary := &Summary{DryRun: req.DryRun}
+	if summary.Slots, err = s.planSlots(ctx, user, other); err != nil {
+		return nil, err
+	}
+
+	userHashedUUID := other.HashedUUID
+	if _, summary.NotificationChannels, err = s.notificationRepo.Find(ctx, domain.NotificationQueryParams{UserHashedUUID: &userHashedUUID}); err != nil {
+		return nil, pkgErrors.Wrap(err, "could not count notification channels")
+	}
+	tokens, err := s.apiTokenRepo.FindByUser(ctx, other.HashedUUID)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not count API tokens")
+	}
+	summary.APITokens = len(tokens)
+
+	if req.DryRun {
+		return summary, nil
+	}
+
+	// Libraries are written before the account is deleted, merging a library
+	// again after a failure adds nothing twice
+	for _, slot := range summary.Slots {
+		if err := s.storeSlot(ctx, user, slot); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.userRepo.MergeInto(ctx, other.HashedUUID, user.HashedUUID); err != nil {
+		return nil, pkgErrors.Wrap(err, "could not merge account")
+	}
+
+	// Sessions of the merged account no longer find their user, ending them is cleanup
+	if err := s.sessions.RevokeAll(ctx, other.HashedUUID, ""); err != nil {
+		s.log.Error().Err(err).Str("hashed_uuid", other.HashedUUID).Msg("could not end sessions of merged account")
+	}
+	// Access tokens are not checked against the user, they would keep acting on the merged account
+	if err := s.tokens.RevokeUser(ctx, other.HashedUUID); err != nil {
+		s.log.Error().Err(err).Str("hashed_uuid", other.HashedUUID).Msg("could not revoke access tokens of merged account")
+	}
+
+	s.audit.Record(ctx, domain.AuditEvent{
+		Action:   domain.AuditActionMergeAccount,
+		ActorID:  user.PublicID,
+		TargetID: user.PublicID,
+		IP:       ip,
+		Details:  map[string]string{"merged_id": other.PublicID},
+	})
+
+	s.log.Info().Str("hashed_uuid", user.HashedUUID).Str("merged_hashed_uuid", other.HashedUUID).Msg("account merged")
+	return summary, nil
+}
+
+// planSlots merges every library of other into the slot of the same name of
+// user, in memory. Named slots user does not have yet are created, within the slot limit.
+func (s *service) planSlots(ctx context.Context, user *domain.User, other *domain.User) ([]SlotSummary, error) {
+	otherSlots, err := s.syncRepo.ListSlots(ctx, other.HashedUUID)
+	if err != nil {
+		return nil, err
+	}
+	ownSlots, err := s.syncRepo.ListSlots(ctx, user.HashedUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]bool{}
+	named := 0
+	for _, slot := range ownSlots {
+		existing[slot.Name] = true
+		if !slot.Default {
+			named++
+		}
+	}
+
+	summaries := []SlotSummary{}
+	for _, slot := range otherSlots {
+		if slot.Size == 0 {
+			continue
+		}
+
+		key := slot.Name
+		if slot.Default {
+			key = domain.DefaultSyncSlot
+		}
+
+		otherData, _, err := s.syncRepo.GetSyncDataAndETag(ctx, other.HashedUUID, key)
+		if err != nil {
+			return nil, err
+		}
+		summary := SlotSummary{Name: slot.Name, slot: key}
+
+		if !existing[slot.Name] {
+			named++
+			if named > s.cfg.Sync.MaxSlots {
+				return nil, ErrSlotLimitReached
+			}
+			summary.Created = true
+			summary.create = true
+		} else {
+			if summary.data, summary.etag, err = s.syncRepo.GetSyncDataAndETag(ctx, user.HashedUUID, key); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(summary.data) == 0 {
+			// Nothing to merge with, the library is taken over as it is
+			library, err := backup.Decode(otherData)
+			if err != nil {
+				return nil, pkgErrors.Wrap(err, "could not read library %s", slot.Name)
+			}
+			summary.data = otherData
+			summary.etag = nil
+			summary.Library = backup.MergeStats{MangaAdded: len(library.Manga), CategoriesAdded: len(library.Categories)}
+		} else {
+			merged, stats, err := backup.Merge(summary.data, otherData)
+			if err != nil {
+				return nil, pkgErrors.Wrap(err, "could not merge library %s", slot.Name)
+			}
+			summary.data = merged
+			summary.Library = *stats
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+// storeSlot writes a merged library, unless it changed since it was read. It
+// writes through the repository rather than sync.Service, the reading events of
+// the other account are moved with it and would otherwise be derived twice.
+func (s *service) storeSlot(ctx context.Context, user *domain.User, slot SlotSummary) error {
+	if slot.create {
+		if _, err := s.syncRepo.CreateSlot(ctx, user.HashedUUID, slot.slot); err != nil {
+			return err
+		}
+	}
+
+	if slot.etag == nil {
+		_, err := s.syncRepo.SetSyncData(ctx, user.HashedUUID, slot.slot, slot.data)
+		return err
+	}
+
+	etag, err := s.syncRepo.SetSyncDataIfMatch(ctx, user.HashedUUID, slot.slot, *slot.etag, slot.data)
+	if err != nil {
+		return err
+	}
+	if etag == nil {
+		return ErrLibraryChanged
+	}
+	return nil
+}
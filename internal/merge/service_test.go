@@ -0,0 +1,176 @@
+package merge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/audit"
+	"github.com/flurbudurbur/Shiori/internal/backup"
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUserService struct {
+	users map[string]*domain.User
+}
+
+func (s *fakeUserService) AuthenticateByBookmark(ctx context.Context, hashedUUID string, password string, twoFactorCode string, ip string) (*domain.User, error) {
+	u, ok := s.users[hashedUUID]
+	if !ok {
+		return nil, user.ErrAuthenticationFailed
+	}
+	return u, nil
+}
+
+// fakeSessionService records whose sessions and access tokens were ended.
+type fakeSessionService struct {
+	revoked       []string
+	tokensRevoked []string
+}
+
+func (s *fakeSessionService) RevokeAll(ctx context.Context, userHashedUUID string, exceptID string) error {
+	s.revoked = append(s.revoked, userHashedUUID)
+	return nil
+}
+
+func (s *fakeSessionService) RevokeUser(ctx context.Context, userHashedUUID string) error {
+	s.tokensRevoked = append(s.tokensRevoked, userHashedUUID)
+	return nil
+}
+
+var (
+	current = &domain.User{HashedUUID: "current", PublicID: "current-public"}
+	other   = &domain.User{HashedUUID: "other", PublicID: "other-public"}
+
+	otherTablet = `{"backupManga": [{"source": 1, "url": "/manga/3"}], "backupCategories": [{"name": "Reading", "order": 1}]}`
+)
+
+// newTestService seeds both accounts, the other one has a named slot, two notification
+// channels and an API token. A user may have a single named slot.
+func newTestService(t *testing.T) (*service, *fakeSessionService, domain.AuditEventRepo) {
+	db, log := databasetest.New(t)
+	ctx := context.Background()
+	cfg := &domain.Config{Sync: domain.SyncConfig{MaxSlots: 1}}
+
+	userRepo := database.NewUserRepo(log, db)
+	databasetest.StoreUsers(t, userRepo, *current, *other)
+	notificationRepo := database.NewNotificationRepo(log, db)
+	apiTokenRepo := database.NewAPITokenRepo(log, db)
+	auditRepo := database.NewAuditEventRepo(log, db)
+	sessions := &fakeSessionService{}
+	users := &fakeUserService{users: map[string]*domain.User{"current": current, "other": other}}
+	svc := NewService(log, cfg, userRepo, database.NewSyncRepo(log, db), notificationRepo, apiTokenRepo, users, sessions, sessions, audit.NewService(log, auditRepo)).(*service)
+
+	setData(t, svc, "current", domain.DefaultSyncSlot, `{"backupManga": [{"source": 1, "url": "/manga/1", "chapters": [{"url": "/chapter/1"}]}]}`)
+	setData(t, svc, "other", domain.DefaultSyncSlot, `{"backupManga": [{"source": 1, "url": "/manga/1", "chapters": [{"url": "/chapter/1", "read": true}]}, {"source": 1, "url": "/manga/2"}]}`)
+	setData(t, svc, "other", "tablet", otherTablet)
+	for _, name := range []string{"discord", "ntfy"} {
+		_, err := notificationRepo.Store(ctx, domain.Notification{UserHashedUUID: "other", Name: name})
+		require.NoError(t, err)
+	}
+	require.NoError(t, apiTokenRepo.Store(ctx, &domain.APIToken{UserHashedUUID: "other", Name: "Phone", LookupID: "phone"}))
+
+	return svc, sessions, auditRepo
+}
+
+// setData stores data in the slot, creating a named slot first.
+func setData(t *testing.T, svc *service, userHashedUUID string, slot string, data string) {
+	ctx := context.Background()
+	if slot != domain.DefaultSyncSlot {
+		_, err := svc.syncRepo.CreateSlot(ctx, userHashedUUID, slot)
+		require.NoError(t, err)
+	}
+	_, err := svc.syncRepo.SetSyncData(ctx, userHashedUUID, slot, []byte(data))
+	require.NoError(t, err)
+}
+
+func slotData(t *testing.T, svc *service, userHashedUUID string, slot string) []byte {
+	data, _, err := svc.syncRepo.GetSyncDataAndETag(context.Background(), userHashedUUID, slot)
+	require.NoError(t, err)
+	return data
+}
+
+// merged reports whether the other account was merged away.
+func merged(t *testing.T, svc *service) bool {
+	u, err := svc.userRepo.FindByHashedUUID(context.Background(), "other")
+	require.NoError(t, err)
+	return u == nil
+}
+
+func TestService_MergeDryRun(t *testing.T) {
+	svc, _, auditRepo := newTestService(t)
+	before := string(slotData(t, svc, "current", domain.DefaultSyncSlot))
+
+	summary, err := svc.Merge(context.Background(), current, Request{Bookmark: "other", DryRun: true}, "192.0.2.1")
+	require.NoError(t, err)
+
+	assert.True(t, summary.DryRun)
+	assert.Equal(t, 2, summary.NotificationChannels)
+	assert.Equal(t, 1, summary.APITokens)
+	require.Len(t, summary.Slots, 2)
+	assert.Equal(t, domain.DefaultSyncSlotName, summary.Slots[0].Name)
+	assert.Equal(t, backup.MergeStats{MangaAdded: 1, MangaMerged: 1, ChaptersUpdated: 1}, summary.Slots[0].Library)
+	assert.Equal(t, "tablet", summary.Slots[1].Name)
+	assert.True(t, summary.Slots[1].Created)
+	assert.Equal(t, backup.MergeStats{MangaAdded: 1, CategoriesAdded: 1}, summary.Slots[1].Library)
+
+	// A preview changes nothing
+	assert.Equal(t, before, string(slotData(t, svc, "current", domain.DefaultSyncSlot)))
+	assert.Nil(t, slotData(t, svc, "current", "tablet"))
+	assert.False(t, merged(t, svc))
+	assert.Empty(t, databasetest.AuditEvents(t, auditRepo))
+}
+
+func TestService_Merge(t *testing.T) {
+	svc, sessions, auditRepo := newTestService(t)
+
+	summary, err := svc.Merge(context.Background(), current, Request{Bookmark: "other"}, "192.0.2.1")
+	require.NoError(t, err)
+	assert.False(t, summary.DryRun)
+
+	library, err := backup.Decode(slotData(t, svc, "current", domain.DefaultSyncSlot))
+	require.NoError(t, err)
+	require.Len(t, library.Manga, 2)
+	assert.True(t, library.Manga[0].Chapters[0].Read)
+	assert.Equal(t, otherTablet, string(slotData(t, svc, "current", "tablet")))
+
+	assert.True(t, merged(t, svc))
+	assert.Equal(t, []string{"other"}, sessions.revoked)
+	assert.Equal(t, []string{"other"}, sessions.tokensRevoked)
+	events := databasetest.AuditEvents(t, auditRepo)
+	require.Len(t, events, 1)
+	assert.Equal(t, domain.AuditActionMergeAccount, events[0].Action)
+	assert.Equal(t, "current-public", events[0].TargetID)
+	assert.Equal(t, "other-public", events[0].Details["merged_id"])
+}
+
+func TestService_MergeRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     Request
+		slot    string // A named slot the current account has
+		wantErr error
+	}{
+		{name: "no_bookmark", req: Request{}, wantErr: ErrBookmarkRequired},
+		{name: "unknown", req: Request{Bookmark: "unknown"}, wantErr: user.ErrAuthenticationFailed},
+		{name: "same_account", req: Request{Bookmark: "current"}, wantErr: ErrSameAccount},
+		// The named slot of the other account does not fit into the remaining one
+		{name: "slot_limit", req: Request{Bookmark: "other", DryRun: true}, slot: "phone", wantErr: ErrSlotLimitReached},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _, _ := newTestService(t)
+			if tt.slot != "" {
+				setData(t, svc, "current", tt.slot, `{}`)
+			}
+
+			_, err := svc.Merge(context.Background(), current, tt.req, "192.0.2.1")
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.False(t, merged(t, svc))
+		})
+	}
+}
@@ -18,6 +18,9 @@ type Service interface {
 	Update(ctx context.Context, n domain.Notification) (*domain.Notification, error)
 	Delete(ctx context.Context, id int) error
 	Send(event domain.NotificationEvent, payload domain.NotificationPayload)
+	// SendToUser sends to the enabled channels of a user, whether or not they selected the event.
+	// It is meant for notices about the account itself, which the user must not miss.
+	SendToUser(ctx context.Context, userHashedUUID string, payload domain.NotificationPayload) error
 	Test(ctx context.Context, notification domain.Notification) error
 }
 
@@ -116,13 +119,8 @@ func (s *service) registerSenders() {
 
 	for _, n := range senders {
 		if n.Enabled {
-			switch n.Type {
-			case domain.NotificationTypeDiscord:
-				s.senders = append(s.senders, NewDiscordSender(s.log, n))
-			case domain.NotificationTypeNotifiarr:
-				s.senders = append(s.senders, NewNotifiarrSender(s.log, n))
-			case domain.NotificationTypeTelegram:
-				s.senders = append(s.senders, NewTelegramSender(s.log, n))
+			if sender := s.newSender(n); sender != nil {
+				s.senders = append(s.senders, sender)
 			}
 		}
 	}
@@ -130,6 +128,19 @@ func (s *service) registerSenders() {
 	return
 }
 
+// newSender returns the sender of a channel, nil for unsupported types.
+func (s *service) newSender(n domain.Notification) domain.NotificationSender {
+	switch n.Type {
+	case domain.NotificationTypeDiscord:
+		return NewDiscordSender(s.log, n)
+	case domain.NotificationTypeNotifiarr:
+		return NewNotifiarrSender(s.log, n)
+	case domain.NotificationTypeTelegram:
+		return NewTelegramSender(s.log, n)
+	}
+	return nil
+}
+
 // Send notifications
 func (s *service) Send(event domain.NotificationEvent, payload domain.NotificationPayload) {
 	if len(s.senders) > 0 {
@@ -148,6 +159,31 @@ func (s *service) Send(event domain.NotificationEvent, payload domain.Notificati
 	return
 }
 
+// SendToUser sends a payload to every enabled channel of a user.
+func (s *service) SendToUser(ctx context.Context, userHashedUUID string, payload domain.NotificationPayload) error {
+	channels, _, err := s.repo.Find(ctx, domain.NotificationQueryParams{UserHashedUUID: &userHashedUUID})
+	if err != nil {
+		return err
+	}
+
+	var sendErr error
+	for _, n := range channels {
+		if !n.Enabled {
+			continue
+		}
+		sender := s.newSender(n)
+		if sender == nil {
+			continue
+		}
+		if err := sender.Send(payload.Event, payload); err != nil {
+			s.log.Error().Err(err).Msgf("could not send %v notification to %v", payload.Event, n.Type)
+			sendErr = err
+		}
+	}
+
+	return sendErr
+}
+
 func (s *service) Test(ctx context.Context, notification domain.Notification) error {
 	var agent domain.NotificationSender
 
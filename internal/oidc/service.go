@@ -0,0 +1,302 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	valkeyClient "github.com/valkey-io/valkey-go"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrDisabled = pkgErrors.New("oidc login is not enabled")
+	// ErrInvalidState is returned for callbacks without a pending login, e.g. replayed or expired ones.
+	ErrInvalidState = pkgErrors.New("invalid or expired oidc state")
+	// ErrIdentityNotLinked is returned when the identity has no user and auto provisioning is off.
+	ErrIdentityNotLinked = pkgErrors.New("oidc identity is not linked to a user")
+	// ErrIdentityLinked is returned when linking an identity that already belongs to another user.
+	ErrIdentityLinked = pkgErrors.New("oidc identity is linked to another user")
+)
+
+const (
+	// stateTTL is how long a login may take at the provider.
+	stateTTL       = 10 * time.Minute
+	stateKeyPrefix = "oidc:state:"
+)
+
+// userService is the part of user.Service needed to log in and provision users.
+type userService interface {
+	RegisterNewUser(ctx context.Context) (hashedUUID string, plainToken string, err error)
+	GetUserForAuthentication(ctx context.Context, hashedUUID string) (*domain.User, error)
+}
+
+// registrar is the part of registration.Service that decides whether a user may be provisioned.
+type registrar interface {
+	AdmitProvisioned(ctx context.Context) error
+}
+
+type Service interface {
+	Enabled() bool
+	// AuthCodeURL starts a login and returns the state and the provider URL to redirect the browser to.
+	// When linkUserHashedUUID is set the identity is linked to that user instead of logging in.
+	AuthCodeURL(ctx context.Context, linkUserHashedUUID string) (state string, url string, err error)
+	// Callback completes a login with the state and code of the provider redirect and returns the user to log in.
+	Callback(ctx context.Context, state string, code string) (*domain.User, error)
+}
+
+// pendingLogin is stored in Valkey between the redirect to the provider and the callback.
+type pendingLogin struct {
+	Verifier           string `json:"verifier"`
+	Nonce              string `json:"nonce"`
+	LinkUserHashedUUID string `json:"link_user_hashed_uuid,omitempty"`
+}
+
+type service struct {
+	log       zerolog.Logger
+	cfg       domain.OIDCConfig
+	repo      domain.OIDCIdentityRepo
+	userSvc   userService
+	registrar registrar
+	client    valkeyClient.Client
+
+	// provider is discovered on first use, so the server starts while the provider is unreachable
+	m        sync.Mutex
+	provider *gooidc.Provider
+}
+
+func NewService(log logger.Logger, cfg domain.OIDCConfig, repo domain.OIDCIdentityRepo, userSvc userService, registrar registrar, client valkeyClient.Client) Service {
+	return &service{
+		log:       log.With().Str("module", "oidc").Logger(),
+		cfg:       cfg,
+		repo:      repo,
+		userSvc:   userSvc,
+		registrar: registrar,
+		client:    client,
+	}
+}
+
+func (s *service) Enabled() bool {
+	return s.cfg.Enabled && s.cfg.Issuer != "" && s.cfg.ClientID != ""
+}
+
+// discover returns the provider, fetching its discovery document if that did not succeed yet.
+func (s *service) discover(ctx context.Context) (*gooidc.Provider, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.provider != nil {
+		return s.provider, nil
+	}
+
+	provider, err := gooidc.NewProvider(ctx, s.cfg.Issuer)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not discover oidc provider %s", s.cfg.Issuer)
+	}
+
+	s.provider = provider
+	return provider, nil
+}
+
+func (s *service) oauth2Config(provider *gooidc.Provider) *oauth2.Config {
+	scopes := []string{gooidc.ScopeOpenID}
+	for _, scope := range strings.Split(s.cfg.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" && scope != gooidc.ScopeOpenID {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return &oauth2.Config{
+		ClientID:     s.cfg.ClientID,
+		ClientSecret: s.cfg.ClientSecret,
+		RedirectURL:  s.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+}
+
+func (s *service) AuthCodeURL(ctx context.Context, linkUserHashedUUID string) (string, string, error) {
+	if !s.Enabled() {
+		return "", "", ErrDisabled
+	}
+
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	pending := pendingLogin{
+		Verifier:           oauth2.GenerateVerifier(),
+		Nonce:              nonce,
+		LinkUserHashedUUID: linkUserHashedUUID,
+	}
+	encoded, err := json.Marshal(pending)
+	if err != nil {
+		return "", "", pkgErrors.Wrap(err, "could not encode oidc state")
+	}
+	if err := s.client.Do(ctx, s.client.B().Set().Key(stateKeyPrefix+state).Value(string(encoded)).Ex(stateTTL).Build()).Error(); err != nil {
+		return "", "", pkgErrors.Wrap(err, "could not store oidc state")
+	}
+
+	url := s.oauth2Config(provider).AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(pending.Verifier))
+	return state, url, nil
+}
+
+// takePendingLogin returns and removes the pending login of a state, so every state is used once.
+func (s *service) takePendingLogin(ctx context.Context, state string) (*pendingLogin, error) {
+	value, err := s.client.Do(ctx, s.client.B().Getdel().Key(stateKeyPrefix+state).Build()).ToString()
+	if err != nil {
+		if errors.Is(err, valkeyClient.Nil) {
+			return nil, ErrInvalidState
+		}
+		return nil, pkgErrors.Wrap(err, "could not read oidc state")
+	}
+
+	var pending pendingLogin
+	if err := json.Unmarshal([]byte(value), &pending); err != nil {
+		return nil, pkgErrors.Wrap(err, "could not decode oidc state")
+	}
+	return &pending, nil
+}
+
+func (s *service) Callback(ctx context.Context, state string, code string) (*domain.User, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	if state == "" || code == "" {
+		return nil, ErrInvalidState
+	}
+
+	pending, err := s.takePendingLogin(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not exchange oidc code")
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, pkgErrors.New("oidc token response has no id_token")
+	}
+
+	idToken, err := provider.Verifier(&gooidc.Config{ClientID: s.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not verify oidc id token")
+	}
+	if idToken.Nonce != pending.Nonce {
+		return nil, pkgErrors.New("oidc id token nonce does not match")
+	}
+
+	var claims struct {
+		Email string `json:"email"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, pkgErrors.Wrap(err, "could not decode oidc claims")
+	}
+
+	identity, err := s.repo.FindBySubject(ctx, s.cfg.Issuer, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity == nil {
+		return s.linkIdentity(ctx, pending.LinkUserHashedUUID, idToken.Subject, claims.Email)
+	}
+
+	if pending.LinkUserHashedUUID != "" && pending.LinkUserHashedUUID != identity.UserHashedUUID {
+		return nil, ErrIdentityLinked
+	}
+
+	user, err := s.userSvc.GetUserForAuthentication(ctx, identity.UserHashedUUID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrIdentityNotLinked
+	}
+
+	if err := s.repo.RecordLogin(ctx, identity.ID, time.Now()); err != nil {
+		s.log.Error().Err(err).Int64("identity_id", identity.ID).Msg("could not record oidc login")
+	}
+
+	s.log.Info().Str("hashed_uuid", user.HashedUUID).Msg("oidc login")
+	return user, nil
+}
+
+// linkIdentity stores a new identity for the user linking it, or for a new
+// user when auto provisioning is enabled.
+func (s *service) linkIdentity(ctx context.Context, userHashedUUID string, subject string, email string) (*domain.User, error) {
+	if userHashedUUID == "" {
+		if !s.cfg.AutoProvision {
+			return nil, ErrIdentityNotLinked
+		}
+		if err := s.registrar.AdmitProvisioned(ctx); err != nil {
+			return nil, err
+		}
+
+		hashedUUID, _, err := s.userSvc.RegisterNewUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+		userHashedUUID = hashedUUID
+		s.log.Info().Str("hashed_uuid", userHashedUUID).Msg("provisioned user for oidc identity")
+	}
+
+	user, err := s.userSvc.GetUserForAuthentication(ctx, userHashedUUID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrIdentityNotLinked
+	}
+
+	now := time.Now()
+	identity := &domain.OIDCIdentity{
+		Issuer:         s.cfg.Issuer,
+		Subject:        subject,
+		UserHashedUUID: userHashedUUID,
+		Email:          email,
+		LastLoginAt:    &now,
+	}
+	if err := s.repo.Store(ctx, identity); err != nil {
+		return nil, err
+	}
+
+	s.log.Info().Str("hashed_uuid", userHashedUUID).Msg("linked oidc identity")
+	return user, nil
+}
+
+func randomString() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", pkgErrors.Wrap(err, "could not generate random string")
+	}
+	return hex.EncodeToString(raw), nil
+}
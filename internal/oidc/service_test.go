@@ -0,0 +1,279 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+const testClientID = "shiori"
+
+// mockProvider is a minimal OpenID Connect provider: discovery, JWKS and a
+// token endpoint checking PKCE. Codes are issued directly by the test.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	m     sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	subject   string
+	nonce     string
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &mockProvider{key: key, codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.handleToken)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize plays the login at the provider for the redirect URL and returns the code.
+func (p *mockProvider) authorize(t *testing.T, redirectURL string, subject string) string {
+	parsed, err := url.Parse(redirectURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	code := rand.Text()
+	p.m.Lock()
+	p.codes[code] = mockGrant{subject: subject, nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+	p.m.Unlock()
+	return code
+}
+
+func (p *mockProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.m.Lock()
+	grant, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.m.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.idToken(grant),
+	})
+}
+
+func (p *mockProvider) idToken(grant mockGrant) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(map[string]any{
+		"iss":   p.server.URL,
+		"sub":   grant.subject,
+		"aud":   testClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": grant.nonce,
+		"email": grant.subject + "@example.com",
+	})
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// fakeUserService registers users straight into the repository.
+type fakeUserService struct {
+	repo domain.UserRepo
+}
+
+func (s *fakeUserService) RegisterNewUser(ctx context.Context) (string, string, error) {
+	hashedUUID := rand.Text()
+	return hashedUUID, "shi_token", s.repo.Store(ctx, domain.User{HashedUUID: hashedUUID, APITokenHash: hashedUUID})
+}
+
+func (s *fakeUserService) GetUserForAuthentication(ctx context.Context, hashedUUID string) (*domain.User, error) {
+	return s.repo.FindByHashedUUID(ctx, hashedUUID)
+}
+
+// fakeRegistrar refuses to provision users with err.
+type fakeRegistrar struct {
+	err error
+}
+
+func (r *fakeRegistrar) AdmitProvisioned(context.Context) error {
+	return r.err
+}
+
+// newTestService logs in at the issuer, the users bookmark and other exist.
+func newTestService(t *testing.T, issuer string, autoProvision bool, admit error) (Service, domain.UserRepo) {
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	db, log := databasetest.New(t)
+	users := database.NewUserRepo(log, db)
+	databasetest.StoreUsers(t, users, domain.User{HashedUUID: "bookmark"}, domain.User{HashedUUID: "other"})
+
+	cfg := domain.OIDCConfig{
+		Enabled:       true,
+		Issuer:        issuer,
+		ClientID:      testClientID,
+		ClientSecret:  "secret",
+		RedirectURL:   "http://shiori.test/api/auth/oidc/callback",
+		Scopes:        "email",
+		AutoProvision: autoProvision,
+	}
+	return NewService(log, cfg, database.NewOIDCIdentityRepo(log, db), &fakeUserService{repo: users}, &fakeRegistrar{err: admit}, client), users
+}
+
+func login(t *testing.T, ctx context.Context, svc Service, provider *mockProvider, subject string, linkUserHashedUUID string) (*domain.User, error) {
+	state, redirectURL, err := svc.AuthCodeURL(ctx, linkUserHashedUUID)
+	require.NoError(t, err)
+	return svc.Callback(ctx, state, provider.authorize(t, redirectURL, subject))
+}
+
+func TestService_FirstLogin(t *testing.T) {
+	errClosed := errors.New("registration is closed")
+
+	tests := []struct {
+		name          string
+		autoProvision bool
+		admit         error
+		link          string
+		want          string // The user logged in, empty for a provisioned one
+		users         int
+		wantErr       error
+	}{
+		{name: "auto_provision", autoProvision: true, users: 3},
+		{name: "not_linked", wantErr: ErrIdentityNotLinked, users: 2},
+		{name: "provisioning_refused", autoProvision: true, admit: errClosed, wantErr: errClosed, users: 2},
+		{name: "link", link: "bookmark", want: "bookmark", users: 2},
+		// Linking an existing user creates no account and is still possible
+		{name: "link_while_closed", autoProvision: true, admit: errClosed, link: "bookmark", want: "bookmark", users: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			provider := newMockProvider(t)
+			svc, users := newTestService(t, provider.server.URL, tt.autoProvision, tt.admit)
+
+			user, err := login(t, ctx, svc, provider, "alice", tt.link)
+			count, countErr := users.GetUserCount(ctx)
+			require.NoError(t, countErr)
+			assert.Equal(t, tt.users, count)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			if tt.want != "" {
+				assert.Equal(t, tt.want, user.HashedUUID)
+			}
+
+			// The identity logs in as the same user from now on
+			again, err := login(t, ctx, svc, provider, "alice", "")
+			require.NoError(t, err)
+			assert.Equal(t, user.HashedUUID, again.HashedUUID)
+		})
+	}
+}
+
+func TestService_Link(t *testing.T) {
+	ctx := context.Background()
+	provider := newMockProvider(t)
+	svc, _ := newTestService(t, provider.server.URL, false, nil)
+
+	linked, err := login(t, ctx, svc, provider, "alice", "bookmark")
+	require.NoError(t, err)
+	assert.Equal(t, "bookmark", linked.HashedUUID)
+
+	// An identity is linked to a single user
+	_, err = login(t, ctx, svc, provider, "alice", "other")
+	assert.ErrorIs(t, err, ErrIdentityLinked)
+}
+
+func TestService_Callback(t *testing.T) {
+	ctx := context.Background()
+	provider := newMockProvider(t)
+	svc, _ := newTestService(t, provider.server.URL, true, nil)
+
+	state, redirectURL, err := svc.AuthCodeURL(ctx, "")
+	require.NoError(t, err)
+	code := provider.authorize(t, redirectURL, "alice")
+
+	_, err = svc.Callback(ctx, "unknown", code)
+	assert.ErrorIs(t, err, ErrInvalidState)
+
+	_, err = svc.Callback(ctx, state, code)
+	require.NoError(t, err)
+
+	// States are single use
+	_, err = svc.Callback(ctx, state, code)
+	assert.ErrorIs(t, err, ErrInvalidState)
+
+	// A code that was not issued for the state's PKCE verifier is rejected by the provider
+	state, _, err = svc.AuthCodeURL(ctx, "")
+	require.NoError(t, err)
+	_, err = svc.Callback(ctx, state, code)
+	assert.Error(t, err)
+}
@@ -0,0 +1,392 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/rs/zerolog"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+var (
+	ErrDisabled = pkgErrors.New("passkeys are not enabled")
+	// ErrInvalidCeremony is returned when finishing a registration or login that was not started or expired.
+	ErrInvalidCeremony = pkgErrors.New("invalid or expired passkey ceremony")
+	ErrLoginFailed     = pkgErrors.New("passkey login failed")
+	ErrInvalidName     = pkgErrors.New("invalid passkey name")
+	ErrLimitReached    = pkgErrors.New("passkey limit reached")
+	// ErrNoPasskey is returned when turning the UUID login off without a passkey to log in with.
+	ErrNoPasskey = pkgErrors.New("the bookmark UUID login can only be disabled with a registered passkey")
+	// ErrLastPasskey is returned when removing the only way left to log in.
+	ErrLastPasskey = pkgErrors.New("the last passkey cannot be removed while the bookmark UUID login is disabled")
+)
+
+const (
+	maxPasskeys          = 20
+	maxPasskeyNameLength = 64
+	defaultPasskeyName   = "Passkey"
+
+	// ceremonyTTL is how long the browser may take between begin and finish.
+	ceremonyTTL           = 5 * time.Minute
+	registrationKeyPrefix = "webauthn:registration:"
+	loginKeyPrefix        = "webauthn:login:"
+)
+
+// userService is the part of user.Service needed to load users.
+type userService interface {
+	GetUserForAuthentication(ctx context.Context, hashedUUID string) (*domain.User, error)
+}
+
+type Service interface {
+	Enabled() bool
+	// BeginRegistration returns the options for navigator.credentials.create() of a new passkey.
+	BeginRegistration(ctx context.Context, user *domain.User) (*protocol.CredentialCreation, error)
+	// FinishRegistration verifies the response of navigator.credentials.create() and stores the passkey.
+	FinishRegistration(ctx context.Context, user *domain.User, name string, response []byte) (*domain.WebAuthnCredential, error)
+	// BeginLogin returns the ceremony ID and the options for navigator.credentials.get().
+	BeginLogin(ctx context.Context) (ceremonyID string, assertion *protocol.CredentialAssertion, err error)
+	// FinishLogin verifies the response of navigator.credentials.get() and returns the user to log in.
+	FinishLogin(ctx context.Context, ceremonyID string, response []byte) (*domain.User, error)
+	ListCredentials(ctx context.Context, userHashedUUID string) ([]domain.WebAuthnCredential, error)
+	DeleteCredential(ctx context.Context, user *domain.User, id int64) error
+	// SetUUIDLoginDisabled turns the bookmark UUID login off, which requires a passkey, or back on.
+	SetUUIDLoginDisabled(ctx context.Context, user *domain.User, disabled bool) error
+}
+
+type service struct {
+	log      zerolog.Logger
+	webAuthn *webauthn.WebAuthn
+	repo     domain.WebAuthnCredentialRepo
+	userRepo domain.UserRepo
+	userSvc  userService
+	client   valkeyClient.Client
+}
+
+func NewService(log logger.Logger, cfg domain.WebAuthnConfig, repo domain.WebAuthnCredentialRepo, userRepo domain.UserRepo, userSvc userService, client valkeyClient.Client) Service {
+	s := &service{
+		log:      log.With().Str("module", "passkey").Logger(),
+		repo:     repo,
+		userRepo: userRepo,
+		userSvc:  userSvc,
+		client:   client,
+	}
+
+	if cfg.RPID == "" {
+		return s
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(cfg.RPOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		s.log.Error().Err(err).Msg("invalid webauthn config, passkeys are disabled")
+		return s
+	}
+
+	s.webAuthn = webAuthn
+	return s
+}
+
+func (s *service) Enabled() bool {
+	return s.webAuthn != nil
+}
+
+// passkeyUser adapts a user and their passkeys to webauthn.User.
+type passkeyUser struct {
+	user        *domain.User
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return u.user.WebAuthnHandle()
+}
+
+// WebAuthnName is shown by authenticators to tell accounts apart, users have no name.
+func (u *passkeyUser) WebAuthnName() string {
+	return "shiori-" + hex.EncodeToString(u.WebAuthnID()[:4])
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.WebAuthnName()
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// loadUser returns the user with the decoded passkeys, the stored passkeys are returned alongside.
+func (s *service) loadUser(ctx context.Context, user *domain.User) (*passkeyUser, []domain.WebAuthnCredential, error) {
+	stored, err := s.repo.FindByUser(ctx, user.HashedUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(stored))
+	for _, c := range stored {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(c.Credential, &credential); err != nil {
+			return nil, nil, pkgErrors.Wrap(err, "could not decode passkey %d", c.ID)
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return &passkeyUser{user: user, credentials: credentials}, stored, nil
+}
+
+// storeCeremony keeps the session data of a ceremony until it is finished.
+func (s *service) storeCeremony(ctx context.Context, key string, session *webauthn.SessionData) error {
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		return pkgErrors.Wrap(err, "could not encode passkey ceremony")
+	}
+	if err := s.client.Do(ctx, s.client.B().Set().Key(key).Value(string(encoded)).Ex(ceremonyTTL).Build()).Error(); err != nil {
+		return pkgErrors.Wrap(err, "could not store passkey ceremony")
+	}
+	return nil
+}
+
+// takeCeremony returns and removes the session data of a ceremony, so every ceremony is finished once.
+func (s *service) takeCeremony(ctx context.Context, key string) (*webauthn.SessionData, error) {
+	value, err := s.client.Do(ctx, s.client.B().Getdel().Key(key).Build()).ToString()
+	if err != nil {
+		if errors.Is(err, valkeyClient.Nil) {
+			return nil, ErrInvalidCeremony
+		}
+		return nil, pkgErrors.Wrap(err, "could not read passkey ceremony")
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return nil, pkgErrors.Wrap(err, "could not decode passkey ceremony")
+	}
+	return &session, nil
+}
+
+func (s *service) BeginRegistration(ctx context.Context, user *domain.User) (*protocol.CredentialCreation, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+
+	passkeyUser, stored, err := s.loadUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) >= maxPasskeys {
+		return nil, ErrLimitReached
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(passkeyUser.credentials))
+	for _, credential := range passkeyUser.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	// Passkeys must be discoverable, the login does not know the user beforehand
+	creation, session, err := s.webAuthn.BeginRegistration(passkeyUser,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not begin passkey registration")
+	}
+
+	if err := s.storeCeremony(ctx, registrationKeyPrefix+user.HashedUUID, session); err != nil {
+		return nil, err
+	}
+	return creation, nil
+}
+
+func (s *service) FinishRegistration(ctx context.Context, user *domain.User, name string, response []byte) (*domain.WebAuthnCredential, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	if utf8.RuneCountInString(name) > maxPasskeyNameLength {
+		return nil, ErrInvalidName
+	}
+
+	session, err := s.takeCeremony(ctx, registrationKeyPrefix+user.HashedUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, pkgErrors.Wrap(ErrInvalidCeremony, "could not parse passkey registration: %v", err)
+	}
+
+	passkeyUser, _, err := s.loadUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.webAuthn.CreateCredential(passkeyUser, *session, parsed)
+	if err != nil {
+		return nil, pkgErrors.Wrap(ErrInvalidCeremony, "could not verify passkey registration: %v", err)
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not encode passkey")
+	}
+
+	stored := &domain.WebAuthnCredential{
+		UserHashedUUID: user.HashedUUID,
+		Name:           name,
+		CredentialID:   base64.RawURLEncoding.EncodeToString(credential.ID),
+		Credential:     encoded,
+	}
+	if err := s.repo.Store(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	s.log.Info().Str("hashed_uuid", user.HashedUUID).Int64("passkey_id", stored.ID).Msg("passkey registered")
+	return stored, nil
+}
+
+func (s *service) BeginLogin(ctx context.Context) (string, *protocol.CredentialAssertion, error) {
+	if !s.Enabled() {
+		return "", nil, ErrDisabled
+	}
+
+	assertion, session, err := s.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not begin passkey login")
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not generate passkey ceremony ID")
+	}
+	ceremonyID := hex.EncodeToString(raw)
+
+	if err := s.storeCeremony(ctx, loginKeyPrefix+ceremonyID, session); err != nil {
+		return "", nil, err
+	}
+	return ceremonyID, assertion, nil
+}
+
+func (s *service) FinishLogin(ctx context.Context, ceremonyID string, response []byte) (*domain.User, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	if ceremonyID == "" {
+		return nil, ErrInvalidCeremony
+	}
+
+	session, err := s.takeCeremony(ctx, loginKeyPrefix+ceremonyID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, pkgErrors.Wrap(ErrLoginFailed, "could not parse passkey login: %v", err)
+	}
+
+	var stored *domain.WebAuthnCredential
+	handler := func(rawID, handle []byte) (webauthn.User, error) {
+		credential, err := s.repo.FindByCredentialID(ctx, base64.RawURLEncoding.EncodeToString(rawID))
+		if err != nil {
+			return nil, err
+		}
+		if credential == nil {
+			return nil, ErrLoginFailed
+		}
+
+		user, err := s.userSvc.GetUserForAuthentication(ctx, credential.UserHashedUUID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || !bytes.Equal(user.WebAuthnHandle(), handle) {
+			return nil, ErrLoginFailed
+		}
+
+		stored = credential
+		passkeyUser, _, err := s.loadUser(ctx, user)
+		return passkeyUser, err
+	}
+
+	validatedUser, credential, err := s.webAuthn.ValidatePasskeyLogin(handler, *session, parsed)
+	if err != nil {
+		return nil, pkgErrors.Wrap(ErrLoginFailed, "could not verify passkey login: %v", err)
+	}
+	if credential.Authenticator.CloneWarning {
+		s.log.Warn().Int64("passkey_id", stored.ID).Msg("passkey sign counter went backwards, the authenticator may be cloned")
+		return nil, ErrLoginFailed
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not encode passkey")
+	}
+	if err := s.repo.RecordUse(ctx, stored.ID, encoded, time.Now()); err != nil {
+		return nil, err
+	}
+
+	user := validatedUser.(*passkeyUser).user
+	s.log.Info().Str("hashed_uuid", user.HashedUUID).Int64("passkey_id", stored.ID).Msg("passkey login")
+	return user, nil
+}
+
+func (s *service) ListCredentials(ctx context.Context, userHashedUUID string) ([]domain.WebAuthnCredential, error) {
+	credentials, err := s.repo.FindByUser(ctx, userHashedUUID)
+	if err != nil {
+		return nil, err
+	}
+	if credentials == nil {
+		credentials = []domain.WebAuthnCredential{}
+	}
+	return credentials, nil
+}
+
+func (s *service) DeleteCredential(ctx context.Context, user *domain.User, id int64) error {
+	// The repository keeps the last passkey of users who turned the UUID login off
+	if err := s.repo.Delete(ctx, user.HashedUUID, id); err != nil {
+		if errors.Is(err, domain.ErrLastWebAuthnCredential) {
+			return ErrLastPasskey
+		}
+		return err
+	}
+
+	s.log.Info().Str("hashed_uuid", user.HashedUUID).Int64("passkey_id", id).Msg("passkey removed")
+	return nil
+}
+
+func (s *service) SetUUIDLoginDisabled(ctx context.Context, user *domain.User, disabled bool) error {
+	// The repository refuses to turn the UUID login off without a passkey
+	if err := s.userRepo.UpdateUUIDLoginDisabled(ctx, user.HashedUUID, disabled); err != nil {
+		if errors.Is(err, domain.ErrNoWebAuthnCredential) {
+			return ErrNoPasskey
+		}
+		return err
+	}
+
+	s.log.Info().Str("hashed_uuid", user.HashedUUID).Bool("disabled", disabled).Msg("bookmark UUID login changed")
+	return nil
+}
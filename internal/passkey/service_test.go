@@ -0,0 +1,41 @@
+package passkey
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_UUIDLoginGuards(t *testing.T) {
+	ctx := context.Background()
+	db, log := databasetest.New(t)
+
+	users := database.NewUserRepo(log, db)
+	passkeys := database.NewWebAuthnCredentialRepo(log, db)
+	svc := NewService(log, domain.WebAuthnConfig{}, passkeys, users, nil, nil)
+
+	require.NoError(t, users.Store(ctx, domain.User{HashedUUID: "bookmark", PublicID: "public", APITokenHash: "hash"}))
+	user := &domain.User{HashedUUID: "bookmark"}
+
+	// The UUID login stays on without a passkey to log in with
+	assert.ErrorIs(t, svc.SetUUIDLoginDisabled(ctx, user, true), ErrNoPasskey)
+
+	require.NoError(t, passkeys.Store(ctx, &domain.WebAuthnCredential{UserHashedUUID: "bookmark", Name: "Passkey", CredentialID: "a"}))
+	require.NoError(t, svc.SetUUIDLoginDisabled(ctx, user, true))
+
+	// The only way left to log in cannot be removed
+	credentials, err := svc.ListCredentials(ctx, "bookmark")
+	require.NoError(t, err)
+	require.Len(t, credentials, 1)
+	assert.ErrorIs(t, svc.DeleteCredential(ctx, user, credentials[0].ID), ErrLastPasskey)
+
+	// Turning the UUID login back on allows it again
+	require.NoError(t, svc.SetUUIDLoginDisabled(ctx, user, false))
+	require.NoError(t, svc.DeleteCredential(ctx, user, credentials[0].ID))
+	assert.ErrorIs(t, svc.DeleteCredential(ctx, user, credentials[0].ID), domain.ErrWebAuthnCredentialNotFound)
+}
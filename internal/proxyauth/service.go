@@ -0,0 +1,162 @@
+package proxyauth
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// userService is the part of user.Service needed to provision and load users.
+type userService interface {
+	RegisterNewUser(ctx context.Context) (hashedUUID string, plainToken string, err error)
+	GetUserForAuthentication(ctx context.Context, hashedUUID string) (*domain.User, error)
+}
+
+// registrar is the part of registration.Service that decides whether a user may be provisioned.
+type registrar interface {
+	AdmitProvisioned(ctx context.Context) error
+}
+
+type Service interface {
+	Enabled() bool
+	// UserHeader returns the name of the header carrying the username.
+	UserHeader() string
+	// Trusted reports whether a peer address, host or host:port, belongs to a trusted proxy.
+	Trusted(peerAddr string) bool
+	// Authenticate returns the user of a username asserted by a trusted proxy, creating it on first sight.
+	Authenticate(ctx context.Context, username string) (*domain.User, error)
+}
+
+type service struct {
+	log       zerolog.Logger
+	cfg       domain.ForwardAuthConfig
+	proxies   []netip.Prefix
+	repo      domain.ProxyIdentityRepo
+	userSvc   userService
+	registrar registrar
+}
+
+func NewService(log logger.Logger, cfg domain.ForwardAuthConfig, repo domain.ProxyIdentityRepo, userSvc userService, registrar registrar) Service {
+	s := &service{
+		log:       log.With().Str("module", "proxyauth").Logger(),
+		cfg:       cfg,
+		repo:      repo,
+		userSvc:   userSvc,
+		registrar: registrar,
+	}
+	s.proxies = parseTrustedProxies(s.log, cfg.TrustedProxies)
+
+	if cfg.Enabled && len(s.proxies) == 0 {
+		s.log.Warn().Msg("forward auth is enabled without trusted proxies, the user header is ignored")
+	}
+
+	return s
+}
+
+// parseTrustedProxies parses comma-separated IPs and CIDRs, invalid entries are logged and skipped.
+func parseTrustedProxies(log zerolog.Logger, raw string) []netip.Prefix {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		log.Warn().Str("entry", entry).Msg("ignoring invalid trusted proxy")
+	}
+	return proxies
+}
+
+func (s *service) Enabled() bool {
+	return s.cfg.Enabled && len(s.proxies) > 0 && s.cfg.UserHeader != ""
+}
+
+func (s *service) UserHeader() string {
+	return s.cfg.UserHeader
+}
+
+func (s *service) Trusted(peerAddr string) bool {
+	host := peerAddr
+	if h, _, err := net.SplitHostPort(peerAddr); err == nil {
+		host = h
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range s.proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *service) Authenticate(ctx context.Context, username string) (*domain.User, error) {
+	identity, err := s.repo.FindByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity == nil {
+		identity, err = s.provision(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := s.userSvc.GetUserForAuthentication(ctx, identity.UserHashedUUID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, pkgErrors.New("user of proxy identity %s does not exist", username)
+	}
+
+	return user, nil
+}
+
+// provision creates a user for a username seen for the first time.
+func (s *service) provision(ctx context.Context, username string) (*domain.ProxyIdentity, error) {
+	if err := s.registrar.AdmitProvisioned(ctx); err != nil {
+		return nil, err
+	}
+
+	hashedUUID, _, err := s.userSvc.RegisterNewUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &domain.ProxyIdentity{
+		Username:       username,
+		UserHashedUUID: hashedUUID,
+	}
+	if err := s.repo.Store(ctx, identity); err != nil {
+		// A concurrent request provisioned the username first, the user created here stays unused
+		if existing, findErr := s.repo.FindByUsername(ctx, username); findErr == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+
+	s.log.Info().Str("username", username).Str("hashed_uuid", hashedUUID).Msg("provisioned user for proxy identity")
+	return identity, nil
+}
@@ -0,0 +1,111 @@
+package proxyauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUserService registers users straight into the repository.
+type fakeUserService struct {
+	repo domain.UserRepo
+}
+
+func (s *fakeUserService) RegisterNewUser(ctx context.Context) (string, string, error) {
+	return "provisioned", "shi_token", s.repo.Store(ctx, domain.User{HashedUUID: "provisioned", APITokenHash: "provisioned"})
+}
+
+func (s *fakeUserService) GetUserForAuthentication(ctx context.Context, hashedUUID string) (*domain.User, error) {
+	return s.repo.FindByHashedUUID(ctx, hashedUUID)
+}
+
+// fakeRegistrar refuses to provision users with err.
+type fakeRegistrar struct {
+	err error
+}
+
+func (r *fakeRegistrar) AdmitProvisioned(context.Context) error {
+	return r.err
+}
+
+func TestService_Trusted(t *testing.T) {
+	s := &service{
+		cfg:     domain.ForwardAuthConfig{Enabled: true, UserHeader: "Remote-User"},
+		proxies: parseTrustedProxies(zerolog.Nop(), "172.16.0.0/12, 10.0.0.5,fd00::/8,not-an-ip"),
+	}
+
+	tests := []struct {
+		name     string
+		peerAddr string
+		want     bool
+	}{
+		{name: "in cidr", peerAddr: "172.18.0.2:51234", want: true},
+		{name: "single ip", peerAddr: "10.0.0.5:443", want: true},
+		{name: "ipv6 cidr", peerAddr: "[fd00::1]:8080", want: true},
+		{name: "ipv4 mapped", peerAddr: "[::ffff:10.0.0.5]:443", want: true},
+		{name: "without port", peerAddr: "10.0.0.5", want: true},
+		{name: "outside", peerAddr: "10.0.0.6:443", want: false},
+		{name: "public", peerAddr: "203.0.113.7:443", want: false},
+		{name: "malformed", peerAddr: "proxy", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, s.Trusted(tt.peerAddr))
+		})
+	}
+
+	assert.True(t, s.Enabled())
+	assert.False(t, (&service{cfg: s.cfg}).Enabled(), "no trusted proxies")
+}
+
+func TestService_Authenticate(t *testing.T) {
+	errClosed := errors.New("registration is closed")
+
+	tests := []struct {
+		name     string
+		username string
+		admit    error
+		want     string
+		wantErr  error
+	}{
+		{name: "known", username: "alice", want: "bookmark"},
+		{name: "known_while_closed", username: "alice", admit: errClosed, want: "bookmark"},
+		{name: "provisioned", username: "bob", want: "provisioned"},
+		{name: "provisioning_refused", username: "bob", admit: errClosed, wantErr: errClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db, log := databasetest.New(t)
+			users := database.NewUserRepo(log, db)
+			databasetest.StoreUsers(t, users, domain.User{HashedUUID: "bookmark"})
+			repo := database.NewProxyIdentityRepo(log, db)
+			require.NoError(t, repo.Store(ctx, &domain.ProxyIdentity{Username: "alice", UserHashedUUID: "bookmark"}))
+			s := &service{log: zerolog.Nop(), repo: repo, userSvc: &fakeUserService{repo: users}, registrar: &fakeRegistrar{err: tt.admit}}
+
+			user, err := s.Authenticate(ctx, tt.username)
+			identity, findErr := repo.FindByUsername(ctx, tt.username)
+			require.NoError(t, findErr)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				provisioned, findErr := users.FindByHashedUUID(ctx, "provisioned")
+				require.NoError(t, findErr)
+				assert.Nil(t, provisioned)
+				assert.Nil(t, identity)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, user.HashedUUID)
+			assert.Equal(t, tt.want, identity.UserHashedUUID)
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package reading
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/backup"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/rs/zerolog"
+)
+
+const dateFormat = "2006-01-02"
+
+type Service interface {
+	// Ingest derives reading events from an upload to slot, previous is the snapshot it replaced, nil for the first upload.
+	Ingest(ctx context.Context, userHashedUUID string, slot string, previous, current []byte) error
+	// GetStats returns reading totals grouped as requested.
+	GetStats(ctx context.Context, userHashedUUID string, query domain.ReadingStatsQuery) (*domain.ReadingStats, error)
+	// GetStreaks returns the current and longest run of consecutive days with reading activity.
+	GetStreaks(ctx context.Context, userHashedUUID string, loc *time.Location) (*domain.ReadingStreaks, error)
+}
+
+type service struct {
+	log  zerolog.Logger
+	repo domain.ReadingEventRepo
+}
+
+func NewService(log logger.Logger, repo domain.ReadingEventRepo) Service {
+	return &service{
+		log:  log.With().Str("module", "reading").Logger(),
+		repo: repo,
+	}
+}
+
+func (s *service) Ingest(ctx context.Context, userHashedUUID string, slot string, previous, current []byte) error {
+	currentBackup, err := backup.Decode(current)
+	if err != nil {
+		s.log.Debug().Err(err).Msg("could not decode uploaded snapshot, skipping reading events")
+		return nil
+	}
+
+	var previousBackup *backup.Backup
+	if previous != nil {
+		if previousBackup, err = backup.Decode(previous); err != nil {
+			s.log.Debug().Err(err).Msg("could not decode previous snapshot, deriving from history only")
+		}
+	}
+
+	events := deriveEvents(userHashedUUID, slot, previousBackup, currentBackup, time.Now().UTC())
+	if err := s.repo.StoreMany(ctx, events); err != nil {
+		s.log.Error().Err(err).Msg("could not store reading events")
+		return err
+	}
+
+	s.log.Debug().Int("count", len(events)).Msg("derived reading events from upload")
+	return nil
+}
+
+type chapterKey struct {
+	source int64
+	url    string
+}
+
+// deriveEvents compares two snapshots and returns the chapters read in between.
+// Every history entry whose last read time moved is a read at that time. Chapters
+// that turned read without a history entry are recorded at uploadedAt, but only
+// when a previous snapshot exists, otherwise a whole library would be counted as read at once.
+func deriveEvents(userHashedUUID string, slot string, previous, current *backup.Backup, uploadedAt time.Time) []domain.ReadingEvent {
+	previousHistory := map[chapterKey]backup.History{}
+	previousRead := map[chapterKey]bool{}
+	if previous != nil {
+		for _, manga := range previous.Manga {
+			for _, history := range manga.History {
+				previousHistory[chapterKey{manga.Source, history.URL}] = history
+			}
+			for _, chapter := range manga.Chapters {
+				previousRead[chapterKey{manga.Source, chapter.URL}] = chapter.Read
+			}
+		}
+	}
+
+	var events []domain.ReadingEvent
+	for _, manga := range current.Manga {
+		chapters := make(map[string]backup.Chapter, len(manga.Chapters))
+		for _, chapter := range manga.Chapters {
+			chapters[chapter.URL] = chapter
+		}
+
+		newEvent := func(chapterURL string, readAt time.Time) domain.ReadingEvent {
+			return domain.ReadingEvent{
+				UserHashedUUID: userHashedUUID,
+				Slot:           slot,
+				Source:         manga.Source,
+				MangaURL:       manga.URL,
+				MangaTitle:     manga.Title,
+				ChapterURL:     chapterURL,
+				ChapterNumber:  chapters[chapterURL].ChapterNumber,
+				ReadAt:         readAt,
+			}
+		}
+
+		withHistory := make(map[string]bool, len(manga.History))
+		for _, history := range manga.History {
+			withHistory[history.URL] = true
+			if history.LastRead <= 0 {
+				continue
+			}
+
+			key := chapterKey{manga.Source, history.URL}
+			before, seen := previousHistory[key]
+			if seen && before.LastRead == history.LastRead {
+				continue
+			}
+
+			event := newEvent(history.URL, time.UnixMilli(history.LastRead).UTC())
+			// The client accumulates the reading time per chapter
+			event.ReadDuration = history.ReadDuration
+			if seen && history.ReadDuration >= before.ReadDuration {
+				event.ReadDuration = history.ReadDuration - before.ReadDuration
+			}
+			events = append(events, event)
+		}
+
+		if previous == nil {
+			continue
+		}
+
+		for _, chapter := range manga.Chapters {
+			if !chapter.Read || withHistory[chapter.URL] {
+				continue
+			}
+			wasRead, known := previousRead[chapterKey{manga.Source, chapter.URL}]
+			if !known || wasRead {
+				continue
+			}
+
+			event := newEvent(chapter.URL, uploadedAt)
+			event.Inferred = true
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+func (s *service) GetStats(ctx context.Context, userHashedUUID string, query domain.ReadingStatsQuery) (*domain.ReadingStats, error) {
+	events, err := s.repo.Find(ctx, userHashedUUID, query.From, query.To)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not load reading events")
+		return nil, err
+	}
+
+	loc := query.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	stats := &domain.ReadingStats{
+		Group:    query.Group,
+		TimeZone: loc.String(),
+		Buckets:  []domain.ReadingStatsBucket{},
+	}
+
+	buckets := map[string]*domain.ReadingStatsBucket{}
+	var order []string
+	bucket := func(key string) *domain.ReadingStatsBucket {
+		b, ok := buckets[key]
+		if !ok {
+			b = &domain.ReadingStatsBucket{Key: key}
+			buckets[key] = b
+			order = append(order, key)
+		}
+		return b
+	}
+
+	// Fixed-size groups always return every bucket, in their natural order
+	switch query.Group {
+	case domain.ReadingStatsGroupHour:
+		for hour := 0; hour < 24; hour++ {
+			bucket(strconv.Itoa(hour))
+		}
+	case domain.ReadingStatsGroupWeekday:
+		for day := 1; day <= 7; day++ {
+			bucket(time.Weekday(day % 7).String())
+		}
+	}
+
+	for _, event := range events {
+		readAt := event.ReadAt.In(loc)
+
+		var key string
+		switch query.Group {
+		case domain.ReadingStatsGroupWeek:
+			// Weeks start on monday
+			offset := (int(readAt.Weekday()) + 6) % 7
+			key = readAt.AddDate(0, 0, -offset).Format(dateFormat)
+		case domain.ReadingStatsGroupMonth:
+			key = readAt.Format("2006-01")
+		case domain.ReadingStatsGroupHour:
+			key = strconv.Itoa(readAt.Hour())
+		case domain.ReadingStatsGroupWeekday:
+			key = readAt.Weekday().String()
+		case domain.ReadingStatsGroupSeries:
+			key = event.MangaTitle
+		default:
+			key = readAt.Format(dateFormat)
+		}
+
+		b := bucket(key)
+		b.ChaptersRead++
+		b.ReadingDuration += event.ReadDuration
+		stats.ChaptersRead++
+		stats.ReadingDuration += event.ReadDuration
+	}
+
+	for _, key := range order {
+		stats.Buckets = append(stats.Buckets, *buckets[key])
+	}
+
+	if query.Group == domain.ReadingStatsGroupSeries {
+		sort.SliceStable(stats.Buckets, func(i, j int) bool {
+			return stats.Buckets[i].ChaptersRead > stats.Buckets[j].ChaptersRead
+		})
+	}
+
+	return stats, nil
+}
+
+func (s *service) GetStreaks(ctx context.Context, userHashedUUID string, loc *time.Location) (*domain.ReadingStreaks, error) {
+	events, err := s.repo.Find(ctx, userHashedUUID, nil, nil)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not load reading events")
+		return nil, err
+	}
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	return computeStreaks(events, loc, time.Now()), nil
+}
+
+// computeStreaks expects events ordered by read time. The current streak is still
+// alive when the last reading day was today or yesterday.
+func computeStreaks(events []domain.ReadingEvent, loc *time.Location, now time.Time) *domain.ReadingStreaks {
+	streaks := &domain.ReadingStreaks{TimeZone: loc.String()}
+
+	var days []time.Time
+	for _, event := range events {
+		readAt := event.ReadAt.In(loc)
+		day := time.Date(readAt.Year(), readAt.Month(), readAt.Day(), 0, 0, 0, 0, loc)
+		if len(days) == 0 || !days[len(days)-1].Equal(day) {
+			days = append(days, day)
+		}
+	}
+
+	if len(days) == 0 {
+		return streaks
+	}
+
+	run, runStart := 0, days[0]
+	var longestStart, longestEnd time.Time
+	for i, day := range days {
+		if i > 0 && days[i-1].AddDate(0, 0, 1).Equal(day) {
+			run++
+		} else {
+			run, runStart = 1, day
+		}
+		if run > streaks.Longest {
+			streaks.Longest = run
+			longestStart, longestEnd = runStart, day
+		}
+	}
+
+	last := days[len(days)-1]
+	nowLocal := now.In(loc)
+	today := time.Date(nowLocal.Year(), nowLocal.Month(), nowLocal.Day(), 0, 0, 0, 0, loc)
+	if last.Equal(today) || last.AddDate(0, 0, 1).Equal(today) {
+		streaks.Current = run
+	}
+
+	format := func(t time.Time) *string {
+		value := t.Format(dateFormat)
+		return &value
+	}
+	streaks.LongestStart = format(longestStart)
+	streaks.LongestEnd = format(longestEnd)
+	streaks.LastReadingOn = format(last)
+
+	return streaks
+}
+
+// ParseGroup validates a group query parameter, an empty value defaults to day.
+func ParseGroup(value string) (domain.ReadingStatsGroup, error) {
+	switch group := domain.ReadingStatsGroup(value); group {
+	case "":
+		return domain.ReadingStatsGroupDay, nil
+	case domain.ReadingStatsGroupDay, domain.ReadingStatsGroupWeek, domain.ReadingStatsGroupMonth,
+		domain.ReadingStatsGroupHour, domain.ReadingStatsGroupWeekday, domain.ReadingStatsGroupSeries:
+		return group, nil
+	default:
+		return "", fmt.Errorf("invalid group %q", value)
+	}
+}
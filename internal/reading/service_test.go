@@ -0,0 +1,175 @@
+package reading
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/backup"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeriveEvents(t *testing.T) {
+	uploadedAt := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	readAt := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+
+	library := func(read bool, history ...backup.History) *backup.Backup {
+		return &backup.Backup{Manga: []backup.Manga{{
+			Source: 1, URL: "/manga", Title: "Manga",
+			Chapters: []backup.Chapter{
+				{URL: "/chapter/1", ChapterNumber: 1, Read: true},
+				{URL: "/chapter/2", ChapterNumber: 2, Read: read},
+			},
+			History: history,
+		}}}
+	}
+	history := func(lastRead time.Time, duration int64) backup.History {
+		return backup.History{URL: "/chapter/1", LastRead: lastRead.UnixMilli(), ReadDuration: duration}
+	}
+
+	tests := []struct {
+		name     string
+		previous *backup.Backup
+		current  *backup.Backup
+		want     []domain.ReadingEvent
+	}{
+		{
+			name:    "first_upload_only_history",
+			current: library(true, history(readAt, 60)),
+			want: []domain.ReadingEvent{
+				{UserHashedUUID: "user", Slot: "tablet", Source: 1, MangaURL: "/manga", MangaTitle: "Manga", ChapterURL: "/chapter/1", ChapterNumber: 1, ReadAt: readAt, ReadDuration: 60},
+			},
+		},
+		{
+			name:     "unchanged_history",
+			previous: library(false, history(readAt, 60)),
+			current:  library(false, history(readAt, 60)),
+		},
+		{
+			name:     "history_moved_counts_added_duration",
+			previous: library(false, history(readAt.Add(-time.Hour), 60)),
+			current:  library(false, history(readAt, 100)),
+			want: []domain.ReadingEvent{
+				{UserHashedUUID: "user", Slot: "tablet", Source: 1, MangaURL: "/manga", MangaTitle: "Manga", ChapterURL: "/chapter/1", ChapterNumber: 1, ReadAt: readAt, ReadDuration: 40},
+			},
+		},
+		{
+			name:     "marked_read_without_history",
+			previous: library(false),
+			current:  library(true),
+			want: []domain.ReadingEvent{
+				{UserHashedUUID: "user", Slot: "tablet", Source: 1, MangaURL: "/manga", MangaTitle: "Manga", ChapterURL: "/chapter/2", ChapterNumber: 2, ReadAt: uploadedAt, Inferred: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, deriveEvents("user", "tablet", tt.previous, tt.current, uploadedAt))
+		})
+	}
+}
+
+func TestComputeStreaks(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	readOn := func(days ...int) []domain.ReadingEvent {
+		events := make([]domain.ReadingEvent, 0, len(days))
+		for _, day := range days {
+			events = append(events, domain.ReadingEvent{ReadAt: time.Date(2026, 10, day, 20, 0, 0, 0, time.UTC)})
+		}
+		return events
+	}
+	date := func(value string) *string { return &value }
+
+	tests := []struct {
+		name   string
+		events []domain.ReadingEvent
+		loc    *time.Location
+		want   domain.ReadingStreaks
+	}{
+		{
+			name: "no_events",
+			loc:  time.UTC,
+			want: domain.ReadingStreaks{TimeZone: "UTC"},
+		},
+		{
+			name:   "current_streak_until_yesterday",
+			events: readOn(10, 11, 12, 15, 16, 17, 17),
+			loc:    time.UTC,
+			want:   domain.ReadingStreaks{TimeZone: "UTC", Current: 3, Longest: 3, LongestStart: date("2026-10-10"), LongestEnd: date("2026-10-12"), LastReadingOn: date("2026-10-17")},
+		},
+		{
+			name:   "broken_streak",
+			events: readOn(10, 11, 15),
+			loc:    time.UTC,
+			want:   domain.ReadingStreaks{TimeZone: "UTC", Longest: 2, LongestStart: date("2026-10-10"), LongestEnd: date("2026-10-11"), LastReadingOn: date("2026-10-15")},
+		},
+		{
+			// 20:00 UTC is the next day four hours east
+			name:   "days_in_time_zone",
+			events: readOn(16, 17),
+			loc:    time.FixedZone("UTC+4", 4*60*60),
+			want:   domain.ReadingStreaks{TimeZone: "UTC+4", Current: 2, Longest: 2, LongestStart: date("2026-10-17"), LongestEnd: date("2026-10-18"), LastReadingOn: date("2026-10-18")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, *computeStreaks(tt.events, tt.loc, now))
+		})
+	}
+}
+
+type readingEventRepo struct {
+	domain.ReadingEventRepo
+	events []domain.ReadingEvent
+}
+
+func (r readingEventRepo) Find(ctx context.Context, userHashedUUID string, from, to *time.Time) ([]domain.ReadingEvent, error) {
+	return r.events, nil
+}
+
+func TestService_GetStats(t *testing.T) {
+	// Monday 2026-10-12 and Sunday 2026-10-18, the sunday is a monday in UTC+14
+	events := []domain.ReadingEvent{
+		{MangaTitle: "A", ReadAt: time.Date(2026, 10, 12, 8, 0, 0, 0, time.UTC), ReadDuration: 10},
+		{MangaTitle: "B", ReadAt: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), ReadDuration: 20},
+		{MangaTitle: "B", ReadAt: time.Date(2026, 10, 18, 13, 0, 0, 0, time.UTC), ReadDuration: 30},
+	}
+	svc := &service{repo: readingEventRepo{events: events}}
+	kiribati := time.FixedZone("UTC+14", 14*60*60)
+
+	tests := []struct {
+		name    string
+		group   domain.ReadingStatsGroup
+		loc     *time.Location
+		buckets map[string]int // Chapters read per bucket key, empty buckets left out
+		count   int            // Number of buckets
+	}{
+		{name: "day", group: domain.ReadingStatsGroupDay, buckets: map[string]int{"2026-10-12": 1, "2026-10-18": 2}, count: 2},
+		{name: "week", group: domain.ReadingStatsGroupWeek, buckets: map[string]int{"2026-10-12": 3}, count: 1},
+		{name: "week_in_time_zone", group: domain.ReadingStatsGroupWeek, loc: kiribati, buckets: map[string]int{"2026-10-12": 1, "2026-10-19": 2}, count: 2},
+		{name: "month", group: domain.ReadingStatsGroupMonth, buckets: map[string]int{"2026-10": 3}, count: 1},
+		{name: "hour", group: domain.ReadingStatsGroupHour, buckets: map[string]int{"8": 1, "12": 1, "13": 1}, count: 24},
+		{name: "weekday", group: domain.ReadingStatsGroupWeekday, buckets: map[string]int{"Monday": 1, "Sunday": 2}, count: 7},
+		{name: "series", group: domain.ReadingStatsGroupSeries, buckets: map[string]int{"B": 2, "A": 1}, count: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := svc.GetStats(context.Background(), "user", domain.ReadingStatsQuery{Group: tt.group, Location: tt.loc})
+			require.NoError(t, err)
+
+			assert.Equal(t, 3, stats.ChaptersRead)
+			assert.Equal(t, int64(60), stats.ReadingDuration)
+			require.Len(t, stats.Buckets, tt.count)
+			for _, bucket := range stats.Buckets {
+				assert.Equal(t, tt.buckets[bucket.Key], bucket.ChaptersRead, bucket.Key)
+			}
+		})
+	}
+
+	// Series are ordered by the chapters read
+	stats, err := svc.GetStats(context.Background(), "user", domain.ReadingStatsQuery{Group: domain.ReadingStatsGroupSeries})
+	require.NoError(t, err)
+	assert.Equal(t, "B", stats.Buckets[0].Key)
+}
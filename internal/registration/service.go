@@ -0,0 +1,236 @@
+// Package registration decides who may create an account with the register
+// endpoint: the configured mode, the user limit, an optional proof of work
+// and invite codes. The mode and the user limit also apply to users
+// provisioned by OpenID Connect and forward auth.
+package registration
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"strconv"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+const (
+	// ChallengeTTL is how long a client has to solve a proof of work.
+	ChallengeTTL = 5 * time.Minute
+
+	challengeBytes     = 16
+	challengeKeyPrefix = "registration:pow:"
+	// maxProofOfWorkBits keeps a misconfigured difficulty solvable.
+	maxProofOfWorkBits = 32
+)
+
+var (
+	ErrRegistrationClosed  = pkgErrors.New("registration is closed")
+	ErrUserLimitReached    = pkgErrors.New("this instance does not accept new users")
+	ErrInviteRequired      = pkgErrors.New("an invite code is required")
+	ErrInvalidInvite       = pkgErrors.New("unknown, used up or expired invite code")
+	ErrProofOfWorkRequired = pkgErrors.New("proof of work required")
+	ErrInvalidProofOfWork  = pkgErrors.New("invalid or expired proof of work")
+)
+
+type Service interface {
+	// Status tells clients whether registration is possible and what it requires.
+	Status(ctx context.Context) (*domain.RegistrationStatus, error)
+	// NewChallenge issues a proof of work, it returns nil if none is required.
+	NewChallenge(ctx context.Context) (*domain.RegistrationChallenge, error)
+	// Admit checks that a registration may go ahead. The challenge and a use
+	// of the invite code are spent by it, even if the registration fails later.
+	// The user limit is counted, not reserved: registrations running at the
+	// same time can each pass it and exceed max_users by the requests in flight.
+	Admit(ctx context.Context, req domain.RegisterRequest) error
+	// AdmitProvisioned checks that an account may be created for an identity
+	// asserted by OpenID Connect or forward auth. Closed registration and the
+	// user limit apply, invites and the proof of work do not, the identity
+	// provider vouches for the person.
+	AdmitProvisioned(ctx context.Context) error
+}
+
+type service struct {
+	log        zerolog.Logger
+	cfg        domain.RegistrationConfig
+	userRepo   domain.UserRepo
+	inviteRepo domain.InviteRepo
+	client     valkeyClient.Client
+}
+
+func NewService(log logger.Logger, cfg domain.RegistrationConfig, userRepo domain.UserRepo, inviteRepo domain.InviteRepo, client valkeyClient.Client) Service {
+	s := &service{
+		log:        log.With().Str("module", "registration").Logger(),
+		cfg:        cfg,
+		userRepo:   userRepo,
+		inviteRepo: inviteRepo,
+		client:     client,
+	}
+	if s.cfg.ProofOfWorkBits > maxProofOfWorkBits {
+		s.log.Warn().Int("bits", s.cfg.ProofOfWorkBits).Msgf("Proof of work difficulty lowered to %d bits", maxProofOfWorkBits)
+		s.cfg.ProofOfWorkBits = maxProofOfWorkBits
+	}
+	return s
+}
+
+// mode returns the configured mode, unknown modes close registration.
+func (s *service) mode() domain.RegistrationMode {
+	switch mode := domain.RegistrationMode(s.cfg.Mode); mode {
+	case domain.RegistrationModeOpen, domain.RegistrationModeInvite, domain.RegistrationModeClosed:
+		return mode
+	case "":
+		return domain.RegistrationModeOpen
+	default:
+		s.log.Warn().Str("mode", s.cfg.Mode).Msg("Unknown registration mode, registration is closed")
+		return domain.RegistrationModeClosed
+	}
+}
+
+func (s *service) Status(ctx context.Context) (*domain.RegistrationStatus, error) {
+	mode := s.mode()
+	status := &domain.RegistrationStatus{
+		Mode:            mode,
+		InviteRequired:  mode == domain.RegistrationModeInvite,
+		ProofOfWorkBits: s.cfg.ProofOfWorkBits,
+	}
+
+	if mode == domain.RegistrationModeClosed {
+		return status, nil
+	}
+	full, err := s.full(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.Open = !full
+	return status, nil
+}
+
+// full reports whether the user limit is reached.
+func (s *service) full(ctx context.Context) (bool, error) {
+	if s.cfg.MaxUsers <= 0 {
+		return false, nil
+	}
+	count, err := s.userRepo.GetUserCount(ctx)
+	if err != nil {
+		return false, pkgErrors.Wrap(err, "failed to count users")
+	}
+	return count >= s.cfg.MaxUsers, nil
+}
+
+func (s *service) NewChallenge(ctx context.Context) (*domain.RegistrationChallenge, error) {
+	if s.cfg.ProofOfWorkBits <= 0 {
+		return nil, nil
+	}
+
+	b := make([]byte, challengeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, pkgErrors.Wrap(err, "failed to generate challenge")
+	}
+	challenge := hex.EncodeToString(b)
+
+	// The difficulty is stored, a configuration reload does not change issued challenges
+	cmd := s.client.B().Set().Key(challengeKeyPrefix + challenge).Value(strconv.Itoa(s.cfg.ProofOfWorkBits)).Ex(ChallengeTTL).Build()
+	if err := s.client.Do(ctx, cmd).Error(); err != nil {
+		return nil, pkgErrors.Wrap(err, "failed to store challenge")
+	}
+
+	return &domain.RegistrationChallenge{
+		Challenge: challenge,
+		Bits:      s.cfg.ProofOfWorkBits,
+		ExpiresAt: time.Now().Add(ChallengeTTL),
+	}, nil
+}
+
+func (s *service) Admit(ctx context.Context, req domain.RegisterRequest) error {
+	mode := s.mode()
+	if mode == domain.RegistrationModeInvite && req.InviteCode == "" {
+		return ErrInviteRequired
+	}
+	if err := s.admit(ctx, mode); err != nil {
+		return err
+	}
+
+	if s.cfg.ProofOfWorkBits > 0 {
+		if err := s.checkProofOfWork(ctx, req.Challenge, req.Nonce); err != nil {
+			return err
+		}
+	}
+
+	// Codes are ignored while registration is open, they are kept for later
+	if mode == domain.RegistrationModeInvite {
+		ok, err := s.inviteRepo.Consume(ctx, domain.HashInviteCode(req.InviteCode), time.Now())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidInvite
+		}
+	}
+
+	return nil
+}
+
+func (s *service) AdmitProvisioned(ctx context.Context) error {
+	return s.admit(ctx, s.mode())
+}
+
+// admit applies the checks shared by every way of creating an account.
+func (s *service) admit(ctx context.Context, mode domain.RegistrationMode) error {
+	if mode == domain.RegistrationModeClosed {
+		return ErrRegistrationClosed
+	}
+	full, err := s.full(ctx)
+	if err != nil {
+		return err
+	}
+	if full {
+		return ErrUserLimitReached
+	}
+	return nil
+}
+
+// checkProofOfWork spends the challenge and verifies the nonce solves it.
+func (s *service) checkProofOfWork(ctx context.Context, challenge string, nonce string) error {
+	if challenge == "" || nonce == "" {
+		return ErrProofOfWorkRequired
+	}
+
+	stored, err := s.client.Do(ctx, s.client.B().Getdel().Key(challengeKeyPrefix+challenge).Build()).ToString()
+	if errors.Is(err, valkeyClient.Nil) {
+		return ErrInvalidProofOfWork
+	}
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to look up challenge")
+	}
+
+	difficulty, err := strconv.Atoi(stored)
+	if err != nil {
+		return pkgErrors.Wrap(err, "invalid stored challenge")
+	}
+	if !solves(challenge, nonce, difficulty) {
+		return ErrInvalidProofOfWork
+	}
+	return nil
+}
+
+// solves reports whether the SHA-256 of challenge followed by nonce starts with difficulty zero bits.
+func solves(challenge string, nonce string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(challenge + nonce))
+
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
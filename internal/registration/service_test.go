@@ -0,0 +1,175 @@
+package registration
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+// newTestService opens registration with cfg on a database holding the given
+// number of users and an invite for ABCD-EFGH with a single use.
+func newTestService(t *testing.T, cfg domain.RegistrationConfig, users int) (Service, domain.InviteRepo) {
+	ctx := context.Background()
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	db, log := databasetest.New(t)
+	userRepo := database.NewUserRepo(log, db)
+	for i := 0; i < users; i++ {
+		databasetest.StoreUsers(t, userRepo, domain.User{HashedUUID: "bookmark-" + strconv.Itoa(i)})
+	}
+	invites := database.NewInviteRepo(log, db)
+	require.NoError(t, invites.Store(ctx, &domain.Invite{CodeHash: domain.HashInviteCode("ABCD-EFGH"), MaxUses: 1}))
+
+	return NewService(log, cfg, userRepo, invites, client), invites
+}
+
+// solve brute forces a nonce for the challenge.
+func solve(t *testing.T, challenge *domain.RegistrationChallenge) string {
+	for i := 0; i < 1<<24; i++ {
+		nonce := strconv.Itoa(i)
+		if solves(challenge.Challenge, nonce, challenge.Bits) {
+			return nonce
+		}
+	}
+	t.Fatal("no nonce found")
+	return ""
+}
+
+func TestService_Status(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   domain.RegistrationConfig
+		users int
+		want  domain.RegistrationStatus
+	}{
+		{name: "open", want: domain.RegistrationStatus{Mode: domain.RegistrationModeOpen, Open: true}},
+		{name: "closed", cfg: domain.RegistrationConfig{Mode: "closed"}, want: domain.RegistrationStatus{Mode: domain.RegistrationModeClosed}},
+		{name: "invite", cfg: domain.RegistrationConfig{Mode: "invite"}, want: domain.RegistrationStatus{Mode: domain.RegistrationModeInvite, Open: true, InviteRequired: true}},
+		{name: "below_limit", cfg: domain.RegistrationConfig{MaxUsers: 2}, users: 1, want: domain.RegistrationStatus{Mode: domain.RegistrationModeOpen, Open: true}},
+		{name: "limit_reached", cfg: domain.RegistrationConfig{MaxUsers: 2}, users: 2, want: domain.RegistrationStatus{Mode: domain.RegistrationModeOpen}},
+		{name: "proof_of_work", cfg: domain.RegistrationConfig{ProofOfWorkBits: 8}, want: domain.RegistrationStatus{Mode: domain.RegistrationModeOpen, Open: true, ProofOfWorkBits: 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _ := newTestService(t, tt.cfg, tt.users)
+			status, err := svc.Status(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *status)
+		})
+	}
+}
+
+func TestService_Admit(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     domain.RegistrationConfig
+		users   int
+		req     domain.RegisterRequest
+		wantErr error
+	}{
+		{name: "open"},
+		{name: "closed", cfg: domain.RegistrationConfig{Mode: "closed"}, wantErr: ErrRegistrationClosed},
+		// A typo must not open registration
+		{name: "unknown_mode", cfg: domain.RegistrationConfig{Mode: "invites"}, wantErr: ErrRegistrationClosed},
+		{name: "below_limit", cfg: domain.RegistrationConfig{MaxUsers: 2}, users: 1},
+		{name: "limit_reached", cfg: domain.RegistrationConfig{MaxUsers: 2}, users: 2, wantErr: ErrUserLimitReached},
+		{name: "invite_required", cfg: domain.RegistrationConfig{Mode: "invite"}, wantErr: ErrInviteRequired},
+		{name: "invite_unknown", cfg: domain.RegistrationConfig{Mode: "invite"}, req: domain.RegisterRequest{InviteCode: "WXYZ-WXYZ"}, wantErr: ErrInvalidInvite},
+		// Codes are normalised
+		{name: "invite", cfg: domain.RegistrationConfig{Mode: "invite"}, req: domain.RegisterRequest{InviteCode: "abcd efgh"}},
+		{name: "proof_of_work_required", cfg: domain.RegistrationConfig{ProofOfWorkBits: 8}, wantErr: ErrProofOfWorkRequired},
+		{name: "proof_of_work_unknown_challenge", cfg: domain.RegistrationConfig{ProofOfWorkBits: 8}, req: domain.RegisterRequest{Challenge: "unknown", Nonce: "1"}, wantErr: ErrInvalidProofOfWork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _ := newTestService(t, tt.cfg, tt.users)
+			assert.ErrorIs(t, svc.Admit(context.Background(), tt.req), tt.wantErr)
+		})
+	}
+}
+
+func TestService_AdmitLimitBestEffort(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t, domain.RegistrationConfig{MaxUsers: 1}, 0)
+
+	// The limit is counted, not reserved: registrations admitted before either
+	// account is stored both pass and together exceed max_users
+	assert.NoError(t, svc.Admit(ctx, domain.RegisterRequest{}))
+	assert.NoError(t, svc.Admit(ctx, domain.RegisterRequest{}))
+	assert.NoError(t, svc.AdmitProvisioned(ctx))
+}
+
+func TestService_InviteSpent(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t, domain.RegistrationConfig{Mode: "invite"}, 0)
+
+	// The single use is spent by the first registration
+	assert.NoError(t, svc.Admit(ctx, domain.RegisterRequest{InviteCode: "ABCD-EFGH"}))
+	assert.ErrorIs(t, svc.Admit(ctx, domain.RegisterRequest{InviteCode: "ABCD-EFGH"}), ErrInvalidInvite)
+}
+
+func TestService_ProofOfWork(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t, domain.RegistrationConfig{ProofOfWorkBits: 8}, 0)
+
+	challenge, err := svc.NewChallenge(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, challenge)
+	assert.Equal(t, 8, challenge.Bits)
+
+	nonce := solve(t, challenge)
+	assert.NoError(t, svc.Admit(ctx, domain.RegisterRequest{Challenge: challenge.Challenge, Nonce: nonce}))
+
+	// A solved challenge cannot be used again
+	assert.ErrorIs(t, svc.Admit(ctx, domain.RegisterRequest{Challenge: challenge.Challenge, Nonce: nonce}), ErrInvalidProofOfWork)
+}
+
+func TestService_NoProofOfWork(t *testing.T) {
+	svc, _ := newTestService(t, domain.RegistrationConfig{}, 0)
+
+	challenge, err := svc.NewChallenge(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, challenge)
+}
+
+func TestService_AdmitProvisioned(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     domain.RegistrationConfig
+		users   int
+		wantErr error
+	}{
+		{name: "open", cfg: domain.RegistrationConfig{}},
+		{name: "invite_not_needed", cfg: domain.RegistrationConfig{Mode: "invite"}},
+		{name: "proof_of_work_not_needed", cfg: domain.RegistrationConfig{ProofOfWorkBits: 8}},
+		{name: "below_limit", cfg: domain.RegistrationConfig{MaxUsers: 2}, users: 1},
+		{name: "closed", cfg: domain.RegistrationConfig{Mode: "closed"}, wantErr: ErrRegistrationClosed},
+		{name: "limit_reached", cfg: domain.RegistrationConfig{Mode: "invite", MaxUsers: 2}, users: 2, wantErr: ErrUserLimitReached},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, invites := newTestService(t, tt.cfg, tt.users)
+
+			err := svc.AdmitProvisioned(context.Background())
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			all, err := invites.FindAll(context.Background())
+			require.NoError(t, err)
+			assert.Zero(t, all[0].Uses, "no invite is spent")
+		})
+	}
+}
@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/flurbudurbur/Shiori/internal/domain"
@@ -49,9 +50,10 @@ func (j *CheckUpdatesJob) Run() {
 }
 
 type PruneExpiredUsersJob struct {
-	Name string
-	Log  zerolog.Logger
-	Repo domain.UserRepo // Inject UserRepo
+	Name   string
+	Log    zerolog.Logger
+	Repo   domain.UserRepo // Inject UserRepo
+	Config *domain.RetentionConfig
 }
 
 func (j *PruneExpiredUsersJob) Run() {
@@ -59,8 +61,8 @@ func (j *PruneExpiredUsersJob) Run() {
 	ctx := context.Background() // Use background context for scheduled job
 	now := time.Now()
 
-	// 1. Find expired user IDs
-	expiredIDs, err := j.Repo.FindExpiredUserIDs(ctx, now)
+	// 1. Find expired user IDs, accounts are kept read-only for the grace period
+	expiredIDs, err := j.Repo.FindExpiredUserIDs(ctx, now.AddDate(0, 0, -j.Config.GraceDays))
 	if err != nil {
 		j.Log.Error().Err(err).Msg("Failed to find expired user IDs")
 		return // Exit job run on error
@@ -92,6 +94,69 @@ func (j *PruneExpiredUsersJob) Run() {
 	j.Log.Info().Msgf("Expired user pruning job finished. Success: %d, Failed: %d", successCount, failCount)
 }
 
+// WarnExpiringUsersJob notifies users through their notification channels
+// before their account expires, once per deletion date
+type WarnExpiringUsersJob struct {
+	Name     string
+	Log      zerolog.Logger
+	Repo     domain.UserRepo
+	NotifSvc notification.Service
+	Config   *domain.RetentionConfig
+}
+
+// Run executes the expiry warning job
+func (j *WarnExpiringUsersJob) Run() {
+	if j.Config.WarningDays <= 0 {
+		j.Log.Debug().Msg("Expiry warnings are disabled")
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+
+	users, err := j.Repo.FindUsersToWarn(ctx, now, now.AddDate(0, 0, j.Config.WarningDays))
+	if err != nil {
+		j.Log.Error().Err(err).Msg("Failed to find users to warn about their expiry")
+		return
+	}
+
+	var warned, failed int
+	for _, user := range users {
+		if err := j.NotifSvc.SendToUser(ctx, user.HashedUUID, j.payload(user)); err != nil {
+			// Not marked as sent, the next run tries again
+			j.Log.Error().Err(err).Str("userHashedUUID", user.HashedUUID).Msg("Failed to send expiry warning")
+			failed++
+			continue
+		}
+		if err := j.Repo.MarkExpiryWarningSent(ctx, user.HashedUUID); err != nil {
+			j.Log.Error().Err(err).Str("userHashedUUID", user.HashedUUID).Msg("Failed to mark expiry warning as sent")
+		}
+		warned++
+	}
+
+	j.Log.Info().
+		Int("warned", warned).
+		Int("failed", failed).
+		Msg("Expiry warning job finished")
+}
+
+// payload describes the expiry of a user, the bookmark is a credential and never included.
+func (j *WarnExpiringUsersJob) payload(user domain.User) domain.NotificationPayload {
+	message := fmt.Sprintf("Your Shiori account has not synced for a while and expires on %s. Sync once to keep it.",
+		user.DeletionDate.Format("2006-01-02"))
+	if j.Config.GraceDays > 0 {
+		message += fmt.Sprintf(" After that it is read-only until it is deleted on %s.",
+			j.Config.DeletesAt(user.DeletionDate).Format("2006-01-02"))
+	}
+
+	return domain.NotificationPayload{
+		Subject:   "Your account expires soon",
+		Message:   message,
+		Event:     domain.NotificationEventAccountExpiring,
+		Timestamp: time.Now(),
+	}
+}
+
 // ProfileUUIDCleanupJob implements a scheduled job to clean up stale and orphaned profile UUIDs
 type ProfileUUIDCleanupJob struct {
 	Name   string
@@ -200,3 +265,61 @@ func (j *ProfileUUIDCleanupJob) Run() {
 		Bool("soft_delete_used", j.Config.UseSoftDelete).
 		Msg("Profile UUID cleanup job completed")
 }
+
+// PruneSyncEventsJob removes sync audit log entries older than the configured retention
+type PruneSyncEventsJob struct {
+	Name   string
+	Log    zerolog.Logger
+	Repo   domain.SyncEventRepo
+	Config *domain.SyncConfig
+}
+
+// Run executes the sync event retention job
+func (j *PruneSyncEventsJob) Run() {
+	if j.Config.EventRetentionDays <= 0 {
+		j.Log.Debug().Msg("Sync event retention is disabled, keeping all events")
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -j.Config.EventRetentionDays)
+
+	deleted, err := j.Repo.DeleteOlderThan(context.Background(), cutoff)
+	if err != nil {
+		j.Log.Error().Err(err).Msg("Failed to prune sync events")
+		return
+	}
+
+	j.Log.Info().
+		Int("deleted", deleted).
+		Int("retention_days", j.Config.EventRetentionDays).
+		Msg("Sync event pruning job finished")
+}
+
+// PruneAuditEventsJob removes security audit events older than the configured retention
+type PruneAuditEventsJob struct {
+	Name   string
+	Log    zerolog.Logger
+	Repo   domain.AuditEventRepo
+	Config *domain.AuditConfig
+}
+
+// Run executes the audit event retention job
+func (j *PruneAuditEventsJob) Run() {
+	if j.Config.RetentionDays <= 0 {
+		j.Log.Debug().Msg("Audit event retention is disabled, keeping all events")
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -j.Config.RetentionDays)
+
+	deleted, err := j.Repo.DeleteOlderThan(context.Background(), cutoff)
+	if err != nil {
+		j.Log.Error().Err(err).Msg("Failed to prune audit events")
+		return
+	}
+
+	j.Log.Info().
+		Int("deleted", deleted).
+		Int("retention_days", j.Config.RetentionDays).
+		Msg("Audit event pruning job finished")
+}
@@ -32,6 +32,8 @@ type service struct {
 	updateSvc       *update.Service
 	userRepo        domain.UserRepo
 	profileUUIDRepo domain.ProfileUUIDRepo // Add ProfileUUIDRepo
+	syncEventRepo   domain.SyncEventRepo
+	auditEventRepo  domain.AuditEventRepo
 
 	cron *cron.Cron
 	jobs map[string]cron.EntryID
@@ -40,7 +42,7 @@ type service struct {
 
 // Update NewService to accept ProfileUUIDRepo
 func NewService(log logger.Logger, config *domain.Config, notificationSvc notification.Service,
-	updateSvc *update.Service, userRepo domain.UserRepo, profileUUIDRepo domain.ProfileUUIDRepo) Service {
+	updateSvc *update.Service, userRepo domain.UserRepo, profileUUIDRepo domain.ProfileUUIDRepo, syncEventRepo domain.SyncEventRepo, auditEventRepo domain.AuditEventRepo) Service {
 	return &service{
 		log:             log.With().Str("module", "scheduler").Logger(),
 		config:          config,
@@ -48,6 +50,8 @@ func NewService(log logger.Logger, config *domain.Config, notificationSvc notifi
 		updateSvc:       updateSvc,
 		userRepo:        userRepo,
 		profileUUIDRepo: profileUUIDRepo, // Store ProfileUUIDRepo
+		syncEventRepo:   syncEventRepo,
+		auditEventRepo:  auditEventRepo,
 		cron: cron.New(cron.WithChain(
 			cron.Recover(cron.DefaultLogger), // Add recovery middleware
 		)),
@@ -93,9 +97,10 @@ func (s *service) addAppJobs() {
 
 	// --- Add PruneExpiredUsersJob ---
 	pruneUsersJob := &PruneExpiredUsersJob{
-		Name: "app-prune-expired-users",
-		Log:  s.log.With().Str("job", "app-prune-expired-users").Logger(),
-		Repo: s.userRepo,
+		Name:   "app-prune-expired-users",
+		Log:    s.log.With().Str("job", "app-prune-expired-users").Logger(),
+		Repo:   s.userRepo,
+		Config: &s.config.Retention,
 	}
 
 	// Schedule to run daily at 3 AM server time (as per plan)
@@ -106,6 +111,20 @@ func (s *service) addAppJobs() {
 		s.log.Error().Err(err).Msg("Failed to add 'app-prune-expired-users' job")
 	}
 
+	// --- Add WarnExpiringUsersJob ---
+	warnUsersJob := &WarnExpiringUsersJob{
+		Name:     "app-warn-expiring-users",
+		Log:      s.log.With().Str("job", "app-warn-expiring-users").Logger(),
+		Repo:     s.userRepo,
+		NotifSvc: s.notificationSvc,
+		Config:   &s.config.Retention,
+	}
+
+	// Daily before the pruning, users are warned at most once per deletion date
+	if _, err := s.AddJobWithSpec(warnUsersJob, "0 2 * * *", "app-warn-expiring-users"); err != nil {
+		s.log.Error().Err(err).Msg("Failed to add 'app-warn-expiring-users' job")
+	}
+
 	// --- Add ProfileUUIDCleanupJob ---
 	profileUUIDCleanupJob := &ProfileUUIDCleanupJob{
 		Name:   "app-profile-uuid-cleanup",
@@ -131,6 +150,30 @@ func (s *service) addAppJobs() {
 			Msg("Profile UUID cleanup job scheduled")
 	}
 
+	// --- Add PruneSyncEventsJob ---
+	pruneSyncEventsJob := &PruneSyncEventsJob{
+		Name:   "app-prune-sync-events",
+		Log:    s.log.With().Str("job", "app-prune-sync-events").Logger(),
+		Repo:   s.syncEventRepo,
+		Config: &s.config.Sync,
+	}
+
+	if _, err := s.AddJobWithSpec(pruneSyncEventsJob, "30 3 * * *", "app-prune-sync-events"); err != nil {
+		s.log.Error().Err(err).Msg("Failed to add 'app-prune-sync-events' job")
+	}
+
+	// --- Add PruneAuditEventsJob ---
+	pruneAuditEventsJob := &PruneAuditEventsJob{
+		Name:   "app-prune-audit-events",
+		Log:    s.log.With().Str("job", "app-prune-audit-events").Logger(),
+		Repo:   s.auditEventRepo,
+		Config: &s.config.Audit,
+	}
+
+	if _, err := s.AddJobWithSpec(pruneAuditEventsJob, "45 3 * * *", "app-prune-audit-events"); err != nil {
+		s.log.Error().Err(err).Msg("Failed to add 'app-prune-audit-events' job")
+	}
+
 	s.log.Info().Msg("Finished adding application-specific scheduled jobs")
 }
 
@@ -8,6 +8,7 @@ import (
 	"github.com/flurbudurbur/Shiori/internal/domain"
 	"github.com/flurbudurbur/Shiori/internal/logger"
 	"github.com/flurbudurbur/Shiori/internal/scheduler"
+	syncservice "github.com/flurbudurbur/Shiori/internal/sync"
 	"github.com/flurbudurbur/Shiori/internal/update"
 	"github.com/rs/zerolog"
 )
@@ -18,17 +19,19 @@ type Server struct {
 
 	scheduler     scheduler.Service
 	updateService *update.Service
+	syncService   syncservice.Service
 
 	stopWG sync.WaitGroup
 	lock   sync.Mutex
 }
 
-func NewServer(log logger.Logger, config *domain.Config, scheduler scheduler.Service, updateSvc *update.Service) *Server {
+func NewServer(log logger.Logger, config *domain.Config, scheduler scheduler.Service, updateSvc *update.Service, syncSvc syncservice.Service) *Server {
 	return &Server{
 		log:           log.With().Str("module", "server").Logger(),
 		config:        config,
 		scheduler:     scheduler,
 		updateService: updateSvc,
+		syncService:   syncSvc,
 	}
 }
 
@@ -38,6 +41,9 @@ func (s *Server) Start() error {
 	// start cron scheduler
 	s.scheduler.Start()
 
+	// start deriving reading events from uploads
+	s.syncService.Start()
+
 	return nil
 }
 
@@ -46,6 +52,9 @@ func (s *Server) Shutdown() {
 
 	// stop cron scheduler
 	s.scheduler.Stop()
+
+	// stop reading event workers
+	s.syncService.Stop()
 }
 
 func (s *Server) checkUpdates() {
@@ -0,0 +1,250 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+const (
+	tokenBytes = 32
+	// sessionTTL is the idle lifetime of a session, every use extends it.
+	sessionTTL = 30 * 24 * time.Hour
+	// touchInterval limits how often the last seen time of a session is written.
+	touchInterval = time.Minute
+
+	sessionKeyPrefix     = "websession:"
+	userSessionKeyPrefix = "websessions:"
+)
+
+type Service interface {
+	// Create starts a session for a user and returns the token for the cookie, which is only available now.
+	Create(ctx context.Context, userHashedUUID string, userAgent string, ip string) (string, *domain.Session, error)
+	// Authenticate returns the session of a cookie token and marks it seen, nil if there is none.
+	Authenticate(ctx context.Context, token string, ip string) (*domain.Session, error)
+	// List returns the sessions of a user, most recently seen first.
+	List(ctx context.Context, userHashedUUID string) ([]domain.Session, error)
+	// Revoke ends a session of a user, returns ErrSessionNotFound if the user has no such session.
+	Revoke(ctx context.Context, userHashedUUID string, id string) error
+	// RevokeAll ends every session of a user except the one with exceptID, which may be empty.
+	RevokeAll(ctx context.Context, userHashedUUID string, exceptID string) error
+	// Logout ends the session of a cookie token.
+	Logout(ctx context.Context, token string) error
+}
+
+type service struct {
+	log    zerolog.Logger
+	client valkeyClient.Client
+}
+
+func NewService(log logger.Logger, client valkeyClient.Client) Service {
+	return &service{
+		log:    log.With().Str("module", "session").Logger(),
+		client: client,
+	}
+}
+
+// sessionID returns the ID a cookie token is stored under.
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionKey(id string) string {
+	return sessionKeyPrefix + id
+}
+
+func userSessionsKey(userHashedUUID string) string {
+	return userSessionKeyPrefix + userHashedUUID
+}
+
+func (s *service) Create(ctx context.Context, userHashedUUID string, userAgent string, ip string) (string, *domain.Session, error) {
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, pkgErrors.Wrap(err, "could not generate session token")
+	}
+	token := hex.EncodeToString(raw)
+
+	now := time.Now()
+	session := &domain.Session{
+		ID:             sessionID(token),
+		UserHashedUUID: userHashedUUID,
+		UserAgent:      userAgent,
+		IP:             ip,
+		CreatedAt:      now,
+		LastSeenAt:     now,
+	}
+
+	if err := s.store(ctx, session); err != nil {
+		return "", nil, err
+	}
+
+	s.log.Info().Str("hashed_uuid", userHashedUUID).Str("session_id", session.ID[:8]).Msg("session created")
+	return token, session, nil
+}
+
+// record is the stored form of a session. The user is left out of the JSON of
+// domain.Session, which is returned by the API.
+type record struct {
+	domain.Session
+	UserHashedUUID string `json:"user_hashed_uuid"`
+}
+
+// store writes a session and adds it to the set of its user, both expire after sessionTTL.
+func (s *service) store(ctx context.Context, session *domain.Session) error {
+	encoded, err := json.Marshal(record{Session: *session, UserHashedUUID: session.UserHashedUUID})
+	if err != nil {
+		return pkgErrors.Wrap(err, "could not encode session")
+	}
+
+	results := s.client.DoMulti(ctx,
+		s.client.B().Set().Key(sessionKey(session.ID)).Value(string(encoded)).Ex(sessionTTL).Build(),
+		s.client.B().Sadd().Key(userSessionsKey(session.UserHashedUUID)).Member(session.ID).Build(),
+		s.client.B().Expire().Key(userSessionsKey(session.UserHashedUUID)).Seconds(int64(sessionTTL.Seconds())).Build(),
+	)
+	for _, result := range results {
+		if err := result.Error(); err != nil {
+			return pkgErrors.Wrap(err, "could not store session")
+		}
+	}
+
+	return nil
+}
+
+// get returns a stored session, nil if it does not exist or expired.
+func (s *service) get(ctx context.Context, id string) (*domain.Session, error) {
+	value, err := s.client.Do(ctx, s.client.B().Get().Key(sessionKey(id)).Build()).ToString()
+	if err != nil {
+		if errors.Is(err, valkeyClient.Nil) {
+			return nil, nil
+		}
+		return nil, pkgErrors.Wrap(err, "could not read session")
+	}
+
+	var stored record
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return nil, pkgErrors.Wrap(err, "could not decode session")
+	}
+	stored.Session.UserHashedUUID = stored.UserHashedUUID
+	return &stored.Session, nil
+}
+
+func (s *service) Authenticate(ctx context.Context, token string, ip string) (*domain.Session, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	session, err := s.get(ctx, sessionID(token))
+	if err != nil || session == nil {
+		return nil, err
+	}
+
+	if time.Since(session.LastSeenAt) >= touchInterval || session.IP != ip {
+		session.LastSeenAt = time.Now()
+		session.IP = ip
+		if err := s.store(ctx, session); err != nil {
+			// The session stays valid until its previous expiry
+			s.log.Error().Err(err).Str("session_id", session.ID[:8]).Msg("could not update session")
+		}
+	}
+
+	return session, nil
+}
+
+func (s *service) List(ctx context.Context, userHashedUUID string) ([]domain.Session, error) {
+	ids, err := s.client.Do(ctx, s.client.B().Smembers().Key(userSessionsKey(userHashedUUID)).Build()).AsStrSlice()
+	if err != nil {
+		return nil, pkgErrors.Wrap(err, "could not list sessions")
+	}
+
+	sessions := []domain.Session{}
+	var expired []string
+	for _, id := range ids {
+		session, err := s.get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if session == nil {
+			expired = append(expired, id)
+			continue
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if len(expired) > 0 {
+		// Sessions expire on their own, drop them from the set of the user as well
+		if err := s.client.Do(ctx, s.client.B().Srem().Key(userSessionsKey(userHashedUUID)).Member(expired...).Build()).Error(); err != nil {
+			s.log.Error().Err(err).Str("hashed_uuid", userHashedUUID).Msg("could not remove expired sessions")
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (s *service) Revoke(ctx context.Context, userHashedUUID string, id string) error {
+	session, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if session == nil || session.UserHashedUUID != userHashedUUID {
+		return domain.ErrSessionNotFound
+	}
+
+	return s.delete(ctx, userHashedUUID, id)
+}
+
+func (s *service) RevokeAll(ctx context.Context, userHashedUUID string, exceptID string) error {
+	ids, err := s.client.Do(ctx, s.client.B().Smembers().Key(userSessionsKey(userHashedUUID)).Build()).AsStrSlice()
+	if err != nil {
+		return pkgErrors.Wrap(err, "could not list sessions")
+	}
+
+	for _, id := range ids {
+		if id == exceptID {
+			continue
+		}
+		if err := s.delete(ctx, userHashedUUID, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *service) Logout(ctx context.Context, token string) error {
+	session, err := s.get(ctx, sessionID(token))
+	if err != nil || session == nil {
+		return err
+	}
+
+	return s.delete(ctx, session.UserHashedUUID, session.ID)
+}
+
+func (s *service) delete(ctx context.Context, userHashedUUID string, id string) error {
+	results := s.client.DoMulti(ctx,
+		s.client.B().Del().Key(sessionKey(id)).Build(),
+		s.client.B().Srem().Key(userSessionsKey(userHashedUUID)).Member(id).Build(),
+	)
+	for _, result := range results {
+		if err := result.Error(); err != nil {
+			return pkgErrors.Wrap(err, "could not delete session")
+		}
+	}
+
+	s.log.Info().Str("hashed_uuid", userHashedUUID).Str("session_id", id[:min(len(id), 8)]).Msg("session ended")
+	return nil
+}
@@ -0,0 +1,193 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/flurbudurbur/Shiori/internal/config"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+func newTestService(t *testing.T) (*service, *miniredis.Miniredis) {
+	cfg := config.New(t.TempDir(), "test").Config
+	cfg.Logging.Path = ""
+
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	return NewService(logger.New(cfg), client).(*service), valkey
+}
+
+func TestService_CreateAndAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	svc, valkey := newTestService(t)
+
+	token, created, err := svc.Create(ctx, "bookmark", "Firefox", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, sessionID(token), created.ID)
+	assert.NotContains(t, valkey.Keys(), sessionKey(token), "the token itself is never stored")
+	assert.Equal(t, sessionTTL, valkey.TTL(sessionKey(created.ID)))
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "valid", token: token, want: true},
+		{name: "empty", token: ""},
+		{name: "unknown", token: "unknown"},
+		{name: "session_id", token: created.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := svc.Authenticate(ctx, tt.token, "192.0.2.1")
+			require.NoError(t, err)
+			if !tt.want {
+				assert.Nil(t, session)
+				return
+			}
+			require.NotNil(t, session)
+			assert.Equal(t, "bookmark", session.UserHashedUUID)
+			assert.Equal(t, "Firefox", session.UserAgent)
+		})
+	}
+
+	// Use from another address is recorded and extends the session
+	valkey.FastForward(time.Hour)
+	session, err := svc.Authenticate(ctx, token, "192.0.2.2")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.2", session.IP)
+	assert.Equal(t, sessionTTL, valkey.TTL(sessionKey(created.ID)))
+
+	// Idle sessions expire
+	valkey.FastForward(sessionTTL)
+	session, err = svc.Authenticate(ctx, token, "192.0.2.2")
+	require.NoError(t, err)
+	assert.Nil(t, session)
+}
+
+func TestService_List(t *testing.T) {
+	ctx := context.Background()
+	svc, valkey := newTestService(t)
+
+	_, first, err := svc.Create(ctx, "bookmark", "Firefox", "192.0.2.1")
+	require.NoError(t, err)
+	_, second, err := svc.Create(ctx, "bookmark", "Safari", "192.0.2.2")
+	require.NoError(t, err)
+	_, _, err = svc.Create(ctx, "other", "Chrome", "192.0.2.3")
+	require.NoError(t, err)
+
+	sessions, err := svc.List(ctx, "bookmark")
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, second.ID, sessions[0].ID, "most recently seen first")
+	assert.Equal(t, first.ID, sessions[1].ID)
+
+	// Expired sessions are left out and dropped from the set of the user
+	valkey.Del(sessionKey(first.ID))
+	sessions, err = svc.List(ctx, "bookmark")
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	members, err := valkey.Members(userSessionsKey("bookmark"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{second.ID}, members)
+
+	sessions, err = svc.List(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestService_Revoke(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+
+	token, session, err := svc.Create(ctx, "bookmark", "Firefox", "192.0.2.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		user    string
+		id      string
+		wantErr error
+	}{
+		{name: "other_user", user: "other", id: session.ID, wantErr: domain.ErrSessionNotFound},
+		{name: "unknown", user: "bookmark", id: "unknown", wantErr: domain.ErrSessionNotFound},
+		{name: "own", user: "bookmark", id: session.ID},
+		{name: "already_revoked", user: "bookmark", id: session.ID, wantErr: domain.ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.Revoke(ctx, tt.user, tt.id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			authenticated, err := svc.Authenticate(ctx, token, "192.0.2.1")
+			require.NoError(t, err)
+			assert.Nil(t, authenticated)
+		})
+	}
+}
+
+func TestService_RevokeAll(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+
+	current, _, err := svc.Create(ctx, "bookmark", "Firefox", "192.0.2.1")
+	require.NoError(t, err)
+	old, _, err := svc.Create(ctx, "bookmark", "Safari", "192.0.2.2")
+	require.NoError(t, err)
+	other, _, err := svc.Create(ctx, "other", "Chrome", "192.0.2.3")
+	require.NoError(t, err)
+
+	require.NoError(t, svc.RevokeAll(ctx, "bookmark", sessionID(current)))
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "kept", token: current, want: true},
+		{name: "revoked", token: old},
+		{name: "other_user", token: other, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := svc.Authenticate(ctx, tt.token, "192.0.2.1")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, session != nil)
+		})
+	}
+
+	// Without an exception every session ends, a merged account uses this
+	require.NoError(t, svc.RevokeAll(ctx, "bookmark", ""))
+	sessions, err := svc.List(ctx, "bookmark")
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestService_Logout(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+
+	token, _, err := svc.Create(ctx, "bookmark", "Firefox", "192.0.2.1")
+	require.NoError(t, err)
+
+	require.NoError(t, svc.Logout(ctx, token))
+	session, err := svc.Authenticate(ctx, token, "192.0.2.1")
+	require.NoError(t, err)
+	assert.Nil(t, session)
+
+	// Logging out twice or with an unknown token is not an error
+	assert.NoError(t, svc.Logout(ctx, token))
+}
@@ -0,0 +1,286 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/backup"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/internal/user"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const tokenBytes = 32
+
+var (
+	ErrInvalidShare       = errors.Sentinel("invalid share")
+	ErrNoData             = errors.Sentinel("nothing to share, the slot has no data")
+	ErrSnapshotChanged    = errors.Sentinel("the library changed, the requested snapshot is no longer available")
+	ErrShareGone          = errors.Sentinel("share expired or revoked")
+	ErrPassphraseRequired = errors.Sentinel("passphrase required")
+)
+
+type Service interface {
+	// Create a share, returns the plain token which is only available now.
+	Create(ctx context.Context, userHashedUUID string, req domain.CreateShareRequest) (string, *domain.Share, error)
+	List(ctx context.Context, userHashedUUID string) ([]domain.Share, error)
+	Revoke(ctx context.Context, userHashedUUID string, id int64) error
+	// Open returns the share for a token together with the bare backup document it gives access to.
+	// Wrong passphrases count towards the lockout of the share, a *user.LockoutError is returned while it lasts.
+	Open(ctx context.Context, token string, passphrase string) (*domain.Share, []byte, error)
+	// Library renders the JSON view of a backup document returned by Open.
+	Library(share *domain.Share, document []byte) (*domain.SharedLibrary, error)
+}
+
+type service struct {
+	log      zerolog.Logger
+	repo     domain.ShareRepo
+	syncRepo domain.SyncRepo
+	limiter  user.RateLimiterStore
+}
+
+func NewService(log logger.Logger, repo domain.ShareRepo, syncRepo domain.SyncRepo, limiter user.RateLimiterStore) Service {
+	return &service{
+		log:      log.With().Str("module", "share").Logger(),
+		repo:     repo,
+		syncRepo: syncRepo,
+		limiter:  limiter,
+	}
+}
+
+// hashToken returns the value stored for a token. Tokens are random, so a plain
+// SHA-256 is enough and allows looking shares up by token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *service) Create(ctx context.Context, userHashedUUID string, req domain.CreateShareRequest) (string, *domain.Share, error) {
+	if req.Scope == "" {
+		req.Scope = domain.ShareScopeLibrary
+	}
+	if strings.EqualFold(req.Slot, domain.DefaultSyncSlotName) {
+		req.Slot = domain.DefaultSyncSlot
+	}
+
+	switch req.Scope {
+	case domain.ShareScopeLibrary, domain.ShareScopeSnapshot:
+	case domain.ShareScopeCategories:
+		if len(req.Categories) == 0 {
+			return "", nil, errors.Wrap(ErrInvalidShare, "categories scope needs at least one category")
+		}
+	default:
+		return "", nil, errors.Wrap(ErrInvalidShare, "unknown scope %q", req.Scope)
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return "", nil, errors.Wrap(ErrInvalidShare, "expiry must be in the future")
+	}
+
+	data, etag, err := s.syncRepo.GetSyncDataAndETag(ctx, userHashedUUID, req.Slot)
+	if err != nil {
+		return "", nil, err
+	}
+	if data == nil {
+		return "", nil, ErrNoData
+	}
+
+	share := &domain.Share{
+		UserHashedUUID: userHashedUUID,
+		Name:           req.Name,
+		Scope:          req.Scope,
+		Slot:           req.Slot,
+		ExpiresAt:      req.ExpiresAt,
+	}
+
+	if req.Scope == domain.ShareScopeCategories {
+		share.Categories = req.Categories
+	}
+
+	if req.Scope == domain.ShareScopeSnapshot {
+		if req.SnapshotETag != "" && req.SnapshotETag != *etag {
+			return "", nil, ErrSnapshotChanged
+		}
+		share.SnapshotETag = *etag
+		share.Snapshot = data
+	}
+
+	if req.Passphrase != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Passphrase), bcrypt.DefaultCost)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "could not hash share passphrase")
+		}
+		share.PassphraseHash = string(hash)
+		share.HasPassphrase = true
+	}
+
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, errors.Wrap(err, "could not generate share token")
+	}
+	token := hex.EncodeToString(raw)
+	share.TokenHash = hashToken(token)
+
+	if err := s.repo.Store(ctx, share); err != nil {
+		return "", nil, err
+	}
+
+	s.log.Info().Int64("share_id", share.ID).Str("scope", string(share.Scope)).Msg("share created")
+	return token, share, nil
+}
+
+func (s *service) List(ctx context.Context, userHashedUUID string) ([]domain.Share, error) {
+	shares, err := s.repo.FindByUser(ctx, userHashedUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range shares {
+		shares[i].HasPassphrase = shares[i].Protected()
+	}
+	return shares, nil
+}
+
+func (s *service) Revoke(ctx context.Context, userHashedUUID string, id int64) error {
+	return s.repo.Revoke(ctx, userHashedUUID, id)
+}
+
+func (s *service) Open(ctx context.Context, token string, passphrase string) (*domain.Share, []byte, error) {
+	share, err := s.repo.FindByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if share == nil {
+		return nil, nil, domain.ErrShareNotFound
+	}
+	if !share.Active(time.Now()) {
+		return nil, nil, ErrShareGone
+	}
+
+	if share.Protected() {
+		if err := s.checkPassphrase(ctx, share, passphrase); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	data := share.Snapshot
+	if share.Scope != domain.ShareScopeSnapshot {
+		if data, _, err = s.syncRepo.GetSyncDataAndETag(ctx, share.UserHashedUUID, share.Slot); err != nil {
+			return nil, nil, err
+		}
+		if data == nil {
+			// The slot was emptied or deleted after sharing
+			return nil, nil, ErrShareGone
+		}
+	}
+
+	var document []byte
+	if share.Scope == domain.ShareScopeCategories {
+		document, err = backup.FilterCategories(data, share.Categories)
+	} else {
+		document, err = backup.Unwrap(data)
+	}
+	if err != nil {
+		s.log.Error().Err(err).Int64("share_id", share.ID).Msg("could not read shared library")
+		return nil, nil, err
+	}
+
+	if err := s.repo.RecordAccess(ctx, share.ID); err != nil {
+		// Counting is best effort, the share is still served
+		s.log.Error().Err(err).Int64("share_id", share.ID).Msg("could not record share access")
+	}
+
+	return share, document, nil
+}
+
+// checkPassphrase compares the passphrase of a protected share. Failures are
+// counted per share, a share link passed around cannot be brute forced from
+// many addresses. Like logins it fails closed when the limiter is unavailable.
+func (s *service) checkPassphrase(ctx context.Context, share *domain.Share, passphrase string) error {
+	if passphrase == "" {
+		return ErrPassphraseRequired
+	}
+
+	key := lockoutKey(share)
+	remaining, err := s.limiter.IsLockedOut(ctx, key)
+	if err != nil {
+		return errors.Wrap(err, "could not check lockout of share %d", share.ID)
+	}
+	if remaining > 0 {
+		return &user.LockoutError{RetryAfter: remaining}
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(share.PassphraseHash), []byte(passphrase)); err != nil {
+		lockout, err := s.limiter.RecordFailure(ctx, key)
+		if err != nil {
+			s.log.Error().Err(err).Int64("share_id", share.ID).Msg("could not record wrong share passphrase")
+		}
+		if lockout > 0 {
+			s.log.Warn().Int64("share_id", share.ID).Dur("duration", lockout).Msg("too many wrong share passphrases, locked out")
+			return &user.LockoutError{RetryAfter: lockout}
+		}
+		return ErrPassphraseRequired
+	}
+
+	if err := s.limiter.ClearFailures(ctx, key); err != nil {
+		s.log.Error().Err(err).Int64("share_id", share.ID).Msg("could not clear share passphrase failures")
+	}
+	return nil
+}
+
+// lockoutKey returns the rate limiter key of a share, its token hash.
+func lockoutKey(share *domain.Share) string {
+	return "share:" + share.TokenHash
+}
+
+func (s *service) Library(share *domain.Share, document []byte) (*domain.SharedLibrary, error) {
+	decoded, err := backup.Decode(document)
+	if err != nil {
+		return nil, err
+	}
+
+	library := &domain.SharedLibrary{
+		Name:       share.Name,
+		Scope:      share.Scope,
+		SharedAt:   share.CreatedAt,
+		Categories: []string{},
+		Manga:      []domain.SharedManga{},
+	}
+
+	categoryNames := map[int64]string{}
+	for _, category := range decoded.Categories {
+		categoryNames[category.Order] = category.Name
+		library.Categories = append(library.Categories, category.Name)
+	}
+
+	for _, manga := range decoded.Manga {
+		entry := domain.SharedManga{
+			Title:         manga.Title,
+			Source:        manga.Source,
+			URL:           manga.URL,
+			Favorite:      manga.Favorite,
+			Categories:    []string{},
+			ChaptersTotal: len(manga.Chapters),
+		}
+		for _, order := range manga.Categories {
+			if name, ok := categoryNames[order]; ok {
+				entry.Categories = append(entry.Categories, name)
+			}
+		}
+		for _, chapter := range manga.Chapters {
+			if chapter.Read {
+				entry.ChaptersRead++
+				entry.LastChapterRead = max(entry.LastChapterRead, chapter.ChapterNumber)
+			}
+		}
+		library.Manga = append(library.Manga, entry)
+	}
+
+	return library, nil
+}
@@ -0,0 +1,180 @@
+package share
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/flurbudurbur/Shiori/internal/config"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+const library = `{"backupManga": [{"source": 1, "url": "/manga/1", "title": "One", "categories": [1]}, {"source": 1, "url": "/manga/2", "title": "Two"}], "backupCategories": [{"name": "Reading", "order": 1}]}`
+
+// shareRepo keeps shares by token hash.
+type shareRepo struct {
+	domain.ShareRepo
+	shares map[string]*domain.Share
+}
+
+func (r *shareRepo) Store(ctx context.Context, share *domain.Share) error {
+	share.ID = int64(len(r.shares) + 1)
+	r.shares[share.TokenHash] = share
+	return nil
+}
+
+func (r *shareRepo) FindByTokenHash(ctx context.Context, tokenHash string) (*domain.Share, error) {
+	return r.shares[tokenHash], nil
+}
+
+func (r *shareRepo) RecordAccess(ctx context.Context, id int64) error {
+	return nil
+}
+
+// syncRepo serves a single library, etag "v1".
+type syncRepo struct {
+	domain.SyncRepo
+	data []byte
+}
+
+func (r *syncRepo) GetSyncDataAndETag(ctx context.Context, userHashedUUID string, slot string) ([]byte, *string, error) {
+	if r.data == nil {
+		return nil, nil, nil
+	}
+	etag := "v1"
+	return r.data, &etag, nil
+}
+
+func newService(t *testing.T, data string) (*service, *syncRepo) {
+	cfg := config.New(t.TempDir(), "test").Config
+	cfg.Logging.Path = ""
+
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	limiter := user.NewValkeyRateLimiterStore(client, domain.LockoutConfig{Threshold: 2, WindowSeconds: 60, BaseDurationSeconds: 60, MaxDurationSeconds: 600})
+	sync := &syncRepo{}
+	if data != "" {
+		sync.data = []byte(data)
+	}
+	return NewService(logger.New(cfg), &shareRepo{shares: map[string]*domain.Share{}}, sync, limiter).(*service), sync
+}
+
+func TestService_Create(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		data    string
+		req     domain.CreateShareRequest
+		wantErr error
+	}{
+		{name: "library", data: library, req: domain.CreateShareRequest{Slot: "Default"}},
+		{name: "unknown_scope", data: library, req: domain.CreateShareRequest{Scope: "everything"}, wantErr: ErrInvalidShare},
+		{name: "categories_missing", data: library, req: domain.CreateShareRequest{Scope: domain.ShareScopeCategories}, wantErr: ErrInvalidShare},
+		{name: "expired", data: library, req: domain.CreateShareRequest{ExpiresAt: &past}, wantErr: ErrInvalidShare},
+		{name: "empty_slot", req: domain.CreateShareRequest{}, wantErr: ErrNoData},
+		{name: "snapshot_changed", data: library, req: domain.CreateShareRequest{Scope: domain.ShareScopeSnapshot, SnapshotETag: "v0"}, wantErr: ErrSnapshotChanged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _ := newService(t, tt.data)
+
+			token, share, err := svc.Create(context.Background(), "user", tt.req)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, hashToken(token), share.TokenHash)
+			assert.Equal(t, domain.ShareScopeLibrary, share.Scope)
+			assert.Equal(t, domain.DefaultSyncSlot, share.Slot)
+		})
+	}
+}
+
+func TestService_Open(t *testing.T) {
+	ctx := context.Background()
+	yesterday := time.Now().Add(-24 * time.Hour)
+
+	tests := []struct {
+		name       string
+		req        domain.CreateShareRequest
+		revoke     bool
+		empty      bool // The slot is emptied after sharing
+		passphrase string
+		wantManga  int
+		wantErr    error
+	}{
+		{name: "library", wantManga: 2},
+		{name: "categories", req: domain.CreateShareRequest{Scope: domain.ShareScopeCategories, Categories: []string{"Reading"}}, wantManga: 1},
+		{name: "snapshot_of_emptied_slot", req: domain.CreateShareRequest{Scope: domain.ShareScopeSnapshot}, empty: true, wantManga: 2},
+		{name: "emptied_slot", empty: true, wantErr: ErrShareGone},
+		{name: "revoked", revoke: true, wantErr: ErrShareGone},
+		{name: "passphrase", req: domain.CreateShareRequest{Passphrase: "secret"}, passphrase: "secret", wantManga: 2},
+		{name: "passphrase_missing", req: domain.CreateShareRequest{Passphrase: "secret"}, wantErr: ErrPassphraseRequired},
+		{name: "passphrase_wrong", req: domain.CreateShareRequest{Passphrase: "secret"}, passphrase: "guess", wantErr: ErrPassphraseRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, sync := newService(t, library)
+			token, share, err := svc.Create(ctx, "user", tt.req)
+			require.NoError(t, err)
+			if tt.revoke {
+				share.RevokedAt = &yesterday
+			}
+			if tt.empty {
+				sync.data = nil
+			}
+
+			shared, document, err := svc.Open(ctx, token, tt.passphrase)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			view, err := svc.Library(shared, document)
+			require.NoError(t, err)
+			assert.Len(t, view.Manga, tt.wantManga)
+		})
+	}
+
+	svc, _ := newService(t, library)
+	_, _, err := svc.Open(ctx, "unknown", "")
+	assert.ErrorIs(t, err, domain.ErrShareNotFound)
+}
+
+func TestService_OpenLockout(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newService(t, library)
+	token, _, err := svc.Create(ctx, "user", domain.CreateShareRequest{Passphrase: "secret"})
+	require.NoError(t, err)
+
+	_, _, err = svc.Open(ctx, token, "guess")
+	assert.ErrorIs(t, err, ErrPassphraseRequired)
+
+	// The second wrong passphrase reaches the threshold
+	_, _, err = svc.Open(ctx, token, "guess")
+	var lockout *user.LockoutError
+	require.ErrorAs(t, err, &lockout)
+	assert.Equal(t, time.Minute, lockout.RetryAfter)
+
+	// Even the right passphrase is rejected while the share is locked out
+	_, _, err = svc.Open(ctx, token, "secret")
+	assert.ErrorIs(t, err, user.ErrUserLockedOut)
+
+	// Other shares are not affected
+	other, _, err := svc.Create(ctx, "user", domain.CreateShareRequest{Passphrase: "secret"})
+	require.NoError(t, err)
+	_, _, err = svc.Open(ctx, other, "secret")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,61 @@
+package sync
+
+import (
+	"context"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+)
+
+// addHistory keeps the version an upload replaced. Failures are logged but never fail the upload.
+func (s service) addHistory(ctx context.Context, userHashedUUID string, slot string, etag string, data []byte) {
+	keep := s.cfg.Sync.HistoryVersions
+	if keep <= 0 {
+		return
+	}
+
+	if err := s.repo.AddHistory(ctx, userHashedUUID, slot, etag, data, keep); err != nil {
+		s.log.Error().Err(err).Str("slot", slot).Msg("could not keep replaced version in the history")
+	}
+}
+
+// checkSlot returns ErrSyncSlotNotFound for a named slot the user does not have, the default slot always exists.
+func (s service) checkSlot(ctx context.Context, userHashedUUID string, slot string) error {
+	if slot == domain.DefaultSyncSlot {
+		return nil
+	}
+
+	slots, err := s.repo.ListSlots(ctx, userHashedUUID)
+	if err != nil {
+		return err
+	}
+	for _, existing := range slots {
+		if !existing.Default && existing.Name == slot {
+			return nil
+		}
+	}
+	return domain.ErrSyncSlotNotFound
+}
+
+// List the replaced versions of a slot, newest first.
+func (s service) ListHistory(ctx context.Context, userHashedUUID string, slot string) ([]domain.SyncHistoryEntry, error) {
+	if err := s.checkSlot(ctx, userHashedUUID, slot); err != nil {
+		return nil, err
+	}
+
+	return s.repo.ListHistory(ctx, userHashedUUID, slot)
+}
+
+// Restore a replaced version of a slot like an upload of it, so the quota applies and
+// the version it replaces is kept in the history in turn.
+func (s service) RestoreHistory(ctx context.Context, userHashedUUID string, slot string, id int64) (*string, error) {
+	if err := s.checkSlot(ctx, userHashedUUID, slot); err != nil {
+		return nil, err
+	}
+
+	data, err := s.repo.GetHistoryData(ctx, userHashedUUID, slot, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.SetSyncData(ctx, userHashedUUID, slot, data)
+}
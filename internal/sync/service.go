@@ -3,63 +3,214 @@ package sync
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/flurbudurbur/Shiori/internal/notification"
+	"github.com/flurbudurbur/Shiori/internal/reading"
 
 	"github.com/flurbudurbur/Shiori/internal/domain"
 	"github.com/flurbudurbur/Shiori/internal/logger"
 	"github.com/rs/zerolog"
 )
 
+const (
+	// readingWorkers is the number of uploads whose reading events are derived at the same time.
+	readingWorkers = 2
+	// readingQueueSize is the number of uploads waiting for their reading events, more are skipped.
+	readingQueueSize = 64
+)
+
 type Service interface {
+	// Start the workers deriving reading events from uploads.
+	Start()
+	// Stop the workers, uploads still queued are skipped.
+	Stop()
 	// Get etag of sync data.
 	// For avoid memory usage, only the etag will be returnedj
-	GetSyncDataETag(ctx context.Context, userHashedUUID string) (*string, error)
+	GetSyncDataETag(ctx context.Context, userHashedUUID string, slot string) (*string, error)
 	// Get sync data and etag
-	GetSyncDataAndETag(ctx context.Context, userHashedUUID string) ([]byte, *string, error)
+	GetSyncDataAndETag(ctx context.Context, userHashedUUID string, slot string) ([]byte, *string, error)
 	// Create or replace sync data, returns the new etag.
-	SetSyncData(ctx context.Context, userHashedUUID string, data []byte) (*string, error)
+	// Returns ErrQuotaExceeded if the user would store more than the storage quota.
+	SetSyncData(ctx context.Context, userHashedUUID string, slot string, data []byte) (*string, error)
 	// Replace sync data only if the etag matches,
-	// returns the new etag if updated, or nil if not.
-	SetSyncDataIfMatch(ctx context.Context, userHashedUUID string, etag string, data []byte) (*string, error)
+	// returns the new etag if updated, or nil if not. The storage quota applies as well.
+	SetSyncDataIfMatch(ctx context.Context, userHashedUUID string, slot string, etag string, data []byte) (*string, error)
+	// List the slots of a user, the default slot first.
+	ListSlots(ctx context.Context, userHashedUUID string) ([]domain.SyncSlot, error)
+	// Create an empty named slot.
+	CreateSlot(ctx context.Context, userHashedUUID string, name string) (*domain.SyncSlot, error)
+	// Rename a named slot.
+	RenameSlot(ctx context.Context, userHashedUUID string, name string, newName string) error
+	// Delete a named slot and its data.
+	DeleteSlot(ctx context.Context, userHashedUUID string, name string) error
+	// List the replaced versions of a slot, newest first.
+	ListHistory(ctx context.Context, userHashedUUID string, slot string) ([]domain.SyncHistoryEntry, error)
+	// Restore a replaced version of a slot, returns the new etag. The version it replaces is kept in the history.
+	RestoreHistory(ctx context.Context, userHashedUUID string, slot string, id int64) (*string, error)
+	// Record an entry in the sync audit log.
+	RecordEvent(ctx context.Context, event domain.SyncEvent) error
+	// Find entries of the sync audit log.
+	FindEvents(ctx context.Context, params domain.SyncEventQueryParams) ([]domain.SyncEvent, int, error)
+	// Get sync statistics of a user over the last windowDays days.
+	GetUserStats(ctx context.Context, userHashedUUID string, windowDays int) (*domain.UserSyncStats, error)
+	// Get instance-wide statistics over the last windowDays days.
+	GetServerStats(ctx context.Context, windowDays int) (*domain.ServerStats, error)
 }
 
-func NewService(log logger.Logger, repo domain.SyncRepo, notificationSvc notification.Service) Service {
+func NewService(log logger.Logger, cfg *domain.Config, repo domain.SyncRepo, eventRepo domain.SyncEventRepo, userRepo domain.UserRepo, readingSvc reading.Service, notificationSvc notification.Service) Service {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &service{
 		log:                 log.With().Str("module", "sync").Logger(),
+		cfg:                 cfg,
 		repo:                repo,
+		eventRepo:           eventRepo,
+		userRepo:            userRepo,
+		readingService:      readingSvc,
 		notificationService: notificationSvc,
 		// apiRepo removed
+		ingests: make(chan ingest, readingQueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		workers: &sync.WaitGroup{},
 	}
 }
 
 type service struct {
 	log                 zerolog.Logger
+	cfg                 *domain.Config
 	repo                domain.SyncRepo
+	eventRepo           domain.SyncEventRepo
+	userRepo            domain.UserRepo
+	readingService      reading.Service
 	notificationService notification.Service
 	// apiRepo removed
+
+	ingests chan ingest
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers *sync.WaitGroup
+}
+
+// ingest is an upload waiting for its reading events.
+type ingest struct {
+	userHashedUUID    string
+	slot              string
+	previous, current []byte
+}
+
+func (s service) Start() {
+	for i := 0; i < readingWorkers; i++ {
+		s.workers.Add(1)
+		go s.ingestWorker()
+	}
+}
+
+func (s service) Stop() {
+	s.cancel()
+	s.workers.Wait()
+}
+
+func (s service) ingestWorker() {
+	defer s.workers.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case job := <-s.ingests:
+			ctx, cancel := context.WithTimeout(s.ctx, time.Minute)
+			if err := s.readingService.Ingest(ctx, job.userHashedUUID, job.slot, job.previous, job.current); err != nil {
+				s.log.Error().Err(err).Msg("could not derive reading events")
+			}
+			cancel()
+		}
+	}
 }
 
 // Get etag of sync data.
 // For avoid memory usage, only the etag will be returned.
-func (s service) GetSyncDataETag(ctx context.Context, userHashedUUID string) (*string, error) {
-	return s.repo.GetSyncDataETag(ctx, userHashedUUID)
+func (s service) GetSyncDataETag(ctx context.Context, userHashedUUID string, slot string) (*string, error) {
+	return s.repo.GetSyncDataETag(ctx, userHashedUUID, slot)
 }
 
 // Get sync data and etag
-func (s service) GetSyncDataAndETag(ctx context.Context, userHashedUUID string) ([]byte, *string, error) {
-	return s.repo.GetSyncDataAndETag(ctx, userHashedUUID)
+func (s service) GetSyncDataAndETag(ctx context.Context, userHashedUUID string, slot string) ([]byte, *string, error) {
+	return s.repo.GetSyncDataAndETag(ctx, userHashedUUID, slot)
 }
 
 // Create or replace sync data, returns the new etag.
-func (s service) SetSyncData(ctx context.Context, userHashedUUID string, data []byte) (*string, error) {
-	return s.repo.SetSyncData(ctx, userHashedUUID, data)
+func (s service) SetSyncData(ctx context.Context, userHashedUUID string, slot string, data []byte) (*string, error) {
+	if err := s.checkQuota(ctx, userHashedUUID, slot, int64(len(data))); err != nil {
+		return nil, err
+	}
+	previous, previousETag := s.previousSnapshot(ctx, userHashedUUID, slot)
+
+	etag, err := s.repo.SetSyncData(ctx, userHashedUUID, slot, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if previousETag != nil {
+		s.addHistory(ctx, userHashedUUID, slot, *previousETag, previous)
+	}
+	s.deriveReadingEvents(userHashedUUID, slot, previous, data)
+	return etag, nil
 }
 
 // Replace sync data only if the etag matches,
 // returns the new etag if updated, or nil if not.
-func (s service) SetSyncDataIfMatch(ctx context.Context, userHashedUUID string, etag string, data []byte) (*string, error) {
-	return s.repo.SetSyncDataIfMatch(ctx, userHashedUUID, etag, data)
+func (s service) SetSyncDataIfMatch(ctx context.Context, userHashedUUID string, slot string, etag string, data []byte) (*string, error) {
+	if err := s.checkQuota(ctx, userHashedUUID, slot, int64(len(data))); err != nil {
+		return nil, err
+	}
+	// Uploads failing the precondition do not read the snapshot they would have replaced
+	newEtag, previous, err := s.repo.ReplaceSyncDataIfMatch(ctx, userHashedUUID, slot, etag, data)
+	if err != nil || newEtag == nil {
+		return newEtag, err
+	}
+
+	if previous != nil {
+		s.addHistory(ctx, userHashedUUID, slot, etag, previous)
+	}
+	s.deriveReadingEvents(userHashedUUID, slot, previous, data)
+	return newEtag, nil
+}
+
+// previousSnapshot loads the snapshot an unconditional upload is about to replace and its etag, nil if there is none.
+func (s service) previousSnapshot(ctx context.Context, userHashedUUID string, slot string) ([]byte, *string) {
+	data, etag, err := s.repo.GetSyncDataAndETag(ctx, userHashedUUID, slot)
+	if err != nil {
+		s.log.Warn().Err(err).Msg("could not load previous snapshot for history and reading events")
+		return nil, nil
+	}
+	return data, etag
+}
+
+// deriveReadingEvents queues the upload for the workers so uploads are not slowed down by decoding the snapshots.
+// When the queue is full the upload is skipped instead of holding on to more snapshots.
+func (s service) deriveReadingEvents(userHashedUUID string, slot string, previous, current []byte) {
+	select {
+	case s.ingests <- ingest{userHashedUUID: userHashedUUID, slot: slot, previous: previous, current: current}:
+	default:
+		s.log.Warn().Str("slot", slot).Msg("reading event queue is full, skipping upload")
+	}
+}
+
+// Record an entry in the sync audit log.
+func (s service) RecordEvent(ctx context.Context, event domain.SyncEvent) error {
+	return s.eventRepo.Store(ctx, event)
+}
+
+// Find entries of the sync audit log.
+func (s service) FindEvents(ctx context.Context, params domain.SyncEventQueryParams) ([]domain.SyncEvent, int, error) {
+	events, count, err := s.eventRepo.Find(ctx, params)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not find sync events")
+		return nil, 0, err
+	}
+
+	return events, count, nil
 }
 
 func (s service) notifySyncStarted(username string) {
@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/config"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/internal/reading"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type uploadRepo struct {
+	slotRepo
+	history []string // etags of the versions kept in the history
+	stored  []byte
+}
+
+func (uploadRepo) GetSyncDataAndETag(ctx context.Context, userHashedUUID string, slot string) ([]byte, *string, error) {
+	etag := "uuid=previous"
+	return []byte("previous"), &etag, nil
+}
+
+func (r *uploadRepo) SetSyncData(ctx context.Context, userHashedUUID string, slot string, data []byte) (*string, error) {
+	r.stored = data
+	etag := "uuid=new"
+	return &etag, nil
+}
+
+func (r *uploadRepo) AddHistory(ctx context.Context, userHashedUUID string, slot string, etag string, data []byte, keep int) error {
+	r.history = append(r.history, etag)
+	return nil
+}
+
+func (r *uploadRepo) GetHistoryData(ctx context.Context, userHashedUUID string, slot string, id int64) ([]byte, error) {
+	if id != 1 {
+		return nil, domain.ErrSyncHistoryNotFound
+	}
+	return []byte("restored"), nil
+}
+
+// ingestRecorder passes every ingested upload on to the test.
+type ingestRecorder struct {
+	reading.Service
+	ingested chan ingest
+}
+
+func (r ingestRecorder) Ingest(ctx context.Context, userHashedUUID string, slot string, previous, current []byte) error {
+	r.ingested <- ingest{userHashedUUID: userHashedUUID, slot: slot, previous: previous, current: current}
+	return nil
+}
+
+func newTestService(t *testing.T, cfg domain.SyncConfig) (Service, *uploadRepo, ingestRecorder) {
+	logCfg := config.New(t.TempDir(), "test").Config
+	logCfg.Logging.Path = ""
+
+	repo := &uploadRepo{slotRepo: slotRepo{slots: []domain.SyncSlot{
+		{Name: domain.DefaultSyncSlotName, Default: true},
+		{Name: "tablet"},
+	}}}
+	recorder := ingestRecorder{ingested: make(chan ingest, readingQueueSize)}
+	svc := NewService(logger.New(logCfg), &domain.Config{Sync: cfg}, repo, nil, nil, recorder, nil)
+	return svc, repo, recorder
+}
+
+func TestService_DeriveReadingEvents(t *testing.T) {
+	svc, _, recorder := newTestService(t, domain.SyncConfig{})
+	svc.Start()
+	defer svc.Stop()
+
+	_, err := svc.SetSyncData(context.Background(), "user", "tablet", []byte("current"))
+	require.NoError(t, err)
+
+	select {
+	case got := <-recorder.ingested:
+		assert.Equal(t, ingest{userHashedUUID: "user", slot: "tablet", previous: []byte("previous"), current: []byte("current")}, got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload was not ingested")
+	}
+}
+
+func TestService_DeriveReadingEventsQueueFull(t *testing.T) {
+	svc, _, _ := newTestService(t, domain.SyncConfig{})
+
+	// Without workers the queue fills up, further uploads are skipped instead of blocking
+	for i := 0; i < readingQueueSize+1; i++ {
+		_, err := svc.SetSyncData(context.Background(), "user", domain.DefaultSyncSlot, []byte("current"))
+		require.NoError(t, err)
+	}
+	assert.Len(t, svc.(*service).ingests, readingQueueSize)
+}
+
+func TestService_History(t *testing.T) {
+	tests := []struct {
+		name        string
+		versions    int
+		slot        string
+		id          int64
+		wantErr     error
+		wantHistory []string
+	}{
+		{name: "restore", versions: 5, slot: "tablet", id: 1, wantHistory: []string{"uuid=previous"}},
+		{name: "restore_default", versions: 5, slot: domain.DefaultSyncSlot, id: 1, wantHistory: []string{"uuid=previous"}},
+		{name: "history_disabled", slot: "tablet", id: 1},
+		{name: "missing_entry", versions: 5, slot: "tablet", id: 2, wantErr: domain.ErrSyncHistoryNotFound},
+		{name: "missing_slot", versions: 5, slot: "phone", id: 1, wantErr: domain.ErrSyncSlotNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, repo, _ := newTestService(t, domain.SyncConfig{HistoryVersions: tt.versions})
+
+			etag, err := svc.RestoreHistory(context.Background(), "user", tt.slot, tt.id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Nil(t, repo.stored)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "uuid=new", *etag)
+			assert.Equal(t, []byte("restored"), repo.stored)
+			// The version replaced by the restore is kept in turn
+			assert.Equal(t, tt.wantHistory, repo.history)
+		})
+	}
+}
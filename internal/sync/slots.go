@@ -0,0 +1,98 @@
+package sync
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+var (
+	ErrInvalidSlotName   = errors.Sentinel("invalid slot name")
+	ErrSlotLimitReached  = errors.Sentinel("slot limit reached")
+	ErrDefaultSlotLocked = errors.Sentinel("the default slot cannot be renamed or deleted")
+	ErrQuotaExceeded     = errors.Sentinel("storage quota exceeded")
+)
+
+// slotNamePattern keeps slot names safe to use as a single URL path segment.
+var slotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+-]{0,63}$`)
+
+// ValidateSlotName checks a name for a named slot.
+func ValidateSlotName(name string) error {
+	if !slotNamePattern.MatchString(name) || strings.EqualFold(name, domain.DefaultSyncSlotName) {
+		return ErrInvalidSlotName
+	}
+	return nil
+}
+
+// List the slots of a user, the default slot first.
+func (s service) ListSlots(ctx context.Context, userHashedUUID string) ([]domain.SyncSlot, error) {
+	return s.repo.ListSlots(ctx, userHashedUUID)
+}
+
+// Create an empty named slot.
+func (s service) CreateSlot(ctx context.Context, userHashedUUID string, name string) (*domain.SyncSlot, error) {
+	if err := ValidateSlotName(name); err != nil {
+		return nil, err
+	}
+
+	count, err := s.repo.CountSlots(ctx, userHashedUUID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= s.cfg.Sync.MaxSlots {
+		return nil, ErrSlotLimitReached
+	}
+
+	return s.repo.CreateSlot(ctx, userHashedUUID, name)
+}
+
+// checkQuota rejects an upload that would take the user over the storage quota. The
+// library it replaces is not counted. Concurrent uploads to different slots are
+// checked independently and can exceed the quota by at most one upload.
+func (s service) checkQuota(ctx context.Context, userHashedUUID string, slot string, size int64) error {
+	limit := s.cfg.Sync.MaxStoragePerUser
+	if limit <= 0 {
+		return nil
+	}
+
+	slots, err := s.repo.ListSlots(ctx, userHashedUUID)
+	if err != nil {
+		return err
+	}
+
+	used := size
+	for _, existing := range slots {
+		replaced := existing.Default && slot == domain.DefaultSyncSlot || !existing.Default && existing.Name == slot
+		if !replaced {
+			used += existing.Size
+		}
+	}
+	if used > limit {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Rename a named slot.
+func (s service) RenameSlot(ctx context.Context, userHashedUUID string, name string, newName string) error {
+	if name == domain.DefaultSyncSlot || strings.EqualFold(name, domain.DefaultSyncSlotName) {
+		return ErrDefaultSlotLocked
+	}
+	if err := ValidateSlotName(newName); err != nil {
+		return err
+	}
+
+	return s.repo.RenameSlot(ctx, userHashedUUID, name, newName)
+}
+
+// Delete a named slot and its data.
+func (s service) DeleteSlot(ctx context.Context, userHashedUUID string, name string) error {
+	if name == domain.DefaultSyncSlot || strings.EqualFold(name, domain.DefaultSyncSlotName) {
+		return ErrDefaultSlotLocked
+	}
+
+	return s.repo.DeleteSlot(ctx, userHashedUUID, name)
+}
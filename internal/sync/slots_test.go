@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSlotName(t *testing.T) {
+	tests := []struct {
+		name    string
+		slot    string
+		wantErr bool
+	}{
+		{name: "simple", slot: "tablet"},
+		{name: "punctuation", slot: "phone_2.old+new-1"},
+		{name: "max_length", slot: "a123456789012345678901234567890123456789012345678901234567890123"},
+		{name: "empty", slot: "", wantErr: true},
+		{name: "too_long", slot: "a1234567890123456789012345678901234567890123456789012345678901234", wantErr: true},
+		{name: "leading_dot", slot: ".hidden", wantErr: true},
+		{name: "path_separator", slot: "a/b", wantErr: true},
+		{name: "space", slot: "my phone", wantErr: true},
+		{name: "reserved_default", slot: "Default", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSlotName(tt.slot)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidSlotName)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+type slotRepo struct {
+	domain.SyncRepo
+	slots []domain.SyncSlot
+}
+
+func (r slotRepo) ListSlots(ctx context.Context, userHashedUUID string) ([]domain.SyncSlot, error) {
+	return r.slots, nil
+}
+
+func TestService_CheckQuota(t *testing.T) {
+	svc := service{
+		cfg: &domain.Config{Sync: domain.SyncConfig{MaxStoragePerUser: 100}},
+		repo: slotRepo{slots: []domain.SyncSlot{
+			{Name: domain.DefaultSyncSlotName, Default: true, Size: 40},
+			{Name: "tablet", Size: 30},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		limit   int64
+		slot    string
+		size    int64
+		wantErr bool
+	}{
+		{name: "replaces_default", limit: 100, slot: domain.DefaultSyncSlot, size: 70},
+		{name: "replaces_named", limit: 100, slot: "tablet", size: 60},
+		{name: "over_quota", limit: 100, slot: domain.DefaultSyncSlot, size: 71, wantErr: true},
+		{name: "new_slot_counts_all", limit: 100, slot: "phone", size: 31, wantErr: true},
+		{name: "unlimited", limit: 0, slot: "phone", size: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc.cfg.Sync.MaxStoragePerUser = tt.limit
+			err := svc.checkQuota(context.Background(), "user", tt.slot, tt.size)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrQuotaExceeded)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
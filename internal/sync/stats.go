@@ -0,0 +1,158 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+)
+
+const (
+	statsDateFormat   = "2006-01-02"
+	topConsumersLimit = 10
+)
+
+// GetUserStats aggregates the sync audit log of a user over the last windowDays days.
+// The database counts the events, only the latest events of each day and device are loaded.
+func (s service) GetUserStats(ctx context.Context, userHashedUUID string, windowDays int) (*domain.UserSyncStats, error) {
+	since := time.Now().UTC().AddDate(0, 0, -windowDays)
+
+	summary, err := s.eventRepo.SummarizeUser(ctx, userHashedUUID, since)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not summarize sync events for user stats")
+		return nil, err
+	}
+
+	return userStats(summary, windowDays), nil
+}
+
+// userStats buckets the summarized events of a user per day and device.
+func userStats(summary *domain.SyncEventSummary, windowDays int) *domain.UserSyncStats {
+	stats := &domain.UserSyncStats{
+		WindowDays:       windowDays,
+		PayloadSizeTrend: []domain.PayloadSizePoint{},
+		SyncsPerDay:      []domain.DailySyncCount{},
+		Devices:          []domain.DeviceSyncStats{},
+	}
+
+	days := map[string]*domain.DailySyncCount{}
+	devices := map[string]*domain.DeviceSyncStats{}
+	var dayOrder []string
+
+	for _, count := range summary.Counts {
+		day, ok := days[count.Date]
+		if !ok {
+			day = &domain.DailySyncCount{Date: count.Date}
+			days[count.Date] = day
+			dayOrder = append(dayOrder, count.Date)
+		}
+
+		device, ok := devices[count.Device]
+		if !ok {
+			device = &domain.DeviceSyncStats{Device: count.Device}
+			devices[count.Device] = device
+		}
+
+		switch {
+		case count.Method == http.MethodPut && count.Outcome == domain.SyncOutcomeOK:
+			day.Uploads += count.Count
+			device.Uploads += count.Count
+		case count.Method == http.MethodPut && count.Outcome == domain.SyncOutcomePreconditionFailed:
+			day.Conflicts += count.Count
+			device.Uploads += count.Count
+			device.Conflicts += count.Count
+		case count.Method == http.MethodGet && (count.Outcome == domain.SyncOutcomeOK || count.Outcome == domain.SyncOutcomeNotModified):
+			day.Downloads += count.Count
+		}
+	}
+
+	sort.Strings(dayOrder)
+	for _, date := range dayOrder {
+		stats.SyncsPerDay = append(stats.SyncsPerDay, *days[date])
+	}
+
+	for _, upload := range summary.LatestUploads {
+		stats.PayloadSizeTrend = append(stats.PayloadSizeTrend, domain.PayloadSizePoint{
+			Date: upload.CreatedAt.UTC().Format(statsDateFormat),
+			Size: upload.PayloadSize,
+		})
+		stats.CurrentPayloadSize = upload.PayloadSize
+	}
+
+	if summary.LatestSuccess != nil {
+		createdAt := summary.LatestSuccess.CreatedAt
+		stats.LastSuccessfulSync = &createdAt
+	}
+
+	for _, event := range summary.LatestByDevice {
+		if device, ok := devices[event.Device]; ok {
+			device.LastSeen = event.CreatedAt
+		}
+	}
+	for _, device := range devices {
+		if device.Uploads > 0 {
+			device.ConflictRate = float64(device.Conflicts) / float64(device.Uploads)
+		}
+		stats.Devices = append(stats.Devices, *device)
+	}
+	sort.Slice(stats.Devices, func(i, j int) bool {
+		return stats.Devices[i].LastSeen.After(stats.Devices[j].LastSeen)
+	})
+
+	return stats
+}
+
+// GetServerStats aggregates instance-wide usage over the last windowDays days.
+func (s service) GetServerStats(ctx context.Context, windowDays int) (*domain.ServerStats, error) {
+	since := time.Now().UTC().AddDate(0, 0, -windowDays)
+
+	totalUsers, err := s.userRepo.GetUserCount(ctx)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not count users for server stats")
+		return nil, err
+	}
+
+	activeUsers, err := s.eventRepo.CountActiveUsers(ctx, since)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not count active users for server stats")
+		return nil, err
+	}
+
+	totalStorage, topConsumers, err := s.repo.GetStorageUsage(ctx, topConsumersLimit)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not get storage usage for server stats")
+		return nil, err
+	}
+
+	outcomes, err := s.eventRepo.CountOutcomes(ctx, since, "")
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not count sync outcomes for server stats")
+		return nil, err
+	}
+
+	uploadOutcomes, err := s.eventRepo.CountOutcomes(ctx, since, http.MethodPut)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not count upload outcomes for server stats")
+		return nil, err
+	}
+
+	stats := &domain.ServerStats{
+		WindowDays:        windowDays,
+		TotalUsers:        totalUsers,
+		ActiveUsers:       activeUsers,
+		TotalStorageBytes: totalStorage,
+		TopConsumers:      topConsumers,
+		Outcomes:          outcomes,
+	}
+
+	for _, count := range uploadOutcomes {
+		stats.Uploads += count
+	}
+	if stats.Uploads > 0 {
+		stats.PreconditionFailedRate = float64(uploadOutcomes[domain.SyncOutcomePreconditionFailed]) / float64(stats.Uploads)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,68 @@
+package sync
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserStats(t *testing.T) {
+	day1 := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+
+	tests := []struct {
+		name    string
+		summary domain.SyncEventSummary
+		want    domain.UserSyncStats
+	}{
+		{
+			name: "empty",
+			want: domain.UserSyncStats{
+				WindowDays:       30,
+				PayloadSizeTrend: []domain.PayloadSizePoint{},
+				SyncsPerDay:      []domain.DailySyncCount{},
+				Devices:          []domain.DeviceSyncStats{},
+			},
+		},
+		{
+			name: "buckets_per_day_and_device",
+			summary: domain.SyncEventSummary{
+				Counts: []domain.SyncEventCount{
+					{Date: "2026-10-18", Device: "tablet", Method: http.MethodGet, Outcome: domain.SyncOutcomeNotModified, Count: 4},
+					{Date: "2026-10-17", Device: "phone", Method: http.MethodPut, Outcome: domain.SyncOutcomeOK, Count: 3},
+					{Date: "2026-10-17", Device: "tablet", Method: http.MethodPut, Outcome: domain.SyncOutcomePreconditionFailed, Count: 1},
+					{Date: "2026-10-17", Device: "tablet", Method: http.MethodPut, Outcome: domain.SyncOutcomeOK, Count: 1},
+					{Date: "2026-10-18", Device: "phone", Method: http.MethodGet, Outcome: domain.SyncOutcomeError, Count: 2},
+				},
+				LatestByDevice: []domain.SyncEvent{
+					{Device: "phone", CreatedAt: day1},
+					{Device: "tablet", CreatedAt: day2},
+				},
+				LatestUploads: []domain.SyncEvent{{PayloadSize: 20, CreatedAt: day1}},
+				LatestSuccess: &domain.SyncEvent{CreatedAt: day2},
+			},
+			want: domain.UserSyncStats{
+				WindowDays:         30,
+				LastSuccessfulSync: &day2,
+				CurrentPayloadSize: 20,
+				PayloadSizeTrend:   []domain.PayloadSizePoint{{Date: "2026-10-17", Size: 20}},
+				SyncsPerDay: []domain.DailySyncCount{
+					{Date: "2026-10-17", Uploads: 4, Conflicts: 1},
+					{Date: "2026-10-18", Downloads: 4},
+				},
+				Devices: []domain.DeviceSyncStats{
+					{Device: "tablet", Uploads: 2, Conflicts: 1, ConflictRate: 0.5, LastSeen: day2},
+					{Device: "phone", Uploads: 3, LastSeen: day1},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, *userStats(&tt.summary, 30))
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+// ErrBookmarkMismatch is returned when the bookmark confirming a deletion is not the user's
+var ErrBookmarkMismatch = pkgErrors.New("bookmark does not match")
+
+// DeleteAccount deletes the user and all of their data once bookmark confirms it. The cached
+// profile UUIDs of the user and the web sessions in sessionIDs are dropped, the sessions
+// themselves are ended by the caller.
+func (s *service) DeleteAccount(ctx context.Context, user *domain.User, bookmark string, sessionIDs []string) error {
+	if subtle.ConstantTimeCompare([]byte(bookmark), []byte(user.HashedUUID)) != 1 {
+		return ErrBookmarkMismatch
+	}
+
+	if err := s.repo.DeleteUserAndAssociatedData(ctx, user.HashedUUID); err != nil {
+		return pkgErrors.Wrap(err, "failed to delete account")
+	}
+
+	// The cached keys expire on their own, a failure here is not worth failing the deletion
+	client := s.valkeyService.GetClient()
+	keys := make([]string, 0, len(sessionIDs)+1)
+	keys = append(keys, profileUUIDKey(user.HashedUUID))
+	for _, id := range sessionIDs {
+		keys = append(keys, profileUUIDKey(id))
+	}
+	if err := client.Do(ctx, client.B().Del().Key(keys...).Build()).Error(); err != nil {
+		s.log.Error().Str("service", "user").Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to delete cached profile UUIDs of deleted account")
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", user.HashedUUID).Msg("Account deleted by its user")
+	return nil
+}
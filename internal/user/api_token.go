@@ -0,0 +1,115 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+const (
+	maxAPITokens          = 50
+	maxAPITokenNameLength = 64
+	// apiTokenUseInterval limits how often the last use of a token is written,
+	// a sync client authenticates on every request.
+	apiTokenUseInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPITokenRequest = pkgErrors.New("invalid API token request")
+	ErrAPITokenLimitReached   = pkgErrors.New("API token limit reached")
+)
+
+// CreateAPIToken creates a named token. A token cannot be granted scopes beyond
+// allowed, the scopes of the request creating it. Without requested scopes the
+// token gets all allowed scopes except admin.
+func (s *service) CreateAPIToken(ctx context.Context, hashedUUID string, allowed domain.ScopeSet, req domain.CreateAPITokenRequest) (string, *domain.APIToken, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return "", nil, pkgErrors.Wrap(ErrInvalidAPITokenRequest, "name is required")
+	}
+	if len(name) > maxAPITokenNameLength {
+		return "", nil, pkgErrors.Wrap(ErrInvalidAPITokenRequest, "name is longer than %d characters", maxAPITokenNameLength)
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return "", nil, pkgErrors.Wrap(ErrInvalidAPITokenRequest, "expiry must be in the future")
+	}
+
+	scopes := domain.ScopeSet{}
+	for _, name := range req.Scopes {
+		scope, ok := domain.ParseScope(name)
+		if !ok {
+			return "", nil, pkgErrors.Wrap(ErrInvalidAPITokenRequest, "unknown scope %q", name)
+		}
+		if !allowed.Has(scope) {
+			return "", nil, pkgErrors.Wrap(ErrInvalidAPITokenRequest, "scope %q is not granted to you", name)
+		}
+		scopes[scope] = struct{}{}
+	}
+	if len(req.Scopes) == 0 {
+		scopes = allowed.Intersect(domain.NewScopeSet(domain.ScopeSyncRead, domain.ScopeSyncWrite, domain.ScopeNotificationsWrite))
+	}
+	if len(scopes) == 0 {
+		return "", nil, pkgErrors.Wrap(ErrInvalidAPITokenRequest, "token would have no scopes")
+	}
+
+	existing, err := s.apiTokenRepo.FindByUser(ctx, hashedUUID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(existing) >= maxAPITokens {
+		return "", nil, ErrAPITokenLimitReached
+	}
+
+	generated, err := newAPIToken()
+	if err != nil {
+		s.log.Error().Str("service", "user").Err(err).Msg("Failed to generate secure API token")
+		return "", nil, err
+	}
+
+	token := &domain.APIToken{
+		UserHashedUUID: hashedUUID,
+		Name:           name,
+		LookupID:       generated.LookupID,
+		SecretHash:     generated.SecretHash,
+		Scopes:         scopes.Names(),
+		ExpiresAt:      req.ExpiresAt,
+	}
+	if err := s.apiTokenRepo.Store(ctx, token); err != nil {
+		return "", nil, err
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", hashedUUID).Int64("token_id", token.ID).Msg("Created API token")
+	return generated.Plain, token, nil
+}
+
+func (s *service) ListAPITokens(ctx context.Context, hashedUUID string) ([]domain.APIToken, error) {
+	return s.apiTokenRepo.FindByUser(ctx, hashedUUID)
+}
+
+func (s *service) RevokeAPIToken(ctx context.Context, hashedUUID string, id int64) error {
+	if err := s.apiTokenRepo.Delete(ctx, hashedUUID, id); err != nil {
+		return err
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", hashedUUID).Int64("token_id", id).Msg("Revoked API token")
+	return nil
+}
+
+// recordAPITokenUse stores the last use of a token, at most once per apiTokenUseInterval.
+// Failures are logged, they never fail the authentication.
+func (s *service) recordAPITokenUse(ctx context.Context, token *domain.APIToken, ip string) {
+	now := time.Now()
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < apiTokenUseInterval && token.LastUsedIP == ip {
+		return
+	}
+
+	if err := s.apiTokenRepo.RecordUse(ctx, token.ID, now, ip); err != nil {
+		s.log.Error().Str("service", "user").Err(err).Int64("token_id", token.ID).Msg("Failed to record API token use")
+		return
+	}
+	token.LastUsedAt = &now
+	token.LastUsedIP = ip
+}
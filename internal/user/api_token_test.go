@@ -0,0 +1,179 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// otherSecret changes the last hex digit of the secret of a token.
+func otherSecret(plain string) string {
+	if strings.HasSuffix(plain, "0") {
+		return plain[:len(plain)-1] + "1"
+	}
+	return plain[:len(plain)-1] + "0"
+}
+
+// storeAPIToken stores a sync:read token named Phone for the bookmark.
+func storeAPIToken(t *testing.T, svc *service, expiresAt *time.Time) (string, *domain.APIToken) {
+	generated, err := newAPIToken()
+	require.NoError(t, err)
+	token := &domain.APIToken{
+		UserHashedUUID: "bookmark",
+		Name:           "Phone",
+		LookupID:       generated.LookupID,
+		SecretHash:     generated.SecretHash,
+		Scopes:         []string{string(domain.ScopeSyncRead)},
+		ExpiresAt:      expiresAt,
+	}
+	require.NoError(t, svc.apiTokenRepo.Store(context.Background(), token))
+	return generated.Plain, token
+}
+
+func TestService_CreateAPIToken(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	syncOnly := domain.NewScopeSet(domain.ScopeSyncRead, domain.ScopeSyncWrite)
+
+	tests := []struct {
+		name       string
+		allowed    domain.ScopeSet
+		req        domain.CreateAPITokenRequest
+		wantScopes []string
+		wantErr    error
+	}{
+		{name: "default_scopes", allowed: domain.NewScopeSet(domain.ScopeSyncRead, domain.ScopeSyncWrite, domain.ScopeAdmin), req: domain.CreateAPITokenRequest{Name: " Phone "}, wantScopes: []string{"sync:read", "sync:write"}},
+		{name: "requested_scopes", allowed: syncOnly, req: domain.CreateAPITokenRequest{Name: "Reader", Scopes: []string{"sync:read"}}, wantScopes: []string{"sync:read"}},
+		{name: "name_missing", allowed: syncOnly, req: domain.CreateAPITokenRequest{Name: "  "}, wantErr: ErrInvalidAPITokenRequest},
+		{name: "name_too_long", allowed: syncOnly, req: domain.CreateAPITokenRequest{Name: strings.Repeat("a", maxAPITokenNameLength+1)}, wantErr: ErrInvalidAPITokenRequest},
+		{name: "expiry_in_past", allowed: syncOnly, req: domain.CreateAPITokenRequest{Name: "Phone", ExpiresAt: &past}, wantErr: ErrInvalidAPITokenRequest},
+		{name: "unknown_scope", allowed: syncOnly, req: domain.CreateAPITokenRequest{Name: "Phone", Scopes: []string{"everything"}}, wantErr: ErrInvalidAPITokenRequest},
+		{name: "scope_not_granted", allowed: syncOnly, req: domain.CreateAPITokenRequest{Name: "Phone", Scopes: []string{"admin"}}, wantErr: ErrInvalidAPITokenRequest},
+		{name: "no_scopes_left", allowed: domain.NewScopeSet(domain.ScopeAdmin), req: domain.CreateAPITokenRequest{Name: "Phone"}, wantErr: ErrInvalidAPITokenRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			svc, _, _ := newTestService(t, domain.User{HashedUUID: "bookmark"})
+
+			plain, token, err := svc.CreateAPIToken(ctx, "bookmark", tt.allowed, tt.req)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				stored, err := svc.ListAPITokens(ctx, "bookmark")
+				require.NoError(t, err)
+				assert.Empty(t, stored)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, strings.TrimSpace(tt.req.Name), token.Name)
+			assert.ElementsMatch(t, tt.wantScopes, token.Scopes)
+			assert.True(t, strings.HasPrefix(plain, "shi_"+token.LookupID+"_"))
+			assert.NotContains(t, token.SecretHash, plain)
+		})
+	}
+}
+
+func TestService_CreateAPITokenLimit(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t, domain.User{HashedUUID: "bookmark"}, domain.User{HashedUUID: "other"})
+	allowed := domain.NewScopeSet(domain.ScopeSyncRead)
+
+	for range maxAPITokens {
+		_, _, err := svc.CreateAPIToken(ctx, "bookmark", allowed, domain.CreateAPITokenRequest{Name: "Phone"})
+		require.NoError(t, err)
+	}
+	_, _, err := svc.CreateAPIToken(ctx, "bookmark", allowed, domain.CreateAPITokenRequest{Name: "Phone"})
+	assert.ErrorIs(t, err, ErrAPITokenLimitReached)
+
+	// The limit is per user
+	_, _, err = svc.CreateAPIToken(ctx, "other", allowed, domain.CreateAPITokenRequest{Name: "Phone"})
+	assert.NoError(t, err)
+}
+
+func TestService_RevokeAPIToken(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t, domain.User{HashedUUID: "bookmark", DeletionDate: time.Now().AddDate(0, 0, 30)})
+
+	plain, token, err := svc.CreateAPIToken(ctx, "bookmark", domain.NewScopeSet(domain.ScopeSyncRead), domain.CreateAPITokenRequest{Name: "Phone"})
+	require.NoError(t, err)
+
+	// Tokens of other users cannot be revoked
+	assert.ErrorIs(t, svc.RevokeAPIToken(ctx, "other", token.ID), domain.ErrAPITokenNotFound)
+
+	require.NoError(t, svc.RevokeAPIToken(ctx, "bookmark", token.ID))
+	tokens, err := svc.ListAPITokens(ctx, "bookmark")
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	_, _, err = svc.AuthenticateUserByToken(ctx, plain, "192.0.2.1")
+	assert.ErrorIs(t, err, ErrAuthenticationFailed)
+}
+
+func TestService_AuthenticateUserByNamedToken(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		owner   *domain.User // nil when the owner was deleted
+		expires *time.Time
+		token   func(plain string) string
+		wantErr error
+	}{
+		{name: "valid", owner: &domain.User{HashedUUID: "bookmark"}},
+		{name: "wrong_secret", owner: &domain.User{HashedUUID: "bookmark"}, token: otherSecret, wantErr: ErrAuthenticationFailed},
+		{name: "malformed", owner: &domain.User{HashedUUID: "bookmark"}, token: func(string) string { return "bookmark" }, wantErr: ErrAuthenticationFailed},
+		{name: "expired_token", owner: &domain.User{HashedUUID: "bookmark"}, expires: &past, wantErr: ErrTokenExpired},
+		{name: "owner_deleted", wantErr: ErrAuthenticationFailed},
+		{name: "owner_disabled", owner: &domain.User{HashedUUID: "bookmark", Disabled: true}, wantErr: ErrUserDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var users []domain.User
+			if tt.owner != nil {
+				tt.owner.DeletionDate = time.Now().AddDate(0, 0, 30)
+				users = append(users, *tt.owner)
+			}
+			svc, limiter, _ := newTestService(t, users...)
+
+			plain, _ := storeAPIToken(t, svc, tt.expires)
+			if tt.token != nil {
+				plain = tt.token(plain)
+			}
+
+			authenticated, token, err := svc.AuthenticateUserByToken(ctx, plain, "192.0.2.1")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				if tt.wantErr == ErrAuthenticationFailed {
+					assert.Equal(t, 1, limiter.failures["ip:192.0.2.1"])
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "bookmark", authenticated.HashedUUID)
+			require.NotNil(t, token)
+			assert.Equal(t, "Phone", token.Name)
+			assert.Equal(t, "192.0.2.1", token.LastUsedIP)
+		})
+	}
+}
+
+func TestService_AuthenticateUserByTokenClearsFailures(t *testing.T) {
+	ctx := context.Background()
+	svc, limiter, _ := newTestService(t, domain.User{HashedUUID: "bookmark", DeletionDate: time.Now().AddDate(0, 0, 30)})
+	plain, token := storeAPIToken(t, svc, nil)
+
+	_, _, err := svc.AuthenticateUserByToken(ctx, otherSecret(plain), "192.0.2.1")
+	assert.ErrorIs(t, err, ErrAuthenticationFailed)
+	assert.Equal(t, 1, limiter.failures["token:"+token.LookupID])
+
+	// A valid token resets its own count, the client keeps its failures
+	_, _, err = svc.AuthenticateUserByToken(ctx, plain, "192.0.2.1")
+	require.NoError(t, err)
+	assert.NotContains(t, limiter.failures, "token:"+token.LookupID)
+	assert.Equal(t, 1, limiter.failures["ip:192.0.2.1"])
+}
@@ -0,0 +1,75 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+// RotateBookmark moves the user and all of their data to a new bookmark. The
+// user token is replaced and named API tokens are deleted, the profile UUID
+// cached for token requests moves along while those of the web sessions in
+// sessionIDs are dropped, the sessions themselves are ended by the caller.
+func (s *service) RotateBookmark(ctx context.Context, user *domain.User, sessionIDs []string) (string, string, error) {
+	hashedUUID, err := generateHashedUUID()
+	if err != nil {
+		return "", "", pkgErrors.Wrap(err, "failed to generate user identifier")
+	}
+
+	rotated := *user
+	rotated.HashedUUID = hashedUUID
+	// Authenticators keep the user handle of a passkey, it must not change with the bookmark
+	rotated.WebAuthnUserHandle = base64.RawURLEncoding.EncodeToString(user.WebAuthnHandle())
+
+	plainToken, err := s.generateAndStoreUserToken(&rotated)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.repo.RotateHashedUUID(ctx, user.HashedUUID, rotated); err != nil {
+		return "", "", pkgErrors.Wrap(err, "failed to rotate bookmark")
+	}
+
+	// The data is moved already, a failure below only loses cached profile UUIDs
+	if err := moveProfileUUIDs(ctx, s.valkeyService.GetClient(), user.HashedUUID, hashedUUID, sessionIDs); err != nil {
+		s.log.Error().Str("service", "user").Err(err).Str("hashed_uuid", hashedUUID).Msg("Failed to move cached profile UUIDs of rotated bookmark")
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", hashedUUID).Msg("Bookmark rotated")
+	return hashedUUID, plainToken, nil
+}
+
+// moveProfileUUIDs re-keys the profile UUID of requests without web session,
+// which use the user ID as session ID, and deletes those of the web sessions.
+func moveProfileUUIDs(ctx context.Context, client valkeyClient.Client, oldHashedUUID string, newHashedUUID string, sessionIDs []string) error {
+	oldKey := profileUUIDKey(oldHashedUUID)
+	profileUUID, err := client.Do(ctx, client.B().Getdel().Key(oldKey).Build()).ToString()
+	if err != nil && !errors.Is(err, valkeyClient.Nil) {
+		return err
+	}
+	if err == nil && profileUUID != "" {
+		newKey := profileUUIDKey(newHashedUUID)
+		if err := client.Do(ctx, client.B().Set().Key(newKey).Value(profileUUID).Ex(profileUUIDTTL).Build()).Error(); err != nil {
+			return err
+		}
+	}
+
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(sessionIDs))
+	for _, id := range sessionIDs {
+		keys = append(keys, profileUUIDKey(id))
+	}
+	return client.Do(ctx, client.B().Del().Key(keys...).Build()).Error()
+}
+
+// profileUUIDKey is the Valkey key caching the profile UUID of a session, or of a user for requests without one.
+func profileUUIDKey(sessionID string) string {
+	return fmt.Sprintf("session:%s:profile_uuid", sessionID)
+}
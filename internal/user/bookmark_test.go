@@ -0,0 +1,36 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+func TestMoveProfileUUIDs(t *testing.T) {
+	ctx := context.Background()
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	require.NoError(t, valkey.Set("session:old:profile_uuid", "profile"))
+	require.NoError(t, valkey.Set("session:web:profile_uuid", "web-profile"))
+	require.NoError(t, valkey.Set("session:other:profile_uuid", "other-profile"))
+
+	require.NoError(t, moveProfileUUIDs(ctx, client, "old", "new", []string{"web"}))
+
+	profileUUID, err := valkey.Get("session:new:profile_uuid")
+	require.NoError(t, err)
+	assert.Equal(t, "profile", profileUUID)
+	assert.False(t, valkey.Exists("session:old:profile_uuid"))
+	assert.False(t, valkey.Exists("session:web:profile_uuid"))
+	assert.True(t, valkey.Exists("session:other:profile_uuid"))
+
+	// Users without cached profile UUID have nothing to move
+	require.NoError(t, moveProfileUUIDs(ctx, client, "missing", "new-missing", nil))
+	assert.False(t, valkey.Exists("session:new-missing:profile_uuid"))
+}
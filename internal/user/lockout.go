@@ -0,0 +1,131 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+// LockoutError is returned while a client or credential is locked out after
+// too many failed attempts. It matches ErrUserLockedOut with errors.Is.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrUserLockedOut, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockoutError) Is(target error) bool {
+	return target == ErrUserLockedOut
+}
+
+const (
+	lockoutKeyPrefix = "lockout:"
+	// lockoutHistoryTTL is how long earlier lockouts count towards the doubling of the duration.
+	lockoutHistoryTTL = 24 * time.Hour
+)
+
+// ValkeyRateLimiterStore counts failed attempts in Valkey. Every key has a
+// failure counter expiring after the configured window. Reaching the threshold
+// locks the key out, each lockout within a day doubles the duration of the
+// next one up to the configured maximum.
+type ValkeyRateLimiterStore struct {
+	client valkeyClient.Client
+	cfg    domain.LockoutConfig
+}
+
+func NewValkeyRateLimiterStore(client valkeyClient.Client, cfg domain.LockoutConfig) *ValkeyRateLimiterStore {
+	return &ValkeyRateLimiterStore{
+		client: client,
+		cfg:    cfg,
+	}
+}
+
+// Ensure ValkeyRateLimiterStore implements the interface
+var _ RateLimiterStore = (*ValkeyRateLimiterStore)(nil)
+
+func (s *ValkeyRateLimiterStore) failuresKey(key string) string {
+	return lockoutKeyPrefix + "failures:" + key
+}
+
+func (s *ValkeyRateLimiterStore) lockedKey(key string) string {
+	return lockoutKeyPrefix + "locked:" + key
+}
+
+func (s *ValkeyRateLimiterStore) historyKey(key string) string {
+	return lockoutKeyPrefix + "history:" + key
+}
+
+func (s *ValkeyRateLimiterStore) IsLockedOut(ctx context.Context, key string) (time.Duration, error) {
+	ms, err := s.client.Do(ctx, s.client.B().Pttl().Key(s.lockedKey(key)).Build()).AsInt64()
+	if err != nil {
+		return 0, pkgErrors.Wrap(err, "failed to read lockout of %s", key)
+	}
+	if ms <= 0 {
+		// -2 means there is no lockout, -1 cannot happen as lockouts are always set with an expiry
+		return 0, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (s *ValkeyRateLimiterStore) RecordFailure(ctx context.Context, key string) (time.Duration, error) {
+	window := time.Duration(max(s.cfg.WindowSeconds, 1)) * time.Second
+
+	results := s.client.DoMulti(ctx,
+		s.client.B().Incr().Key(s.failuresKey(key)).Build(),
+		s.client.B().Expire().Key(s.failuresKey(key)).Seconds(int64(window.Seconds())).Nx().Build(),
+	)
+	count, err := results[0].AsInt64()
+	if err != nil {
+		return 0, pkgErrors.Wrap(err, "failed to count failure of %s", key)
+	}
+	if count < int64(max(s.cfg.Threshold, 1)) {
+		return 0, nil
+	}
+
+	results = s.client.DoMulti(ctx,
+		s.client.B().Incr().Key(s.historyKey(key)).Build(),
+		s.client.B().Expire().Key(s.historyKey(key)).Seconds(int64(lockoutHistoryTTL.Seconds())).Build(),
+	)
+	lockouts, err := results[0].AsInt64()
+	if err != nil {
+		return 0, pkgErrors.Wrap(err, "failed to count lockouts of %s", key)
+	}
+
+	duration := lockoutDuration(s.cfg, lockouts)
+	results = s.client.DoMulti(ctx,
+		s.client.B().Set().Key(s.lockedKey(key)).Value("1").Px(duration).Build(),
+		s.client.B().Del().Key(s.failuresKey(key)).Build(),
+	)
+	for _, result := range results {
+		if err := result.Error(); err != nil {
+			return 0, pkgErrors.Wrap(err, "failed to lock out %s", key)
+		}
+	}
+
+	return duration, nil
+}
+
+func (s *ValkeyRateLimiterStore) ClearFailures(ctx context.Context, key string) error {
+	if err := s.client.Do(ctx, s.client.B().Del().Key(s.failuresKey(key)).Build()).Error(); err != nil {
+		return pkgErrors.Wrap(err, "failed to clear failures of %s", key)
+	}
+	return nil
+}
+
+// lockoutDuration returns the duration of the nth lockout, doubling from the base duration.
+func lockoutDuration(cfg domain.LockoutConfig, nth int64) time.Duration {
+	base := time.Duration(max(cfg.BaseDurationSeconds, 1)) * time.Second
+	maximum := time.Duration(max(cfg.MaxDurationSeconds, cfg.BaseDurationSeconds, 1)) * time.Second
+
+	duration := base
+	for i := int64(1); i < nth && duration < maximum; i++ {
+		duration *= 2
+	}
+	return min(duration, maximum)
+}
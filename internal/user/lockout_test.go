@@ -0,0 +1,153 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+var testLockoutConfig = domain.LockoutConfig{Threshold: 3, WindowSeconds: 60, BaseDurationSeconds: 60, MaxDurationSeconds: 300}
+
+func newTestRateLimiterStore(t *testing.T) (*ValkeyRateLimiterStore, *miniredis.Miniredis) {
+	valkey := miniredis.RunT(t)
+	client, err := valkeyClient.NewClient(valkeyClient.ClientOption{InitAddress: []string{valkey.Addr()}, DisableCache: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	return NewValkeyRateLimiterStore(client, testLockoutConfig), valkey
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  domain.LockoutConfig
+		nth  int64
+		want time.Duration
+	}{
+		{name: "first", cfg: testLockoutConfig, nth: 1, want: time.Minute},
+		{name: "second_doubles", cfg: testLockoutConfig, nth: 2, want: 2 * time.Minute},
+		{name: "third_doubles_again", cfg: testLockoutConfig, nth: 3, want: 4 * time.Minute},
+		{name: "capped", cfg: testLockoutConfig, nth: 4, want: 5 * time.Minute},
+		{name: "capped_far_out", cfg: testLockoutConfig, nth: 100, want: 5 * time.Minute},
+		{name: "maximum_below_base", cfg: domain.LockoutConfig{BaseDurationSeconds: 60, MaxDurationSeconds: 10}, nth: 2, want: time.Minute},
+		{name: "unset", nth: 1, want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, lockoutDuration(tt.cfg, tt.nth))
+		})
+	}
+}
+
+func TestValkeyRateLimiterStore(t *testing.T) {
+	ctx := context.Background()
+	store, valkey := newTestRateLimiterStore(t)
+
+	// Failures below the threshold do not lock out
+	for range testLockoutConfig.Threshold - 1 {
+		duration, err := store.RecordFailure(ctx, "ip:192.0.2.1")
+		require.NoError(t, err)
+		assert.Zero(t, duration)
+	}
+	remaining, err := store.IsLockedOut(ctx, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+
+	duration, err := store.RecordFailure(ctx, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, duration)
+	remaining, err = store.IsLockedOut(ctx, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, remaining)
+
+	// Other keys are not affected
+	remaining, err = store.IsLockedOut(ctx, "ip:192.0.2.2")
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+
+	// The lockout expires, the next one within a day doubles
+	valkey.FastForward(time.Minute)
+	remaining, err = store.IsLockedOut(ctx, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+	for range testLockoutConfig.Threshold {
+		duration, err = store.RecordFailure(ctx, "ip:192.0.2.1")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2*time.Minute, duration)
+
+	// A day without lockouts starts over at the base duration
+	valkey.FastForward(lockoutHistoryTTL)
+	for range testLockoutConfig.Threshold {
+		duration, err = store.RecordFailure(ctx, "ip:192.0.2.1")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, time.Minute, duration)
+}
+
+func TestValkeyRateLimiterStore_Window(t *testing.T) {
+	ctx := context.Background()
+	store, valkey := newTestRateLimiterStore(t)
+
+	for range testLockoutConfig.Threshold - 1 {
+		_, err := store.RecordFailure(ctx, "token:abc")
+		require.NoError(t, err)
+	}
+
+	// Failures outside the window are forgotten
+	valkey.FastForward(time.Duration(testLockoutConfig.WindowSeconds) * time.Second)
+	duration, err := store.RecordFailure(ctx, "token:abc")
+	require.NoError(t, err)
+	assert.Zero(t, duration)
+
+	// So are failures before a success
+	require.NoError(t, store.ClearFailures(ctx, "token:abc"))
+	for range testLockoutConfig.Threshold - 1 {
+		duration, err = store.RecordFailure(ctx, "token:abc")
+		require.NoError(t, err)
+		assert.Zero(t, duration)
+	}
+}
+
+func TestService_HandleAuthFailure(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestRateLimiterStore(t)
+	svc, _, auditRepo := newTestService(t)
+	svc.limiter = store
+
+	keys := lockoutKeys("192.0.2.1", bookmarkLockoutKey("0b9b4a4e-5a3c-4b7f-9a53-1d9f1c1a2b3c"))
+	assert.Equal(t, []string{"bookmark:0b9b4a4e", "ip:192.0.2.1"}, keys)
+	target := &domain.User{PublicID: "public"}
+
+	for range testLockoutConfig.Threshold - 1 {
+		require.NoError(t, svc.checkLockout(ctx, keys))
+		assert.ErrorIs(t, svc.handleAuthFailure(ctx, keys, "192.0.2.1", target), ErrAuthenticationFailed)
+	}
+	assert.Empty(t, databasetest.AuditEvents(t, auditRepo))
+
+	// Reaching the threshold locks out both keys and is audited for the user
+	err := svc.handleAuthFailure(ctx, keys, "192.0.2.1", target)
+	var lockout *LockoutError
+	require.ErrorAs(t, err, &lockout)
+	assert.Equal(t, time.Minute, lockout.RetryAfter)
+	events := databasetest.AuditEvents(t, auditRepo)
+	require.Len(t, events, 1)
+	assert.Equal(t, domain.AuditActionLockout, events[0].Action)
+	assert.Equal(t, "public", events[0].TargetID)
+
+	// Another client is still locked out of the bookmark
+	err = svc.checkLockout(ctx, lockoutKeys("192.0.2.2", keys[0]))
+	require.ErrorAs(t, err, &lockout)
+	assert.ErrorIs(t, err, ErrUserLockedOut)
+
+	// An unavailable limiter rejects the attempt
+	unavailable, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, svc.checkLockout(unavailable, keys), ErrAuthenticationFailed)
+}
@@ -0,0 +1,116 @@
+package user
+
+import (
+	"context"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/pkg/argon2id"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+)
+
+// maxPasswordLength bounds the input hashed on every login attempt.
+const maxPasswordLength = 256
+
+var (
+	ErrPasswordRequired = pkgErrors.New("password required")
+	ErrInvalidPassword  = pkgErrors.New("invalid password")
+	ErrPasswordMismatch = pkgErrors.New("current password is incorrect")
+)
+
+// passwordParams returns the configured argon2id parameters.
+func (s *service) passwordParams() *argon2id.Params {
+	return &argon2id.Params{
+		Memory:      s.passwordCfg.Memory,
+		Iterations:  s.passwordCfg.Iterations,
+		Parallelism: s.passwordCfg.Parallelism,
+		SaltLength:  s.passwordCfg.SaltLength,
+		KeyLength:   s.passwordCfg.KeyLength,
+	}
+}
+
+// checkPassword verifies the password of a user. A hash made with other
+// parameters than configured is replaced after a match, a failed upgrade only
+// gets logged.
+func (s *service) checkPassword(ctx context.Context, user *domain.User, password string) (bool, error) {
+	if len(password) > maxPasswordLength {
+		return false, nil
+	}
+
+	match, params, err := argon2id.CheckHash(password, user.PasswordHash)
+	if err != nil {
+		return false, pkgErrors.Wrap(err, "failed to check password")
+	}
+	if !match {
+		return false, nil
+	}
+
+	if configured := s.passwordParams(); *params != *configured {
+		hash, err := argon2id.CreateHash(password, configured)
+		if err == nil {
+			err = s.repo.UpdatePasswordHash(ctx, user.HashedUUID, hash)
+		}
+		if err != nil {
+			s.log.Error().Str("service", "user").Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to upgrade password hash")
+		} else {
+			user.PasswordHash = hash
+			s.log.Info().Str("service", "user").Str("hashed_uuid", user.HashedUUID).Msg("Upgraded password hash to the configured parameters")
+		}
+	}
+
+	return true, nil
+}
+
+// SetPassword sets or changes the password of a user, the current password is
+// required when one is set.
+func (s *service) SetPassword(ctx context.Context, user *domain.User, currentPassword string, password string) error {
+	if len(password) < s.passwordCfg.MinLength {
+		return pkgErrors.Wrap(ErrInvalidPassword, "password must be at least %d characters", s.passwordCfg.MinLength)
+	}
+	if len(password) > maxPasswordLength {
+		return pkgErrors.Wrap(ErrInvalidPassword, "password must be at most %d characters", maxPasswordLength)
+	}
+
+	if user.HasPassword() {
+		match, err := s.checkPassword(ctx, user, currentPassword)
+		if err != nil {
+			return err
+		}
+		if !match {
+			return ErrPasswordMismatch
+		}
+	}
+
+	hash, err := argon2id.CreateHash(password, s.passwordParams())
+	if err != nil {
+		return pkgErrors.Wrap(err, "failed to hash password")
+	}
+	if err := s.repo.UpdatePasswordHash(ctx, user.HashedUUID, hash); err != nil {
+		return err
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", user.HashedUUID).Msg("Password set")
+	return nil
+}
+
+// RemovePassword removes the password of a user after checking it, the
+// bookmark UUID alone logs in again.
+func (s *service) RemovePassword(ctx context.Context, user *domain.User, currentPassword string) error {
+	if !user.HasPassword() {
+		return nil
+	}
+
+	match, err := s.checkPassword(ctx, user, currentPassword)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return ErrPasswordMismatch
+	}
+
+	if err := s.repo.UpdatePasswordHash(ctx, user.HashedUUID, ""); err != nil {
+		return err
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", user.HashedUUID).Msg("Password removed")
+	return nil
+}
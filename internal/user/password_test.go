@@ -0,0 +1,60 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/flurbudurbur/Shiori/pkg/argon2id"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_SetPassword(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t, domain.User{HashedUUID: "bookmark"})
+	user := findUser(t, svc, "bookmark")
+
+	assert.ErrorIs(t, svc.SetPassword(ctx, user, "", "123"), ErrInvalidPassword)
+
+	require.NoError(t, svc.SetPassword(ctx, user, "", "1234"))
+	user = findUser(t, svc, "bookmark")
+	require.True(t, user.HasPassword())
+
+	assert.ErrorIs(t, svc.SetPassword(ctx, user, "0000", "5678"), ErrPasswordMismatch)
+	require.NoError(t, svc.SetPassword(ctx, user, "1234", "5678"))
+	user = findUser(t, svc, "bookmark")
+
+	assert.ErrorIs(t, svc.RemovePassword(ctx, user, "1234"), ErrPasswordMismatch)
+	require.NoError(t, svc.RemovePassword(ctx, user, "5678"))
+	assert.False(t, findUser(t, svc, "bookmark").HasPassword())
+}
+
+func TestService_CheckPasswordUpgradesParams(t *testing.T) {
+	ctx := context.Background()
+	oldHash, err := argon2id.CreateHash("1234", &argon2id.Params{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	require.NoError(t, err)
+	svc, _, _ := newTestService(t, domain.User{HashedUUID: "bookmark", PasswordHash: oldHash})
+	user := findUser(t, svc, "bookmark")
+
+	match, err := svc.checkPassword(ctx, user, "wrong")
+	require.NoError(t, err)
+	assert.False(t, match)
+	assert.Equal(t, oldHash, findUser(t, svc, "bookmark").PasswordHash, "a failed check must not rehash")
+
+	match, err = svc.checkPassword(ctx, user, "1234")
+	require.NoError(t, err)
+	assert.True(t, match)
+
+	upgraded := findUser(t, svc, "bookmark").PasswordHash
+	require.NotEqual(t, oldHash, upgraded)
+	params, _, _, err := argon2id.DecodeHash(upgraded)
+	require.NoError(t, err)
+	assert.Equal(t, *svc.passwordParams(), *params)
+
+	// Current hashes are left alone
+	match, err = svc.checkPassword(ctx, user, "1234")
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.Equal(t, upgraded, findUser(t, svc, "bookmark").PasswordHash)
+}
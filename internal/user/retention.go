@@ -0,0 +1,38 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+)
+
+// retentionUpdateInterval limits how often syncs write a new deletion date.
+const retentionUpdateInterval = 24 * time.Hour
+
+// pastGracePeriod reports whether an expired user is no longer allowed in and
+// only waits for the prune job.
+func (s *service) pastGracePeriod(user *domain.User, now time.Time) bool {
+	return now.After(s.retentionCfg.DeletesAt(user.DeletionDate))
+}
+
+// ExtendRetention keeps a syncing user from expiring. Expired users are not
+// extended, their account stays read-only until it is deleted.
+func (s *service) ExtendRetention(ctx context.Context, user *domain.User) error {
+	now := time.Now()
+	if user.Expired(now) {
+		return nil
+	}
+
+	deletionDate := s.retentionCfg.ExpiresAt(now)
+	// Clients sync often, the date only needs to move once a day
+	if deletionDate.Sub(user.DeletionDate) < retentionUpdateInterval {
+		return nil
+	}
+
+	if err := s.repo.UpdateDeletionDate(ctx, user.HashedUUID, deletionDate); err != nil {
+		return err
+	}
+	user.DeletionDate = deletionDate
+	return nil
+}
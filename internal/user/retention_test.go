@@ -0,0 +1,45 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_ExtendRetention(t *testing.T) {
+	tests := []struct {
+		name         string
+		deletionDate time.Time
+		extended     bool
+	}{
+		{name: "active", deletionDate: time.Now().AddDate(0, 0, 10), extended: true},
+		// Syncs within a day do not write the date again
+		{name: "synced_recently", deletionDate: time.Now().AddDate(0, 0, 60).Add(-time.Hour)},
+		// Expired accounts stay read-only until they are deleted
+		{name: "expired", deletionDate: time.Now().Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _, _ := newTestService(t, domain.User{HashedUUID: "bookmark", DeletionDate: tt.deletionDate})
+			require.NoError(t, svc.ExtendRetention(context.Background(), findUser(t, svc, "bookmark")))
+
+			want := tt.deletionDate
+			if tt.extended {
+				want = time.Now().AddDate(0, 0, 60)
+			}
+			assert.WithinDuration(t, want, findUser(t, svc, "bookmark").DeletionDate, time.Minute)
+		})
+	}
+}
+
+func TestService_PastGracePeriod(t *testing.T) {
+	svc := &service{retentionCfg: domain.RetentionConfig{Days: 60, GraceDays: 7}}
+	expired := &domain.User{DeletionDate: time.Now().Add(-time.Hour)}
+
+	assert.False(t, svc.pastGracePeriod(expired, time.Now()))
+	assert.True(t, svc.pastGracePeriod(expired, time.Now().AddDate(0, 0, 8)))
+}
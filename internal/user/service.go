@@ -2,9 +2,6 @@ package user
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
-
 	// "database/sql" // No longer needed directly here
 	"errors"
 	"fmt"
@@ -16,16 +13,17 @@ import (
 	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors" // Use alias to avoid conflict
 	"github.com/google/uuid"                              // For UUID generation
 	valkeyClient "github.com/valkey-io/valkey-go"         // Direct import for ErrNil
-	"golang.org/x/crypto/bcrypt"                          // For API Token hashing
 	"gorm.io/gorm"                                        // Import gorm for ErrRecordNotFound
 )
 
 var (
 	ErrAuthenticationFailed = pkgErrors.New("authentication failed")
 	ErrUserExpired          = pkgErrors.New("user account expired")
-	ErrUserLockedOut        = pkgErrors.New("user account locked out") // Keep for potential future use
+	ErrUserLockedOut        = pkgErrors.New("too many failed attempts") // Returned as *LockoutError
 	ErrTokenGeneration      = pkgErrors.New("failed to generate API token")
-	ErrTokenHashing         = pkgErrors.New("failed to hash API token")
+	ErrTokenExpired         = pkgErrors.New("API token expired")
+	ErrUUIDLoginDisabled    = pkgErrors.New("login with the bookmark UUID is disabled for this account")
+	ErrUserDisabled         = pkgErrors.New("user account disabled")
 )
 
 // UUIDGenerationError wraps the original error from uuid generation
@@ -43,61 +41,114 @@ func (e *UUIDGenerationError) Unwrap() error {
 	return e.OriginalError
 }
 
-// RateLimiterStore defines the interface for interacting with the rate limiting backend (Redis)
-// Kept for potential future use with token auth, but implementation needs review.
+// RateLimiterStore tracks failed authentication attempts per key and locks keys
+// out once they fail too often. Keys identify a client IP or a credential prefix.
 type RateLimiterStore interface {
-	IsLockedOut(ctx context.Context, key string) (bool, error)       // Key could be HashedUUID or token hash prefix
-	IncrementFailure(ctx context.Context, key string) (int64, error) // Returns new count
+	// IsLockedOut returns the remaining lockout of a key, zero if it is not locked out.
+	IsLockedOut(ctx context.Context, key string) (time.Duration, error)
+	// RecordFailure counts a failure and returns the lockout it caused, zero if none.
+	RecordFailure(ctx context.Context, key string) (time.Duration, error)
 	ClearFailures(ctx context.Context, key string) error
-	SetLockout(ctx context.Context, key string) error
 }
 
-// Configurable rate limiting parameters (adjust as needed for token auth)
-const (
-	lockoutThreshold = 10 // Failures before lockout (adjust for token attempts)
-	// lockoutDuration  = 30 * time.Minute // Defined in Redis implementation?
-	// failureWindow    = 10 * time.Minute // Defined in Redis implementation?
-)
+// auditRecorder stores security events, see audit.Service.
+type auditRecorder interface {
+	Record(ctx context.Context, event domain.AuditEvent)
+}
 
-// Default scopes for a new user
-const defaultScopes = `{"read": true, "write": false}` // Example JSON string
+// Default scopes for a new user, stored as a JSON array
+var defaultScopes = domain.NewScopeSet(domain.DefaultScopes...).String()
 
 type Service interface {
 	GetUserCount(ctx context.Context) (int, error)
 	// RegisterNewUser generates a new HashedUUID, generates/hashes an API token,
-	// stores the user, and returns the HashedUUID (bookmark).
-	RegisterNewUser(ctx context.Context) (hashedUUID string, err error)
-	// AuthenticateUserByToken verifies the provided plain API token against stored hashes.
-	// WARNING: Current implementation iterates through users and is inefficient.
-	AuthenticateUserByToken(ctx context.Context, plainToken string) (*domain.User, error) // This remains for potential API use
+	// stores the user, and returns the HashedUUID (bookmark) and the plain API token.
+	RegisterNewUser(ctx context.Context) (hashedUUID string, plainToken string, err error)
+	// AuthenticateUserByToken verifies a shi_<lookupID>_<secret> API token, looking the user up by its lookup ID.
+	// The APIToken is returned when a named token was used, ip is recorded as its last address.
+	AuthenticateUserByToken(ctx context.Context, plainToken string, ip string) (*domain.User, *domain.APIToken, error)
 	// GetUserForAuthentication retrieves a user by HashedUUID, intended for use after successful token auth.
 	GetUserForAuthentication(ctx context.Context, hashedUUID string) (*domain.User, error)
+	// AuthenticateByBookmark logs a user in with their bookmark, the password and the two-factor code
+	// are checked for users who set them up. Failures count towards the lockout of the client and bookmark.
+	// It returns ErrUUIDLoginDisabled for users who turned the bookmark login off, ErrPasswordRequired
+	// and ErrTwoFactorRequired when the password or code is missing.
+	AuthenticateByBookmark(ctx context.Context, hashedUUID string, password string, twoFactorCode string, ip string) (*domain.User, error)
 	// ResetAndRetrieveUserToken generates a new API token for the user, stores its hash, and returns the plain token.
 	ResetAndRetrieveUserToken(ctx context.Context, hashedUUID string) (plainToken string, err error)
+	// RotateLegacyAPIToken replaces a token issued before the prefixed format and returns the new plain token.
+	// It returns an empty string if the user already has a current token.
+	RotateLegacyAPIToken(ctx context.Context, user *domain.User) (plainToken string, err error)
 	// GetOrGenerateProfileUUID retrieves an existing profile-specific UUID from Valkey or the persistent database,
 	// or generates a new one if not found.
-	GetOrGenerateProfileUUID(ctx context.Context, sessionID string) (string, error)
+	GetOrGenerateProfileUUID(ctx context.Context, userID string, sessionID string) (string, error)
 
 	// PromoteProfileUUID promotes a profile UUID from Valkey to the persistent database.
 	PromoteProfileUUID(ctx context.Context, userID string, profileUUID string) error
+
+	// CreateAPIToken creates a named token limited to the allowed scopes, the plain token is only returned now.
+	CreateAPIToken(ctx context.Context, hashedUUID string, allowed domain.ScopeSet, req domain.CreateAPITokenRequest) (plainToken string, token *domain.APIToken, err error)
+	ListAPITokens(ctx context.Context, hashedUUID string) ([]domain.APIToken, error)
+	RevokeAPIToken(ctx context.Context, hashedUUID string, id int64) error
+
+	// SetPassword sets or changes the password required with the bookmark, currentPassword is checked if one is set.
+	SetPassword(ctx context.Context, user *domain.User, currentPassword string, password string) error
+	RemovePassword(ctx context.Context, user *domain.User, currentPassword string) error
+
+	TwoFactorStatus(ctx context.Context, user *domain.User) (*domain.TwoFactorStatus, error)
+	// BeginTOTPEnrolment stores a new TOTP secret and returns it with its otpauth URI, it is required once confirmed.
+	BeginTOTPEnrolment(ctx context.Context, user *domain.User) (secret string, uri string, err error)
+	// ConfirmTOTPEnrolment enables TOTP with a code of the new secret and returns the plain recovery codes.
+	ConfirmTOTPEnrolment(ctx context.Context, user *domain.User, code string, ip string) (recoveryCodes []string, err error)
+	RegenerateRecoveryCodes(ctx context.Context, user *domain.User, code string, ip string) (recoveryCodes []string, err error)
+	DisableTwoFactor(ctx context.Context, user *domain.User, code string, ip string) error
+	// ResetTwoFactor disables two-factor authentication without a code, for administrators.
+	ResetTwoFactor(ctx context.Context, hashedUUID string) error
+
+	// RotateBookmark moves the user and their data to a new bookmark, invalidating the user token and
+	// named API tokens. It returns the new bookmark and user token, which are only available now.
+	RotateBookmark(ctx context.Context, user *domain.User, sessionIDs []string) (hashedUUID string, plainToken string, err error)
+
+	// DeleteAccount deletes the user and all of their data, bookmark must be theirs to confirm it.
+	// It returns ErrBookmarkMismatch otherwise.
+	DeleteAccount(ctx context.Context, user *domain.User, bookmark string, sessionIDs []string) error
+
+	// ExtendRetention moves the deletion date of an active user out by the retention days, called on every sync.
+	ExtendRetention(ctx context.Context, user *domain.User) error
 }
 
 type service struct {
 	repo            domain.UserRepo
+	apiTokenRepo    domain.APITokenRepo
 	limiter         RateLimiterStore       // Keep limiter, adapt usage
 	log             logger.Logger          // Add logger field
 	valkeyService   *valkey.Service        // Add Valkey service
 	profileUUIDRepo domain.ProfileUUIDRepo // Add ProfileUUID repository
+
+	recoveryCodeRepo domain.RecoveryCodeRepo
+	audit            auditRecorder
+	passwordCfg      domain.PasswordConfig
+	twoFactorCfg     domain.TwoFactorConfig
+	totpKey          []byte // AES key of the TOTP secrets
+	retentionCfg     domain.RetentionConfig
 }
 
 // NewService creates a new user service instance.
-func NewService(repo domain.UserRepo, limiter RateLimiterStore, log logger.Logger, valkeyService *valkey.Service, profileUUIDRepo domain.ProfileUUIDRepo) Service {
+func NewService(repo domain.UserRepo, apiTokenRepo domain.APITokenRepo, limiter RateLimiterStore, log logger.Logger, valkeyService *valkey.Service, profileUUIDRepo domain.ProfileUUIDRepo, recoveryCodeRepo domain.RecoveryCodeRepo, audit auditRecorder, cfg *domain.Config) Service {
 	return &service{
 		repo:            repo,
+		apiTokenRepo:    apiTokenRepo,
 		limiter:         limiter,
 		log:             log, // Store the logger interface instance directly
 		valkeyService:   valkeyService,
 		profileUUIDRepo: profileUUIDRepo,
+
+		recoveryCodeRepo: recoveryCodeRepo,
+		audit:            audit,
+		passwordCfg:      cfg.Password,
+		twoFactorCfg:     cfg.TwoFactor,
+		totpKey:          newTOTPKey(cfg),
+		retentionCfg:     cfg.Retention,
 	}
 }
 
@@ -111,50 +162,31 @@ func (s *service) GetUserCount(ctx context.Context) (int, error) {
 	return count, nil
 }
 
-// generateApiToken creates a cryptographically secure random token string.
-func generateApiToken(byteLength int) (string, error) {
-	tokenBytes := make([]byte, byteLength)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		return "", ErrTokenGeneration
-	}
-	return hex.EncodeToString(tokenBytes), nil
-}
-
-// hashApiToken hashes the token using bcrypt.
-func hashApiToken(token string) (string, error) {
-	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
-	if err != nil {
-		return "", ErrTokenHashing
-	}
-	return string(hashedBytes), nil
-}
-
-// generateAndStoreUserToken generates, hashes, and prepares token/scopes for a user.
+// generateAndStoreUserToken generates a token and sets its lookup ID, hash and scopes on the user.
 // It returns the plain token. It does NOT store the user itself.
 func (s *service) generateAndStoreUserToken(user *domain.User) (string, error) {
-	// 1. Generate plain token
-	// TODO: Make token byte length configurable
-	plainToken, err := generateApiToken(32) // 32 bytes = 64 hex characters
+	token, err := newAPIToken()
 	if err != nil {
 		s.log.Error().Str("service", "user").Err(err).Msg("Failed to generate secure API token")
-		return "", err // Already wrapped ErrTokenGeneration
-	}
-
-	// 2. Hash the token
-	hashedToken, err := hashApiToken(plainToken)
-	if err != nil {
-		s.log.Error().Str("service", "user").Err(err).Msg("Failed to hash API token")
-		return "", err // Already wrapped ErrTokenHashing
+		return "", err // Already ErrTokenGeneration
 	}
 
-	// 3. Set fields on the user object
-	user.APITokenHash = hashedToken
+	user.APITokenLookupID = &token.LookupID
+	user.APITokenHash = token.SecretHash
 	if user.Scopes == "" { // Set default scopes if none provided
 		user.Scopes = defaultScopes
+	} else if domain.IsLegacyScopes(user.Scopes) {
+		// The legacy read/write object was never enforced, upgrade it to the
+		// defaults while keeping anything granted on top of them
+		scopes := domain.ParseScopes(user.Scopes)
+		for _, scope := range domain.DefaultScopes {
+			scopes[scope] = struct{}{}
+		}
+		user.Scopes = scopes.String()
 	}
 
-	// 4. Return plain token (caller is responsible for storing the user)
-	return plainToken, nil
+	// Return plain token (caller is responsible for storing the user)
+	return token.Plain, nil
 }
 
 // hashUUID generates a unique identifier for the user record itself (not for auth).
@@ -170,7 +202,7 @@ func generateHashedUUID() (string, error) {
 	return newUUID.String(), nil
 }
 
-func (s *service) RegisterNewUser(ctx context.Context) (string, error) {
+func (s *service) RegisterNewUser(ctx context.Context) (string, string, error) {
 	s.log.Debug().Str("service", "user").Msg("Attempting to register new user")
 
 	// 1. Generate HashedUUID for the user record
@@ -178,12 +210,11 @@ func (s *service) RegisterNewUser(ctx context.Context) (string, error) {
 	if err != nil {
 		// Logging handled within generateHashedUUID if it wraps logger
 		// If not, log here: s.log.Error().Err(err).Msg("Failed to generate HashedUUID")
-		return "", pkgErrors.Wrap(err, "failed to generate user identifier") // Return wrapped error
+		return "", "", pkgErrors.Wrap(err, "failed to generate user identifier") // Return wrapped error
 	}
 
-	// 2. Calculate deletion date
-	// TODO: Make deletion duration configurable
-	deletionDate := time.Now().AddDate(0, 0, 60) // Now + 60 days
+	// 2. Calculate deletion date, moved out again by every sync
+	deletionDate := s.retentionCfg.ExpiresAt(time.Now())
 
 	// 3. Create User object (initially without token)
 	newUser := domain.User{
@@ -193,10 +224,10 @@ func (s *service) RegisterNewUser(ctx context.Context) (string, error) {
 	}
 
 	// 4. Generate and hash API token, set fields on newUser
-	_, err = s.generateAndStoreUserToken(&newUser) // plainToken is not returned by RegisterNewUser anymore
+	plainToken, err := s.generateAndStoreUserToken(&newUser)
 	if err != nil {
 		// Logging handled within generateAndStoreUserToken
-		return "", err // Return error from token generation/hashing
+		return "", "", err // Return error from token generation
 	}
 
 	// 5. Store user with HashedUUID, APITokenHash, Scopes, DeletionDate
@@ -204,123 +235,94 @@ func (s *service) RegisterNewUser(ctx context.Context) (string, error) {
 	if err != nil {
 		s.log.Error().Str("service", "user").Err(err).Str("hashed_uuid", newUser.HashedUUID).Msg("Failed to store new user in repository")
 		// Handle potential unique constraint violations (e.g., duplicate HashedUUID - unlikely, or APITokenHash - very unlikely)
-		return "", pkgErrors.Wrap(err, "failed to store new user")
+		return "", "", pkgErrors.Wrap(err, "failed to store new user")
 	}
 
 	s.log.Info().Str("service", "user").Str("hashed_uuid", newUser.HashedUUID).Msg("Successfully registered new user")
-	// 6. Return HashedUUID (the bookmark)
-	return newUser.HashedUUID, nil
+	// 6. Return HashedUUID (the bookmark) and the plain token, which is only available now
+	return newUser.HashedUUID, plainToken, nil
 }
 
 // AuthenticateUserByToken attempts to authenticate a user using a plain API token.
-// WARNING: This implementation iterates through all users and compares hashes,
-// which is highly inefficient and not suitable for production with many users.
-// A different approach (e.g., caching, different token scheme) is needed.
-func (s *service) AuthenticateUserByToken(ctx context.Context, plainToken string) (*domain.User, error) {
+// The lookup ID part of the token finds the named token or the user token through
+// an index, the secret is then compared with the stored hash in constant time.
+// The returned APIToken is nil when the user token was used.
+func (s *service) AuthenticateUserByToken(ctx context.Context, plainToken string, ip string) (*domain.User, *domain.APIToken, error) {
 	s.log.Debug().Str("service", "user").Msg("Attempting authentication via API token")
 
-	// --- Rate Limiting Check (Example using plainToken prefix) ---
-	// Using a hash or prefix of the token might be better than the full token as key.
-	// This part needs careful consideration based on security/privacy needs.
-	rateLimitKey := plainToken
-	if len(rateLimitKey) > 16 { // Use prefix to avoid storing full tokens in Redis keys
-		rateLimitKey = rateLimitKey[:16]
+	lookupID, secret, ok := parseAPIToken(plainToken)
+	if !ok {
+		// Legacy tokens and bookmarks end up here as well, they carry no lookup ID so only the client is counted
+		s.log.Warn().Str("service", "user").Msg("Malformed API token")
+		keys := lockoutKeys(ip)
+		if err := s.checkLockout(ctx, keys); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, s.handleAuthFailure(ctx, keys, ip, nil)
 	}
 
-	locked, err := s.limiter.IsLockedOut(ctx, rateLimitKey)
-	if err != nil {
-		s.log.Error().Str("service", "user").Err(err).Str("key_prefix", rateLimitKey).Msg("Rate limiter check failed")
-		// Fail closed if limiter check fails
-		return nil, ErrAuthenticationFailed
-	}
-	if locked {
-		s.log.Warn().Str("service", "user").Str("key_prefix", rateLimitKey).Msg("Authentication attempt rejected due to rate limiting lockout")
-		return nil, ErrUserLockedOut
-	}
-
-	// --- Inefficient User Iteration ---
-	// TODO: Replace this entire block with an efficient lookup method.
-	// This requires either changing the token format (e.g., include HashedUUID)
-	// or using a different storage/lookup mechanism.
-	s.log.Warn().Msg("Performing inefficient user iteration for token authentication. Replace this!")
-
-	// Fetching ALL users is not feasible. This needs a proper solution.
-	// For demonstration, let's assume we can somehow get relevant users.
-	// Placeholder: In a real scenario, you CANNOT fetch all users.
-	// We will simulate failure here as fetching all is wrong.
-	// If FindByAPITokenHash existed and worked with bcrypt, we'd use it.
-	// Since it doesn't work directly with bcrypt comparison, we simulate the issue.
-
-	// Correct approach would involve repo changes or different token strategy.
-	// For now, we cannot implement this correctly without fetching all users.
-	// Let's call the repo's FindByAPITokenHash, acknowledging it won't work as intended
-	// with bcrypt comparison logic here. The repo method itself might be useful
-	// if the *hash* was known, but it isn't from the plain token.
-
-	// Let's pretend we found a user via magic (THIS IS WRONG):
-	// magicUser, err := s.repo.FindByAPITokenHash(ctx, "some_hash_we_dont_have")
-
-	// Corrected (but still inefficient) approach: Fetch users page by page? Still bad.
-	// Let's just fail here for now, as the premise is flawed without a better lookup.
-	s.log.Error().Str("service", "user").Msg("Cannot implement AuthenticateUserByToken efficiently with current structure. Failing authentication.")
-	s.handleAuthFailure(ctx, rateLimitKey) // Increment failure count for the token prefix
-	return nil, ErrAuthenticationFailed
-
-	/* --- Start of Hypothetical Correct Logic (If User Lookup Was Possible) ---
-	var foundUser *domain.User = nil // Assume magicLookupFunction finds the potential user
-
-	if foundUser == nil {
-		s.log.Warn().Str("service", "user").Msg("No user found matching the provided token (simulated)")
-		s.handleAuthFailure(ctx, rateLimitKey)
-		return nil, ErrAuthenticationFailed
+	// Failures are counted per client and per lookup ID, it is public and never reveals the secret
+	rateLimitKeys := lockoutKeys(ip, "token:"+lookupID)
+	if err := s.checkLockout(ctx, rateLimitKeys); err != nil {
+		return nil, nil, err
 	}
 
-	// --- Verification ---
-	// 1. Compare provided plain token with the found user's hash
-	err = bcrypt.CompareHashAndPassword([]byte(foundUser.APITokenHash), []byte(plainToken))
+	// Named tokens first, then the user token
+	apiToken, err := s.apiTokenRepo.FindByLookupID(ctx, lookupID)
 	if err != nil {
-		// If err is bcrypt.ErrMismatchedHashAndPassword, it's a standard auth failure.
-		// Other errors might indicate issues with the hash itself.
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			s.log.Warn().Str("service", "user").Str("hashed_uuid", foundUser.HashedUUID).Msg("API token mismatch")
-		} else {
-			s.log.Error().Str("service", "user").Err(err).Str("hashed_uuid", foundUser.HashedUUID).Msg("Error comparing token hash")
+		return nil, nil, pkgErrors.Wrap(err, "failed to look up API token")
+	}
+
+	var foundUser *domain.User
+	if apiToken != nil {
+		if !tokenSecretMatches(secret, apiToken.SecretHash) {
+			s.log.Warn().Str("service", "user").Str("lookup_id", lookupID).Msg("API token mismatch")
+			return nil, nil, s.handleAuthFailure(ctx, rateLimitKeys, ip, nil)
+		}
+		if apiToken.Expired(time.Now()) {
+			s.log.Warn().Str("service", "user").Int64("token_id", apiToken.ID).Msg("Authentication failed: API token expired")
+			return nil, nil, ErrTokenExpired
+		}
+
+		if foundUser, err = s.repo.FindByHashedUUID(ctx, apiToken.UserHashedUUID); err != nil {
+			return nil, nil, pkgErrors.Wrap(err, "failed to look up owner of API token")
+		}
+		if foundUser == nil {
+			// The owner was deleted, tokens are removed with it eventually
+			return nil, nil, s.handleAuthFailure(ctx, rateLimitKeys, ip, nil)
+		}
+	} else {
+		if foundUser, err = s.repo.FindByAPITokenLookupID(ctx, lookupID); err != nil {
+			return nil, nil, pkgErrors.Wrap(err, "failed to look up API token")
+		}
+		if foundUser == nil || !tokenSecretMatches(secret, foundUser.APITokenHash) {
+			s.log.Warn().Str("service", "user").Str("lookup_id", lookupID).Msg("API token mismatch")
+			return nil, nil, s.handleAuthFailure(ctx, rateLimitKeys, ip, nil)
 		}
-		s.handleAuthFailure(ctx, rateLimitKey) // Use token prefix for unidentified failure
-		return nil, ErrAuthenticationFailed
 	}
 
-	// 2. Check if expired
-	if time.Now().After(foundUser.DeletionDate) {
+	// Check if expired, accounts in their grace period can still read their data
+	if s.pastGracePeriod(foundUser, time.Now()) {
 		s.log.Warn().Str("service", "user").Str("hashed_uuid", foundUser.HashedUUID).Msg("Authentication failed: User account expired")
 		// Don't increment failure count for expired users
-		return nil, ErrUserExpired
+		return nil, nil, ErrUserExpired
+	}
+	if foundUser.Disabled {
+		s.log.Warn().Str("service", "user").Str("hashed_uuid", foundUser.HashedUUID).Msg("Authentication failed: User account disabled")
+		return nil, nil, ErrUserDisabled
 	}
 
 	// --- Success Path ---
-	s.log.Info().Str("service", "user").Str("hashed_uuid", foundUser.HashedUUID).Msg("API token authentication successful")
+	s.log.Debug().Str("service", "user").Str("hashed_uuid", foundUser.HashedUUID).Msg("API token authentication successful")
 
-	// 3. Clear any previous failures for this user in Redis (use HashedUUID now we know who it is)
-	clearErr := s.limiter.ClearFailures(ctx, foundUser.HashedUUID)
-	if clearErr != nil {
-		s.log.Error().Str("service", "user").Err(clearErr).Str("hashed_uuid", foundUser.HashedUUID).Msg("Failed to clear rate limiter failures after successful auth")
-		// Proceed with successful auth despite limiter error
-	}
+	// Clear any previous failures for this token
+	s.clearFailures(ctx, "token:"+lookupID)
 
-	// 4. Update deletion date in DB
-	newDeletionDate := time.Now().AddDate(0, 0, 60) // TODO: Make duration configurable
-	updateErr := s.repo.UpdateDeletionDate(ctx, foundUser.HashedUUID, newDeletionDate)
-	if updateErr != nil {
-		s.log.Error().Str("service", "user").Err(updateErr).Str("hashed_uuid", foundUser.HashedUUID).Msg("Failed to update user deletion date after successful auth")
-		// Proceed with successful auth, but user object won't have updated date
-	} else {
-		// Update the user object's deletion date only if DB update was successful
-		foundUser.DeletionDate = newDeletionDate
+	if apiToken != nil {
+		s.recordAPITokenUse(ctx, apiToken, ip)
 	}
 
-	// 5. Return authenticated user
-	return foundUser, nil
-	--- End of Hypothetical Correct Logic --- */
+	return foundUser, apiToken, nil
 }
 
 // GetUserForAuthentication retrieves user details by HashedUUID.
@@ -340,14 +342,67 @@ func (s *service) GetUserForAuthentication(ctx context.Context, hashedUUID strin
 		return nil, pkgErrors.Wrap(err, "failed to retrieve user details")
 	}
 	// Check expiry again just in case? Optional defense-in-depth.
-	if user != nil && time.Now().After(user.DeletionDate) {
+	if user != nil && s.pastGracePeriod(user, time.Now()) {
 		s.log.Warn().Str("service", "user").Str("hashed_uuid", hashedUUID).Msg("User found by HashedUUID but is expired")
 		return nil, ErrUserExpired // Return specific error if expired
 	}
+	if user != nil && user.Disabled {
+		s.log.Warn().Str("service", "user").Str("hashed_uuid", hashedUUID).Msg("User found by HashedUUID but is disabled")
+		return nil, ErrUserDisabled
+	}
 
 	return user, nil
 }
 
+// AuthenticateByBookmark looks a user up by bookmark like GetUserForAuthentication,
+// unknown bookmarks, wrong passwords and codes are counted per client IP and bookmark prefix.
+func (s *service) AuthenticateByBookmark(ctx context.Context, hashedUUID string, password string, twoFactorCode string, ip string) (*domain.User, error) {
+	bookmarkKey := bookmarkLockoutKey(hashedUUID)
+	keys := lockoutKeys(ip, bookmarkKey)
+	if err := s.checkLockout(ctx, keys); err != nil {
+		return nil, err
+	}
+
+	foundUser, err := s.GetUserForAuthentication(ctx, hashedUUID)
+	if err != nil {
+		return nil, err
+	}
+	if foundUser == nil {
+		return nil, s.loginFailed(ctx, keys, ip, nil, "unknown_bookmark")
+	}
+
+	// The bookmark is valid, but the user only logs in with a passkey
+	if foundUser.UUIDLoginDisabled {
+		s.clearFailures(ctx, bookmarkKey)
+		return nil, ErrUUIDLoginDisabled
+	}
+
+	if foundUser.HasPassword() {
+		if password == "" {
+			return nil, ErrPasswordRequired
+		}
+		match, err := s.checkPassword(ctx, foundUser, password)
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			return nil, s.loginFailed(ctx, keys, ip, foundUser, "password")
+		}
+	}
+
+	if foundUser.TOTPEnabled {
+		if err := s.requireTwoFactorCode(ctx, foundUser, twoFactorCode, ip); err != nil {
+			if errors.Is(err, ErrInvalidTwoFactorCode) {
+				s.recordLoginFailure(ctx, ip, foundUser, "two_factor")
+			}
+			return nil, err
+		}
+	}
+
+	s.clearFailures(ctx, bookmarkKey)
+	return foundUser, nil
+}
+
 // ResetAndRetrieveUserToken generates a new API token for the user, stores its hash,
 // and returns the plain token.
 func (s *service) ResetAndRetrieveUserToken(ctx context.Context, hashedUUID string) (string, error) {
@@ -375,7 +430,7 @@ func (s *service) ResetAndRetrieveUserToken(ctx context.Context, hashedUUID stri
 	}
 
 	// 3. Persist the updated APITokenHash and Scopes to the database
-	err = s.repo.UpdateTokenAndScopes(ctx, user.HashedUUID, user.APITokenHash, user.Scopes)
+	err = s.repo.UpdateTokenAndScopes(ctx, user.HashedUUID, *user.APITokenLookupID, user.APITokenHash, user.Scopes)
 	if err != nil {
 		s.log.Error().Str("service", "user").Err(err).Str("hashed_uuid", user.HashedUUID).Msg("Failed to update user token and scopes in repository")
 		return "", pkgErrors.Wrap(err, "failed to store updated token and scopes")
@@ -386,23 +441,108 @@ func (s *service) ResetAndRetrieveUserToken(ctx context.Context, hashedUUID stri
 	return plainToken, nil
 }
 
-// handleAuthFailure increments the failure count and sets lockout if threshold is reached.
-// Uses the provided key (e.g., token prefix or HashedUUID) for rate limiting.
-func (s *service) handleAuthFailure(ctx context.Context, key string) {
-	newCount, err := s.limiter.IncrementFailure(ctx, key)
-	if err != nil {
-		s.log.Error().Str("service", "user").Err(err).Str("key", key).Msg("Failed to increment rate limiter failure count")
-		return // Don't proceed to lockout if increment failed
+// RotateLegacyAPIToken issues a new token for users whose stored token is a
+// bcrypt hash without lookup ID. Such tokens cannot be verified anymore, so
+// the reset is forced on the next login instead.
+func (s *service) RotateLegacyAPIToken(ctx context.Context, user *domain.User) (string, error) {
+	if user == nil || !user.HasLegacyAPIToken() {
+		return "", nil
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", user.HashedUUID).Msg("Replacing legacy API token on login")
+	return s.ResetAndRetrieveUserToken(ctx, user.HashedUUID)
+}
+
+// bookmarkLockoutKey returns the rate limiter key of a bookmark. Only its first
+// 8 characters are used, the bookmark is a credential and must not end up in
+// Valkey keys or logs. Bookmarks sharing a prefix share their failure count.
+// Guesses spread over many bookmarks are limited by the client key instead.
+func bookmarkLockoutKey(hashedUUID string) string {
+	prefix := hashedUUID
+	if len(prefix) > 8 {
+		prefix = prefix[:8]
+	}
+	return "bookmark:" + prefix
+}
+
+// lockoutKeys returns the rate limiter keys for a client IP and credential keys.
+func lockoutKeys(ip string, credentialKeys ...string) []string {
+	keys := credentialKeys
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// checkLockout returns a *LockoutError if any of the keys is locked out. It
+// fails closed, an unavailable limiter rejects the attempt.
+func (s *service) checkLockout(ctx context.Context, keys []string) error {
+	var retryAfter time.Duration
+	for _, key := range keys {
+		remaining, err := s.limiter.IsLockedOut(ctx, key)
+		if err != nil {
+			s.log.Error().Str("service", "user").Err(err).Str("key", key).Msg("Rate limiter check failed")
+			return ErrAuthenticationFailed
+		}
+		retryAfter = max(retryAfter, remaining)
+	}
+
+	if retryAfter > 0 {
+		s.log.Warn().Str("service", "user").Strs("keys", keys).Dur("retry_after", retryAfter).Msg("Authentication attempt rejected due to lockout")
+		return &LockoutError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// loginFailed records a failed bookmark login and counts it towards the lockout
+// of keys. target is the user of the bookmark, nil if the bookmark is unknown.
+func (s *service) loginFailed(ctx context.Context, keys []string, ip string, target *domain.User, reason string) error {
+	s.recordLoginFailure(ctx, ip, target, reason)
+	return s.handleAuthFailure(ctx, keys, ip, target)
+}
+
+func (s *service) recordLoginFailure(ctx context.Context, ip string, target *domain.User, reason string) {
+	event := domain.AuditEvent{Action: domain.AuditActionLoginFailed, IP: ip, Details: map[string]string{"reason": reason}}
+	if target != nil {
+		event.TargetID = target.PublicID
 	}
-	s.log.Warn().Str("service", "user").Str("key", key).Int64("failure_count", newCount).Msg("Authentication failure recorded")
+	s.audit.Record(ctx, event)
+}
 
-	if newCount >= lockoutThreshold {
-		err = s.limiter.SetLockout(ctx, key)
+// handleAuthFailure records a failure for every key and returns the error for
+// the attempt, a *LockoutError if the failure locked out one of the keys.
+// Lockouts are recorded in the audit log, with the user they protect if known.
+func (s *service) handleAuthFailure(ctx context.Context, keys []string, ip string, target *domain.User) error {
+	var lockout time.Duration
+	for _, key := range keys {
+		duration, err := s.limiter.RecordFailure(ctx, key)
 		if err != nil {
-			s.log.Error().Str("service", "user").Err(err).Str("key", key).Msg("Failed to set rate limiter lockout")
-		} else {
-			s.log.Warn().Str("service", "user").Str("key", key).Msg("Rate limiter lockout threshold reached and lockout set")
+			s.log.Error().Str("service", "user").Err(err).Str("key", key).Msg("Failed to record authentication failure")
+			continue
+		}
+		if duration > 0 {
+			s.log.Warn().Str("service", "user").Str("key", key).Dur("duration", duration).Msg("Too many authentication failures, locked out")
+			lockout = max(lockout, duration)
+		}
+	}
+
+	if lockout > 0 {
+		event := domain.AuditEvent{Action: domain.AuditActionLockout, IP: ip, Details: map[string]string{"duration": lockout.String()}}
+		if target != nil {
+			event.TargetID = target.PublicID
 		}
+		s.audit.Record(ctx, event)
+		return &LockoutError{RetryAfter: lockout}
+	}
+	return ErrAuthenticationFailed
+}
+
+// clearFailures resets the failure count of a credential after a successful
+// authentication. Client IP counters are kept, a valid credential must not
+// reset the count of failures guessing others.
+func (s *service) clearFailures(ctx context.Context, key string) {
+	if err := s.limiter.ClearFailures(ctx, key); err != nil {
+		s.log.Error().Str("service", "user").Err(err).Str("key", key).Msg("Failed to clear rate limiter failures after successful auth")
 	}
 }
 
@@ -414,11 +554,12 @@ const (
 )
 
 // GetOrGenerateProfileUUID retrieves an existing profile-specific UUID from Valkey or the persistent database
-// for a given sessionID, or generates, stores, and returns a new one if not found or expired.
-func (s *service) GetOrGenerateProfileUUID(ctx context.Context, sessionID string) (string, error) {
-	if sessionID == "" {
-		s.log.Warn().Str("service", "user").Msg("SessionID is empty, cannot generate profile UUID")
-		return "", pkgErrors.New("sessionID cannot be empty for profile UUID generation")
+// for a given session of a user, or generates, stores, and returns a new one if not found or expired.
+// Requests without a web session pass the user ID as sessionID.
+func (s *service) GetOrGenerateProfileUUID(ctx context.Context, userID string, sessionID string) (string, error) {
+	if userID == "" || sessionID == "" {
+		s.log.Warn().Str("service", "user").Msg("UserID or sessionID is empty, cannot generate profile UUID")
+		return "", pkgErrors.New("userID and sessionID cannot be empty for profile UUID generation")
 	}
 
 	valkeyKey := fmt.Sprintf("session:%s:profile_uuid", sessionID)
@@ -450,31 +591,27 @@ func (s *service) GetOrGenerateProfileUUID(ctx context.Context, sessionID string
 	}
 
 	// UUID not found in Valkey, check if it exists in the persistent database
-	// Extract user ID from session ID (in this case, we're using HashedUUID as the user ID)
-	userID := sessionID
-	if userID != "" {
-		persistentUUID, err := s.profileUUIDRepo.FindByUserID(ctx, userID)
-		if err != nil {
-			s.log.Error().Str("service", "user").Err(err).Str("user_id", userID).Msg("Failed to check persistent database for profile UUID")
-			// Continue to generate a new UUID
-		} else if persistentUUID != nil {
-			s.log.Info().Str("service", "user").Str("user_id", userID).Str("profile_uuid", persistentUUID.ProfileUUID).Msg("Found existing profile UUID in persistent database, caching in Valkey")
-
-			// Cache the UUID in Valkey with an extended TTL since it's a promoted UUID
-			setCmd := client.B().Set().Key(valkeyKey).Value(persistentUUID.ProfileUUID).Ex(promotedProfileUUIDTTL).Build()
-			if err := client.Do(ctx, setCmd).Error(); err != nil {
-				s.log.Error().Str("service", "user").Err(err).Str("valkey_key", valkeyKey).Msg("Failed to cache persistent UUID in Valkey")
-				// Continue with the UUID from the persistent database even if caching fails
-			}
-
-			// Update last activity timestamp
-			if err := s.profileUUIDRepo.UpdateLastActivity(ctx, userID, persistentUUID.ProfileUUID); err != nil {
-				s.log.Error().Str("service", "user").Err(err).Str("user_id", userID).Str("profile_uuid", persistentUUID.ProfileUUID).Msg("Failed to update last activity timestamp")
-				// Continue with the UUID even if update fails
-			}
+	persistentUUID, err := s.profileUUIDRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		s.log.Error().Str("service", "user").Err(err).Str("user_id", userID).Msg("Failed to check persistent database for profile UUID")
+		// Continue to generate a new UUID
+	} else if persistentUUID != nil {
+		s.log.Info().Str("service", "user").Str("user_id", userID).Str("profile_uuid", persistentUUID.ProfileUUID).Msg("Found existing profile UUID in persistent database, caching in Valkey")
+
+		// Cache the UUID in Valkey with an extended TTL since it's a promoted UUID
+		setCmd := client.B().Set().Key(valkeyKey).Value(persistentUUID.ProfileUUID).Ex(promotedProfileUUIDTTL).Build()
+		if err := client.Do(ctx, setCmd).Error(); err != nil {
+			s.log.Error().Str("service", "user").Err(err).Str("valkey_key", valkeyKey).Msg("Failed to cache persistent UUID in Valkey")
+			// Continue with the UUID from the persistent database even if caching fails
+		}
 
-			return persistentUUID.ProfileUUID, nil
+		// Update last activity timestamp
+		if err := s.profileUUIDRepo.UpdateLastActivity(ctx, userID, persistentUUID.ProfileUUID); err != nil {
+			s.log.Error().Str("service", "user").Err(err).Str("user_id", userID).Str("profile_uuid", persistentUUID.ProfileUUID).Msg("Failed to update last activity timestamp")
+			// Continue with the UUID even if update fails
 		}
+
+		return persistentUUID.ProfileUUID, nil
 	}
 
 	// UUID not found or expired, generate a new one
@@ -499,7 +636,7 @@ func (s *service) GetOrGenerateProfileUUID(ctx context.Context, sessionID string
 			s.log.Info().Str("service", "user").Str("valkey_key", valkeyKey).Msg("SET NX failed, key already exists (race condition). Re-fetching profile UUID.")
 			// Recursive call to re-fetch. Be mindful of potential deep recursion if contention is very high,
 			// though unlikely for session-scoped UUIDs.
-			return s.GetOrGenerateProfileUUID(ctx, sessionID)
+			return s.GetOrGenerateProfileUUID(ctx, userID, sessionID)
 		}
 		s.log.Error().Str("service", "user").Err(err).Str("valkey_key", valkeyKey).Msg("Failed to store new profile UUID in Valkey using SET NX EX")
 		return "", pkgErrors.Wrap(err, "failed to store new profile UUID in Valkey")
@@ -0,0 +1,104 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/audit"
+	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/database/databasetest"
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLimiter locks a key out on its second failure.
+type fakeLimiter struct {
+	failures map[string]int
+}
+
+func (l *fakeLimiter) IsLockedOut(_ context.Context, key string) (time.Duration, error) {
+	return 0, nil
+}
+
+func (l *fakeLimiter) RecordFailure(_ context.Context, key string) (time.Duration, error) {
+	l.failures[key]++
+	if l.failures[key] >= 2 {
+		return time.Minute, nil
+	}
+	return 0, nil
+}
+
+func (l *fakeLimiter) ClearFailures(_ context.Context, key string) error {
+	delete(l.failures, key)
+	return nil
+}
+
+var testPasswordConfig = domain.PasswordConfig{
+	MinLength:   4,
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// newTestService stores the users in a throwaway database. Accounts are kept
+// for 60 days and read-only for 7 more, the audit log is returned to be checked.
+func newTestService(t *testing.T, users ...domain.User) (*service, *fakeLimiter, domain.AuditEventRepo) {
+	db, log := databasetest.New(t)
+	repo := database.NewUserRepo(log, db)
+	databasetest.StoreUsers(t, repo, users...)
+
+	limiter := &fakeLimiter{failures: map[string]int{}}
+	auditRepo := database.NewAuditEventRepo(log, db)
+	svc := &service{
+		repo:         repo,
+		apiTokenRepo: database.NewAPITokenRepo(log, db),
+		limiter:      limiter,
+		log:          log,
+		audit:        audit.NewService(log, auditRepo),
+		passwordCfg:  testPasswordConfig,
+		retentionCfg: domain.RetentionConfig{Days: 60, GraceDays: 7},
+	}
+	return svc, limiter, auditRepo
+}
+
+// findUser loads a user as currently stored.
+func findUser(t *testing.T, svc *service, hashedUUID string) *domain.User {
+	u, err := svc.repo.FindByHashedUUID(context.Background(), hashedUUID)
+	require.NoError(t, err)
+	return u
+}
+
+func TestService_AuthenticateByBookmark_Audit(t *testing.T) {
+	ctx := context.Background()
+	svc, _, auditRepo := newTestService(t, domain.User{HashedUUID: "bookmark", PublicID: "public", DeletionDate: time.Now().AddDate(0, 0, 30)})
+	require.NoError(t, svc.SetPassword(ctx, findUser(t, svc, "bookmark"), "", "1234"))
+
+	_, err := svc.AuthenticateByBookmark(ctx, "unknown", "", "", "192.0.2.1")
+	assert.ErrorIs(t, err, ErrAuthenticationFailed)
+	events := databasetest.AuditEvents(t, auditRepo)
+	require.Len(t, events, 1)
+	assert.Equal(t, domain.AuditActionLoginFailed, events[0].Action)
+	assert.Empty(t, events[0].TargetID)
+	assert.Equal(t, "unknown_bookmark", events[0].Details["reason"])
+	assert.Equal(t, "192.0.2.1", events[0].IP)
+
+	// The second failure from the client locks it out, the lockout is recorded for the user
+	_, err = svc.AuthenticateByBookmark(ctx, "bookmark", "0000", "", "192.0.2.1")
+	assert.ErrorIs(t, err, ErrUserLockedOut)
+	events = databasetest.AuditEvents(t, auditRepo)
+	require.Len(t, events, 3)
+	assert.Equal(t, domain.AuditActionLoginFailed, events[1].Action)
+	assert.Equal(t, "public", events[1].TargetID)
+	assert.Equal(t, "password", events[1].Details["reason"])
+	assert.Equal(t, domain.AuditActionLockout, events[2].Action)
+	assert.Equal(t, "public", events[2].TargetID)
+
+	// A successful login is recorded by the session handler, not here
+	_, err = svc.AuthenticateByBookmark(ctx, "bookmark", "1234", "", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Len(t, databasetest.AuditEvents(t, auditRepo), 3)
+}
@@ -0,0 +1,82 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// API tokens have the form shi_<lookupID>_<secret>. The lookup ID is stored in
+// plain text and indexed so the owner is found with a single query, only the
+// SHA-256 of the secret is stored. Both parts are random, so a fast hash is
+// sufficient, unlike for user chosen secrets.
+const (
+	apiTokenPrefix      = "shi"
+	apiTokenLookupBytes = 8  // 16 hex characters
+	apiTokenSecretBytes = 32 // 64 hex characters
+)
+
+// apiToken is a freshly generated token, Plain is only available at creation.
+type apiToken struct {
+	Plain      string
+	LookupID   string
+	SecretHash string
+}
+
+func randomHex(byteLength int) (string, error) {
+	b := make([]byte, byteLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", ErrTokenGeneration
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newAPIToken generates a token and the values to store for it.
+func newAPIToken() (*apiToken, error) {
+	lookupID, err := randomHex(apiTokenLookupBytes)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(apiTokenSecretBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiToken{
+		Plain:      apiTokenPrefix + "_" + lookupID + "_" + secret,
+		LookupID:   lookupID,
+		SecretHash: hashTokenSecret(secret),
+	}, nil
+}
+
+// parseAPIToken splits a token into lookup ID and secret, ok is false for malformed tokens.
+func parseAPIToken(token string) (lookupID string, secret string, ok bool) {
+	parts := strings.Split(token, "_")
+	if len(parts) != 3 || parts[0] != apiTokenPrefix {
+		return "", "", false
+	}
+	if !isHex(parts[1], apiTokenLookupBytes*2) || !isHex(parts[2], apiTokenSecretBytes*2) {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func isHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenSecretMatches compares a secret with a stored hash in constant time.
+func tokenSecretMatches(secret string, storedHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashTokenSecret(secret)), []byte(storedHash)) == 1
+}
@@ -0,0 +1,59 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAPIToken(t *testing.T) {
+	token, err := newAPIToken()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(token.Plain, "shi_"+token.LookupID+"_"))
+	assert.NotContains(t, token.SecretHash, strings.TrimPrefix(token.Plain, "shi_"+token.LookupID+"_"))
+
+	lookupID, secret, ok := parseAPIToken(token.Plain)
+	require.True(t, ok)
+	assert.Equal(t, token.LookupID, lookupID)
+	assert.True(t, tokenSecretMatches(secret, token.SecretHash))
+	assert.False(t, tokenSecretMatches(secret+"0", token.SecretHash))
+
+	other, err := newAPIToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token.Plain, other.Plain)
+}
+
+func TestParseAPIToken(t *testing.T) {
+	lookup := strings.Repeat("a", 16)
+	secret := strings.Repeat("b", 64)
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{name: "valid", token: "shi_" + lookup + "_" + secret, ok: true},
+		{name: "legacy hex token", token: strings.Repeat("c", 64), ok: false},
+		{name: "bookmark uuid", token: "0b9b4a4e-5a3c-4b7f-9a53-1d9f1c1a2b3c", ok: false},
+		{name: "wrong prefix", token: "abc_" + lookup + "_" + secret, ok: false},
+		{name: "short lookup", token: "shi_abc_" + secret, ok: false},
+		{name: "short secret", token: "shi_" + lookup + "_abc", ok: false},
+		{name: "not hex", token: "shi_" + strings.Repeat("z", 16) + "_" + secret, ok: false},
+		{name: "extra part", token: "shi_" + lookup + "_" + secret + "_x", ok: false},
+		{name: "empty", token: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLookup, gotSecret, ok := parseAPIToken(tt.token)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, lookup, gotLookup)
+				assert.Equal(t, secret, gotSecret)
+			}
+		})
+	}
+}
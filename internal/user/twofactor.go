@@ -0,0 +1,272 @@
+package user
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	pkgErrors "github.com/flurbudurbur/Shiori/pkg/errors"
+	"github.com/flurbudurbur/Shiori/pkg/totp"
+	valkeyClient "github.com/valkey-io/valkey-go"
+)
+
+const (
+	recoveryCodeCount = 10
+	// totpSkew accepts the codes of the previous and next step for clock drift.
+	totpSkew = 1
+	// totpUsedKeyPrefix marks time steps whose code was used, codes cannot be replayed.
+	totpUsedKeyPrefix = "totp:used:"
+	totpUsedTTL       = (2*totpSkew + 1) * totp.Period
+)
+
+var (
+	ErrTwoFactorRequired    = pkgErrors.New("two-factor code required")
+	ErrInvalidTwoFactorCode = pkgErrors.New("invalid two-factor code")
+	ErrTwoFactorEnabled     = pkgErrors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = pkgErrors.New("two-factor authentication is not enabled")
+	ErrTOTPNotEnrolled      = pkgErrors.New("no TOTP enrolment was started")
+)
+
+// newTOTPKey derives the key TOTP secrets are encrypted with.
+func newTOTPKey(cfg *domain.Config) []byte {
+	secret := cfg.TwoFactor.EncryptionKey
+	if secret == "" {
+		secret = cfg.SessionSecret
+	}
+	key := sha256.Sum256([]byte(secret))
+	return key[:]
+}
+
+// encryptSecret seals a TOTP secret with AES-GCM, the nonce is prepended.
+func (s *service) encryptSecret(secret string) (string, error) {
+	gcm, err := s.totpCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *service) decryptSecret(encrypted string) (string, error) {
+	gcm, err := s.totpCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", pkgErrors.New("malformed TOTP secret")
+	}
+
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", pkgErrors.Wrap(err, "failed to decrypt TOTP secret, was the encryption key changed?")
+	}
+	return string(secret), nil
+}
+
+func (s *service) totpCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.totpKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newRecoveryCodes returns plain codes formatted as XXXX-XXXX-XXXX and their hashes.
+func newRecoveryCodes() ([]string, []string) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code := rand.Text()[:12]
+		codes = append(codes, fmt.Sprintf("%s-%s-%s", code[:4], code[4:8], code[8:]))
+		hashes = append(hashes, hashTokenSecret(code))
+	}
+	return codes, hashes
+}
+
+// normalizeCode removes the separators users type or copy along with a code.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// verifyTwoFactorCode checks a TOTP code, or a recovery code which is used up.
+func (s *service) verifyTwoFactorCode(ctx context.Context, user *domain.User, code string) (bool, error) {
+	code = normalizeCode(code)
+
+	if len(code) != totp.Digits {
+		return s.recoveryCodeRepo.Use(ctx, user.HashedUUID, hashTokenSecret(code))
+	}
+
+	secret, err := s.decryptSecret(user.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+	step, ok, err := totp.Validate(secret, code, time.Now(), totpSkew)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// A code is accepted once, an observed code cannot be replayed within its validity
+	key := fmt.Sprintf("%s%s:%d", totpUsedKeyPrefix, user.HashedUUID, step)
+	client := s.valkeyService.GetClient()
+	err = client.Do(ctx, client.B().Set().Key(key).Value("1").Nx().Ex(totpUsedTTL).Build()).Error()
+	if errors.Is(err, valkeyClient.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, pkgErrors.Wrap(err, "failed to record used TOTP code")
+	}
+	return true, nil
+}
+
+// requireTwoFactorCode verifies a code for a user with two-factor
+// authentication. Wrong codes count towards the lockout like wrong logins.
+func (s *service) requireTwoFactorCode(ctx context.Context, user *domain.User, code string, ip string) error {
+	if code == "" {
+		return ErrTwoFactorRequired
+	}
+
+	keys := lockoutKeys(ip, bookmarkLockoutKey(user.HashedUUID))
+	if err := s.checkLockout(ctx, keys); err != nil {
+		return err
+	}
+
+	ok, err := s.verifyTwoFactorCode(ctx, user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := s.handleAuthFailure(ctx, keys, ip, user); errors.Is(err, ErrUserLockedOut) {
+			return err
+		}
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// TwoFactorStatus returns whether two-factor authentication is enabled and the recovery codes left.
+func (s *service) TwoFactorStatus(ctx context.Context, user *domain.User) (*domain.TwoFactorStatus, error) {
+	status := &domain.TwoFactorStatus{Enabled: user.TOTPEnabled}
+	if !user.TOTPEnabled {
+		return status, nil
+	}
+
+	remaining, err := s.recoveryCodeRepo.CountUnused(ctx, user.HashedUUID)
+	if err != nil {
+		return nil, err
+	}
+	status.RecoveryCodesRemaining = remaining
+	return status, nil
+}
+
+// BeginTOTPEnrolment stores a new secret which is required once confirmed, it
+// returns the secret and its otpauth URI for the authenticator app.
+func (s *service) BeginTOTPEnrolment(ctx context.Context, user *domain.User) (string, string, error) {
+	if user.TOTPEnabled {
+		return "", "", ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", pkgErrors.Wrap(err, "failed to generate TOTP secret")
+	}
+	encrypted, err := s.encryptSecret(secret)
+	if err != nil {
+		return "", "", pkgErrors.Wrap(err, "failed to encrypt TOTP secret")
+	}
+
+	if err := s.repo.UpdateTOTP(ctx, user.HashedUUID, encrypted, false); err != nil {
+		return "", "", err
+	}
+
+	// The bookmark is the credential, it must not end up in the authenticator app
+	return secret, totp.URI(s.twoFactorCfg.Issuer, "", secret), nil
+}
+
+// ConfirmTOTPEnrolment enables two-factor authentication once a code of the
+// enrolled secret is entered, it returns the plain recovery codes.
+func (s *service) ConfirmTOTPEnrolment(ctx context.Context, user *domain.User, code string, ip string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	// Recovery codes do not exist yet, only a TOTP code confirms the enrolment
+	if len(normalizeCode(code)) != totp.Digits {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	if err := s.requireTwoFactorCode(ctx, user, code, ip); err != nil {
+		return nil, err
+	}
+
+	codes, hashes := newRecoveryCodes()
+	if err := s.recoveryCodeRepo.Replace(ctx, user.HashedUUID, hashes); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateTOTP(ctx, user.HashedUUID, user.TOTPSecret, true); err != nil {
+		return nil, err
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", user.HashedUUID).Msg("Two-factor authentication enabled")
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking a code.
+func (s *service) RegenerateRecoveryCodes(ctx context.Context, user *domain.User, code string, ip string) ([]string, error) {
+	if !user.TOTPEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err := s.requireTwoFactorCode(ctx, user, code, ip); err != nil {
+		return nil, err
+	}
+
+	codes, hashes := newRecoveryCodes()
+	if err := s.recoveryCodeRepo.Replace(ctx, user.HashedUUID, hashes); err != nil {
+		return nil, err
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", user.HashedUUID).Msg("Recovery codes regenerated")
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off after checking a code.
+func (s *service) DisableTwoFactor(ctx context.Context, user *domain.User, code string, ip string) error {
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if err := s.requireTwoFactorCode(ctx, user, code, ip); err != nil {
+		return err
+	}
+
+	return s.ResetTwoFactor(ctx, user.HashedUUID)
+}
+
+// ResetTwoFactor removes the TOTP secret and recovery codes of a user without
+// a code, for administrators helping users who lost their authenticator.
+func (s *service) ResetTwoFactor(ctx context.Context, hashedUUID string) error {
+	if err := s.repo.UpdateTOTP(ctx, hashedUUID, "", false); err != nil {
+		return err
+	}
+	if err := s.recoveryCodeRepo.DeleteByUser(ctx, hashedUUID); err != nil {
+		return err
+	}
+
+	s.log.Info().Str("service", "user").Str("hashed_uuid", hashedUUID).Msg("Two-factor authentication disabled")
+	return nil
+}
@@ -0,0 +1,40 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flurbudurbur/Shiori/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_EncryptSecret(t *testing.T) {
+	svc := &service{totpKey: newTOTPKey(&domain.Config{SessionSecret: "session"})}
+
+	encrypted, err := svc.encryptSecret("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, "JBSWY3DPEHPK3PXP")
+
+	secret, err := svc.decryptSecret(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", secret)
+
+	// The dedicated key takes precedence over the session secret
+	other := &service{totpKey: newTOTPKey(&domain.Config{SessionSecret: "session", TwoFactor: domain.TwoFactorConfig{EncryptionKey: "key"}})}
+	_, err = other.decryptSecret(encrypted)
+	assert.Error(t, err)
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes := newRecoveryCodes()
+	require.Len(t, codes, recoveryCodeCount)
+	require.Len(t, hashes, recoveryCodeCount)
+
+	for i, code := range codes {
+		assert.Regexp(t, `^[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}$`, code)
+		// Codes are accepted without separators and in lower case
+		assert.Equal(t, hashes[i], hashTokenSecret(normalizeCode(code)))
+		assert.Equal(t, hashes[i], hashTokenSecret(normalizeCode(" "+strings.ToLower(code)+" ")))
+	}
+}
@@ -4,18 +4,32 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/asaskevich/EventBus"
+	"github.com/flurbudurbur/Shiori/internal/accesstoken"
+	"github.com/flurbudurbur/Shiori/internal/admin"
+	"github.com/flurbudurbur/Shiori/internal/audit"
 	"github.com/flurbudurbur/Shiori/internal/auth"
 	"github.com/flurbudurbur/Shiori/internal/config"
 	"github.com/flurbudurbur/Shiori/internal/database"
+	"github.com/flurbudurbur/Shiori/internal/device"
 	"github.com/flurbudurbur/Shiori/internal/events"
+	"github.com/flurbudurbur/Shiori/internal/export"
 	"github.com/flurbudurbur/Shiori/internal/http"
 	"github.com/flurbudurbur/Shiori/internal/logger"
+	"github.com/flurbudurbur/Shiori/internal/merge"
 	"github.com/flurbudurbur/Shiori/internal/notification"
+	"github.com/flurbudurbur/Shiori/internal/oidc"
+	"github.com/flurbudurbur/Shiori/internal/passkey"
+	"github.com/flurbudurbur/Shiori/internal/proxyauth"
+	"github.com/flurbudurbur/Shiori/internal/reading"
+	"github.com/flurbudurbur/Shiori/internal/registration"
 	"github.com/flurbudurbur/Shiori/internal/scheduler"
 	"github.com/flurbudurbur/Shiori/internal/server"
+	"github.com/flurbudurbur/Shiori/internal/session"
+	"github.com/flurbudurbur/Shiori/internal/share"
 	"github.com/flurbudurbur/Shiori/internal/sync"
 	"github.com/flurbudurbur/Shiori/internal/update"
 	"github.com/flurbudurbur/Shiori/internal/user"
@@ -30,32 +44,11 @@ var (
 	date    = ""
 )
 
-// NoOpRateLimiter is a placeholder implementation of user.RateLimiterStore
-// that performs no operations. Used to satisfy dependencies during build.
-type NoOpRateLimiter struct{}
-
-func (n *NoOpRateLimiter) IsLockedOut(ctx context.Context, uuid string) (bool, error) {
-	return false, nil // Never locked out
-}
-
-func (n *NoOpRateLimiter) IncrementFailure(ctx context.Context, uuid string) (int64, error) {
-	return 0, nil // Always return 0 failures
-}
-
-func (n *NoOpRateLimiter) ClearFailures(ctx context.Context, uuid string) error {
-	return nil // No-op
-}
-
-func (n *NoOpRateLimiter) SetLockout(ctx context.Context, uuid string) error {
-	return nil // No-op
-}
-
-// Ensure NoOpRateLimiter implements the interface
-var _ user.RateLimiterStore = (*NoOpRateLimiter)(nil)
-
 func main() {
-	var configPath string
+	var configPath, resetTwoFactor, grantAdmin string
 	pflag.StringVar(&configPath, "config", "", "path to configuration file")
+	pflag.StringVar(&resetTwoFactor, "reset-2fa", "", "disable two-factor authentication of the user with this bookmark UUID and exit")
+	pflag.StringVar(&grantAdmin, "grant-admin", "", "grant the admin role to the user with this account ID and exit")
 	pflag.Parse()
 
 	// read config
@@ -96,15 +89,22 @@ func main() {
 
 	// setup repos
 	var (
-		notificationRepo = database.NewNotificationRepo(log, db)
-		userRepo         = database.NewUserRepo(log, db)
-		syncRepo         = database.NewSyncRepo(log, db)
-		profileUUIDRepo  = database.NewProfileUUIDRepo(log, db)
+		notificationRepo  = database.NewNotificationRepo(log, db)
+		userRepo          = database.NewUserRepo(log, db)
+		apiTokenRepo      = database.NewAPITokenRepo(log, db)
+		syncRepo          = database.NewSyncRepo(log, db)
+		profileUUIDRepo   = database.NewProfileUUIDRepo(log, db)
+		syncEventRepo     = database.NewSyncEventRepo(log, db)
+		readingEventRepo  = database.NewReadingEventRepo(log, db)
+		shareRepo         = database.NewShareRepo(log, db)
+		oidcIdentityRepo  = database.NewOIDCIdentityRepo(log, db)
+		proxyIdentityRepo = database.NewProxyIdentityRepo(log, db)
+		passkeyRepo       = database.NewWebAuthnCredentialRepo(log, db)
+		recoveryCodeRepo  = database.NewRecoveryCodeRepo(log, db)
+		auditEventRepo    = database.NewAuditEventRepo(log, db)
+		inviteRepo        = database.NewInviteRepo(log, db)
 	)
 
-	// setup placeholder rate limiter
-	rateLimiter := &NoOpRateLimiter{}
-
 	// init Valkey service
 	valkeyService, err := valkey.NewService(cfg.Config.Valkey)
 	if err != nil {
@@ -113,18 +113,66 @@ func main() {
 	defer valkeyService.Close()
 	log.Info().Msg("Valkey service initialized")
 
+	// failed logins, API token attempts and share passphrases are counted in Valkey
+	rateLimiter := user.NewValkeyRateLimiterStore(valkeyService.GetClient(), cfg.Config.Lockout)
+
 	// setup services
 	var (
 		notificationService = notification.NewService(log, notificationRepo)
 		updateService       = update.NewUpdate(log, cfg.Config)
 		// Pass userRepo and profileUUIDRepo to scheduler service
-		schedulingService = scheduler.NewService(log, cfg.Config, notificationService, updateService, userRepo, profileUUIDRepo)
+		schedulingService = scheduler.NewService(log, cfg.Config, notificationService, updateService, userRepo, profileUUIDRepo, syncEventRepo, auditEventRepo)
+		auditService      = audit.NewService(log, auditEventRepo)
 		// Pass rateLimiter, logger, valkeyService, and profileUUIDRepo to user service
-		userService = user.NewService(userRepo, rateLimiter, log, valkeyService, profileUUIDRepo) // Added profileUUIDRepo
-		authService = auth.NewService(log, userService)                                           // Instantiate auth service
-		syncService = sync.NewService(log, syncRepo, notificationService)
+		userService         = user.NewService(userRepo, apiTokenRepo, rateLimiter, log, valkeyService, profileUUIDRepo, recoveryCodeRepo, auditService, cfg.Config) // Added profileUUIDRepo
+		authService         = auth.NewService(log, userService)                                                                                                     // Instantiate auth service
+		readingService      = reading.NewService(log, readingEventRepo)
+		syncService         = sync.NewService(log, cfg.Config, syncRepo, syncEventRepo, userRepo, readingService, notificationService)
+		shareService        = share.NewService(log, shareRepo, syncRepo, rateLimiter)
+		sessionService      = session.NewService(log, valkeyService.GetClient())
+		registrationService = registration.NewService(log, cfg.Config.Registration, userRepo, inviteRepo, valkeyService.GetClient())
+		oidcService         = oidc.NewService(log, cfg.Config.OIDC, oidcIdentityRepo, userService, registrationService, valkeyService.GetClient())
+		proxyAuthService    = proxyauth.NewService(log, cfg.Config.ForwardAuth, proxyIdentityRepo, userService, registrationService)
+		passkeyService      = passkey.NewService(log, cfg.Config.WebAuthn, passkeyRepo, userRepo, userService, valkeyService.GetClient())
+		deviceService       = device.NewService(log, userService, valkeyService.GetClient())
+		accessTokenService  = accesstoken.NewService(log, cfg.Config, userService, apiTokenRepo, auditService, valkeyService.GetClient())
+		adminService        = admin.NewService(log, userRepo, syncRepo, auditEventRepo, inviteRepo, userService, sessionService, accessTokenService)
+		exportService       = export.NewService(log, syncRepo, shareRepo, notificationRepo, apiTokenRepo, passkeyRepo, syncEventRepo, readingEventRepo, auditEventRepo, sessionService)
+		mergeService        = merge.NewService(log, cfg.Config, userRepo, syncRepo, notificationRepo, apiTokenRepo, userService, sessionService, accessTokenService, auditService)
 	)
 
+	if resetTwoFactor != "" {
+		if err := userService.ResetTwoFactor(context.Background(), resetTwoFactor); err != nil {
+			log.Fatal().Err(err).Msg("could not reset two-factor authentication")
+		}
+		log.Info().Msg("Two-factor authentication reset")
+		if err := db.Close(); err != nil {
+			log.Error().Err(err).Msg("could not close db connection")
+		}
+		return
+	}
+
+	if grantAdmin != "" {
+		if err := adminService.GrantAdmin(context.Background(), grantAdmin); err != nil {
+			log.Fatal().Err(err).Msg("could not grant admin role")
+		}
+		log.Info().Msg("Admin role granted")
+		if err := db.Close(); err != nil {
+			log.Error().Err(err).Msg("could not close db connection")
+		}
+		return
+	}
+
+	// admins named in the config are granted the role on every start
+	for _, publicID := range strings.Split(cfg.Config.Admin.Users, ",") {
+		if publicID = strings.TrimSpace(publicID); publicID == "" {
+			continue
+		}
+		if err := adminService.GrantAdmin(context.Background(), publicID); err != nil {
+			log.Error().Err(err).Str("public_id", publicID).Msg("could not grant admin role to a user from the config")
+		}
+	}
+
 	// register event subscribers
 	events.NewSubscribers(log, bus, notificationService)
 
@@ -145,6 +193,19 @@ func main() {
 			updateService,
 			userService,
 			syncService,
+			readingService,
+			shareService,
+			sessionService,
+			oidcService,
+			proxyAuthService,
+			passkeyService,
+			deviceService,
+			exportService,
+			adminService,
+			registrationService,
+			auditService,
+			accessTokenService,
+			mergeService,
 			valkeyService, // Pass valkeyService for rate limiting
 		)
 		errorChannel <- httpServer.Open()
@@ -153,7 +214,7 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
 
-	srv := server.NewServer(log, cfg.Config, schedulingService, updateService)
+	srv := server.NewServer(log, cfg.Config, schedulingService, updateService, syncService)
 	if err := srv.Start(); err != nil {
 		log.Fatal().Stack().Err(err).Msg("could not start server")
 		return
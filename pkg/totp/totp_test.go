@@ -0,0 +1,66 @@
+package totp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 test key of RFC 6238, "12345678901234567890", in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tt.code, code, "time %d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok, err := Validate(rfcSecret, "081804", now, 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// The code of the previous step is accepted with a skew of one
+	_, ok, err = Validate(rfcSecret, "081804", now.Add(Period), 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = Validate(rfcSecret, "081804", now.Add(2*Period), 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = Validate(rfcSecret, "81804", now, 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = Validate("not base32!", "081804", now, 1)
+	assert.ErrorIs(t, err, ErrInvalidSecret)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	_, err = Code(secret, 1)
+	assert.NoError(t, err)
+}